@@ -6,12 +6,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/audit"
 	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/datasource"
 	"github.com/janhoon/dash/backend/internal/db"
 	"github.com/janhoon/dash/backend/internal/handlers"
+	"github.com/janhoon/dash/backend/internal/mailer"
+	"github.com/janhoon/dash/backend/internal/notify"
+	"github.com/janhoon/dash/backend/internal/quota"
 	"github.com/janhoon/dash/backend/internal/valkey"
 	"github.com/redis/go-redis/v9"
 )
@@ -47,15 +54,22 @@ func main() {
 		log.Fatalf("Failed to initialize JWT manager: %v", err)
 	}
 
-	// Initialize Valkey client (optional - refresh tokens won't work without it)
+	// Initialize Valkey client (optional - single-node deployments can run on Postgres alone)
 	valkeyClient, err := valkey.NewClient()
 	if err != nil {
-		log.Printf("Warning: Valkey not available, refresh tokens disabled: %v", err)
+		log.Printf("Warning: Valkey not available, using Postgres and in-memory fallbacks: %v", err)
 	} else {
 		defer valkeyClient.Close()
 		log.Println("Valkey connected successfully")
 	}
 
+	// Mailer for account and invitation emails (MAIL_DRIVER=smtp|file|log);
+	// without one, email verification, password reset and invitation emails are off
+	mail, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+
 	// Setup router
 	mux := http.NewServeMux()
 
@@ -67,45 +81,161 @@ func main() {
 	if valkeyClient != nil {
 		rdb = valkeyClient.GetRedis()
 	}
-	authHandler := handlers.NewAuthHandler(pool, jwtManager, rdb)
+
+	// Refresh token store (TOKEN_STORE=valkey|postgres)
+	tokenStore, err := auth.NewTokenStoreFromEnv(pool, rdb)
+	if err != nil {
+		log.Fatalf("Failed to configure token store: %v", err)
+	}
+	refreshTokens := auth.NewRefreshTokenManager(tokenStore)
+
+	// Revoked access tokens, synced from Valkey so other instances' revocations
+	// apply within a few seconds
+	denylist := auth.NewDenylist(rdb)
+	denylistCtx, stopDenylist := context.WithCancel(context.Background())
+	defer stopDenylist()
+	denylist.Start(denylistCtx, 5*time.Second)
+	jwtManager.SetDenylist(denylist)
+
+	// Scheduled signing key rotation (JWT_KEY_ROTATION_INTERVAL); retired keys
+	// keep verifying tokens for JWT_KEY_OVERLAP. Stored keys are encrypted with
+	// JWT_KEY_ENCRYPTION_KEY.
+	if v := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); v != "" {
+		if interval, err := time.ParseDuration(v); err == nil && interval > 0 {
+			overlap := 24 * time.Hour
+			if v := os.Getenv("JWT_KEY_OVERLAP"); v != "" {
+				if d, err := time.ParseDuration(v); err == nil && d > 0 {
+					overlap = d
+				}
+			}
+			encryptionKey, err := auth.ParseKeyEncryptionKey(os.Getenv("JWT_KEY_ENCRYPTION_KEY"))
+			if err != nil {
+				log.Fatalf("JWT_KEY_ROTATION_INTERVAL requires JWT_KEY_ENCRYPTION_KEY: %v", err)
+			}
+			rotator, err := auth.NewKeyRotator(pool, jwtManager, encryptionKey, interval, overlap)
+			if err != nil {
+				log.Fatalf("Failed to create JWT key rotator: %v", err)
+			}
+			rotateCtx, stopRotation := context.WithCancel(context.Background())
+			defer stopRotation()
+			rotator.Start(rotateCtx, time.Minute)
+		}
+	}
+
+	// Audit log, optionally exported to the JSONL file in AUDIT_LOG_FILE
+	auditLog, err := audit.NewLoggerFromEnv(pool)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	authHandler := handlers.NewAuthHandler(pool, jwtManager, rdb, refreshTokens, mail)
+	mux.HandleFunc("GET /.well-known/jwks.json", authHandler.JWKS)
 	mux.HandleFunc("POST /api/auth/register", authHandler.Register)
 	mux.HandleFunc("POST /api/auth/login", authHandler.Login)
 	mux.HandleFunc("GET /api/auth/me", auth.RequireAuth(jwtManager, authHandler.Me))
 	mux.HandleFunc("GET /api/auth/me/methods", auth.RequireAuth(jwtManager, authHandler.GetAuthMethods))
 	mux.HandleFunc("DELETE /api/auth/me/methods/{id}", auth.RequireAuth(jwtManager, authHandler.UnlinkAuthMethod))
+	mux.HandleFunc("GET /api/auth/me/sessions", auth.RequireAuth(jwtManager, authHandler.ListSessions))
+	mux.HandleFunc("DELETE /api/auth/me/sessions/{id}", auth.RequireAuth(jwtManager, authHandler.RevokeSession))
 	mux.HandleFunc("POST /api/auth/refresh", authHandler.Refresh)
 	mux.HandleFunc("POST /api/auth/logout", authHandler.Logout)
 	mux.HandleFunc("POST /api/auth/logout-all", auth.RequireAuth(jwtManager, authHandler.LogoutAll))
+	mux.HandleFunc("POST /api/auth/token/org", auth.RequireAuth(jwtManager, authHandler.OrgToken))
+	mux.HandleFunc("POST /api/auth/verify-email", authHandler.VerifyEmail)
+	mux.HandleFunc("POST /api/auth/verify-email/resend", authHandler.ResendVerification)
+	mux.HandleFunc("POST /api/auth/password/forgot", authHandler.ForgotPassword)
+	mux.HandleFunc("POST /api/auth/password/reset", authHandler.ResetPassword)
+
+	// MFA routes: factor enrolment accepts an access token or an enrolment MFA token
+	mfaHandler := handlers.NewMFAHandler(pool, jwtManager, rdb, refreshTokens)
+	mux.HandleFunc("POST /api/auth/mfa/totp/setup", mfaHandler.SetupTOTP)
+	mux.HandleFunc("POST /api/auth/mfa/totp/confirm", mfaHandler.ConfirmTOTP)
+	mux.HandleFunc("POST /api/auth/mfa/webauthn/register/begin", mfaHandler.BeginWebAuthnRegistration)
+	mux.HandleFunc("POST /api/auth/mfa/webauthn/register/finish", mfaHandler.FinishWebAuthnRegistration)
+	mux.HandleFunc("POST /api/auth/mfa/recovery-codes", auth.RequireAuth(jwtManager, mfaHandler.RegenerateRecoveryCodes))
+	mux.HandleFunc("POST /api/auth/mfa/verify", mfaHandler.Verify)
+	mux.HandleFunc("POST /api/auth/mfa/webauthn/login/begin", mfaHandler.BeginWebAuthnLogin)
+	mux.HandleFunc("POST /api/auth/mfa/webauthn/login/finish", mfaHandler.FinishWebAuthnLogin)
 
 	// Google SSO routes
-	googleSSOHandler := handlers.NewGoogleSSOHandler(pool, jwtManager)
+	googleSSOHandler := handlers.NewGoogleSSOHandler(pool, jwtManager, rdb, auditLog)
 	mux.HandleFunc("GET /api/auth/google/login", googleSSOHandler.Login)
 	mux.HandleFunc("GET /api/auth/google/callback", googleSSOHandler.Callback)
+	mux.HandleFunc("POST /api/auth/google/link", auth.RequireAuth(jwtManager, googleSSOHandler.Link))
 	mux.HandleFunc("POST /api/orgs/{id}/sso/google", auth.RequireAuth(jwtManager, googleSSOHandler.ConfigureSSO))
 	mux.HandleFunc("GET /api/orgs/{id}/sso/google", auth.RequireAuth(jwtManager, googleSSOHandler.GetSSOConfig))
 
 	// Microsoft SSO routes
-	microsoftSSOHandler := handlers.NewMicrosoftSSOHandler(pool, jwtManager)
+	microsoftSSOHandler := handlers.NewMicrosoftSSOHandler(pool, jwtManager, rdb, auditLog)
 	mux.HandleFunc("GET /api/auth/microsoft/login", microsoftSSOHandler.Login)
 	mux.HandleFunc("GET /api/auth/microsoft/callback", microsoftSSOHandler.Callback)
+	mux.HandleFunc("POST /api/auth/microsoft/link", auth.RequireAuth(jwtManager, microsoftSSOHandler.Link))
 	mux.HandleFunc("POST /api/orgs/{id}/sso/microsoft", auth.RequireAuth(jwtManager, microsoftSSOHandler.ConfigureSSO))
 	mux.HandleFunc("GET /api/orgs/{id}/sso/microsoft", auth.RequireAuth(jwtManager, microsoftSSOHandler.GetSSOConfig))
 
+	// Generic OIDC SSO routes (Keycloak, Okta, ...)
+	oidcSSOHandler := handlers.NewOIDCSSOHandler(pool, jwtManager, rdb, auditLog)
+	mux.HandleFunc("GET /api/auth/oidc/login", oidcSSOHandler.Login)
+	mux.HandleFunc("GET /api/auth/oidc/callback", oidcSSOHandler.Callback)
+	mux.HandleFunc("POST /api/auth/oidc/link", auth.RequireAuth(jwtManager, oidcSSOHandler.Link))
+	mux.HandleFunc("POST /api/orgs/{id}/sso/oidc", auth.RequireAuth(jwtManager, oidcSSOHandler.ConfigureSSO))
+	mux.HandleFunc("GET /api/orgs/{id}/sso/oidc", auth.RequireAuth(jwtManager, oidcSSOHandler.GetSSOConfig))
+
+	// SAML 2.0 SSO routes
+	samlSSOHandler := handlers.NewSAMLSSOHandler(pool, jwtManager, rdb, auditLog)
+	mux.HandleFunc("GET /api/auth/saml/login", samlSSOHandler.Login)
+	mux.HandleFunc("POST /api/auth/saml/link", auth.RequireAuth(jwtManager, samlSSOHandler.Link))
+	mux.HandleFunc("GET /api/auth/saml/{org}/metadata", samlSSOHandler.Metadata)
+	mux.HandleFunc("POST /api/auth/saml/{org}/acs", samlSSOHandler.ACS)
+	mux.HandleFunc("POST /api/orgs/{id}/sso/saml", auth.RequireAuth(jwtManager, samlSSOHandler.ConfigureSSO))
+	mux.HandleFunc("GET /api/orgs/{id}/sso/saml", auth.RequireAuth(jwtManager, samlSSOHandler.GetSSOConfig))
+
+	// SSO settings: group-to-role rules and enforced SSO
+	ssoSettingsHandler := handlers.NewSSOSettingsHandler(pool, auditLog)
+	mux.HandleFunc("GET /api/orgs/{id}/sso/settings", auth.RequireAuth(jwtManager, ssoSettingsHandler.GetSettings))
+	mux.HandleFunc("PUT /api/orgs/{id}/sso/settings", auth.RequireAuth(jwtManager, ssoSettingsHandler.UpdateSettings))
+
+	// LDAP routes
+	ldapHandler := handlers.NewLDAPHandler(pool, jwtManager, rdb, refreshTokens, auditLog)
+	mux.HandleFunc("POST /api/auth/ldap/login", ldapHandler.Login)
+	mux.HandleFunc("POST /api/auth/ldap/link", auth.RequireAuth(jwtManager, ldapHandler.Link))
+	mux.HandleFunc("POST /api/orgs/{id}/ldap", auth.RequireAuth(jwtManager, ldapHandler.ConfigureLDAP))
+	mux.HandleFunc("GET /api/orgs/{id}/ldap", auth.RequireAuth(jwtManager, ldapHandler.GetLDAPConfig))
+	mux.HandleFunc("POST /api/orgs/{id}/ldap/sync", auth.RequireAuth(jwtManager, ldapHandler.SyncGroups))
+
+	// Periodically resync LDAP group memberships
+	ldapSyncInterval := time.Hour
+	if v := os.Getenv("LDAP_SYNC_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			ldapSyncInterval = d
+		}
+	}
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	ldapHandler.StartGroupSync(syncCtx, ldapSyncInterval)
+
 	// Organization routes
-	orgHandler := handlers.NewOrganizationHandler(pool, rdb)
+	orgHandler := handlers.NewOrganizationHandler(pool, mail, denylist, auditLog)
 	mux.HandleFunc("POST /api/orgs", auth.RequireAuth(jwtManager, orgHandler.Create))
 	mux.HandleFunc("GET /api/orgs", auth.RequireAuth(jwtManager, orgHandler.List))
 	mux.HandleFunc("GET /api/orgs/{id}", auth.RequireAuth(jwtManager, orgHandler.Get))
 	mux.HandleFunc("PUT /api/orgs/{id}", auth.RequireAuth(jwtManager, orgHandler.Update))
 	mux.HandleFunc("DELETE /api/orgs/{id}", auth.RequireAuth(jwtManager, orgHandler.Delete))
 	mux.HandleFunc("POST /api/orgs/{id}/invitations", auth.RequireAuth(jwtManager, orgHandler.CreateInvitation))
+	mux.HandleFunc("GET /api/orgs/{id}/invitations", auth.RequireAuth(jwtManager, orgHandler.ListInvitations))
+	mux.HandleFunc("POST /api/orgs/{id}/invitations/{invitationId}/resend", auth.RequireAuth(jwtManager, orgHandler.ResendInvitation))
+	mux.HandleFunc("DELETE /api/orgs/{id}/invitations/{invitationId}", auth.RequireAuth(jwtManager, orgHandler.RevokeInvitation))
 	mux.HandleFunc("POST /api/invitations/{token}/accept", auth.RequireAuth(jwtManager, orgHandler.AcceptInvitation))
 	mux.HandleFunc("GET /api/orgs/{id}/members", auth.RequireAuth(jwtManager, orgHandler.ListMembers))
 	mux.HandleFunc("PUT /api/orgs/{id}/members/{userId}/role", auth.RequireAuth(jwtManager, orgHandler.UpdateMemberRole))
 	mux.HandleFunc("DELETE /api/orgs/{id}/members/{userId}", auth.RequireAuth(jwtManager, orgHandler.RemoveMember))
 
+	// Audit log routes
+	auditHandler := handlers.NewAuditHandler(pool, auditLog)
+	mux.HandleFunc("GET /api/orgs/{id}/audit", auth.RequireAuth(jwtManager, auditHandler.List))
+
 	// Dashboard routes (org-scoped for list/create, dashboard ID for get/update/delete)
-	dashboardHandler := handlers.NewDashboardHandler(pool)
+	dashboardHandler := handlers.NewDashboardHandler(pool, auditLog)
 	mux.HandleFunc("POST /api/orgs/{orgId}/dashboards", auth.RequireAuth(jwtManager, dashboardHandler.Create))
 	mux.HandleFunc("GET /api/orgs/{orgId}/dashboards", auth.RequireAuth(jwtManager, dashboardHandler.List))
 	mux.HandleFunc("GET /api/dashboards/{id}", auth.RequireAuth(jwtManager, dashboardHandler.Get))
@@ -126,17 +256,105 @@ func main() {
 	mux.HandleFunc("GET /api/datasources/prometheus/labels", prometheusHandler.Labels)
 	mux.HandleFunc("GET /api/datasources/prometheus/label/{name}/values", prometheusHandler.LabelValues)
 
-	// Multi-source datasource routes
-	dsHandler := handlers.NewDataSourceHandler(pool)
+	// Multi-source datasource routes; log queries return at most
+	// LOG_QUERY_MAX_LINES lines per page
+	if v := os.Getenv("LOG_QUERY_MAX_LINES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			datasource.SetMaxLogLimit(n)
+		}
+	}
+	quotas := quota.NewManager(pool, rdb)
+	// Annotation query results, shared by panel queries and the dashboard annotations route
+	annotations := handlers.NewDerivedAnnotations(pool, quotas)
+	dsHandler := handlers.NewDataSourceHandler(pool, quotas, auditLog, annotations)
 	mux.HandleFunc("POST /api/orgs/{orgId}/datasources", auth.RequireAuth(jwtManager, dsHandler.Create))
 	mux.HandleFunc("GET /api/orgs/{orgId}/datasources", auth.RequireAuth(jwtManager, dsHandler.List))
 	mux.HandleFunc("GET /api/datasources/{id}", auth.RequireAuth(jwtManager, dsHandler.Get))
 	mux.HandleFunc("PUT /api/datasources/{id}", auth.RequireAuth(jwtManager, dsHandler.Update))
 	mux.HandleFunc("DELETE /api/datasources/{id}", auth.RequireAuth(jwtManager, dsHandler.Delete))
 	mux.HandleFunc("POST /api/datasources/{id}/query", auth.RequireAuth(jwtManager, dsHandler.Query))
+	mux.HandleFunc("POST /api/datasources/{id}/log-stats", auth.RequireAuth(jwtManager, dsHandler.LogStats))
+
+	// Live log tailing over server-sent events, capped at TAIL_MAX_CONNECTIONS
+	// open streams and TAIL_MAX_CONNECTIONS_PER_USER per user
+	maxTails := handlers.DefaultMaxTailConnections
+	if v := os.Getenv("TAIL_MAX_CONNECTIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxTails = n
+		}
+	}
+	maxUserTails := handlers.DefaultMaxTailConnectionsPerUser
+	if v := os.Getenv("TAIL_MAX_CONNECTIONS_PER_USER"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxUserTails = n
+		}
+	}
+	tailHandler := handlers.NewTailHandler(pool, denylist, maxTails, maxUserTails)
+	mux.HandleFunc("GET /api/datasources/{id}/tail", auth.RequireAuth(jwtManager, tailHandler.Tail))
+
+	// Alert rules, evaluated by every instance; each due rule is claimed by one
+	alertHandler := handlers.NewAlertRuleHandler(pool, quotas, auditLog)
+	mux.HandleFunc("POST /api/orgs/{orgId}/alert-rules", auth.RequireAuth(jwtManager, alertHandler.Create))
+	mux.HandleFunc("GET /api/orgs/{orgId}/alert-rules", auth.RequireAuth(jwtManager, alertHandler.List))
+	mux.HandleFunc("POST /api/orgs/{orgId}/alert-rules/test", auth.RequireAuth(jwtManager, alertHandler.Test))
+	mux.HandleFunc("GET /api/orgs/{orgId}/alerts", auth.RequireAuth(jwtManager, alertHandler.ListAlerts))
+	mux.HandleFunc("GET /api/alert-rules/{id}", auth.RequireAuth(jwtManager, alertHandler.Get))
+	mux.HandleFunc("PUT /api/alert-rules/{id}", auth.RequireAuth(jwtManager, alertHandler.Update))
+	mux.HandleFunc("DELETE /api/alert-rules/{id}", auth.RequireAuth(jwtManager, alertHandler.Delete))
+	alertCtx, stopAlerts := context.WithCancel(context.Background())
+	defer stopAlerts()
+	alerting.NewScheduler(pool).Start(alertCtx, 10*time.Second)
+
+	// Alert notifications, dispatched by one instance at a time
+	dispatcher := notify.NewDispatcher(pool, mail)
+	notificationHandler := handlers.NewNotificationHandler(pool, dispatcher, auditLog)
+	mux.HandleFunc("POST /api/orgs/{orgId}/notification-channels", auth.RequireAuth(jwtManager, notificationHandler.CreateChannel))
+	mux.HandleFunc("GET /api/orgs/{orgId}/notification-channels", auth.RequireAuth(jwtManager, notificationHandler.ListChannels))
+	mux.HandleFunc("GET /api/notification-channels/{id}", auth.RequireAuth(jwtManager, notificationHandler.GetChannel))
+	mux.HandleFunc("PUT /api/notification-channels/{id}", auth.RequireAuth(jwtManager, notificationHandler.UpdateChannel))
+	mux.HandleFunc("DELETE /api/notification-channels/{id}", auth.RequireAuth(jwtManager, notificationHandler.DeleteChannel))
+	mux.HandleFunc("POST /api/notification-channels/{id}/test", auth.RequireAuth(jwtManager, notificationHandler.TestChannel))
+	mux.HandleFunc("POST /api/orgs/{orgId}/notification-routes", auth.RequireAuth(jwtManager, notificationHandler.CreateRoute))
+	mux.HandleFunc("GET /api/orgs/{orgId}/notification-routes", auth.RequireAuth(jwtManager, notificationHandler.ListRoutes))
+	mux.HandleFunc("PUT /api/notification-routes/{id}", auth.RequireAuth(jwtManager, notificationHandler.UpdateRoute))
+	mux.HandleFunc("DELETE /api/notification-routes/{id}", auth.RequireAuth(jwtManager, notificationHandler.DeleteRoute))
+	mux.HandleFunc("GET /api/orgs/{orgId}/notification-deliveries", auth.RequireAuth(jwtManager, notificationHandler.ListDeliveries))
+	dispatcher.Start(alertCtx, 10*time.Second)
+
+	// Silences and maintenance windows, applied by the scheduler and dispatcher
+	silenceHandler := handlers.NewSilenceHandler(pool, auditLog)
+	mux.HandleFunc("POST /api/orgs/{orgId}/silences", auth.RequireAuth(jwtManager, silenceHandler.CreateSilence))
+	mux.HandleFunc("GET /api/orgs/{orgId}/silences", auth.RequireAuth(jwtManager, silenceHandler.ListSilences))
+	mux.HandleFunc("POST /api/orgs/{orgId}/silences/preview", auth.RequireAuth(jwtManager, silenceHandler.PreviewSilence))
+	mux.HandleFunc("GET /api/silences/{id}", auth.RequireAuth(jwtManager, silenceHandler.GetSilence))
+	mux.HandleFunc("POST /api/silences/{id}/expire", auth.RequireAuth(jwtManager, silenceHandler.ExpireSilence))
+	mux.HandleFunc("POST /api/orgs/{orgId}/maintenance-windows", auth.RequireAuth(jwtManager, silenceHandler.CreateMaintenanceWindow))
+	mux.HandleFunc("GET /api/orgs/{orgId}/maintenance-windows", auth.RequireAuth(jwtManager, silenceHandler.ListMaintenanceWindows))
+	mux.HandleFunc("PUT /api/maintenance-windows/{id}", auth.RequireAuth(jwtManager, silenceHandler.UpdateMaintenanceWindow))
+	mux.HandleFunc("DELETE /api/maintenance-windows/{id}", auth.RequireAuth(jwtManager, silenceHandler.DeleteMaintenanceWindow))
+
+	// Annotations, also returned with panel data
+	annotationHandler := handlers.NewAnnotationHandler(pool, quotas, auditLog, annotations)
+	mux.HandleFunc("POST /api/orgs/{orgId}/annotations", auth.RequireAuth(jwtManager, annotationHandler.CreateAnnotation))
+	mux.HandleFunc("GET /api/orgs/{orgId}/annotations", auth.RequireAuth(jwtManager, annotationHandler.ListAnnotations))
+	mux.HandleFunc("GET /api/annotations/{id}", auth.RequireAuth(jwtManager, annotationHandler.GetAnnotation))
+	mux.HandleFunc("PUT /api/annotations/{id}", auth.RequireAuth(jwtManager, annotationHandler.UpdateAnnotation))
+	mux.HandleFunc("DELETE /api/annotations/{id}", auth.RequireAuth(jwtManager, annotationHandler.DeleteAnnotation))
+	mux.HandleFunc("GET /api/dashboards/{id}/annotations", auth.RequireAuth(jwtManager, annotationHandler.DashboardAnnotations))
+	mux.HandleFunc("POST /api/dashboards/{id}/annotation-queries", auth.RequireAuth(jwtManager, annotationHandler.CreateQuery))
+	mux.HandleFunc("GET /api/dashboards/{id}/annotation-queries", auth.RequireAuth(jwtManager, annotationHandler.ListQueries))
+	mux.HandleFunc("PUT /api/annotation-queries/{id}", auth.RequireAuth(jwtManager, annotationHandler.UpdateQuery))
+	mux.HandleFunc("DELETE /api/annotation-queries/{id}", auth.RequireAuth(jwtManager, annotationHandler.DeleteQuery))
+
+	// Query limits and usage
+	quotaHandler := handlers.NewQuotaHandler(pool, quotas)
+	mux.HandleFunc("GET /api/orgs/{id}/quotas", auth.RequireAuth(jwtManager, quotaHandler.GetQuotas))
+	mux.HandleFunc("PUT /api/orgs/{id}/quotas", auth.RequireAuth(jwtManager, quotaHandler.UpdateQuotas))
+	mux.HandleFunc("DELETE /api/orgs/{id}/quotas", auth.RequireAuth(jwtManager, quotaHandler.ResetQuotas))
+	mux.HandleFunc("GET /api/orgs/{id}/usage", auth.RequireAuth(jwtManager, quotaHandler.GetUsage))
 
-	// Apply CORS middleware
-	handler := corsMiddleware(mux)
+	// Apply rate limiting and CORS middleware
+	handler := corsMiddleware(handlers.APIRateLimit(jwtManager, rdb)(mux))
 
 	// Create server
 	server := &http.Server{
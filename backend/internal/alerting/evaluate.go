@@ -0,0 +1,242 @@
+package alerting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// maxStepsPerQuery keeps the step reasonable for long lookbacks
+const maxStepsPerQuery = 60
+
+// SeriesResult is one series of a rule's query after reduction
+type SeriesResult struct {
+	Labels      map[string]string  `json:"labels"`
+	Fingerprint string             `json:"fingerprint"`
+	Value       float64            `json:"value"`
+	Active      bool               `json:"active"` // the condition holds
+	Samples     []models.LogSample `json:"samples,omitempty"`
+}
+
+// Evaluate runs the rule's query over its lookback window ending at now and
+// reduces each series. Series without values are skipped.
+//
+// On log datasources, metrics rules run as metric queries over the logs and
+// logs rules count the matching lines. Active series get a few of the lines
+// behind them as samples.
+func Evaluate(ctx context.Context, client datasource.Client, rule *models.AlertRule, now time.Time) ([]SeriesResult, error) {
+	lookback := time.Duration(rule.LookbackSeconds) * time.Second
+	step := max(time.Duration(rule.IntervalSeconds)*time.Second, lookback/maxStepsPerQuery)
+	start := now.Add(-lookback)
+
+	logClient, onLogs := client.(datasource.LogMetricsClient)
+	if rule.QueryType == models.AlertQueryLogs {
+		if !onLogs {
+			return nil, errors.New("logs rules need a Loki or Victoria Logs datasource")
+		}
+		return evaluateLogLines(ctx, logClient, rule, start, now)
+	}
+
+	var result *datasource.QueryResult
+	var err error
+	if onLogs {
+		result, err = logClient.QueryMetrics(ctx, rule.Query, start, now, step)
+	} else {
+		result, err = client.Query(ctx, rule.Query, start, now, step, 0)
+	}
+	if err := queryError(result, err); err != nil {
+		return nil, err
+	}
+	if result.Data == nil {
+		return []SeriesResult{}, nil
+	}
+
+	results := []SeriesResult{}
+	var metrics []map[string]string
+	for _, series := range result.Data.Result {
+		value, ok := Reduce(rule.Condition.Reducer, seriesValues(series.Values))
+		if !ok {
+			continue
+		}
+		labels := instanceLabels(rule, series.Metric)
+		results = append(results, SeriesResult{
+			Labels:      labels,
+			Fingerprint: Fingerprint(labels),
+			Value:       value,
+			Active:      Compare(rule.Condition.Operator, value, rule.Condition.Threshold),
+		})
+		metrics = append(metrics, series.Metric)
+	}
+	if onLogs {
+		attachSamples(ctx, logClient, rule, start, now, results, metrics)
+	}
+	return results, nil
+}
+
+// queryError turns a failed query into an error
+func queryError(result *datasource.QueryResult, err error) error {
+	if err != nil {
+		return err
+	}
+	if result.Status != "success" {
+		if result.Error != "" {
+			return errors.New(result.Error)
+		}
+		return fmt.Errorf("query returned status %q", result.Status)
+	}
+	return nil
+}
+
+// seriesValues parses the [timestamp, "value"] pairs of a series, dropping NaNs
+func seriesValues(points [][]interface{}) []float64 {
+	values := make([]float64, 0, len(points))
+	for _, point := range points {
+		if len(point) < 2 {
+			continue
+		}
+		var v float64
+		switch raw := point[1].(type) {
+		case string:
+			parsed, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			v = parsed
+		case float64:
+			v = raw
+		default:
+			continue
+		}
+		if !math.IsNaN(v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Reduce folds a series to a single value; ok is false for an empty series
+func Reduce(reducer string, values []float64) (float64, bool) {
+	if reducer == "count" {
+		return float64(len(values)), true
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	switch reducer {
+	case "last":
+		return values[len(values)-1], true
+	case "min":
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return m, true
+	case "max":
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return m, true
+	case "sum", "avg":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		if reducer == "avg" {
+			return sum / float64(len(values)), true
+		}
+		return sum, true
+	}
+	return 0, false
+}
+
+// Compare applies a condition operator
+func Compare(operator string, value, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// instanceLabels combines the series' labels with the rule's, which win on
+// conflict, and names the alert after the rule
+func instanceLabels(rule *models.AlertRule, metric map[string]string) map[string]string {
+	labels := make(map[string]string, len(metric)+len(rule.Labels)+1)
+	for k, v := range metric {
+		if k != "__name__" {
+			labels[k] = v
+		}
+	}
+	for k, v := range rule.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = rule.Name
+	return labels
+}
+
+// Fingerprint identifies a label set
+func Fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[k]))
+		h.Write([]byte{0xff})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ExpandAnnotations fills in {{ $labels.name }} and {{ $value }} in the rule's
+// annotations. Annotations that fail to render are kept as written.
+func ExpandAnnotations(annotations map[string]string, labels map[string]string, value float64) map[string]string {
+	expanded := make(map[string]string, len(annotations))
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{labels, value}
+
+	for k, text := range annotations {
+		expanded[k] = text
+		if !strings.Contains(text, "{{") {
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=zero").Parse(`{{$labels := .Labels}}{{$value := .Value}}` + text)
+		if err != nil {
+			continue
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err == nil {
+			expanded[k] = b.String()
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,169 @@
+package alerting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestReduce(t *testing.T) {
+	values := []float64{3, 1, 4, 2}
+	tests := []struct {
+		reducer string
+		want    float64
+	}{
+		{"last", 2},
+		{"min", 1},
+		{"max", 4},
+		{"avg", 2.5},
+		{"sum", 10},
+		{"count", 4},
+	}
+	for _, tt := range tests {
+		got, ok := Reduce(tt.reducer, values)
+		if !ok || got != tt.want {
+			t.Errorf("Reduce(%q) = %v, %v; want %v", tt.reducer, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := Reduce("last", nil); ok {
+		t.Error("Expected an empty series to have no value")
+	}
+	if got, ok := Reduce("count", nil); !ok || got != 0 {
+		t.Errorf("Expected count of an empty series to be 0, got %v, %v", got, ok)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		op   string
+		v    float64
+		want bool
+	}{
+		{">", 6, true},
+		{">", 5, false},
+		{">=", 5, true},
+		{"<", 4, true},
+		{"<=", 5, true},
+		{"==", 5, true},
+		{"!=", 5, false},
+		{"~", 5, false},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.op, tt.v, 5); got != tt.want {
+			t.Errorf("Compare(%q, %v, 5) = %v; want %v", tt.op, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint(map[string]string{"alertname": "cpu", "instance": "a"})
+	b := Fingerprint(map[string]string{"instance": "a", "alertname": "cpu"})
+	c := Fingerprint(map[string]string{"alertname": "cpu", "instance": "b"})
+	if a != b {
+		t.Error("Expected the fingerprint not to depend on map order")
+	}
+	if a == c {
+		t.Error("Expected different label sets to have different fingerprints")
+	}
+	// Keys and values are delimited, so shifting characters between them matters
+	if Fingerprint(map[string]string{"ab": "c"}) == Fingerprint(map[string]string{"a": "bc"}) {
+		t.Error("Expected label boundaries to affect the fingerprint")
+	}
+}
+
+func TestExpandAnnotations(t *testing.T) {
+	got := ExpandAnnotations(map[string]string{
+		"summary": "{{ $labels.instance }} is at {{ $value }}",
+		"plain":   "no templates",
+		"broken":  "{{ $labels.instance",
+	}, map[string]string{"instance": "web-1"}, 97.5)
+
+	if got["summary"] != "web-1 is at 97.5" {
+		t.Errorf("Unexpected summary %q", got["summary"])
+	}
+	if got["plain"] != "no templates" || got["broken"] != "{{ $labels.instance" {
+		t.Errorf("Expected other annotations to be kept as written, got %v", got)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	var gotQuery, gotStep string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		gotQuery = r.URL.Query().Get("query")
+		gotStep = r.URL.Query().Get("step")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"cpu","instance":"a"},"values":[[1,"50"],[2,"95"]]},
+			{"metric":{"__name__":"cpu","instance":"b"},"values":[[1,"99"],[2,"20"]]},
+			{"metric":{"__name__":"cpu","instance":"c"},"values":[]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	client, err := datasource.NewVictoriaMetricsClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := &models.AlertRule{
+		Name:            "HighCPU",
+		Query:           "cpu",
+		Condition:       models.AlertCondition{Reducer: "last", Operator: ">", Threshold: 90},
+		IntervalSeconds: 60,
+		LookbackSeconds: 300,
+		Labels:          map[string]string{"severity": "page"},
+	}
+
+	results, err := Evaluate(context.Background(), client, rule, time.Now())
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if gotQuery != "cpu" || gotStep != "60s" {
+		t.Errorf("Unexpected query %q with step %q", gotQuery, gotStep)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	a, b := results[0], results[1]
+	if !a.Active || a.Value != 95 || b.Active || b.Value != 20 {
+		t.Errorf("Unexpected results %+v", results)
+	}
+	if _, ok := a.Labels["__name__"]; ok {
+		t.Error("Expected __name__ to be dropped")
+	}
+	if a.Labels["alertname"] != "HighCPU" || a.Labels["severity"] != "page" || a.Labels["instance"] != "a" {
+		t.Errorf("Unexpected labels %v", a.Labels)
+	}
+	if a.Fingerprint != Fingerprint(a.Labels) {
+		t.Error("Expected the fingerprint of the labels")
+	}
+}
+
+func TestEvaluateQueryError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"error","error":"parse error"}`))
+	}))
+	defer server.Close()
+
+	client, _ := datasource.NewVictoriaMetricsClient(server.URL)
+	rule := &models.AlertRule{
+		Name:            "Bad",
+		Query:           "cpu{",
+		Condition:       models.AlertCondition{Reducer: "last", Operator: ">", Threshold: 1},
+		IntervalSeconds: 60,
+		LookbackSeconds: 60,
+	}
+	if _, err := Evaluate(context.Background(), client, rule, time.Now()); err == nil || err.Error() != "parse error" {
+		t.Errorf("Expected the datasource's error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package alerting
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// maxCountedLines caps the lines fetched on each evaluation of a logs rule;
+// larger counts read as the cap
+const maxCountedLines = 5000
+
+// sampleFetchLimit is how many recent lines are fetched to pick the samples
+// of a metrics rule on a log datasource from
+const sampleFetchLimit = 200
+
+// maxSampleLineBytes keeps long lines from swamping notifications
+const maxSampleLineBytes = 1024
+
+// evaluateLogLines evaluates a logs rule: the lines matching its query are
+// counted and compared with the threshold as a single series
+func evaluateLogLines(ctx context.Context, client datasource.Client, rule *models.AlertRule, start, end time.Time) ([]SeriesResult, error) {
+	result, err := client.Query(ctx, rule.Query, start, end, 0, maxCountedLines)
+	if err := queryError(result, err); err != nil {
+		return nil, err
+	}
+	var logs []datasource.LogEntry
+	if result.Data != nil {
+		logs = result.Data.Logs
+	}
+
+	value := float64(len(logs))
+	labels := instanceLabels(rule, nil)
+	res := SeriesResult{
+		Labels:      labels,
+		Fingerprint: Fingerprint(labels),
+		Value:       value,
+		Active:      Compare(rule.Condition.Operator, value, rule.Condition.Threshold),
+	}
+	if res.Active {
+		res.Samples = logSamples(logs, nil)
+	}
+	return []SeriesResult{res}, nil
+}
+
+// attachSamples adds to each active series of a metric query over logs the
+// most recent lines of the log query it aggregates whose labels match the
+// series'. Samples are best effort: when they can't be fetched, the alert
+// goes out without them.
+func attachSamples(ctx context.Context, client datasource.LogMetricsClient, rule *models.AlertRule, start, end time.Time,
+	results []SeriesResult, metrics []map[string]string) {
+	active := false
+	for _, res := range results {
+		active = active || res.Active
+	}
+	query := client.LogQuery(rule.Query)
+	if !active || query == "" {
+		return
+	}
+
+	result, err := client.Query(ctx, query, start, end, 0, sampleFetchLimit)
+	if queryError(result, err) != nil || result.Data == nil {
+		return
+	}
+	for i := range results {
+		if results[i].Active {
+			results[i].Samples = logSamples(result.Data.Logs, metrics[i])
+		}
+	}
+}
+
+// logSamples picks the most recent lines whose labels include match
+func logSamples(logs []datasource.LogEntry, match map[string]string) []models.LogSample {
+	type entry struct {
+		at  time.Time
+		log datasource.LogEntry
+	}
+	var matched []entry
+outer:
+	for _, l := range logs {
+		for k, v := range match {
+			if k != "__name__" && l.Labels[k] != v {
+				continue outer
+			}
+		}
+		at, _ := time.Parse(time.RFC3339Nano, l.Timestamp)
+		matched = append(matched, entry{at, l})
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].at.After(matched[j].at) })
+
+	var samples []models.LogSample
+	for _, m := range matched {
+		if len(samples) == models.MaxAlertLogSamples {
+			break
+		}
+		line := m.log.Line
+		if len(line) > maxSampleLineBytes {
+			line = strings.ToValidUTF8(line[:maxSampleLineBytes], "") + "…"
+		}
+		samples = append(samples, models.LogSample{
+			Timestamp: m.log.Timestamp,
+			Line:      line,
+			Labels:    m.log.Labels,
+		})
+	}
+	return samples
+}
@@ -0,0 +1,127 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestEvaluateLokiMetricQuery(t *testing.T) {
+	var sampleQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("step") != "" {
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"app":"api"},"values":[[1,"3"],[2,"12"]]},
+				{"metric":{"app":"web"},"values":[[1,"1"],[2,"2"]]}
+			]}}`))
+			return
+		}
+		sampleQuery = r.URL.Query().Get("query")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[
+			{"stream":{"app":"api"},"values":[["2000000000","api failed"],["3000000000","api failed again"]]},
+			{"stream":{"app":"web"},"values":[["2500000000","web failed"]]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	client, _ := datasource.NewLokiClient(server.URL)
+	rule := &models.AlertRule{
+		Name:            "Errors",
+		Query:           `sum by (app) (count_over_time({env="prod"} |= "failed" [5m]))`,
+		QueryType:       models.AlertQueryMetrics,
+		Condition:       models.AlertCondition{Reducer: "last", Operator: ">", Threshold: 10},
+		IntervalSeconds: 60,
+		LookbackSeconds: 300,
+	}
+
+	results, err := Evaluate(context.Background(), client, rule, time.Now())
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(results) != 2 || !results[0].Active || results[0].Value != 12 || results[1].Active {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	if sampleQuery != `{env="prod"} |= "failed"` {
+		t.Errorf("Expected samples from the aggregated log query, got %q", sampleQuery)
+	}
+
+	// Only the active series' own lines, newest first
+	samples := results[0].Samples
+	if len(samples) != 2 || samples[0].Line != "api failed again" || samples[1].Line != "api failed" {
+		t.Errorf("Unexpected samples %+v", samples)
+	}
+	if results[1].Samples != nil {
+		t.Error("Expected no samples for an inactive series")
+	}
+}
+
+func TestEvaluateVictoriaLogsLines(t *testing.T) {
+	var gotPath, gotLimit string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotLimit = r.URL.Query().Get("limit")
+		for i := 0; i < 8; i++ {
+			fmt.Fprintf(w, `{"_msg":"timeout %d","_time":"2026-01-01T00:00:0%dZ","host":"a"}`+"\n", i, i)
+		}
+	}))
+	defer server.Close()
+
+	client, _ := datasource.NewVictoriaLogsClient(server.URL)
+	rule := &models.AlertRule{
+		Name:            "Timeouts",
+		Query:           "timeout",
+		QueryType:       models.AlertQueryLogs,
+		Condition:       models.AlertCondition{Reducer: "count", Operator: ">=", Threshold: 5},
+		IntervalSeconds: 60,
+		LookbackSeconds: 300,
+	}
+
+	results, err := Evaluate(context.Background(), client, rule, time.Now())
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if gotPath != "/select/logsql/query" || gotLimit != "5000" {
+		t.Errorf("Unexpected request %s limit=%s", gotPath, gotLimit)
+	}
+	if len(results) != 1 || !results[0].Active || results[0].Value != 8 || results[0].Labels["alertname"] != "Timeouts" {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	samples := results[0].Samples
+	if len(samples) != models.MaxAlertLogSamples || samples[0].Line != "timeout 7" {
+		t.Errorf("Expected the newest lines as samples, got %+v", samples)
+	}
+}
+
+func TestEvaluateLogsRuleNeedsLogDatasource(t *testing.T) {
+	client, _ := datasource.NewVictoriaMetricsClient("http://localhost:0")
+	rule := &models.AlertRule{
+		Name:            "Lines",
+		Query:           "error",
+		QueryType:       models.AlertQueryLogs,
+		Condition:       models.AlertCondition{Reducer: "count", Operator: ">", Threshold: 0},
+		IntervalSeconds: 60,
+		LookbackSeconds: 60,
+	}
+	if _, err := Evaluate(context.Background(), client, rule, time.Now()); err == nil {
+		t.Error("Expected logs rules to be rejected on a metrics datasource")
+	}
+}
+
+func TestLogSamplesTruncatesLongLines(t *testing.T) {
+	long := strings.Repeat("é", maxSampleLineBytes)
+	samples := logSamples([]datasource.LogEntry{{Timestamp: "2026-01-01T00:00:00Z", Line: long}}, nil)
+	if len(samples) != 1 || len(samples[0].Line) > maxSampleLineBytes+len("…") || !strings.HasSuffix(samples[0].Line, "…") {
+		t.Errorf("Expected the line to be cut, got %d bytes", len(samples[0].Line))
+	}
+	if !strings.HasPrefix(samples[0].Line, "éé") || strings.ContainsRune(samples[0].Line, '�') {
+		t.Error("Expected the line to be cut on a character boundary")
+	}
+}
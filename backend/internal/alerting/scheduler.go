@@ -0,0 +1,201 @@
+package alerting
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// Scheduler evaluates alert rules when they are due and persists their
+// instances. Instances share the work: each due rule is claimed by one of them.
+type Scheduler struct {
+	pool        *pgxpool.Pool
+	batchSize   int // rules claimed per run
+	concurrency int // rules evaluated at once
+	timeout     time.Duration
+	now         func() time.Time
+}
+
+func NewScheduler(pool *pgxpool.Pool) *Scheduler {
+	return &Scheduler{
+		pool:        pool,
+		batchSize:   100,
+		concurrency: 10,
+		timeout:     30 * time.Second,
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// RunOnce evaluates the rules that are due and waits for them to finish
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	now := s.now()
+
+	// Claiming moves next_evaluation_at forward, so other instances skip the rule
+	rows, err := s.pool.Query(ctx,
+		`UPDATE alert_rules SET next_evaluation_at = $1::timestamp + make_interval(secs => interval_seconds)
+		 WHERE id IN (
+			SELECT id FROM alert_rules WHERE enabled AND next_evaluation_at <= $1
+			ORDER BY next_evaluation_at LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+RuleColumns,
+		now, s.batchSize,
+	)
+	if err != nil {
+		return err
+	}
+	var rules []*models.AlertRule
+	for rows.Next() {
+		rule, err := ScanRule(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	for _, rule := range rules {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := s.EvaluateRule(ctx, rule); err != nil {
+				log.Printf("Failed to save evaluation of alert rule %s: %v", rule.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// EvaluateRule evaluates one rule now and saves the outcome. Query failures
+// are recorded on the rule, leaving its instances as they were.
+func (s *Scheduler) EvaluateRule(ctx context.Context, rule *models.AlertRule) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	now := s.now()
+	results, err := s.evaluate(ctx, rule, now)
+	if err != nil {
+		// The evaluation may have failed by running out of time, so the error
+		// is saved outside its deadline
+		saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancelSave()
+		_, dbErr := s.pool.Exec(saveCtx,
+			`UPDATE alert_rules SET health = $2, last_error = $3, last_evaluated_at = $4 WHERE id = $1`,
+			rule.ID, models.AlertHealthError, err.Error(), now,
+		)
+		return dbErr
+	}
+	return s.save(ctx, rule, results, now)
+}
+
+func (s *Scheduler) evaluate(ctx context.Context, rule *models.AlertRule, now time.Time) ([]SeriesResult, error) {
+	ds, err := LoadDataSource(ctx, s.pool, rule.DatasourceID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := datasource.NewClient(*ds)
+	if err != nil {
+		return nil, err
+	}
+	return Evaluate(ctx, client, rule, now)
+}
+
+func (s *Scheduler) save(ctx context.Context, rule *models.AlertRule, results []SeriesResult, now time.Time) error {
+	mutes, err := LoadMutes(ctx, s.pool, &rule.OrganizationID, now)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT `+InstanceColumns+` FROM alert_instances WHERE rule_id = $1 FOR UPDATE`, rule.ID,
+	)
+	if err != nil {
+		return err
+	}
+	previous := map[string]*models.AlertInstance{}
+	for rows.Next() {
+		inst, err := ScanInstance(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		previous[inst.Fingerprint] = inst
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	updated, removed := Apply(rule, previous, results, now)
+	for _, inst := range updated {
+		// Muted alerts still move through their states, so they are current
+		// when the silence ends; the router holds back their notifications
+		inst.Silenced = mutes.Muted(inst.OrganizationID, inst.Labels)
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO alert_instances (`+InstanceColumns+`)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			 ON CONFLICT (rule_id, fingerprint) DO UPDATE SET
+				labels = $4, annotations = $5, state = $6, value = $7,
+				active_at = $8, fired_at = $9, resolved_at = $10, last_evaluated_at = $11, silenced = $12,
+				samples = $13`,
+			inst.RuleID, inst.OrganizationID, inst.Fingerprint, inst.Labels, inst.Annotations, inst.State,
+			inst.Value, inst.ActiveAt, inst.FiredAt, inst.ResolvedAt, inst.LastEvaluatedAt, inst.Silenced,
+			inst.Samples,
+		); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM alert_instances WHERE rule_id = $1 AND fingerprint = ANY($2)`, rule.ID, removed,
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE alert_rules SET health = $2, last_error = '', last_evaluated_at = $3 WHERE id = $1`,
+		rule.ID, models.AlertHealthOK, now,
+	); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Start evaluates due rules every tick until ctx is done
+func (s *Scheduler) Start(ctx context.Context, tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.RunOnce(ctx); err != nil {
+					log.Printf("Warning: failed to run alert rules: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package alerting
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// SilenceColumns are the silences columns read by ScanSilence
+const SilenceColumns = `id, organization_id, matchers, starts_at, ends_at, comment, created_by, created_by_email, created_at`
+
+// ScanSilence reads a row selected with SilenceColumns. State is left for the
+// caller to set.
+func ScanSilence(row pgx.Row) (*models.Silence, error) {
+	var s models.Silence
+	err := row.Scan(&s.ID, &s.OrganizationID, &s.Matchers, &s.StartsAt, &s.EndsAt, &s.Comment, &s.CreatedBy,
+		&s.CreatedByEmail, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// MaintenanceWindowColumns are the maintenance_windows columns read by
+// ScanMaintenanceWindow
+const MaintenanceWindowColumns = `id, organization_id, name, matchers, weekdays, start_time, duration_minutes, timezone,
+	created_by, created_at, updated_at`
+
+// ScanMaintenanceWindow reads a row selected with MaintenanceWindowColumns
+func ScanMaintenanceWindow(row pgx.Row) (*models.MaintenanceWindow, error) {
+	var m models.MaintenanceWindow
+	err := row.Scan(&m.ID, &m.OrganizationID, &m.Name, &m.Matchers, &m.Weekdays, &m.StartTime, &m.DurationMinutes,
+		&m.Timezone, &m.CreatedBy, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Mutes are the silences and maintenance windows in effect at one time
+type Mutes struct {
+	matchers map[uuid.UUID][]models.LabelMatchers
+}
+
+// LoadMutes loads the silences and maintenance windows in effect at now for
+// one organization, or for all of them when orgID is nil
+func LoadMutes(ctx context.Context, pool *pgxpool.Pool, orgID *uuid.UUID, now time.Time) (*Mutes, error) {
+	m := &Mutes{matchers: map[uuid.UUID][]models.LabelMatchers{}}
+
+	rows, err := pool.Query(ctx,
+		`SELECT organization_id, matchers FROM silences
+		 WHERE starts_at <= $1 AND ends_at > $1 AND ($2::uuid IS NULL OR organization_id = $2)`,
+		now, orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var org uuid.UUID
+		var matchers models.LabelMatchers
+		if err := rows.Scan(&org, &matchers); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.matchers[org] = append(m.matchers[org], matchers)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = pool.Query(ctx,
+		`SELECT `+MaintenanceWindowColumns+` FROM maintenance_windows WHERE $1::uuid IS NULL OR organization_id = $1`,
+		orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		window, err := ScanMaintenanceWindow(rows)
+		if err != nil {
+			return nil, err
+		}
+		if window.ActiveAt(now) {
+			m.matchers[window.OrganizationID] = append(m.matchers[window.OrganizationID], window.Matchers)
+		}
+	}
+	return m, rows.Err()
+}
+
+// Muted reports whether an organization's alert with these labels is
+// silenced or in a maintenance window
+func (m *Mutes) Muted(orgID uuid.UUID, labels map[string]string) bool {
+	for _, matchers := range m.matchers[orgID] {
+		if matchers.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
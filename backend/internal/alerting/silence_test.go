@@ -0,0 +1,118 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestMaintenanceWindowActiveAt(t *testing.T) {
+	// Saturdays from 22:00 for four hours, crossing midnight
+	window := models.MaintenanceWindow{
+		Weekdays:        []int{int(time.Saturday)},
+		StartTime:       "22:00",
+		DurationMinutes: 240,
+		Timezone:        "UTC",
+	}
+	tests := []struct {
+		at     string
+		active bool
+	}{
+		{"2026-10-17T21:59:00Z", false}, // Saturday, before the start
+		{"2026-10-17T22:00:00Z", true},
+		{"2026-10-18T01:30:00Z", true}, // Sunday morning, still in Saturday's window
+		{"2026-10-18T02:00:00Z", false},
+		{"2026-10-18T22:30:00Z", false}, // Sunday evening
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := window.ActiveAt(at); got != tt.active {
+			t.Errorf("ActiveAt(%s) = %v, want %v", tt.at, got, tt.active)
+		}
+	}
+}
+
+func TestMaintenanceWindowTimezone(t *testing.T) {
+	window := models.MaintenanceWindow{StartTime: "02:00", DurationMinutes: 60, Timezone: "Europe/Berlin"}
+
+	// 02:30 in Berlin is 00:30 UTC in summer
+	if !window.ActiveAt(time.Date(2026, 7, 1, 0, 30, 0, 0, time.UTC)) {
+		t.Error("Expected the window to follow its time zone")
+	}
+	if window.ActiveAt(time.Date(2026, 7, 1, 2, 30, 0, 0, time.UTC)) {
+		t.Error("Expected the window not to run on UTC time")
+	}
+}
+
+func TestMaintenanceWindowValidate(t *testing.T) {
+	valid := models.MaintenanceWindow{
+		Name:            "patching",
+		Matchers:        models.LabelMatchers{{Name: "env", Operator: "=", Value: "staging"}},
+		StartTime:       "03:00",
+		DurationMinutes: 60,
+		Timezone:        "UTC",
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected a valid window, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(*models.MaintenanceWindow){
+		"no matchers":  func(m *models.MaintenanceWindow) { m.Matchers = nil },
+		"bad weekday":  func(m *models.MaintenanceWindow) { m.Weekdays = []int{7} },
+		"bad start":    func(m *models.MaintenanceWindow) { m.StartTime = "25:00" },
+		"no duration":  func(m *models.MaintenanceWindow) { m.DurationMinutes = 0 },
+		"bad timezone": func(m *models.MaintenanceWindow) { m.Timezone = "Mars/Olympus" },
+	} {
+		m := valid
+		mutate(&m)
+		if err := m.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSilenceState(t *testing.T) {
+	now := time.Now()
+	s := models.Silence{
+		Matchers: models.LabelMatchers{{Name: "alertname", Operator: "=", Value: "HighCPU"}},
+		StartsAt: now,
+		EndsAt:   now.Add(time.Hour),
+		Comment:  "deploying",
+	}
+	if err := s.Validate(now); err != nil {
+		t.Fatalf("Expected a valid silence, got %v", err)
+	}
+	if s.StateAt(now.Add(-time.Minute)) != models.SilencePending ||
+		s.StateAt(now) != models.SilenceActive ||
+		s.StateAt(now.Add(time.Hour)) != models.SilenceExpired {
+		t.Error("Unexpected silence states")
+	}
+
+	s.Comment = ""
+	if err := s.Validate(now); err == nil {
+		t.Error("Expected a silence without a comment to be rejected")
+	}
+	s.Comment = "deploying"
+	if err := s.Validate(now.Add(2 * time.Hour)); err == nil {
+		t.Error("Expected a silence ending in the past to be rejected")
+	}
+}
+
+func TestMutes(t *testing.T) {
+	orgID := uuid.New()
+	mutes := &Mutes{matchers: map[uuid.UUID][]models.LabelMatchers{
+		orgID: {{{Name: "severity", Operator: "=~", Value: "info|warning"}}},
+	}}
+
+	if !mutes.Muted(orgID, map[string]string{"severity": "warning"}) {
+		t.Error("Expected a matching alert to be muted")
+	}
+	if mutes.Muted(orgID, map[string]string{"severity": "page"}) {
+		t.Error("Expected a non-matching alert not to be muted")
+	}
+	if mutes.Muted(uuid.New(), map[string]string{"severity": "warning"}) {
+		t.Error("Expected silences not to apply across organizations")
+	}
+}
@@ -0,0 +1,78 @@
+package alerting
+
+import (
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// ResolvedRetention is how long resolved instances are kept after they resolve
+const ResolvedRetention = 15 * time.Minute
+
+// Apply advances a rule's instances, keyed by fingerprint, through
+// pending → firing → resolved using the results of an evaluation at now. It
+// returns the instances to save and the fingerprints of those to delete.
+//
+// A series that meets the condition starts pending and fires once it has done
+// so for the rule's for-duration. A pending series that stops meeting it is
+// dropped; a firing one resolves.
+func Apply(rule *models.AlertRule, previous map[string]*models.AlertInstance, results []SeriesResult, now time.Time) ([]models.AlertInstance, []string) {
+	forDuration := time.Duration(rule.ForSeconds) * time.Second
+	seen := make(map[string]bool, len(results))
+	updated := []models.AlertInstance{}
+	var removed []string
+
+	for _, res := range results {
+		if !res.Active {
+			continue
+		}
+		seen[res.Fingerprint] = true
+
+		var inst models.AlertInstance
+		if prev, ok := previous[res.Fingerprint]; ok && prev.State != models.AlertResolved {
+			inst = *prev
+		} else {
+			inst = models.AlertInstance{
+				RuleID:         rule.ID,
+				OrganizationID: rule.OrganizationID,
+				Fingerprint:    res.Fingerprint,
+				State:          models.AlertPending,
+				ActiveAt:       now,
+			}
+		}
+		inst.Labels = res.Labels
+		inst.Value = res.Value
+		inst.Samples = res.Samples
+		inst.Annotations = ExpandAnnotations(rule.Annotations, res.Labels, res.Value)
+		inst.LastEvaluatedAt = now
+
+		if inst.State == models.AlertPending && now.Sub(inst.ActiveAt) >= forDuration {
+			inst.State = models.AlertFiring
+			firedAt := now
+			inst.FiredAt = &firedAt
+		}
+		updated = append(updated, inst)
+	}
+
+	for fingerprint, prev := range previous {
+		if seen[fingerprint] {
+			continue
+		}
+		switch prev.State {
+		case models.AlertPending:
+			removed = append(removed, fingerprint)
+		case models.AlertFiring:
+			inst := *prev
+			inst.State = models.AlertResolved
+			resolvedAt := now
+			inst.ResolvedAt = &resolvedAt
+			inst.LastEvaluatedAt = now
+			updated = append(updated, inst)
+		case models.AlertResolved:
+			if prev.ResolvedAt == nil || now.Sub(*prev.ResolvedAt) >= ResolvedRetention {
+				removed = append(removed, fingerprint)
+			}
+		}
+	}
+	return updated, removed
+}
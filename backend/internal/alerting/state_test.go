@@ -0,0 +1,96 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// step applies results to the instances and returns the new set
+func step(rule *models.AlertRule, instances map[string]*models.AlertInstance, results []SeriesResult, now time.Time) map[string]*models.AlertInstance {
+	updated, removed := Apply(rule, instances, results, now)
+	next := map[string]*models.AlertInstance{}
+	for k, v := range instances {
+		next[k] = v
+	}
+	for _, fp := range removed {
+		delete(next, fp)
+	}
+	for i := range updated {
+		next[updated[i].Fingerprint] = &updated[i]
+	}
+	return next
+}
+
+func TestApplyStateMachine(t *testing.T) {
+	rule := &models.AlertRule{ID: uuid.New(), OrganizationID: uuid.New(), ForSeconds: 120}
+	active := []SeriesResult{{Fingerprint: "fp", Labels: map[string]string{"alertname": "x"}, Value: 5, Active: true}}
+	inactive := []SeriesResult{{Fingerprint: "fp", Value: 1}}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	instances := step(rule, map[string]*models.AlertInstance{}, active, start)
+	if inst := instances["fp"]; inst == nil || inst.State != models.AlertPending || !inst.ActiveAt.Equal(start) {
+		t.Fatalf("Expected a pending instance, got %+v", inst)
+	}
+
+	instances = step(rule, instances, active, start.Add(time.Minute))
+	if instances["fp"].State != models.AlertPending {
+		t.Fatalf("Expected the instance to stay pending within the for-duration")
+	}
+
+	instances = step(rule, instances, active, start.Add(2*time.Minute))
+	inst := instances["fp"]
+	if inst.State != models.AlertFiring || inst.FiredAt == nil || !inst.ActiveAt.Equal(start) {
+		t.Fatalf("Expected the instance to fire after the for-duration, got %+v", inst)
+	}
+
+	instances = step(rule, instances, inactive, start.Add(3*time.Minute))
+	inst = instances["fp"]
+	if inst.State != models.AlertResolved || inst.ResolvedAt == nil {
+		t.Fatalf("Expected the instance to resolve, got %+v", inst)
+	}
+
+	instances = step(rule, instances, inactive, start.Add(3*time.Minute+ResolvedRetention))
+	if _, ok := instances["fp"]; ok {
+		t.Fatal("Expected the resolved instance to be dropped after the retention period")
+	}
+}
+
+func TestApplyPendingCleared(t *testing.T) {
+	rule := &models.AlertRule{ID: uuid.New(), ForSeconds: 300}
+	start := time.Now()
+	instances := step(rule, map[string]*models.AlertInstance{},
+		[]SeriesResult{{Fingerprint: "fp", Active: true}}, start)
+
+	// The series disappearing altogether counts as not meeting the condition
+	instances = step(rule, instances, nil, start.Add(time.Minute))
+	if len(instances) != 0 {
+		t.Fatalf("Expected the pending instance to be dropped, got %v", instances)
+	}
+}
+
+func TestApplyFiresImmediatelyWithoutForDuration(t *testing.T) {
+	rule := &models.AlertRule{ID: uuid.New()}
+	instances := step(rule, map[string]*models.AlertInstance{},
+		[]SeriesResult{{Fingerprint: "fp", Active: true}}, time.Now())
+	if instances["fp"].State != models.AlertFiring {
+		t.Fatalf("Expected the instance to fire at once, got %s", instances["fp"].State)
+	}
+}
+
+func TestApplyRefiresAfterResolve(t *testing.T) {
+	rule := &models.AlertRule{ID: uuid.New(), ForSeconds: 60}
+	start := time.Now()
+	resolvedAt := start.Add(-time.Minute)
+	instances := map[string]*models.AlertInstance{
+		"fp": {Fingerprint: "fp", State: models.AlertResolved, ActiveAt: start.Add(-time.Hour), ResolvedAt: &resolvedAt},
+	}
+
+	instances = step(rule, instances, []SeriesResult{{Fingerprint: "fp", Active: true}}, start)
+	inst := instances["fp"]
+	if inst.State != models.AlertPending || !inst.ActiveAt.Equal(start) || inst.ResolvedAt != nil {
+		t.Fatalf("Expected a fresh pending instance, got %+v", inst)
+	}
+}
@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// RuleColumns are the alert_rules columns read by ScanRule
+const RuleColumns = `id, organization_id, datasource_id, name, query, query_type, condition, interval_seconds,
+	lookback_seconds, for_seconds, labels, annotations, enabled, health, last_error, last_evaluated_at, created_by,
+	created_at, updated_at`
+
+// ScanRule reads a row selected with RuleColumns
+func ScanRule(row pgx.Row) (*models.AlertRule, error) {
+	var r models.AlertRule
+	err := row.Scan(&r.ID, &r.OrganizationID, &r.DatasourceID, &r.Name, &r.Query, &r.QueryType, &r.Condition,
+		&r.IntervalSeconds, &r.LookbackSeconds, &r.ForSeconds, &r.Labels, &r.Annotations, &r.Enabled, &r.Health,
+		&r.LastError, &r.LastEvaluatedAt, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InstanceColumns are the alert_instances columns read by ScanInstance
+const InstanceColumns = `rule_id, organization_id, fingerprint, labels, annotations, state, value,
+	active_at, fired_at, resolved_at, last_evaluated_at, silenced, samples`
+
+// ScanInstance reads a row selected with InstanceColumns
+func ScanInstance(row pgx.Row) (*models.AlertInstance, error) {
+	var inst models.AlertInstance
+	err := row.Scan(&inst.RuleID, &inst.OrganizationID, &inst.Fingerprint, &inst.Labels, &inst.Annotations, &inst.State,
+		&inst.Value, &inst.ActiveAt, &inst.FiredAt, &inst.ResolvedAt, &inst.LastEvaluatedAt, &inst.Silenced,
+		&inst.Samples)
+	if err != nil {
+		return nil, err
+	}
+	return &inst, nil
+}
+
+// ListInstances returns the organization's alert instances, optionally only
+// those of one rule, most recently active first
+func ListInstances(ctx context.Context, pool *pgxpool.Pool, orgID uuid.UUID, ruleID *uuid.UUID) ([]models.AlertInstance, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT `+InstanceColumns+` FROM alert_instances
+		 WHERE organization_id = $1 AND ($2::uuid IS NULL OR rule_id = $2)
+		 ORDER BY active_at DESC`,
+		orgID, ruleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	instances := []models.AlertInstance{}
+	for rows.Next() {
+		inst, err := ScanInstance(rows)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, *inst)
+	}
+	return instances, rows.Err()
+}
+
+// LoadDataSource returns the datasource a rule queries
+func LoadDataSource(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (*models.DataSource, error) {
+	var ds models.DataSource
+	err := pool.QueryRow(ctx,
+		`SELECT id, organization_id, name, type, url, is_default, auth_type, auth_config, created_at, updated_at
+		 FROM datasources WHERE id = $1`, id,
+	).Scan(&ds.ID, &ds.OrganizationID, &ds.Name, &ds.Type, &ds.URL, &ds.IsDefault, &ds.AuthType, &ds.AuthConfig, &ds.CreatedAt, &ds.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
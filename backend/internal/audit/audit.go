@@ -0,0 +1,302 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Entry is one recorded change
+type Entry struct {
+	ID             uuid.UUID       `json:"id"`
+	OrganizationID *uuid.UUID      `json:"organization_id,omitempty"`
+	ActorID        *uuid.UUID      `json:"actor_id,omitempty"`
+	ActorEmail     string          `json:"actor_email,omitempty"`
+	Action         string          `json:"action"`
+	TargetType     string          `json:"target_type"`
+	TargetID       string          `json:"target_id,omitempty"`
+	Before         json.RawMessage `json:"before,omitempty"`
+	After          json.RawMessage `json:"after,omitempty"`
+	IP             string          `json:"ip,omitempty"`
+	UserAgent      string          `json:"user_agent,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// Sink receives entries after they are stored, e.g. for shipping to a SIEM
+type Sink interface {
+	Write(entry *Entry) error
+}
+
+// Logger appends entries to the audit_log table, which rejects updates and
+// deletes, and copies them to an optional sink
+type Logger struct {
+	pool *pgxpool.Pool
+	sink Sink
+}
+
+// NewLogger creates a logger; sink may be nil
+func NewLogger(pool *pgxpool.Pool, sink Sink) *Logger {
+	return &Logger{pool: pool, sink: sink}
+}
+
+// NewLoggerFromEnv creates a logger that also appends entries to the JSONL
+// file named by AUDIT_LOG_FILE, if set
+func NewLoggerFromEnv(pool *pgxpool.Pool) (*Logger, error) {
+	path := os.Getenv("AUDIT_LOG_FILE")
+	if path == "" {
+		return NewLogger(pool, nil), nil
+	}
+	sink, err := NewFileSink(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogger(pool, sink), nil
+}
+
+// Record stores the entry, filling in its ID and time. Recording through a nil
+// logger does nothing.
+func (l *Logger) Record(ctx context.Context, entry *Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	err := l.pool.QueryRow(ctx,
+		`INSERT INTO audit_log (organization_id, actor_id, actor_email, action, target_type, target_id, before, after, ip, user_agent)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 RETURNING id, created_at`,
+		entry.OrganizationID, entry.ActorID, entry.ActorEmail, entry.Action, entry.TargetType, entry.TargetID,
+		nullJSON(entry.Before), nullJSON(entry.After), entry.IP, entry.UserAgent,
+	).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if l.sink != nil {
+		// The database is the record; a failing sink shouldn't fail the change
+		if err := l.sink.Write(entry); err != nil {
+			log.Printf("Warning: failed to export audit entry %s: %v", entry.ID, err)
+		}
+	}
+	return nil
+}
+
+func nullJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+// Filter narrows a listing. Zero fields match everything.
+type Filter struct {
+	Action     string // exact, or a prefix ending in "." such as "datasource."
+	ActorID    *uuid.UUID
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	Cursor     string
+	Limit      int
+}
+
+// Default and maximum page sizes for List
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// ErrInvalidCursor is returned for a cursor List didn't produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// List returns the organization's entries, newest first, and a cursor for the
+// next page ("" on the last page)
+func (l *Logger) List(ctx context.Context, orgID uuid.UUID, f Filter) ([]Entry, string, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	query := `SELECT id, organization_id, actor_id, actor_email, action, target_type, target_id, before, after, ip, user_agent, created_at
+		FROM audit_log WHERE organization_id = $1`
+	args := []any{orgID}
+	where := func(clause string, arg any) {
+		args = append(args, arg)
+		query += fmt.Sprintf(" AND "+clause, len(args))
+	}
+
+	if strings.HasSuffix(f.Action, ".") {
+		where("action LIKE $%d", escapeLike(f.Action)+"%")
+	} else if f.Action != "" {
+		where("action = $%d", f.Action)
+	}
+	if f.ActorID != nil {
+		where("actor_id = $%d", *f.ActorID)
+	}
+	if f.TargetType != "" {
+		where("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		where("target_id = $%d", f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		where("created_at >= $%d", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		where("created_at < $%d", f.Until.UTC())
+	}
+	if f.Cursor != "" {
+		createdAt, id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, createdAt, id)
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := l.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.OrganizationID, &e.ActorID, &e.ActorEmail, &e.Action, &e.TargetType, &e.TargetID,
+			&before, &after, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, "", err
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		next = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return entries, next, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()))
+}
+
+func decodeCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	return createdAt, parsedID, nil
+}
+
+// Redacted replaces the values of sensitive fields in recorded changes
+const Redacted = "[redacted]"
+
+// ignoredFields change on every update and say nothing about what changed
+var ignoredFields = map[string]bool{"updated_at": true}
+
+func sensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, s := range []string{"secret", "password", "token", "private_key", "auth_config"} {
+		if strings.Contains(field, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff reduces two JSON objects to the fields that differ, with sensitive
+// values redacted. A nil side (for creations and deletions) stays nil and the
+// other side keeps all its fields.
+func Diff(before, after any) (json.RawMessage, json.RawMessage, error) {
+	b, err := toObject(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := toObject(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b != nil && a != nil {
+		for field, value := range b {
+			if other, ok := a[field]; ok && bytes.Equal(value, other) {
+				delete(a, field)
+				delete(b, field)
+			}
+		}
+	}
+	return fromObject(b), fromObject(a), nil
+}
+
+func toObject(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(raw) == "null" {
+		return nil, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	for field := range object {
+		if ignoredFields[field] {
+			delete(object, field)
+		}
+	}
+	return object, nil
+}
+
+func fromObject(object map[string]json.RawMessage) json.RawMessage {
+	if object == nil {
+		return nil
+	}
+	for field, value := range object {
+		if sensitive(field) && string(value) != "null" && string(value) != `""` {
+			object[field] = json.RawMessage(`"` + Redacted + `"`)
+		}
+	}
+	raw, _ := json.Marshal(object)
+	return raw
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]any{"name": "prod", "url": "http://a", "auth_config": map[string]string{"token": "old"}, "updated_at": "t1"}
+	after := map[string]any{"name": "prod", "url": "http://b", "auth_config": map[string]string{"token": "new"}, "updated_at": "t2"}
+
+	b, a, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	if string(b) != `{"auth_config":"[redacted]","url":"http://a"}` {
+		t.Errorf("Unexpected before: %s", b)
+	}
+	if string(a) != `{"auth_config":"[redacted]","url":"http://b"}` {
+		t.Errorf("Unexpected after: %s", a)
+	}
+}
+
+func TestDiffCreateAndDelete(t *testing.T) {
+	row := json.RawMessage(`{"id":"1","client_id":"abc","client_secret":"s3cret"}`)
+
+	b, a, err := Diff(nil, row)
+	if err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	if b != nil {
+		t.Errorf("Expected no before for a creation, got %s", b)
+	}
+	if string(a) != `{"client_id":"abc","client_secret":"[redacted]","id":"1"}` {
+		t.Errorf("Unexpected after: %s", a)
+	}
+
+	// A missing snapshot is the same as nil
+	b, a, _ = Diff(row, json.RawMessage(nil))
+	if b == nil || a != nil {
+		t.Errorf("Expected only a before for a deletion, got %s / %s", b, a)
+	}
+}
+
+func TestDiffKeepsEmptySecrets(t *testing.T) {
+	_, a, _ := Diff(map[string]string{"bind_password": "x"}, map[string]string{"bind_password": ""})
+	if string(a) != `{"bind_password":""}` {
+		t.Errorf("Expected a cleared secret to show as empty, got %s", a)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	id := uuid.New()
+
+	gotTime, gotID, err := decodeCursor(encodeCursor(createdAt, id))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if !gotTime.Equal(createdAt) || gotID != id {
+		t.Errorf("Expected %v %v, got %v %v", createdAt, id, gotTime, gotID)
+	}
+
+	for _, cursor := range []string{"not base64!", "bm8tc2VwYXJhdG9y"} {
+		if _, _, err := decodeCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", cursor, err)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	for _, action := range []string{"datasource.create", "datasource.delete"} {
+		if err := sink.Write(&Entry{ID: uuid.New(), Action: action, TargetType: "datasource"}); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+	sink.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		actions = append(actions, entry.Action)
+	}
+	if len(actions) != 2 || actions[0] != "datasource.create" || actions[1] != "datasource.delete" {
+		t.Errorf("Expected one line per entry in order, got %v", actions)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	if err := l.Record(t.Context(), &Entry{Action: "test"}); err != nil {
+		t.Errorf("Expected recording through a nil logger to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	denylistKey       = "denylist"
+	denylistKeyPrefix = "denylist:"
+)
+
+// Denylist revokes access tokens before they expire. An entry revokes the
+// tokens issued before its cutoff (in nanoseconds) for one token ID, a user,
+// or a user's membership of an organization (for org-scoped tokens).
+//
+// Entries live in Valkey. Each instance keeps a bloom filter of them so most
+// requests don't need a Valkey round trip; Sync refreshes it with entries added
+// by other instances. Without Valkey entries are kept in memory.
+type Denylist struct {
+	rdb *redis.Client
+
+	mu      sync.Mutex
+	filter  *bloomFilter
+	pending []string // added locally while a sync is running
+	mem     map[string]denylistEntry
+	now     func() time.Time
+}
+
+type denylistEntry struct {
+	cutoff  int64
+	expires time.Time
+}
+
+// NewDenylist creates a denylist backed by Valkey, or memory when rdb is nil
+func NewDenylist(rdb *redis.Client) *Denylist {
+	return &Denylist{
+		rdb:    rdb,
+		filter: newBloomFilter(0),
+		mem:    make(map[string]denylistEntry),
+		now:    time.Now,
+	}
+}
+
+func tokenEntry(jti string) string {
+	return "jti:" + jti
+}
+
+func userEntry(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+func membershipEntry(orgID, userID uuid.UUID) string {
+	return "member:" + orgID.String() + ":" + userID.String()
+}
+
+// RevokeToken revokes a single access token until it expires
+func (d *Denylist) RevokeToken(ctx context.Context, claims *TokenClaims) error {
+	if d == nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return d.add(ctx, tokenEntry(claims.ID), math.MaxInt64, claims.ExpiresAt.Time)
+}
+
+// RevokeUser revokes every access token issued to the user so far
+func (d *Denylist) RevokeUser(ctx context.Context, userID uuid.UUID) error {
+	if d == nil {
+		return nil
+	}
+	now := d.now()
+	return d.add(ctx, userEntry(userID), now.UnixNano(), now.Add(AccessTokenExpiry))
+}
+
+// RevokeMembership revokes the user's tokens scoped to the organization, after
+// their role changed or they were removed
+func (d *Denylist) RevokeMembership(ctx context.Context, orgID, userID uuid.UUID) error {
+	if d == nil {
+		return nil
+	}
+	now := d.now()
+	return d.add(ctx, membershipEntry(orgID, userID), now.UnixNano(), now.Add(AccessTokenExpiry))
+}
+
+func (d *Denylist) add(ctx context.Context, entry string, cutoff int64, expires time.Time) error {
+	ttl := expires.Sub(d.now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	if d.rdb == nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if existing, ok := d.mem[entry]; ok && existing.cutoff > cutoff {
+			cutoff = existing.cutoff
+		}
+		d.mem[entry] = denylistEntry{cutoff: cutoff, expires: expires}
+		return nil
+	}
+
+	pipe := d.rdb.TxPipeline()
+	pipe.Set(ctx, denylistKeyPrefix+entry, cutoff, ttl)
+	pipe.ZAdd(ctx, denylistKey, redis.Z{Score: float64(expires.Unix()), Member: entry})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.filter.add(entry)
+	d.pending = append(d.pending, entry)
+	d.mu.Unlock()
+	return nil
+}
+
+// Revoked reports whether the token has been revoked. Valkey errors are logged
+// and the token is accepted, so an outage doesn't sign everyone out.
+func (d *Denylist) Revoked(ctx context.Context, claims *TokenClaims) bool {
+	if d == nil {
+		return false
+	}
+
+	// Tokens without iat_ns only have iat in seconds, which revokes them along
+	// with any issued earlier in the same second
+	issuedAt := claims.IssuedAtNano
+	if issuedAt == 0 && claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.UnixNano()
+	}
+
+	entries := []string{userEntry(claims.UserID)}
+	if claims.ID != "" {
+		entries = append(entries, tokenEntry(claims.ID))
+	}
+	if claims.OrgID != nil {
+		entries = append(entries, membershipEntry(*claims.OrgID, claims.UserID))
+	}
+
+	for _, entry := range entries {
+		cutoff, err := d.cutoff(ctx, entry)
+		if err != nil {
+			log.Printf("Warning: token denylist unavailable: %v", err)
+			return false
+		}
+		if issuedAt < cutoff {
+			return true
+		}
+	}
+	return false
+}
+
+// cutoff returns the entry's cutoff, or 0 if there is none
+func (d *Denylist) cutoff(ctx context.Context, entry string) (int64, error) {
+	d.mu.Lock()
+	if d.rdb == nil {
+		defer d.mu.Unlock()
+		e, ok := d.mem[entry]
+		if !ok || d.now().After(e.expires) {
+			return 0, nil
+		}
+		return e.cutoff, nil
+	}
+	maybe := d.filter.test(entry)
+	d.mu.Unlock()
+
+	if !maybe {
+		return 0, nil
+	}
+
+	// The filter may have false positives; Valkey has the answer
+	value, err := d.rdb.Get(ctx, denylistKeyPrefix+entry).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// Sync drops expired entries and reloads the local filter from Valkey
+func (d *Denylist) Sync(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+
+	now := d.now()
+	if d.rdb == nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for entry, e := range d.mem {
+			if now.After(e.expires) {
+				delete(d.mem, entry)
+			}
+		}
+		return nil
+	}
+
+	d.mu.Lock()
+	d.pending = nil
+	d.mu.Unlock()
+
+	if err := d.rdb.ZRemRangeByScore(ctx, denylistKey, "-inf", strconv.FormatInt(now.Unix(), 10)).Err(); err != nil {
+		return err
+	}
+	entries, err := d.rdb.ZRange(ctx, denylistKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	filter := newBloomFilter(len(entries))
+	for _, entry := range entries {
+		filter.add(entry)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Keep entries revoked here after the listing was read
+	for _, entry := range d.pending {
+		filter.add(entry)
+	}
+	d.pending = nil
+	d.filter = filter
+	return nil
+}
+
+// Start syncs the denylist now and then every interval until ctx is done
+func (d *Denylist) Start(ctx context.Context, interval time.Duration) {
+	if d == nil {
+		return
+	}
+	if err := d.Sync(ctx); err != nil {
+		log.Printf("Warning: failed to sync token denylist: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				syncCtx, cancel := context.WithTimeout(ctx, interval)
+				if err := d.Sync(syncCtx); err != nil {
+					log.Printf("Warning: failed to sync token denylist: %v", err)
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// bloomFilter is a fixed-size bloom filter with about 1% false positives at
+// the capacity it was created for
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+func newBloomFilter(capacity int) *bloomFilter {
+	// ~10 bits and 7 hashes per element
+	m := max(capacity*10, 1024)
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), k: 7}
+}
+
+// hashes derives the k bit positions from two halves of a 64-bit FNV hash
+func (b *bloomFilter) hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+func (b *bloomFilter) add(s string) {
+	h1, h2 := b.hashes(s)
+	m := uint64(len(b.bits) * 64)
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) test(s string) bool {
+	h1, h2 := b.hashes(s)
+	m := uint64(len(b.bits) * 64)
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
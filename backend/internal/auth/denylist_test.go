@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func testClaims(userID uuid.UUID, issuedAt time.Time) *TokenClaims {
+	return &TokenClaims{
+		UserID:       userID,
+		IssuedAtNano: issuedAt.UnixNano(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(AccessTokenExpiry)),
+		},
+	}
+}
+
+// testDenylist runs the shared checks; advance moves the denylist's clock (and
+// the backing store's, if any) forward
+func testDenylist(t *testing.T, d *Denylist, now func() time.Time, advance func(time.Duration)) {
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// Single tokens
+	token := testClaims(userID, now())
+	other := testClaims(userID, now())
+	if d.Revoked(ctx, token) {
+		t.Fatal("Expected a fresh token not to be revoked")
+	}
+	if err := d.RevokeToken(ctx, token); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if !d.Revoked(ctx, token) {
+		t.Error("Expected the token to be revoked")
+	}
+	if d.Revoked(ctx, other) {
+		t.Error("Expected another token of the user to stay valid")
+	}
+
+	// All of a user's tokens issued before the revocation
+	advance(time.Second)
+	if err := d.RevokeUser(ctx, userID); err != nil {
+		t.Fatalf("Failed to revoke user: %v", err)
+	}
+	if !d.Revoked(ctx, other) {
+		t.Error("Expected the user's earlier tokens to be revoked")
+	}
+	advance(time.Millisecond)
+	if d.Revoked(ctx, testClaims(userID, now())) {
+		t.Error("Expected tokens issued after the revocation to be valid")
+	}
+	legacy := testClaims(userID, now())
+	legacy.IssuedAtNano = 0
+	if !d.Revoked(ctx, legacy) {
+		t.Error("Expected a token with only iat to be revoked within the revocation's second")
+	}
+	if d.Revoked(ctx, testClaims(uuid.New(), now().Add(-time.Minute))) {
+		t.Error("Expected other users' tokens to be valid")
+	}
+
+	// Org-scoped tokens after a membership change
+	orgID := uuid.New()
+	scoped := testClaims(userID, now())
+	scoped.OrgID = &orgID
+	advance(time.Second)
+	if err := d.RevokeMembership(ctx, orgID, userID); err != nil {
+		t.Fatalf("Failed to revoke membership: %v", err)
+	}
+	if !d.Revoked(ctx, scoped) {
+		t.Error("Expected the org-scoped token to be revoked")
+	}
+	if d.Revoked(ctx, testClaims(userID, now().Add(-time.Millisecond))) {
+		t.Error("Expected unscoped tokens to be unaffected by a membership change")
+	}
+
+	// Entries go away once the tokens they cover have expired
+	advance(AccessTokenExpiry + time.Second)
+	if err := d.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if d.Revoked(ctx, scoped) {
+		t.Error("Expected the entry to expire with the tokens")
+	}
+}
+
+func newTestValkeyDenylist(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func TestValkeyDenylist(t *testing.T) {
+	rdb, mr := newTestValkeyDenylist(t)
+	d := NewDenylist(rdb)
+	now := time.Now().Truncate(time.Second).Add(time.Second / 2)
+	d.now = func() time.Time { return now }
+	testDenylist(t, d, d.now, func(dur time.Duration) {
+		now = now.Add(dur)
+		mr.FastForward(dur)
+	})
+}
+
+func TestMemoryDenylist(t *testing.T) {
+	d := NewDenylist(nil)
+	now := time.Now().Truncate(time.Second).Add(time.Second / 2)
+	d.now = func() time.Time { return now }
+	testDenylist(t, d, d.now, func(dur time.Duration) { now = now.Add(dur) })
+}
+
+func TestDenylistSyncsAcrossInstances(t *testing.T) {
+	rdb, _ := newTestValkeyDenylist(t)
+	ctx := context.Background()
+	a, b := NewDenylist(rdb), NewDenylist(rdb)
+
+	userID := uuid.New()
+	token := testClaims(userID, time.Now().Add(-time.Second))
+	if err := a.RevokeUser(ctx, userID); err != nil {
+		t.Fatalf("Failed to revoke user: %v", err)
+	}
+
+	// b's filter doesn't know about the entry until it syncs
+	if b.Revoked(ctx, token) {
+		t.Error("Expected the other instance to skip Valkey before syncing")
+	}
+	if err := b.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if !b.Revoked(ctx, token) {
+		t.Error("Expected the other instance to see the revocation after syncing")
+	}
+
+	// Syncing keeps local entries
+	if err := a.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if !a.Revoked(ctx, token) {
+		t.Error("Expected the revocation to survive a sync")
+	}
+}
+
+func TestNilDenylist(t *testing.T) {
+	var d *Denylist
+	ctx := context.Background()
+	if err := d.RevokeUser(ctx, uuid.New()); err != nil {
+		t.Errorf("Expected revoking through a nil denylist to do nothing, got %v", err)
+	}
+	if d.Revoked(ctx, testClaims(uuid.New(), time.Now())) {
+		t.Error("Expected a nil denylist to revoke nothing")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		f.add(fmt.Sprintf("jti:%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.test(fmt.Sprintf("jti:%d", i)) {
+			t.Fatalf("Expected entry %d to be found", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 1000; i < 11000; i++ {
+		if f.test(fmt.Sprintf("jti:%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("Expected about 1%% false positives, got %d of 10000", falsePositives)
+	}
+}
+
+func TestAuthMiddlewareDenylistAndOrgClaims(t *testing.T) {
+	manager, err := GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+	manager.SetDenylist(NewDenylist(nil))
+
+	userID, orgID := uuid.New(), uuid.New()
+	var gotRole string
+	var scoped bool
+	handler := RequireAuth(manager, func(w http.ResponseWriter, r *http.Request) {
+		gotRole, scoped = GetOrgRole(r.Context(), orgID)
+	})
+	request := func(token string) int {
+		req := httptest.NewRequest("GET", "/api/orgs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	orgToken, err := manager.GenerateOrgAccessToken(userID, "test@example.com", "Test", orgID, "editor")
+	if err != nil {
+		t.Fatalf("Failed to generate org token: %v", err)
+	}
+	if code := request(orgToken); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !scoped || gotRole != "editor" {
+		t.Errorf("Expected the org role from the token, got %q (scoped=%v)", gotRole, scoped)
+	}
+
+	plainToken, _ := manager.GenerateAccessToken(userID, "test@example.com", "Test")
+	if code := request(plainToken); code != http.StatusOK || scoped {
+		t.Errorf("Expected a plain token to carry no org role, got %d (scoped=%v)", code, scoped)
+	}
+
+	claims, _ := manager.VerifyAccessToken(orgToken)
+	if claims.ID == "" {
+		t.Fatal("Expected access tokens to have a jti")
+	}
+	manager.Denylist().RevokeMembership(context.Background(), orgID, userID)
+	if code := request(orgToken); code != http.StatusUnauthorized {
+		t.Errorf("Expected the org token to be rejected after a membership change, got %d", code)
+	}
+	if code := request(plainToken); code != http.StatusOK {
+		t.Errorf("Expected the plain token to stay valid, got %d", code)
+	}
+
+	plainClaims, _ := manager.VerifyAccessToken(plainToken)
+	manager.Denylist().RevokeToken(context.Background(), plainClaims)
+	if code := request(plainToken); code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be rejected, got %d", code)
+	}
+}
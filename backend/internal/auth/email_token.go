@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// EmailTokenPurpose scopes an emailed token to the flow that issued it
+type EmailTokenPurpose string
+
+const (
+	PurposeVerifyEmail   EmailTokenPurpose = "verify_email"
+	PurposePasswordReset EmailTokenPurpose = "password_reset"
+
+	EmailVerificationExpiry = 24 * time.Hour
+	PasswordResetExpiry     = time.Hour
+)
+
+var ErrInvalidEmailToken = errors.New("invalid or expired token")
+
+// EmailTokenData stores the data associated with an emailed token
+type EmailTokenData struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EmailTokenManager issues single-use tokens sent by email (verification links,
+// password resets). Only the latest token per user and purpose is valid.
+type EmailTokenManager struct {
+	rdb *redis.Client
+}
+
+// NewEmailTokenManager creates a new email token manager
+func NewEmailTokenManager(rdb *redis.Client) *EmailTokenManager {
+	return &EmailTokenManager{rdb: rdb}
+}
+
+func emailTokenKey(purpose EmailTokenPurpose, token string) string {
+	return string(purpose) + ":" + token
+}
+
+func emailTokenUserKey(purpose EmailTokenPurpose, userID uuid.UUID) string {
+	return string(purpose) + "_user:" + userID.String()
+}
+
+// Issue creates a token for the user, invalidating any earlier token for the same purpose
+func (m *EmailTokenManager) Issue(ctx context.Context, purpose EmailTokenPurpose, userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(EmailTokenData{UserID: userID, Email: email, CreatedAt: time.Now()})
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.rdb.Set(ctx, emailTokenKey(purpose, token), jsonData, ttl).Err(); err != nil {
+		return "", err
+	}
+
+	// Track the latest token so issuing a new one revokes the previous link
+	previous, err := m.rdb.SetArgs(ctx, emailTokenUserKey(purpose, userID), token, redis.SetArgs{TTL: ttl, Get: true}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	if previous != "" {
+		if err := m.rdb.Del(ctx, emailTokenKey(purpose, previous)).Err(); err != nil {
+			return "", err
+		}
+	}
+
+	return token, nil
+}
+
+// Consume redeems a token; it can only be used once
+func (m *EmailTokenManager) Consume(ctx context.Context, purpose EmailTokenPurpose, token string) (*EmailTokenData, error) {
+	if token == "" {
+		return nil, ErrInvalidEmailToken
+	}
+
+	jsonData, err := m.rdb.GetDel(ctx, emailTokenKey(purpose, token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidEmailToken
+		}
+		return nil, err
+	}
+
+	var data EmailTokenData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, err
+	}
+
+	m.rdb.Del(ctx, emailTokenUserKey(purpose, data.UserID))
+	return &data, nil
+}
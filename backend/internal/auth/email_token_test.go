@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEmailTokenSingleUse(t *testing.T) {
+	rdb, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	m := NewEmailTokenManager(rdb)
+	userID := uuid.New()
+
+	token, err := m.Issue(ctx, PurposePasswordReset, userID, "test@example.com", PasswordResetExpiry)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+
+	// A token for one purpose can't be used for another
+	if _, err := m.Consume(ctx, PurposeVerifyEmail, token); err != ErrInvalidEmailToken {
+		t.Errorf("Expected ErrInvalidEmailToken for wrong purpose, got %v", err)
+	}
+
+	data, err := m.Consume(ctx, PurposePasswordReset, token)
+	if err != nil {
+		t.Fatalf("Failed to consume token: %v", err)
+	}
+	if data.UserID != userID || data.Email != "test@example.com" {
+		t.Errorf("Unexpected token data: %+v", data)
+	}
+
+	if _, err := m.Consume(ctx, PurposePasswordReset, token); err != ErrInvalidEmailToken {
+		t.Errorf("Expected ErrInvalidEmailToken on reuse, got %v", err)
+	}
+}
+
+func TestEmailTokenReissueRevokesPrevious(t *testing.T) {
+	rdb, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	m := NewEmailTokenManager(rdb)
+	userID := uuid.New()
+
+	first, err := m.Issue(ctx, PurposeVerifyEmail, userID, "test@example.com", EmailVerificationExpiry)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	second, err := m.Issue(ctx, PurposeVerifyEmail, userID, "test@example.com", EmailVerificationExpiry)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+
+	if _, err := m.Consume(ctx, PurposeVerifyEmail, first); err != ErrInvalidEmailToken {
+		t.Errorf("Expected the first token to be revoked, got %v", err)
+	}
+	if _, err := m.Consume(ctx, PurposeVerifyEmail, second); err != nil {
+		t.Errorf("Expected the latest token to be valid, got %v", err)
+	}
+}
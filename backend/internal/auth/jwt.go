@@ -1,12 +1,18 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
+	"math/big"
 	"os"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,43 +22,123 @@ import (
 var (
 	ErrInvalidToken = errors.New("invalid token")
 	ErrExpiredToken = errors.New("token has expired")
+	ErrRevokedToken = errors.New("token has been revoked")
 )
 
-// TokenClaims represents the JWT claims
+// AccessTokenExpiry is the lifetime of an access token
+const AccessTokenExpiry = 15 * time.Minute
+
+// TokenClaims represents the JWT claims. Org-scoped tokens also carry the
+// user's role in one organization, so handlers can skip the membership lookup.
+// IssuedAtNano repeats iat in nanoseconds, so the denylist can tell tokens
+// issued just before a revocation from those issued just after.
 type TokenClaims struct {
+	UserID       uuid.UUID  `json:"sub"`
+	Email        string     `json:"email"`
+	Name         string     `json:"name,omitempty"`
+	OrgID        *uuid.UUID `json:"org_id,omitempty"`
+	OrgRole      string     `json:"org_role,omitempty"`
+	IssuedAtNano int64      `json:"iat_ns,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// mfaAudience marks tokens that only prove the password step of a login
+const mfaAudience = "dash-mfa"
+
+// MFATokenExpiry bounds how long a user has to complete the second factor
+const MFATokenExpiry = 5 * time.Minute
+
+// MFAClaims are carried by the short-lived token issued between the password
+// and second-factor steps of a login. Enroll marks users who must set up MFA
+// before they can sign in.
+type MFAClaims struct {
 	UserID uuid.UUID `json:"sub"`
 	Email  string    `json:"email"`
 	Name   string    `json:"name,omitempty"`
+	Enroll bool      `json:"mfa_enroll,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// JWTManager handles JWT token generation and verification
+// SigningKey is one of the RSA keys known to a JWTManager. Keys loaded only to
+// verify tokens have no private key.
+type SigningKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+	CreatedAt  time.Time
+	ExpiresAt  time.Time // zero for keys that don't expire
+}
+
+// NewSigningKey wraps an RSA key, deriving its ID from the public key
+func NewSigningKey(privateKey *rsa.PrivateKey, createdAt time.Time) *SigningKey {
+	return &SigningKey{
+		ID:         KeyID(&privateKey.PublicKey),
+		PrivateKey: privateKey,
+		PublicKey:  &privateKey.PublicKey,
+		CreatedAt:  createdAt,
+	}
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of a public key, used as its kid
+func KeyID(publicKey *rsa.PublicKey) string {
+	jwk := rsaJWK(publicKey)
+	sum := sha256.Sum256([]byte(`{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWTManager handles JWT token generation and verification. Tokens carry the
+// kid of the key that signed them; any key that hasn't expired verifies.
 type JWTManager struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+	mu       sync.RWMutex
+	keys     []*SigningKey // newest first
+	extra    []*SigningKey // verify-only keys from JWT_ADDITIONAL_PUBLIC_KEYS, kept by SetKeys
+	denylist *Denylist
+}
+
+// SetDenylist makes AuthMiddleware reject tokens revoked in d
+func (m *JWTManager) SetDenylist(d *Denylist) {
+	m.denylist = d
+}
+
+// Denylist returns the denylist for revoking access tokens; revoking through
+// a nil denylist does nothing
+func (m *JWTManager) Denylist() *Denylist {
+	return m.denylist
 }
 
-// NewJWTManager creates a new JWTManager from environment variables or generates new keys
+// NewJWTManager creates a new JWTManager from environment variables or generates new keys.
+// JWT_ADDITIONAL_PUBLIC_KEYS may hold more PEM public keys that are still
+// accepted, e.g. the previous key after a manual rotation.
 func NewJWTManager() (*JWTManager, error) {
 	privateKeyPEM := os.Getenv("JWT_PRIVATE_KEY")
 	publicKeyPEM := os.Getenv("JWT_PUBLIC_KEY")
 
+	var m *JWTManager
+	var err error
 	if privateKeyPEM != "" && publicKeyPEM != "" {
-		return NewJWTManagerFromPEM(privateKeyPEM, publicKeyPEM)
+		m, err = NewJWTManagerFromPEM(privateKeyPEM, publicKeyPEM)
+	} else {
+		// Generate new keys if not provided
+		m, err = GenerateJWTManager()
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate new keys if not provided
-	return GenerateJWTManager()
+	if additional := os.Getenv("JWT_ADDITIONAL_PUBLIC_KEYS"); additional != "" {
+		keys, err := parsePublicKeys(additional)
+		if err != nil {
+			return nil, err
+		}
+		m.extra = keys
+		m.keys = append(m.keys, keys...)
+	}
+	return m, nil
 }
 
 // NewJWTManagerFromPEM creates a JWTManager from PEM-encoded keys
 func NewJWTManagerFromPEM(privateKeyPEM, publicKeyPEM string) (*JWTManager, error) {
-	privateBlock, _ := pem.Decode([]byte(privateKeyPEM))
-	if privateBlock == nil {
-		return nil, errors.New("failed to parse private key PEM")
-	}
-
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateBlock.Bytes)
+	privateKey, err := parsePrivateKey(privateKeyPEM)
 	if err != nil {
 		return nil, err
 	}
@@ -71,11 +157,11 @@ func NewJWTManagerFromPEM(privateKeyPEM, publicKeyPEM string) (*JWTManager, erro
 	if !ok {
 		return nil, errors.New("public key is not RSA")
 	}
+	if !publicKey.Equal(&privateKey.PublicKey) {
+		return nil, errors.New("public key does not match private key")
+	}
 
-	return &JWTManager{
-		privateKey: privateKey,
-		publicKey:  publicKey,
-	}, nil
+	return &JWTManager{keys: []*SigningKey{NewSigningKey(privateKey, time.Now())}}, nil
 }
 
 // GenerateJWTManager generates new RSA keys and creates a JWTManager
@@ -85,40 +171,220 @@ func GenerateJWTManager() (*JWTManager, error) {
 		return nil, err
 	}
 
-	return &JWTManager{
-		privateKey: privateKey,
-		publicKey:  &privateKey.PublicKey,
-	}, nil
+	return &JWTManager{keys: []*SigningKey{NewSigningKey(privateKey, time.Now())}}, nil
+}
+
+func parsePrivateKey(privateKeyPEM string) (*rsa.PrivateKey, error) {
+	privateBlock, _ := pem.Decode([]byte(privateKeyPEM))
+	if privateBlock == nil {
+		return nil, errors.New("failed to parse private key PEM")
+	}
+	return x509.ParsePKCS1PrivateKey(privateBlock.Bytes)
+}
+
+// parsePublicKeys reads concatenated PEM public keys as verify-only keys
+func parsePublicKeys(publicKeysPEM string) ([]*SigningKey, error) {
+	var keys []*SigningKey
+	rest := []byte(publicKeysPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not RSA")
+		}
+		keys = append(keys, &SigningKey{ID: KeyID(publicKey), PublicKey: publicKey})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("failed to parse public key PEM")
+	}
+	return keys, nil
+}
+
+// SetKeys replaces the manager's keys. Keys from JWT_ADDITIONAL_PUBLIC_KEYS
+// keep verifying tokens.
+func (m *JWTManager) SetKeys(keys []*SigningKey) error {
+	keys = slices.Clone(keys)
+	slices.SortStableFunc(keys, func(a, b *SigningKey) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	if !slices.ContainsFunc(keys, func(key *SigningKey) bool { return key.PrivateKey != nil }) {
+		return errors.New("no signing key")
+	}
+
+	m.mu.Lock()
+	for _, extra := range m.extra {
+		if !slices.ContainsFunc(keys, func(key *SigningKey) bool { return key.ID == extra.ID }) {
+			keys = append(keys, extra)
+		}
+	}
+	m.keys = keys
+	m.mu.Unlock()
+	return nil
+}
+
+// Keys returns the keys that currently verify tokens, newest first
+func (m *JWTManager) Keys() []*SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]*SigningKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		if key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// currentKey returns the key that signs new tokens: the newest one that has
+// been published for at least JWKSMaxAge, so services caching the JWKS know it
+func (m *JWTManager) currentKey() *SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	published := time.Now().Add(-JWKSMaxAge)
+	var newest *SigningKey
+	for _, key := range m.keys {
+		if key.PrivateKey == nil {
+			continue
+		}
+		if newest == nil {
+			newest = key
+		}
+		if !key.CreatedAt.After(published) {
+			return key
+		}
+	}
+	return newest
+}
+
+func (m *JWTManager) sign(claims jwt.Claims) (string, error) {
+	key := m.currentKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// verificationKey finds the key a token was signed with. Tokens from before
+// key IDs were added have no kid and are checked against every key.
+func (m *JWTManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, ErrInvalidToken
+	}
+
+	keys := m.Keys()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key.PublicKey)
+		}
+		return set, nil
+	}
+	for _, key := range keys {
+		if key.ID == kid {
+			return key.PublicKey, nil
+		}
+	}
+	return nil, ErrInvalidToken
 }
 
 // GenerateAccessToken creates a new JWT access token
 func (m *JWTManager) GenerateAccessToken(userID uuid.UUID, email string, name string) (string, error) {
+	return m.generateAccessToken(TokenClaims{UserID: userID, Email: email, Name: name})
+}
+
+// GenerateOrgAccessToken creates an access token that also carries the user's
+// role in an organization
+func (m *JWTManager) GenerateOrgAccessToken(userID uuid.UUID, email, name string, orgID uuid.UUID, role string) (string, error) {
+	return m.generateAccessToken(TokenClaims{UserID: userID, Email: email, Name: name, OrgID: &orgID, OrgRole: role})
+}
+
+func (m *JWTManager) generateAccessToken(claims TokenClaims) (string, error) {
 	now := time.Now()
-	claims := TokenClaims{
+	claims.IssuedAtNano = now.UnixNano()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenExpiry)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    "dash",
+		Subject:   claims.UserID.String(),
+		ID:        uuid.NewString(),
+	}
+
+	return m.sign(claims)
+}
+
+// VerifyAccessToken verifies and parses a JWT token
+func (m *JWTManager) VerifyAccessToken(tokenString string) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, m.verificationKey)
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// Access tokens carry no audience; anything else (e.g. MFA tokens) is not one
+	if len(claims.Audience) > 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// AuthenticateAccessToken verifies an access token presented with a request
+// and checks that it hasn't been revoked since it was issued
+func (m *JWTManager) AuthenticateAccessToken(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	claims, err := m.VerifyAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if m.denylist.Revoked(ctx, claims) {
+		return nil, ErrRevokedToken
+	}
+	return claims, nil
+}
+
+// GenerateMFAToken creates the token that lets a user complete an MFA login
+func (m *JWTManager) GenerateMFAToken(userID uuid.UUID, email, name string, enroll bool) (string, error) {
+	now := time.Now()
+	claims := MFAClaims{
 		UserID: userID,
 		Email:  email,
 		Name:   name,
+		Enroll: enroll,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "dash",
 			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{mfaAudience},
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(m.privateKey)
+	return m.sign(claims)
 }
 
-// VerifyAccessToken verifies and parses a JWT token
-func (m *JWTManager) VerifyAccessToken(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, ErrInvalidToken
-		}
-		return m.publicKey, nil
-	})
+// VerifyMFAToken verifies a token issued by GenerateMFAToken
+func (m *JWTManager) VerifyMFAToken(tokenString string) (*MFAClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &MFAClaims{}, m.verificationKey, jwt.WithAudience(mfaAudience))
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -127,7 +393,7 @@ func (m *JWTManager) VerifyAccessToken(tokenString string) (*TokenClaims, error)
 		return nil, ErrInvalidToken
 	}
 
-	claims, ok := token.Claims.(*TokenClaims)
+	claims, ok := token.Claims.(*MFAClaims)
 	if !ok || !token.Valid {
 		return nil, ErrInvalidToken
 	}
@@ -135,9 +401,9 @@ func (m *JWTManager) VerifyAccessToken(tokenString string) (*TokenClaims, error)
 	return claims, nil
 }
 
-// GetPublicKeyPEM returns the public key in PEM format
+// GetPublicKeyPEM returns the signing key's public key in PEM format
 func (m *JWTManager) GetPublicKeyPEM() (string, error) {
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(m.publicKey)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(m.currentKey().PublicKey)
 	if err != nil {
 		return "", err
 	}
@@ -150,12 +416,55 @@ func (m *JWTManager) GetPublicKeyPEM() (string, error) {
 	return string(publicPEM), nil
 }
 
-// GetPrivateKeyPEM returns the private key in PEM format
+// GetPrivateKeyPEM returns the signing key's private key in PEM format
 func (m *JWTManager) GetPrivateKeyPEM() string {
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(m.privateKey)
+	return privateKeyPEM(m.currentKey().PrivateKey)
+}
+
+func privateKeyPEM(privateKey *rsa.PrivateKey) string {
+	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
 	privatePEM := pem.EncodeToMemory(&pem.Block{
 		Type:  "RSA PRIVATE KEY",
 		Bytes: privateKeyBytes,
 	})
 	return string(privatePEM)
 }
+
+// JWKSMaxAge is how long other services may cache the JWKS
+const JWKSMaxAge = 5 * time.Minute
+
+// JWK is an RSA public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func rsaJWK(publicKey *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// JWKS returns the public keys that verify tokens, for other services
+func (m *JWTManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range m.Keys() {
+		jwk := rsaJWK(key.PublicKey)
+		jwk.Use = "sig"
+		jwk.Alg = "RS256"
+		jwk.Kid = key.ID
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// keyRotationLockID serializes rotation across instances
+const keyRotationLockID = 0x6a776b73 // "jwks"
+
+// encryptedKeyPrefix marks private keys sealed with the key encryption key;
+// rows written before encryption hold plain PEM and are sealed on next rotation
+const encryptedKeyPrefix = "v1:"
+
+// ErrInvalidKeyEncryptionKey is returned for a key encryption key that isn't
+// 32 base64-encoded bytes
+var ErrInvalidKeyEncryptionKey = errors.New("key encryption key must be 32 base64-encoded bytes")
+
+// ParseKeyEncryptionKey decodes the AES-256 key (JWT_KEY_ENCRYPTION_KEY) that
+// seals private keys stored in jwt_signing_keys
+func ParseKeyEncryptionKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKeyEncryptionKey
+	}
+	return key, nil
+}
+
+// KeyRotator keeps a JWTManager's keys in the jwt_signing_keys table, shared by
+// all instances. Every interval a new key is added; the keys it replaces keep
+// verifying tokens for the overlap window. Private keys are stored encrypted
+// with AES-GCM, bound to their key ID.
+type KeyRotator struct {
+	pool     *pgxpool.Pool
+	manager  *JWTManager
+	aead     cipher.AEAD
+	interval time.Duration
+	overlap  time.Duration
+}
+
+// NewKeyRotator creates a rotator for manager, sealing stored keys with
+// encryptionKey. The overlap is raised to cover tokens signed with a key until
+// its replacement starts signing.
+func NewKeyRotator(pool *pgxpool.Pool, manager *JWTManager, encryptionKey []byte, interval, overlap time.Duration) (*KeyRotator, error) {
+	if len(encryptionKey) != 32 {
+		return nil, ErrInvalidKeyEncryptionKey
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyRotator{
+		pool:     pool,
+		manager:  manager,
+		aead:     aead,
+		interval: interval,
+		overlap:  max(overlap, JWKSMaxAge+AccessTokenExpiry),
+	}, nil
+}
+
+// seal encrypts a private key for storage under kid
+func (r *KeyRotator) seal(kid string, privateKey *rsa.PrivateKey) (string, error) {
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := r.aead.Seal(nonce, nonce, []byte(privateKeyPEM(privateKey)), []byte(kid))
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a stored private key, reporting whether it was still plain PEM
+func (r *KeyRotator) open(kid, stored string) (*rsa.PrivateKey, bool, error) {
+	if !strings.HasPrefix(stored, encryptedKeyPrefix) {
+		privateKey, err := parsePrivateKey(stored)
+		return privateKey, true, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedKeyPrefix))
+	if err != nil || len(sealed) < r.aead.NonceSize() {
+		return nil, false, fmt.Errorf("malformed signing key %s", kid)
+	}
+	nonce, ciphertext := sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():]
+	plaintext, err := r.aead.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt signing key %s: %w", kid, err)
+	}
+	privateKey, err := parsePrivateKey(string(plaintext))
+	return privateKey, false, err
+}
+
+// Rotate adds a new key if the newest one is older than the interval, drops
+// expired keys and loads the rest into the manager
+func (r *KeyRotator) Rotate(ctx context.Context) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, keyRotationLockID); err != nil {
+		return err
+	}
+	// Timestamps are stored in UTC so instances in other time zones agree
+	now := time.Now().UTC()
+	if _, err := tx.Exec(ctx, `DELETE FROM jwt_signing_keys WHERE expires_at < $1`, now); err != nil {
+		return err
+	}
+
+	// The first instance to rotate keeps the key it started with
+	var newest *time.Time
+	if err := tx.QueryRow(ctx,
+		`SELECT MAX(created_at) FROM jwt_signing_keys WHERE expires_at IS NULL`,
+	).Scan(&newest); err != nil {
+		return err
+	}
+	if newest == nil {
+		key := r.manager.currentKey()
+		if err := r.insertSigningKey(ctx, tx, key); err != nil {
+			return err
+		}
+		createdAt := key.CreatedAt.UTC()
+		newest = &createdAt
+	}
+
+	if now.Sub(*newest) >= r.interval {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE jwt_signing_keys SET expires_at = $1 WHERE expires_at IS NULL`, now.Add(r.overlap),
+		); err != nil {
+			return err
+		}
+		if err := r.insertSigningKey(ctx, tx, NewSigningKey(privateKey, now)); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(ctx, `SELECT kid, private_key, created_at, expires_at FROM jwt_signing_keys`)
+	if err != nil {
+		return err
+	}
+	var keys, plain []*SigningKey
+	for rows.Next() {
+		var kid, stored string
+		var createdAt time.Time
+		var expiresAt *time.Time
+		if err := rows.Scan(&kid, &stored, &createdAt, &expiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		privateKey, isPlain, err := r.open(kid, stored)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		key := NewSigningKey(privateKey, createdAt)
+		if expiresAt != nil {
+			key.ExpiresAt = *expiresAt
+		}
+		keys = append(keys, key)
+		if isPlain {
+			plain = append(plain, key)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Seal keys stored before encryption was added
+	for _, key := range plain {
+		sealed, err := r.seal(key.ID, key.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE jwt_signing_keys SET private_key = $2 WHERE kid = $1`, key.ID, sealed); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	return r.manager.SetKeys(keys)
+}
+
+func (r *KeyRotator) insertSigningKey(ctx context.Context, tx pgx.Tx, key *SigningKey) error {
+	sealed, err := r.seal(key.ID, key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO jwt_signing_keys (kid, private_key, created_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (kid) DO UPDATE SET expires_at = NULL`,
+		key.ID, sealed, key.CreatedAt.UTC(),
+	)
+	return err
+}
+
+// Start rotates now and then checks every interval until ctx is done
+func (r *KeyRotator) Start(ctx context.Context, interval time.Duration) {
+	if err := r.Rotate(ctx); err != nil {
+		log.Printf("Warning: failed to rotate JWT signing keys: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rotateCtx, cancel := context.WithTimeout(ctx, interval)
+				if err := r.Rotate(rotateCtx); err != nil {
+					log.Printf("Warning: failed to rotate JWT signing keys: %v", err)
+				}
+				cancel()
+			}
+		}
+	}()
+}
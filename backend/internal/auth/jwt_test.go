@@ -1,9 +1,16 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -13,12 +20,16 @@ func TestGenerateJWTManager(t *testing.T) {
 		t.Fatalf("Failed to generate JWT manager: %v", err)
 	}
 
-	if manager.privateKey == nil {
+	key := manager.currentKey()
+	if key.PrivateKey == nil {
 		t.Error("Private key is nil")
 	}
-	if manager.publicKey == nil {
+	if key.PublicKey == nil {
 		t.Error("Public key is nil")
 	}
+	if key.ID == "" {
+		t.Error("Key ID is empty")
+	}
 }
 
 func TestGenerateAccessToken(t *testing.T) {
@@ -220,3 +231,240 @@ func containsAt(s, substr string, start int) bool {
 	}
 	return false
 }
+
+func TestMFAToken(t *testing.T) {
+	manager, err := GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+
+	userID := uuid.New()
+	token, err := manager.GenerateMFAToken(userID, "test@example.com", "Test User", true)
+	if err != nil {
+		t.Fatalf("Failed to generate MFA token: %v", err)
+	}
+
+	claims, err := manager.VerifyMFAToken(token)
+	if err != nil {
+		t.Fatalf("Failed to verify MFA token: %v", err)
+	}
+	if claims.UserID != userID || !claims.Enroll {
+		t.Errorf("Unexpected MFA claims: %+v", claims)
+	}
+
+	// An MFA token must not be accepted as an access token, nor the reverse
+	if _, err := manager.VerifyAccessToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected MFA token to be rejected as access token, got %v", err)
+	}
+
+	accessToken, err := manager.GenerateAccessToken(userID, "test@example.com", "Test User")
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+	if _, err := manager.VerifyMFAToken(accessToken); err != ErrInvalidToken {
+		t.Errorf("Expected access token to be rejected as MFA token, got %v", err)
+	}
+}
+
+func testSigningKey(t *testing.T, createdAt time.Time) *SigningKey {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return NewSigningKey(privateKey, createdAt)
+}
+
+func TestTokensCarryKeyID(t *testing.T) {
+	manager, err := GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+
+	token, _ := manager.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &TokenClaims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if kid := parsed.Header["kid"]; kid != manager.currentKey().ID {
+		t.Errorf("Expected kid %q, got %v", manager.currentKey().ID, kid)
+	}
+
+	// Tokens from before kids were added still verify
+	claims := TokenClaims{UserID: uuid.New(), RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(manager.currentKey().PrivateKey)
+	if _, err := manager.VerifyAccessToken(legacy); err != nil {
+		t.Errorf("Expected a token without kid to verify, got %v", err)
+	}
+}
+
+func TestKeyRotationOverlap(t *testing.T) {
+	manager, err := GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+	now := time.Now()
+	old := testSigningKey(t, now.Add(-time.Hour))
+	manager.SetKeys([]*SigningKey{old})
+	oldToken, _ := manager.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+
+	// A new key is published before it signs, so cached JWKS pick it up
+	next := testSigningKey(t, now)
+	old.ExpiresAt = now.Add(time.Hour)
+	if err := manager.SetKeys([]*SigningKey{old, next}); err != nil {
+		t.Fatalf("Failed to set keys: %v", err)
+	}
+	if manager.currentKey() != old {
+		t.Error("Expected the old key to sign until the new one is published")
+	}
+	if jwks := manager.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("Expected 2 published keys, got %d", len(jwks.Keys))
+	}
+
+	next.CreatedAt = now.Add(-JWKSMaxAge)
+	manager.SetKeys([]*SigningKey{old, next})
+	if manager.currentKey() != next {
+		t.Error("Expected the new key to sign once published")
+	}
+	newToken, _ := manager.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := manager.VerifyAccessToken(token); err != nil {
+			t.Errorf("Expected tokens from both keys to verify, got %v", err)
+		}
+	}
+
+	// Once the overlap ends, the old key's tokens are rejected
+	old.ExpiresAt = now.Add(-time.Second)
+	if _, err := manager.VerifyAccessToken(oldToken); err == nil {
+		t.Error("Expected tokens from an expired key to be rejected")
+	}
+	if jwks := manager.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Kid != next.ID {
+		t.Errorf("Expected only the new key to be published, got %+v", jwks.Keys)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	manager, err := GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+
+	jwks := manager.JWKS()
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("Expected 1 key, got %d", len(jwks.Keys))
+	}
+	key := jwks.Keys[0]
+	if key.Kty != "RSA" || key.Alg != "RS256" || key.Use != "sig" || key.E != "AQAB" {
+		t.Errorf("Unexpected JWK: %+v", key)
+	}
+	if key.Kid != KeyID(manager.currentKey().PublicKey) {
+		t.Errorf("Expected kid to be the key's thumbprint, got %q", key.Kid)
+	}
+}
+
+func TestKeyIDThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1 example
+	n := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	modulus, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		t.Fatalf("Failed to decode modulus: %v", err)
+	}
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: 65537}
+	if kid := KeyID(publicKey); kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("Unexpected thumbprint %q", kid)
+	}
+}
+
+func TestAdditionalPublicKeys(t *testing.T) {
+	previous := testSigningKey(t, time.Now())
+	current := testSigningKey(t, time.Now())
+
+	encode := func(key *SigningKey) string {
+		der, _ := x509.MarshalPKIXPublicKey(key.PublicKey)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	t.Setenv("JWT_PRIVATE_KEY", privateKeyPEM(current.PrivateKey))
+	t.Setenv("JWT_PUBLIC_KEY", encode(current))
+	t.Setenv("JWT_ADDITIONAL_PUBLIC_KEYS", encode(previous))
+
+	manager, err := NewJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+
+	// A token signed before the manual rotation still verifies
+	oldManager := &JWTManager{keys: []*SigningKey{previous}}
+	token, _ := oldManager.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+	if _, err := manager.VerifyAccessToken(token); err != nil {
+		t.Errorf("Expected a token from the previous key to verify, got %v", err)
+	}
+	if len(manager.JWKS().Keys) != 2 {
+		t.Errorf("Expected both keys to be published, got %d", len(manager.JWKS().Keys))
+	}
+}
+
+func TestAdditionalPublicKeysSurviveKeyChanges(t *testing.T) {
+	previous := testSigningKey(t, time.Now().Add(-time.Hour))
+	current := testSigningKey(t, time.Now())
+
+	der, _ := x509.MarshalPKIXPublicKey(previous.PublicKey)
+	t.Setenv("JWT_PRIVATE_KEY", "")
+	t.Setenv("JWT_PUBLIC_KEY", "")
+	t.Setenv("JWT_ADDITIONAL_PUBLIC_KEYS", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	manager, err := NewJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+
+	// KeyRotator.Rotate replaces the keys with those from the database
+	if err := manager.SetKeys([]*SigningKey{current}); err != nil {
+		t.Fatalf("Failed to set keys: %v", err)
+	}
+
+	oldManager := &JWTManager{keys: []*SigningKey{previous}}
+	token, _ := oldManager.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+	if _, err := manager.VerifyAccessToken(token); err != nil {
+		t.Errorf("Expected a token from an additional public key to verify after rotation, got %v", err)
+	}
+	if len(manager.JWKS().Keys) != 2 {
+		t.Errorf("Expected the additional key to stay published, got %d keys", len(manager.JWKS().Keys))
+	}
+}
+
+func TestKeyRotatorSealsPrivateKeys(t *testing.T) {
+	encryptionKey, err := ParseKeyEncryptionKey(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("Failed to parse encryption key: %v", err)
+	}
+	if _, err := ParseKeyEncryptionKey("c2hvcnQ="); err != ErrInvalidKeyEncryptionKey {
+		t.Errorf("Expected ErrInvalidKeyEncryptionKey for a short key, got %v", err)
+	}
+
+	rotator, err := NewKeyRotator(nil, nil, encryptionKey, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create rotator: %v", err)
+	}
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key := NewSigningKey(privateKey, time.Now())
+
+	sealed, err := rotator.seal(key.ID, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to seal key: %v", err)
+	}
+	opened, plain, err := rotator.open(key.ID, sealed)
+	if err != nil || plain || !opened.Equal(privateKey) {
+		t.Fatalf("Expected the sealed key back, got plain=%v err=%v", plain, err)
+	}
+
+	// The key ID is authenticated, so a sealed key can't be moved to another row
+	if _, _, err := rotator.open("other", sealed); err == nil {
+		t.Error("Expected a sealed key under another ID to be rejected")
+	}
+
+	// Keys stored before encryption are read and reported for sealing
+	if _, plain, err := rotator.open(key.ID, privateKeyPEM(privateKey)); err != nil || !plain {
+		t.Errorf("Expected a plain PEM key to be read as plain, got plain=%v err=%v", plain, err)
+	}
+}
@@ -15,6 +15,9 @@ const (
 	UserIDKey    contextKey = "user_id"
 	UserEmailKey contextKey = "user_email"
 	UserNameKey  contextKey = "user_name"
+	OrgIDKey     contextKey = "org_id"
+	OrgRoleKey   contextKey = "org_role"
+	ClaimsKey    contextKey = "claims"
 )
 
 // AuthMiddleware creates middleware that validates JWT tokens
@@ -37,13 +40,16 @@ func AuthMiddleware(jwtManager *JWTManager) func(http.Handler) http.Handler {
 			tokenString := parts[1]
 
 			// Verify token
-			claims, err := jwtManager.VerifyAccessToken(tokenString)
+			claims, err := jwtManager.AuthenticateAccessToken(r.Context(), tokenString)
 			if err != nil {
-				if err == ErrExpiredToken {
+				switch err {
+				case ErrExpiredToken:
 					http.Error(w, `{"error":"token has expired"}`, http.StatusUnauthorized)
-					return
+				case ErrRevokedToken:
+					http.Error(w, `{"error":"token has been revoked"}`, http.StatusUnauthorized)
+				default:
+					http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 				}
-				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 				return
 			}
 
@@ -51,6 +57,11 @@ func AuthMiddleware(jwtManager *JWTManager) func(http.Handler) http.Handler {
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
 			ctx = context.WithValue(ctx, UserEmailKey, claims.Email)
 			ctx = context.WithValue(ctx, UserNameKey, claims.Name)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			if claims.OrgID != nil {
+				ctx = context.WithValue(ctx, OrgIDKey, *claims.OrgID)
+				ctx = context.WithValue(ctx, OrgRoleKey, claims.OrgRole)
+			}
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -75,6 +86,23 @@ func GetUserName(ctx context.Context) (string, bool) {
 	return name, ok
 }
 
+// GetClaims returns the claims of the access token the request was made with
+func GetClaims(ctx context.Context) (*TokenClaims, bool) {
+	claims, ok := ctx.Value(ClaimsKey).(*TokenClaims)
+	return claims, ok
+}
+
+// GetOrgRole returns the user's role in orgID when the request was made with
+// a token scoped to that organization
+func GetOrgRole(ctx context.Context, orgID uuid.UUID) (string, bool) {
+	tokenOrg, ok := ctx.Value(OrgIDKey).(uuid.UUID)
+	if !ok || tokenOrg != orgID {
+		return "", false
+	}
+	role, ok := ctx.Value(OrgRoleKey).(string)
+	return role, ok && role != ""
+}
+
 // RequireAuth wraps a handler function to require authentication
 func RequireAuth(jwtManager *JWTManager, handler http.HandlerFunc) http.HandlerFunc {
 	middleware := AuthMiddleware(jwtManager)
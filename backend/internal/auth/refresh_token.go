@@ -4,41 +4,49 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 )
 
-const (
-	RefreshTokenExpiry = 30 * 24 * time.Hour // 30 days
-	refreshTokenPrefix = "refresh_token:"
-	userTokensPrefix   = "user_tokens:"
-)
+const RefreshTokenExpiry = 30 * 24 * time.Hour // 30 days
 
 var (
 	ErrInvalidRefreshToken = errors.New("invalid refresh token")
 	ErrExpiredRefreshToken = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrSessionNotFound     = errors.New("session not found")
 )
 
-// RefreshTokenData stores the data associated with a refresh token
+// ClientInfo describes the device a session was started or last used from
+type ClientInfo struct {
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+}
+
+// RefreshTokenData stores the data associated with a refresh token. A session
+// keeps its ID across rotations, so all tokens of one login share it.
 type RefreshTokenData struct {
-	UserID    uuid.UUID `json:"user_id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	SessionID  uuid.UUID `json:"session_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
 }
 
 // RefreshTokenManager handles refresh token operations
 type RefreshTokenManager struct {
-	rdb *redis.Client
+	store TokenStore
 }
 
 // NewRefreshTokenManager creates a new refresh token manager
-func NewRefreshTokenManager(rdb *redis.Client) *RefreshTokenManager {
-	return &RefreshTokenManager{rdb: rdb}
+func NewRefreshTokenManager(store TokenStore) *RefreshTokenManager {
+	return &RefreshTokenManager{store: store}
 }
 
 // GenerateRefreshToken generates a cryptographically secure refresh token
@@ -50,146 +58,104 @@ func GenerateRefreshToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
-// StoreRefreshToken stores a refresh token in Valkey
-func (m *RefreshTokenManager) StoreRefreshToken(ctx context.Context, token string, userID uuid.UUID, email, name string) error {
-	data := RefreshTokenData{
-		UserID:    userID,
-		Email:     email,
-		Name:      name,
-		CreatedAt: time.Now(),
-	}
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	// Store the token with TTL
-	tokenKey := refreshTokenPrefix + token
-	if err := m.rdb.Set(ctx, tokenKey, jsonData, RefreshTokenExpiry).Err(); err != nil {
-		return err
-	}
-
-	// Add token to user's token set for logout-all functionality
-	userTokensKey := userTokensPrefix + userID.String()
-	if err := m.rdb.SAdd(ctx, userTokensKey, token).Err(); err != nil {
-		return err
-	}
-
-	// Set expiry on user's token set (should be at least as long as max token expiry)
-	// We refresh this on every new token to ensure it doesn't expire while tokens are still valid
-	if err := m.rdb.Expire(ctx, userTokensKey, RefreshTokenExpiry+24*time.Hour).Err(); err != nil {
-		return err
-	}
-
-	return nil
+// StoreRefreshToken stores a refresh token for a new session
+func (m *RefreshTokenManager) StoreRefreshToken(ctx context.Context, token string, userID uuid.UUID, email, name string, client ClientInfo) error {
+	now := time.Now()
+	return m.store.Save(ctx, token, &RefreshTokenData{
+		SessionID:  uuid.New(),
+		UserID:     userID,
+		Email:      email,
+		Name:       name,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	})
 }
 
 // GetRefreshToken retrieves and validates a refresh token
 func (m *RefreshTokenManager) GetRefreshToken(ctx context.Context, token string) (*RefreshTokenData, error) {
-	tokenKey := refreshTokenPrefix + token
-
-	jsonData, err := m.rdb.Get(ctx, tokenKey).Bytes()
-	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nil, ErrInvalidRefreshToken
-		}
-		return nil, err
-	}
-
-	var data RefreshTokenData
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return nil, err
-	}
-
-	return &data, nil
+	return m.store.Get(ctx, token)
 }
 
 // RevokeRefreshToken revokes a single refresh token
 func (m *RefreshTokenManager) RevokeRefreshToken(ctx context.Context, token string) error {
-	tokenKey := refreshTokenPrefix + token
+	return m.store.Delete(ctx, token)
+}
+
+// RevokeAllUserTokens revokes all refresh tokens for a user (logout-all)
+func (m *RefreshTokenManager) RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error {
+	return m.store.DeleteUser(ctx, userID)
+}
 
-	// Get the token data first to find the user
-	jsonData, err := m.rdb.Get(ctx, tokenKey).Bytes()
+// RotateRefreshToken invalidates the old token and creates a new one in the
+// same session. Presenting a token that was already rotated means it was
+// stolen or replayed, so the whole session is revoked and
+// ErrRefreshTokenReused returned.
+func (m *RefreshTokenManager) RotateRefreshToken(ctx context.Context, oldToken string, client ClientInfo) (string, *RefreshTokenData, error) {
+	data, err := m.store.Consume(ctx, oldToken)
+	if err == ErrInvalidRefreshToken {
+		return "", nil, m.checkReuse(ctx, oldToken)
+	}
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			// Token doesn't exist, consider it revoked
-			return nil
-		}
-		return err
+		return "", nil, err
 	}
 
-	var data RefreshTokenData
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return err
+	// Generate a new token
+	newToken, err := GenerateRefreshToken()
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Delete the token
-	if err := m.rdb.Del(ctx, tokenKey).Err(); err != nil {
-		return err
+	data.LastUsedAt = time.Now()
+	if client.UserAgent != "" {
+		data.UserAgent = client.UserAgent
+	}
+	if client.IP != "" {
+		data.IP = client.IP
 	}
 
-	// Remove from user's token set
-	userTokensKey := userTokensPrefix + data.UserID.String()
-	if err := m.rdb.SRem(ctx, userTokensKey, token).Err(); err != nil {
-		return err
+	// Store the new token
+	if err := m.store.Save(ctx, newToken, data); err != nil {
+		return "", nil, err
 	}
 
-	return nil
+	return newToken, data, nil
 }
 
-// RevokeAllUserTokens revokes all refresh tokens for a user (logout-all)
-func (m *RefreshTokenManager) RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error {
-	userTokensKey := userTokensPrefix + userID.String()
-
-	// Get all tokens for this user
-	tokens, err := m.rdb.SMembers(ctx, userTokensKey).Result()
+// checkReuse revokes the session of a token that was already rotated
+func (m *RefreshTokenManager) checkReuse(ctx context.Context, token string) error {
+	used, err := m.store.Used(ctx, token)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nil
-		}
 		return err
 	}
-
-	// Delete each token
-	for _, token := range tokens {
-		tokenKey := refreshTokenPrefix + token
-		if err := m.rdb.Del(ctx, tokenKey).Err(); err != nil {
-			return err
-		}
-	}
-
-	// Delete the user's token set
-	if err := m.rdb.Del(ctx, userTokensKey).Err(); err != nil {
+	if err := m.RevokeSession(ctx, used.UserID, used.SessionID); err != nil && err != ErrSessionNotFound {
 		return err
 	}
-
-	return nil
+	return ErrRefreshTokenReused
 }
 
-// RotateRefreshToken invalidates the old token and creates a new one
-func (m *RefreshTokenManager) RotateRefreshToken(ctx context.Context, oldToken string) (string, *RefreshTokenData, error) {
-	// Get the old token data
-	data, err := m.GetRefreshToken(ctx, oldToken)
+// ListSessions returns the user's active sessions, most recently used first
+func (m *RefreshTokenManager) ListSessions(ctx context.Context, userID uuid.UUID) ([]RefreshTokenData, error) {
+	sessions, err := m.store.List(ctx, userID)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
-	// Revoke the old token
-	if err := m.RevokeRefreshToken(ctx, oldToken); err != nil {
-		return "", nil, err
-	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
 
-	// Generate a new token
-	newToken, err := GenerateRefreshToken()
+// RevokeSession revokes the refresh tokens of one of the user's sessions
+func (m *RefreshTokenManager) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	found, err := m.store.DeleteSession(ctx, userID, sessionID)
 	if err != nil {
-		return "", nil, err
+		return err
 	}
-
-	// Store the new token
-	if err := m.StoreRefreshToken(ctx, newToken, data.UserID, data.Email, data.Name); err != nil {
-		return "", nil, err
+	if !found {
+		return ErrSessionNotFound
 	}
-
-	return newToken, data, nil
+	return nil
 }
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const refreshTokenColumns = `session_id, user_id, email, COALESCE(name, ''), user_agent, ip, created_at, last_used_at`
+
+// NewPostgresTokenStore keeps tokens in the refresh_tokens table. Only a hash
+// of each token is stored.
+func NewPostgresTokenStore(pool *pgxpool.Pool) TokenStore {
+	return &postgresTokenStore{pool: pool}
+}
+
+type postgresTokenStore struct {
+	pool *pgxpool.Pool
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanRefreshToken(row pgx.Row) (*RefreshTokenData, error) {
+	var data RefreshTokenData
+	err := row.Scan(&data.SessionID, &data.UserID, &data.Email, &data.Name, &data.UserAgent, &data.IP, &data.CreatedAt, &data.LastUsedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *postgresTokenStore) Save(ctx context.Context, token string, data *RefreshTokenData) error {
+	// Expired tokens are cleaned up as the user signs in again
+	if _, err := s.pool.Exec(ctx,
+		`DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < NOW()`, data.UserID,
+	); err != nil {
+		return err
+	}
+
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO refresh_tokens (token_hash, session_id, user_id, email, name, user_agent, ip, created_at, last_used_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		hashRefreshToken(token), data.SessionID, data.UserID, data.Email, data.Name, data.UserAgent, data.IP,
+		data.CreatedAt, data.LastUsedAt, time.Now().Add(RefreshTokenExpiry),
+	)
+	return err
+}
+
+func (s *postgresTokenStore) Get(ctx context.Context, token string) (*RefreshTokenData, error) {
+	return scanRefreshToken(s.pool.QueryRow(ctx,
+		`SELECT `+refreshTokenColumns+` FROM refresh_tokens
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`,
+		hashRefreshToken(token),
+	))
+}
+
+func (s *postgresTokenStore) Consume(ctx context.Context, token string) (*RefreshTokenData, error) {
+	return scanRefreshToken(s.pool.QueryRow(ctx,
+		`UPDATE refresh_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING `+refreshTokenColumns,
+		hashRefreshToken(token),
+	))
+}
+
+func (s *postgresTokenStore) Used(ctx context.Context, token string) (*RefreshTokenData, error) {
+	return scanRefreshToken(s.pool.QueryRow(ctx,
+		`SELECT `+refreshTokenColumns+` FROM refresh_tokens
+		 WHERE token_hash = $1 AND used_at IS NOT NULL AND expires_at > NOW()`,
+		hashRefreshToken(token),
+	))
+}
+
+func (s *postgresTokenStore) Delete(ctx context.Context, token string) error {
+	_, err := s.pool.Exec(ctx,
+		`DELETE FROM refresh_tokens WHERE token_hash = $1 AND used_at IS NULL`, hashRefreshToken(token),
+	)
+	return err
+}
+
+func (s *postgresTokenStore) List(ctx context.Context, userID uuid.UUID) ([]RefreshTokenData, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+refreshTokenColumns+` FROM refresh_tokens
+		 WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW()`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []RefreshTokenData{}
+	for rows.Next() {
+		data, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *data)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *postgresTokenStore) DeleteSession(ctx context.Context, userID, sessionID uuid.UUID) (bool, error) {
+	result, err := s.pool.Exec(ctx,
+		`DELETE FROM refresh_tokens
+		 WHERE user_id = $1 AND session_id = $2 AND used_at IS NULL AND expires_at > NOW()`,
+		userID, sessionID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (s *postgresTokenStore) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := s.pool.Exec(ctx,
+		`DELETE FROM refresh_tokens WHERE user_id = $1 AND used_at IS NULL`, userID,
+	)
+	return err
+}
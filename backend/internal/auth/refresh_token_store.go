@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshTokenPrefix = "refresh_token:"
+	userTokensPrefix   = "user_tokens:"
+	usedTokenPrefix    = "refresh_token_used:"
+)
+
+// TokenStore persists refresh tokens for RefreshTokenManager. Rotated tokens
+// are remembered until they would have expired so replays can be detected.
+type TokenStore interface {
+	// Save stores the token until RefreshTokenExpiry
+	Save(ctx context.Context, token string, data *RefreshTokenData) error
+	// Get returns an active token, or ErrInvalidRefreshToken
+	Get(ctx context.Context, token string) (*RefreshTokenData, error)
+	// Consume invalidates an active token and marks it as used. Only one
+	// caller can consume a token; the others get ErrInvalidRefreshToken.
+	Consume(ctx context.Context, token string) (*RefreshTokenData, error)
+	// Used returns a token that was consumed, or ErrInvalidRefreshToken
+	Used(ctx context.Context, token string) (*RefreshTokenData, error)
+	// Delete removes a token; unknown tokens are not an error
+	Delete(ctx context.Context, token string) error
+	// List returns the active tokens of a user
+	List(ctx context.Context, userID uuid.UUID) ([]RefreshTokenData, error)
+	// DeleteSession removes the active tokens of one session, reporting
+	// whether there were any
+	DeleteSession(ctx context.Context, userID, sessionID uuid.UUID) (bool, error)
+	// DeleteUser removes all tokens of a user
+	DeleteUser(ctx context.Context, userID uuid.UUID) error
+}
+
+// NewTokenStoreFromEnv returns the refresh token store selected by TOKEN_STORE:
+//   - valkey: requires rdb
+//   - postgres: for single-node deployments without Valkey
+//
+// Without TOKEN_STORE, Valkey is used when available and Postgres otherwise.
+func NewTokenStoreFromEnv(pool *pgxpool.Pool, rdb *redis.Client) (TokenStore, error) {
+	switch driver := os.Getenv("TOKEN_STORE"); driver {
+	case "valkey":
+		if rdb == nil {
+			return nil, errors.New("TOKEN_STORE=valkey requires Valkey")
+		}
+		return NewValkeyTokenStore(rdb), nil
+	case "postgres":
+		return NewPostgresTokenStore(pool), nil
+	case "":
+		if rdb != nil {
+			return NewValkeyTokenStore(rdb), nil
+		}
+		return NewPostgresTokenStore(pool), nil
+	default:
+		return nil, fmt.Errorf("unknown TOKEN_STORE %q", driver)
+	}
+}
+
+// NewValkeyTokenStore keeps tokens in Valkey, with a set per user for
+// logout-all and session listing
+func NewValkeyTokenStore(rdb *redis.Client) TokenStore {
+	return &valkeyTokenStore{rdb: rdb}
+}
+
+type valkeyTokenStore struct {
+	rdb *redis.Client
+}
+
+func (s *valkeyTokenStore) Save(ctx context.Context, token string, data *RefreshTokenData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// Store the token with TTL
+	if err := s.rdb.Set(ctx, refreshTokenPrefix+token, jsonData, RefreshTokenExpiry).Err(); err != nil {
+		return err
+	}
+
+	// Add token to user's token set for logout-all functionality
+	userTokensKey := userTokensPrefix + data.UserID.String()
+	if err := s.rdb.SAdd(ctx, userTokensKey, token).Err(); err != nil {
+		return err
+	}
+
+	// Set expiry on user's token set (should be at least as long as max token expiry)
+	// We refresh this on every new token to ensure it doesn't expire while tokens are still valid
+	return s.rdb.Expire(ctx, userTokensKey, RefreshTokenExpiry+24*time.Hour).Err()
+}
+
+func (s *valkeyTokenStore) Get(ctx context.Context, token string) (*RefreshTokenData, error) {
+	return s.get(ctx, refreshTokenPrefix+token, s.rdb.Get(ctx, refreshTokenPrefix+token))
+}
+
+func (s *valkeyTokenStore) get(ctx context.Context, key string, cmd *redis.StringCmd) (*RefreshTokenData, error) {
+	jsonData, err := cmd.Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	var data RefreshTokenData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *valkeyTokenStore) Consume(ctx context.Context, token string) (*RefreshTokenData, error) {
+	// GETDEL makes sure only one request can consume a token
+	key := refreshTokenPrefix + token
+	data, err := s.get(ctx, key, s.rdb.GetDel(ctx, key))
+	if err != nil {
+		return nil, err
+	}
+	if data.SessionID == uuid.Nil {
+		// Tokens issued before sessions were tracked
+		data.SessionID = uuid.New()
+		data.LastUsedAt = data.CreatedAt
+	}
+
+	if err := s.rdb.SRem(ctx, userTokensPrefix+data.UserID.String(), token).Err(); err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rdb.Set(ctx, usedTokenPrefix+token, jsonData, RefreshTokenExpiry).Err(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *valkeyTokenStore) Used(ctx context.Context, token string) (*RefreshTokenData, error) {
+	return s.get(ctx, usedTokenPrefix+token, s.rdb.Get(ctx, usedTokenPrefix+token))
+}
+
+func (s *valkeyTokenStore) Delete(ctx context.Context, token string) error {
+	// Get the token data first to find the user
+	data, err := s.Get(ctx, token)
+	if err == ErrInvalidRefreshToken {
+		// Token doesn't exist, consider it revoked
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.rdb.Del(ctx, refreshTokenPrefix+token).Err(); err != nil {
+		return err
+	}
+	return s.rdb.SRem(ctx, userTokensPrefix+data.UserID.String(), token).Err()
+}
+
+// tokens returns the user's active tokens by token, dropping expired ones from
+// the user's set
+func (s *valkeyTokenStore) tokens(ctx context.Context, userID uuid.UUID) (map[string]*RefreshTokenData, error) {
+	userTokensKey := userTokensPrefix + userID.String()
+
+	tokens, err := s.rdb.SMembers(ctx, userTokensKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]*RefreshTokenData, len(tokens))
+	for _, token := range tokens {
+		data, err := s.Get(ctx, token)
+		if err == ErrInvalidRefreshToken {
+			s.rdb.SRem(ctx, userTokensKey, token)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		active[token] = data
+	}
+	return active, nil
+}
+
+func (s *valkeyTokenStore) List(ctx context.Context, userID uuid.UUID) ([]RefreshTokenData, error) {
+	tokens, err := s.tokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]RefreshTokenData, 0, len(tokens))
+	for _, data := range tokens {
+		// Tokens issued before sessions were tracked get an ID on their next rotation
+		if data.SessionID != uuid.Nil {
+			sessions = append(sessions, *data)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *valkeyTokenStore) DeleteSession(ctx context.Context, userID, sessionID uuid.UUID) (bool, error) {
+	tokens, err := s.tokens(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	for token, data := range tokens {
+		if data.SessionID != sessionID {
+			continue
+		}
+		if err := s.Delete(ctx, token); err != nil {
+			return false, err
+		}
+		found = true
+	}
+	return found, nil
+}
+
+func (s *valkeyTokenStore) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	userTokensKey := userTokensPrefix + userID.String()
+
+	// Get all tokens for this user
+	tokens, err := s.rdb.SMembers(ctx, userTokensKey).Result()
+	if err != nil {
+		return err
+	}
+
+	// Delete each token
+	for _, token := range tokens {
+		if err := s.rdb.Del(ctx, refreshTokenPrefix+token).Err(); err != nil {
+			return err
+		}
+	}
+
+	// Delete the user's token set
+	return s.rdb.Del(ctx, userTokensKey).Err()
+}
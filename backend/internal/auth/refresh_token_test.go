@@ -55,7 +55,7 @@ func TestStoreAndGetRefreshToken(t *testing.T) {
 	rdb, cleanup := setupTestRedis(t)
 	defer cleanup()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
 	userID := uuid.New()
@@ -68,7 +68,7 @@ func TestStoreAndGetRefreshToken(t *testing.T) {
 	}
 
 	// Store the token
-	err = mgr.StoreRefreshToken(ctx, token, userID, email, name)
+	err = mgr.StoreRefreshToken(ctx, token, userID, email, name, ClientInfo{})
 	if err != nil {
 		t.Fatalf("Failed to store token: %v", err)
 	}
@@ -100,7 +100,7 @@ func TestGetInvalidRefreshToken(t *testing.T) {
 	rdb, cleanup := setupTestRedis(t)
 	defer cleanup()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
 	_, err := mgr.GetRefreshToken(ctx, "nonexistent-token")
@@ -113,14 +113,14 @@ func TestRevokeRefreshToken(t *testing.T) {
 	rdb, cleanup := setupTestRedis(t)
 	defer cleanup()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
 	userID := uuid.New()
 	token, _ := GenerateRefreshToken()
 
 	// Store the token
-	err := mgr.StoreRefreshToken(ctx, token, userID, "test@example.com", "Test")
+	err := mgr.StoreRefreshToken(ctx, token, userID, "test@example.com", "Test", ClientInfo{})
 	if err != nil {
 		t.Fatalf("Failed to store token: %v", err)
 	}
@@ -148,7 +148,7 @@ func TestRevokeAllUserTokens(t *testing.T) {
 	rdb, cleanup := setupTestRedis(t)
 	defer cleanup()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
 	userID := uuid.New()
@@ -159,7 +159,7 @@ func TestRevokeAllUserTokens(t *testing.T) {
 	for i := 0; i < 3; i++ {
 		token, _ := GenerateRefreshToken()
 		tokens[i] = token
-		err := mgr.StoreRefreshToken(ctx, token, userID, email, "Test")
+		err := mgr.StoreRefreshToken(ctx, token, userID, email, "Test", ClientInfo{})
 		if err != nil {
 			t.Fatalf("Failed to store token %d: %v", i, err)
 		}
@@ -192,7 +192,7 @@ func TestRotateRefreshToken(t *testing.T) {
 	rdb, cleanup := setupTestRedis(t)
 	defer cleanup()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
 	userID := uuid.New()
@@ -202,13 +202,13 @@ func TestRotateRefreshToken(t *testing.T) {
 	oldToken, _ := GenerateRefreshToken()
 
 	// Store the original token
-	err := mgr.StoreRefreshToken(ctx, oldToken, userID, email, name)
+	err := mgr.StoreRefreshToken(ctx, oldToken, userID, email, name, ClientInfo{})
 	if err != nil {
 		t.Fatalf("Failed to store token: %v", err)
 	}
 
 	// Rotate the token
-	newToken, data, err := mgr.RotateRefreshToken(ctx, oldToken)
+	newToken, data, err := mgr.RotateRefreshToken(ctx, oldToken, ClientInfo{})
 	if err != nil {
 		t.Fatalf("Failed to rotate token: %v", err)
 	}
@@ -242,10 +242,10 @@ func TestRotateInvalidToken(t *testing.T) {
 	rdb, cleanup := setupTestRedis(t)
 	defer cleanup()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
-	_, _, err := mgr.RotateRefreshToken(ctx, "nonexistent-token")
+	_, _, err := mgr.RotateRefreshToken(ctx, "nonexistent-token", ClientInfo{})
 	if err != ErrInvalidRefreshToken {
 		t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
 	}
@@ -263,13 +263,13 @@ func TestRefreshTokenExpiry(t *testing.T) {
 	})
 	defer rdb.Close()
 
-	mgr := NewRefreshTokenManager(rdb)
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
 	ctx := context.Background()
 
 	userID := uuid.New()
 	token, _ := GenerateRefreshToken()
 
-	err = mgr.StoreRefreshToken(ctx, token, userID, "test@example.com", "Test")
+	err = mgr.StoreRefreshToken(ctx, token, userID, "test@example.com", "Test", ClientInfo{})
 	if err != nil {
 		t.Fatalf("Failed to store token: %v", err)
 	}
@@ -286,3 +286,88 @@ func TestRefreshTokenExpiry(t *testing.T) {
 		t.Errorf("TTL should be around 30 days, got %v", ttl)
 	}
 }
+
+func TestSessions(t *testing.T) {
+	rdb, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
+	ctx := context.Background()
+	userID := uuid.New()
+
+	laptop, _ := GenerateRefreshToken()
+	phone, _ := GenerateRefreshToken()
+	mgr.StoreRefreshToken(ctx, laptop, userID, "test@example.com", "Test", ClientInfo{UserAgent: "Firefox", IP: "10.0.0.1"})
+	mgr.StoreRefreshToken(ctx, phone, userID, "test@example.com", "Test", ClientInfo{UserAgent: "Safari", IP: "10.0.0.2"})
+
+	// Rotation keeps the session and records where it was last used
+	laptop, data, err := mgr.RotateRefreshToken(ctx, laptop, ClientInfo{UserAgent: "Firefox", IP: "10.0.0.3"})
+	if err != nil {
+		t.Fatalf("Failed to rotate token: %v", err)
+	}
+
+	sessions, err := mgr.ListSessions(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].SessionID != data.SessionID || sessions[0].IP != "10.0.0.3" || sessions[0].UserAgent != "Firefox" {
+		t.Errorf("Expected the rotated session first with its latest IP, got %+v", sessions[0])
+	}
+	if sessions[0].LastUsedAt.Before(sessions[0].CreatedAt) {
+		t.Error("Expected last used to be after creation")
+	}
+
+	if err := mgr.RevokeSession(ctx, userID, sessions[1].SessionID); err != nil {
+		t.Fatalf("Failed to revoke session: %v", err)
+	}
+	if _, err := mgr.GetRefreshToken(ctx, phone); err != ErrInvalidRefreshToken {
+		t.Error("Expected the revoked session's token to be invalid")
+	}
+	if _, err := mgr.GetRefreshToken(ctx, laptop); err != nil {
+		t.Errorf("Expected the other session to stay valid: %v", err)
+	}
+
+	if err := mgr.RevokeSession(ctx, userID, sessions[1].SessionID); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if err := mgr.RevokeSession(ctx, uuid.New(), data.SessionID); err != ErrSessionNotFound {
+		t.Errorf("Expected another user's session not to be found, got %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	rdb, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	mgr := NewRefreshTokenManager(NewValkeyTokenStore(rdb))
+	ctx := context.Background()
+	userID := uuid.New()
+
+	first, _ := GenerateRefreshToken()
+	other, _ := GenerateRefreshToken()
+	mgr.StoreRefreshToken(ctx, first, userID, "test@example.com", "Test", ClientInfo{})
+	mgr.StoreRefreshToken(ctx, other, userID, "test@example.com", "Test", ClientInfo{})
+
+	second, _, err := mgr.RotateRefreshToken(ctx, first, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Failed to rotate token: %v", err)
+	}
+	third, _, err := mgr.RotateRefreshToken(ctx, second, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Failed to rotate token: %v", err)
+	}
+
+	// Replaying an already rotated token revokes the whole family
+	if _, _, err := mgr.RotateRefreshToken(ctx, first, ClientInfo{}); err != ErrRefreshTokenReused {
+		t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := mgr.GetRefreshToken(ctx, third); err != ErrInvalidRefreshToken {
+		t.Error("Expected the latest token of the session to be revoked")
+	}
+	if _, err := mgr.GetRefreshToken(ctx, other); err != nil {
+		t.Errorf("Expected other sessions to stay valid: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 test key from RFC 6238 appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	step, ok := ValidateTOTP(secret, code, now)
+	if !ok {
+		t.Fatal("Expected current code to validate")
+	}
+	if step != now.Unix()/30 {
+		t.Errorf("Expected current step, got %d", step)
+	}
+
+	// One step of drift either way is accepted, two is not
+	if _, ok := ValidateTOTP(secret, code, now.Add(30*time.Second)); !ok {
+		t.Error("Expected code from previous step to validate")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(90*time.Second)); ok {
+		t.Error("Expected code from two steps ago to be rejected")
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("Expected short code to be rejected")
+	}
+	if _, ok := ValidateTOTP("not base32!", "123456", now); ok {
+		t.Error("Expected invalid secret to be rejected")
+	}
+}
+
+func TestTOTPURL(t *testing.T) {
+	url := TOTPURL("Dash", "jane@example.com", "ABCDEF")
+	if !strings.HasPrefix(url, "otpauth://totp/Dash:jane@example.com?") {
+		t.Errorf("Unexpected otpauth URL: %s", url)
+	}
+	if !strings.Contains(url, "secret=ABCDEF") || !strings.Contains(url, "issuer=Dash") {
+		t.Errorf("Expected secret and issuer in otpauth URL: %s", url)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatalf("Failed to generate recovery codes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected recovery code format: %s", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate recovery code: %s", code)
+		}
+		seen[code] = true
+	}
+
+	// Hashing ignores case, whitespace and the separator
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" ") {
+		t.Error("Expected normalized recovery codes to hash equally")
+	}
+}
@@ -0,0 +1,90 @@
+package datasource
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// MaxAnnotationEvents bounds the events derived from one annotation query;
+// the most recent are kept
+const MaxAnnotationEvents = 500
+
+// AnnotationEvents derives annotation events from a query result. Metrics
+// results give an event wherever a series' value changes, e.g. the version in
+// a build_info series; logs results give an event per line.
+func AnnotationEvents(result *QueryResult) []models.AnnotationEvent {
+	if result == nil || result.Data == nil {
+		return nil
+	}
+
+	var events []models.AnnotationEvent
+	if result.ResultType == "logs" {
+		for _, entry := range result.Data.Logs {
+			at, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+			if err != nil {
+				continue
+			}
+			events = append(events, models.AnnotationEvent{Time: at, Text: entry.Line})
+		}
+	} else {
+		for _, series := range result.Data.Result {
+			events = append(events, seriesChanges(series)...)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	if len(events) > MaxAnnotationEvents {
+		events = events[len(events)-MaxAnnotationEvents:]
+	}
+	return events
+}
+
+// seriesChanges returns an event at each point whose value differs from the
+// point before it
+func seriesChanges(series MetricResult) []models.AnnotationEvent {
+	var events []models.AnnotationEvent
+	var previous string
+	seen := false
+	for _, point := range series.Values {
+		if len(point) < 2 {
+			continue
+		}
+		ts, ok := point[0].(float64)
+		value, isString := point[1].(string)
+		if !ok || !isString {
+			continue
+		}
+		if seen && value != previous {
+			sec, frac := math.Modf(ts)
+			events = append(events, models.AnnotationEvent{
+				Time: time.Unix(int64(sec), int64(frac*1e9)).UTC(),
+				Text: fmt.Sprintf("%s changed from %s to %s", seriesName(series.Metric), previous, value),
+			})
+		}
+		previous, seen = value, true
+	}
+	return events
+}
+
+// seriesName renders a series as name{k="v", ...}
+func seriesName(metric map[string]string) string {
+	keys := make([]string, 0, len(metric))
+	for k := range metric {
+		if k != "__name__" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + strconv.Quote(metric[k])
+	}
+	return metric["__name__"] + "{" + strings.Join(pairs, ", ") + "}"
+}
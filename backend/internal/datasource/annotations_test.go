@@ -0,0 +1,55 @@
+package datasource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnnotationEventsSeriesChanges(t *testing.T) {
+	result := &QueryResult{
+		Status:     "success",
+		ResultType: "metrics",
+		Data: &QueryData{
+			Result: []MetricResult{{
+				Metric: map[string]string{"__name__": "build_info", "version": "x", "app": "api"},
+				Values: [][]interface{}{
+					{float64(100), "1"},
+					{float64(115), "1"},
+					{float64(130), "2"},
+					{float64(145), "2"},
+					{float64(160), "1"},
+				},
+			}},
+		},
+	}
+
+	events := AnnotationEvents(result)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", events)
+	}
+	if !events[0].Time.Equal(time.Unix(130, 0)) || events[0].Text != `build_info{app="api", version="x"} changed from 1 to 2` {
+		t.Errorf("Unexpected first event %+v", events[0])
+	}
+	if !events[1].Time.Equal(time.Unix(160, 0)) {
+		t.Errorf("Unexpected second event %+v", events[1])
+	}
+}
+
+func TestAnnotationEventsLogs(t *testing.T) {
+	result := &QueryResult{
+		Status:     "success",
+		ResultType: "logs",
+		Data: &QueryData{
+			Logs: []LogEntry{
+				{Timestamp: "2026-01-01T00:00:02Z", Line: "deployed v2"},
+				{Timestamp: "not a time", Line: "skipped"},
+				{Timestamp: "2026-01-01T00:00:01Z", Line: "deployed v1"},
+			},
+		},
+	}
+
+	events := AnnotationEvents(result)
+	if len(events) != 2 || events[0].Text != "deployed v1" || events[1].Text != "deployed v2" {
+		t.Errorf("Expected the valid lines oldest first, got %+v", events)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/janhoon/dash/backend/internal/models"
 )
 
@@ -15,14 +16,26 @@ type QueryRequest struct {
 	End   int64  `json:"end"`   // Unix timestamp in seconds
 	Step  int64  `json:"step"`  // Step interval in seconds
 	Limit int    `json:"limit"` // Max results for log queries
+
+	// Log query paging: "backward" (newest first, the default) or "forward",
+	// continuing from a previous page's next_cursor
+	Direction string `json:"direction,omitempty"`
+	Cursor    string `json:"cursor,omitempty"`
+
+	// Set by dashboard panels to get the annotations to draw with the data
+	DashboardID *uuid.UUID `json:"dashboard_id,omitempty"`
+	PanelID     *uuid.UUID `json:"panel_id,omitempty"`
 }
 
 // QueryResult is the unified query result format
 type QueryResult struct {
-	Status     string      `json:"status"`
-	Data       *QueryData  `json:"data,omitempty"`
-	Error      string      `json:"error,omitempty"`
-	ResultType string      `json:"resultType"` // "metrics" or "logs"
+	Status     string     `json:"status"`
+	Data       *QueryData `json:"data,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ResultType string     `json:"resultType"` // "metrics" or "logs"
+
+	Annotations []models.AnnotationEvent `json:"annotations,omitempty"`
+	NextCursor  string                   `json:"next_cursor,omitempty"` // set when a log query has more lines
 }
 
 // QueryData contains the result
@@ -43,6 +56,7 @@ type LogEntry struct {
 	Timestamp string            `json:"timestamp"`
 	Line      string            `json:"line"`
 	Labels    map[string]string `json:"labels,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"` // parsed from a JSON or logfmt line
 	Level     string            `json:"level,omitempty"`
 }
 
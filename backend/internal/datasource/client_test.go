@@ -86,10 +86,20 @@ func TestDetectLogLevel(t *testing.T) {
 		{map[string]string{}, "INFO starting service", "info"},
 		{map[string]string{}, "DEBUG verbose output", "debug"},
 		{map[string]string{}, "just a regular log line", ""},
+		{map[string]string{}, "no errors found", ""},
+		{map[string]string{}, "information about the request", ""},
+		{map[string]string{}, "2026-01-02 15:04:05 [warn] disk low", "warning"},
+		{map[string]string{}, `{"level":"warn","msg":"slow query"}`, "warning"},
+		{map[string]string{}, `{"log":{"level":"FATAL"},"msg":"no errors"}`, "critical"},
+		{map[string]string{}, `{"severity_number":17,"body":"request failed"}`, "error"},
+		{map[string]string{}, `{"severity":3,"msg":"disk failure"}`, "error"},
+		{map[string]string{}, `level=info msg="no errors found"`, "info"},
+		{map[string]string{}, `lvl=dbg msg=starting`, "debug"},
+		{map[string]string{"level": "info"}, `level=error msg=failed`, "info"},
 	}
 
 	for _, tt := range tests {
-		got := detectLogLevel(tt.labels, tt.line)
+		got := detectLogLevel(tt.labels, ParseLine(tt.line), tt.line)
 		if got != tt.want {
 			t.Errorf("detectLogLevel(%v, %q) = %q, want %q", tt.labels, tt.line, got, tt.want)
 		}
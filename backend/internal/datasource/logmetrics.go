@@ -0,0 +1,86 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LogMetricsClient is implemented by log datasources that can also compute
+// metrics over their logs, which lets alert rules target them
+type LogMetricsClient interface {
+	Client
+
+	// QueryMetrics runs a metric query over logs and returns a matrix, like a
+	// metrics datasource's Query
+	QueryMetrics(ctx context.Context, query string, start, end time.Time, step time.Duration) (*QueryResult, error)
+
+	// LogQuery returns the log query a metric query aggregates, used to fetch
+	// the lines behind an alert. It is empty when none can be found.
+	LogQuery(metricQuery string) string
+}
+
+// getMatrix fetches a Prometheus-style matrix response, as returned by Loki's
+// and Victoria Logs' range endpoints for metric queries
+func getMatrix(ctx context.Context, client *http.Client, reqURL, source string) (*QueryResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", source, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Both report bad queries as plain text with a 4xx status
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			msg = fmt.Sprintf("%s returned status %d", source, resp.StatusCode)
+		}
+		return &QueryResult{
+			Status:     "error",
+			Error:      msg,
+			ResultType: "metrics",
+		}, nil
+	}
+
+	var matrix vmQueryResponse
+	if err := json.Unmarshal(body, &matrix); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if matrix.Status != "success" {
+		return &QueryResult{
+			Status:     "error",
+			Error:      matrix.Error,
+			ResultType: "metrics",
+		}, nil
+	}
+
+	result := &QueryResult{
+		Status:     "success",
+		ResultType: "metrics",
+		Data: &QueryData{
+			ResultType: matrix.Data.ResultType,
+			Result:     make([]MetricResult, len(matrix.Data.Result)),
+		},
+	}
+	for i, r := range matrix.Data.Result {
+		result.Data.Result[i] = MetricResult{
+			Metric: r.Metric,
+			Values: r.Values,
+		}
+	}
+	return result, nil
+}
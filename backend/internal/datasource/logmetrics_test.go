@@ -0,0 +1,90 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLokiLogQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`count_over_time({app="api"}[5m])`, `{app="api"}`},
+		{`sum by (app) (rate({app="api"} |= "error" [1m]))`, `{app="api"} |= "error"`},
+		{`count_over_time({app="api"} |~ "code=[45].." [5m])`, `{app="api"} |~ "code=[45].."`},
+		{"count_over_time({app=\"api\"} |~ `\\[warn\\]` [5m])", "{app=\"api\"} |~ `\\[warn\\]`"},
+		{`sum_over_time({app="api"} | logfmt | unwrap latency [5m])`, `{app="api"} | logfmt`},
+		{`vector(1)`, ``},
+	}
+	client, _ := NewLokiClient("http://localhost:3100")
+	for _, tt := range tests {
+		if got := client.LogQuery(tt.query); got != tt.want {
+			t.Errorf("LogQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestVictoriaLogsLogQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`error | stats count() as errors`, `error`},
+		{`_stream:{app="api"} error |stats by (host) count()`, `_stream:{app="api"} error`},
+		{`* | stats count()`, `*`},
+		{`| stats count()`, `*`},
+		{`error`, ``},
+	}
+	client, _ := NewVictoriaLogsClient("http://localhost:9428")
+	for _, tt := range tests {
+		if got := client.LogQuery(tt.query); got != tt.want {
+			t.Errorf("LogQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestVictoriaLogsQueryMetrics(t *testing.T) {
+	var gotPath, gotStep string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotStep = r.URL.Query().Get("step")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"errors","host":"a"},"values":[[1700000000,"4"]]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewVictoriaLogsClient(server.URL)
+	end := time.Now()
+	result, err := client.QueryMetrics(context.Background(), "error | stats by (host) count() as errors", end.Add(-5*time.Minute), end, time.Minute)
+	if err != nil {
+		t.Fatalf("QueryMetrics failed: %v", err)
+	}
+	if gotPath != "/select/logsql/stats_query_range" || gotStep != "60s" {
+		t.Errorf("Unexpected request %s step=%s", gotPath, gotStep)
+	}
+	if result.Status != "success" || len(result.Data.Result) != 1 || result.Data.Result[0].Metric["host"] != "a" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestLokiQueryMetricsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "parse error : syntax error: unexpected IDENTIFIER", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client, _ := NewLokiClient(server.URL)
+	end := time.Now()
+	result, err := client.QueryMetrics(context.Background(), "count_over_time(oops)", end.Add(-time.Minute), end, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected the error in the result, got %v", err)
+	}
+	if result.Status != "error" || result.Error != "parse error : syntax error: unexpected IDENTIFIER" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
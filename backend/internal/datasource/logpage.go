@@ -0,0 +1,251 @@
+package datasource
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Log line limits: a query without a limit gets DefaultLogLimit lines, and no
+// query gets more than the server maximum, DefaultMaxLogLimit unless changed
+// with SetMaxLogLimit
+const (
+	DefaultLogLimit    = 1000
+	DefaultMaxLogLimit = 5000
+)
+
+var maxLogLimit atomic.Int64
+
+// SetMaxLogLimit sets the most lines one log query can return
+func SetMaxLogLimit(n int) {
+	maxLogLimit.Store(int64(n))
+}
+
+// MaxLogLimit returns the most lines one log query can return
+func MaxLogLimit() int {
+	if n := maxLogLimit.Load(); n > 0 {
+		return int(n)
+	}
+	return DefaultMaxLogLimit
+}
+
+// LogLimit applies the default and maximum to a requested number of lines
+func LogLimit(limit int) int {
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	return min(limit, MaxLogLimit())
+}
+
+// Directions to page through log lines in
+const (
+	LogBackward = "backward" // newest first
+	LogForward  = "forward"  // oldest first
+)
+
+// ErrInvalidLogCursor is returned for a cursor QueryLogs didn't produce
+var ErrInvalidLogCursor = errors.New("invalid cursor")
+
+// LogQueryOptions select a page of a log query's lines
+type LogQueryOptions struct {
+	Limit     int
+	Direction string // LogBackward (the default) or LogForward
+	Cursor    string // a previous page's next cursor; its direction wins
+}
+
+// Validate checks the direction and cursor
+func (o LogQueryOptions) Validate() error {
+	if o.Direction != "" && o.Direction != LogBackward && o.Direction != LogForward {
+		return errors.New("direction must be backward or forward")
+	}
+	if o.Cursor != "" {
+		if _, err := decodeLogCursor(o.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogPager is a log datasource whose lines can be paged through and
+// streamed as they are read
+type LogPager interface {
+	Client
+	// QueryLogs calls emit with each line of a page, in the page's order, and
+	// returns the cursor of the next page ("" on the last page). Errors the
+	// datasource reports about the query are *QueryError.
+	QueryLogs(ctx context.Context, query string, start, end time.Time, opts LogQueryOptions, emit func(LogEntry) error) (string, error)
+}
+
+// QueryError is an error the datasource reported about a query, such as a
+// syntax error, as opposed to a failure to reach it
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+// LogPage collects a page of a log query into a QueryResult
+func LogPage(ctx context.Context, pager LogPager, query string, start, end time.Time, opts LogQueryOptions) (*QueryResult, error) {
+	logs := []LogEntry{}
+	next, err := pager.QueryLogs(ctx, query, start, end, opts, func(entry LogEntry) error {
+		logs = append(logs, entry)
+		return nil
+	})
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		return &QueryResult{
+			Status:     "error",
+			Error:      queryErr.Message,
+			ResultType: "logs",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResult{
+		Status:     "success",
+		ResultType: "logs",
+		Data: &QueryData{
+			ResultType: "streams",
+			Logs:       logs,
+		},
+		NextCursor: next,
+	}, nil
+}
+
+// logCursor is where a page ended: the last line's time, and how many lines
+// at that time were already returned
+type logCursor struct {
+	forward bool
+	at      time.Time
+	skip    int
+}
+
+func encodeLogCursor(c logCursor) string {
+	direction := "b"
+	if c.forward {
+		direction = "f"
+	}
+	raw := fmt.Sprintf("%s|%d|%d", direction, c.at.UnixNano(), c.skip)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLogCursor(cursor string) (logCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return logCursor{}, ErrInvalidLogCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || (parts[0] != "b" && parts[0] != "f") {
+		return logCursor{}, ErrInvalidLogCursor
+	}
+	nsec, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return logCursor{}, ErrInvalidLogCursor
+	}
+	// Pages never skip as many lines as one query can return
+	skip, err := strconv.Atoi(parts[2])
+	if err != nil || skip < 0 || skip >= MaxLogLimit() {
+		return logCursor{}, ErrInvalidLogCursor
+	}
+	return logCursor{forward: parts[0] == "f", at: time.Unix(0, nsec), skip: skip}, nil
+}
+
+// fetchLogsFunc reads up to limit lines between start and end from a
+// datasource, calling emit with each in order: oldest first when forward,
+// newest first otherwise. A non-nil error from emit stops the read and is
+// returned.
+type fetchLogsFunc func(ctx context.Context, query string, start, end time.Time, forward bool, limit int,
+	emit func(LogEntry) error) error
+
+// errPageFull stops a fetch once the page has all its lines
+var errPageFull = errors.New("page full")
+
+// pageLogs implements QueryLogs over a datasource's fetch. Pages continue
+// from the time of the cursor's line, skipping the lines at that time that
+// were already returned, so lines sharing a timestamp aren't lost or repeated
+// across pages. Fetches stay within MaxLogLimit, so a page after many such
+// lines can be short; one of more lines sharing a timestamp than a query can
+// return moves on past that timestamp. A full page always has a next cursor,
+// so the last page can be empty.
+func pageLogs(ctx context.Context, fetch fetchLogsFunc, query string, start, end time.Time, opts LogQueryOptions,
+	emit func(LogEntry) error) (string, error) {
+	limit := LogLimit(opts.Limit)
+	forward := opts.Direction == LogForward
+
+	var from *logCursor
+	if opts.Cursor != "" {
+		c, err := decodeLogCursor(opts.Cursor)
+		if err != nil {
+			return "", err
+		}
+		from, forward = &c, c.forward
+		if forward && c.at.After(start) {
+			start = c.at
+		}
+		if !forward && c.at.Before(end) {
+			end = c.at.Add(time.Nanosecond)
+		}
+	}
+
+	fetchLimit := limit
+	if from != nil {
+		fetchLimit = min(limit+from.skip, MaxLogLimit())
+	}
+
+	fetched, sent, skipped := 0, 0, 0
+	var last logCursor
+	err := fetch(ctx, query, start, end, forward, fetchLimit, func(entry LogEntry) error {
+		fetched++
+		at, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+		if err == nil && from != nil {
+			if forward && at.Before(from.at) || !forward && at.After(from.at) {
+				return nil
+			}
+			if at.Equal(from.at) && skipped < from.skip {
+				skipped++
+				return nil
+			}
+		}
+		if sent == limit {
+			return errPageFull
+		}
+		if err == nil {
+			if at.Equal(last.at) {
+				last.skip++
+			} else {
+				last.at, last.skip = at, 1
+			}
+		}
+		sent++
+		return emit(entry)
+	})
+	if err != nil && err != errPageFull {
+		return "", err
+	}
+
+	if sent < limit && fetched < fetchLimit || last.at.IsZero() {
+		return "", nil
+	}
+	last.forward = forward
+	if from != nil && last.at.Equal(from.at) {
+		last.skip += from.skip
+	}
+	if last.skip >= MaxLogLimit() {
+		if forward {
+			last.at = last.at.Add(time.Nanosecond)
+		} else {
+			last.at = last.at.Add(-time.Nanosecond)
+		}
+		last.skip = 0
+	}
+	return encodeLogCursor(last), nil
+}
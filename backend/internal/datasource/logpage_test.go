@@ -0,0 +1,208 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLogs serves lines at the given seconds, like a datasource would
+func fakeLogs(seconds ...int) fetchLogsFunc {
+	return func(ctx context.Context, query string, start, end time.Time, forward bool, limit int, emit func(LogEntry) error) error {
+		var lines []LogEntry
+		for i, sec := range seconds {
+			at := time.Unix(int64(sec), 0)
+			if at.Before(start) || !at.Before(end) {
+				continue
+			}
+			lines = append(lines, LogEntry{Timestamp: at.UTC().Format(time.RFC3339Nano), Line: fmt.Sprintf("line %d", i)})
+		}
+		if !forward {
+			for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+				lines[i], lines[j] = lines[j], lines[i]
+			}
+		}
+		for _, line := range lines[:min(limit, len(lines))] {
+			if err := emit(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func readPages(t *testing.T, fetch fetchLogsFunc, opts LogQueryOptions) []string {
+	t.Helper()
+	var got []string
+	for page := 0; page < 10; page++ {
+		next, err := pageLogs(context.Background(), fetch, "q", time.Unix(0, 0), time.Unix(100, 0), opts,
+			func(entry LogEntry) error {
+				got = append(got, entry.Line)
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("pageLogs failed: %v", err)
+		}
+		if next == "" {
+			return got
+		}
+		opts.Cursor = next
+	}
+	t.Fatal("Pages didn't end")
+	return nil
+}
+
+func TestPageLogsBackward(t *testing.T) {
+	// Lines 1-3 share a timestamp that falls across a page boundary
+	fetch := fakeLogs(10, 20, 20, 20, 30)
+	got := readPages(t, fetch, LogQueryOptions{Limit: 2})
+	want := "line 4,line 3,line 2,line 1,line 0"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+}
+
+func TestPageLogsForward(t *testing.T) {
+	fetch := fakeLogs(10, 20, 20, 20, 30)
+	got := readPages(t, fetch, LogQueryOptions{Limit: 2, Direction: LogForward})
+	want := "line 0,line 1,line 2,line 3,line 4"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+}
+
+func TestPageLogsStayWithinMaxLimit(t *testing.T) {
+	defer SetMaxLogLimit(0)
+	SetMaxLogLimit(4)
+
+	lines := fakeLogs(10, 20, 20, 20, 30, 40)
+	largest := 0
+	fetch := func(ctx context.Context, query string, start, end time.Time, forward bool, limit int, emit func(LogEntry) error) error {
+		largest = max(largest, limit)
+		return lines(ctx, query, start, end, forward, limit, emit)
+	}
+
+	// Skipping the lines already returned leaves a short page, which still
+	// has a next cursor
+	got := readPages(t, fetch, LogQueryOptions{Limit: 4, Direction: LogForward})
+	want := "line 0,line 1,line 2,line 3,line 4,line 5"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+	if largest > MaxLogLimit() {
+		t.Errorf("Expected fetches within the maximum, got a limit of %d", largest)
+	}
+}
+
+func TestPageLogsMovePastCrowdedTimestamp(t *testing.T) {
+	defer SetMaxLogLimit(0)
+	SetMaxLogLimit(3)
+
+	// More lines share a timestamp than one query can return; paging moves on
+	// rather than asking for more
+	got := readPages(t, fakeLogs(10, 20, 20, 20, 20, 30), LogQueryOptions{Limit: 2, Direction: LogForward})
+	want := "line 0,line 1,line 2,line 3,line 5"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+}
+
+func TestLogQueryOptionsValidate(t *testing.T) {
+	if err := (LogQueryOptions{Direction: "sideways"}).Validate(); err == nil {
+		t.Error("Expected an invalid direction to be rejected")
+	}
+	if err := (LogQueryOptions{Cursor: "bm9wZQ"}).Validate(); err != ErrInvalidLogCursor {
+		t.Errorf("Expected ErrInvalidLogCursor, got %v", err)
+	}
+	cursor := encodeLogCursor(logCursor{forward: true, at: time.Unix(5, 7), skip: 2})
+	if err := (LogQueryOptions{Cursor: cursor}).Validate(); err != nil {
+		t.Errorf("Expected a cursor from a page to be valid, got %v", err)
+	}
+	forged := encodeLogCursor(logCursor{at: time.Unix(5, 7), skip: MaxLogLimit()})
+	if err := (LogQueryOptions{Cursor: forged}).Validate(); err != ErrInvalidLogCursor {
+		t.Errorf("Expected a cursor skipping a whole query to be rejected, got %v", err)
+	}
+}
+
+func TestLogLimit(t *testing.T) {
+	defer SetMaxLogLimit(0)
+
+	if got := LogLimit(0); got != DefaultLogLimit {
+		t.Errorf("LogLimit(0) = %d, want %d", got, DefaultLogLimit)
+	}
+	if got := LogLimit(1_000_000); got != DefaultMaxLogLimit {
+		t.Errorf("LogLimit(1000000) = %d, want %d", got, DefaultMaxLogLimit)
+	}
+	SetMaxLogLimit(50)
+	if got := LogLimit(0); got != 50 {
+		t.Errorf("Expected the default to respect the server maximum, got %d", got)
+	}
+}
+
+func TestLokiQueryMergesStreams(t *testing.T) {
+	var gotLimit, gotDirection string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLimit = r.URL.Query().Get("limit")
+		gotDirection = r.URL.Query().Get("direction")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[
+			{"stream":{"app":"api"},"values":[["3000000000","api 3"],["1000000000","api 1"]]},
+			{"stream":{"app":"web"},"values":[["2000000000","web 2"]]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewLokiClient(server.URL)
+	result, err := client.QueryLogs(context.Background(), `{env="prod"}`, time.Unix(0, 0), time.Unix(10, 0),
+		LogQueryOptions{Limit: 3}, func(entry LogEntry) error { return nil })
+	if err != nil {
+		t.Fatalf("QueryLogs failed: %v", err)
+	}
+	if result == "" {
+		t.Error("Expected a next cursor for a full page")
+	}
+	if gotLimit != "3" || gotDirection != "backward" {
+		t.Errorf("Unexpected request limit=%s direction=%s", gotLimit, gotDirection)
+	}
+
+	page, err := client.Query(context.Background(), `{env="prod"}`, time.Unix(0, 0), time.Unix(10, 0), 0, 0)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if gotLimit != fmt.Sprint(DefaultLogLimit) {
+		t.Errorf("Expected the default limit, got %s", gotLimit)
+	}
+	var lines []string
+	for _, entry := range page.Data.Logs {
+		lines = append(lines, entry.Line)
+	}
+	if strings.Join(lines, ",") != "api 3,web 2,api 1" || page.NextCursor != "" {
+		t.Errorf("Expected lines newest first across streams, got %v cursor=%q", lines, page.NextCursor)
+	}
+}
+
+func TestVictoriaLogsQuerySortsAndLimits(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query().Get("query")
+		fmt.Fprintln(w, `{"_msg":"first","_time":"2026-01-01T00:00:00Z"}`)
+		fmt.Fprintln(w, `{"_msg":"second","_time":"2026-01-01T00:00:01Z"}`)
+	}))
+	defer server.Close()
+
+	client, _ := NewVictoriaLogsClient(server.URL)
+	result, err := LogPage(context.Background(), client, "error", time.Unix(0, 0), time.Now(),
+		LogQueryOptions{Limit: 2, Direction: LogForward})
+	if err != nil {
+		t.Fatalf("LogPage failed: %v", err)
+	}
+	if gotQuery != "error | sort by (_time) limit 2" {
+		t.Errorf("Unexpected query %q", gotQuery)
+	}
+	if len(result.Data.Logs) != 2 || result.NextCursor == "" {
+		t.Errorf("Expected a full page with a cursor, got %+v", result)
+	}
+}
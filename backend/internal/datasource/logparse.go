@@ -0,0 +1,233 @@
+package datasource
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Log levels, normalised from the many spellings loggers use
+const (
+	LevelTrace    = "trace"
+	LevelDebug    = "debug"
+	LevelInfo     = "info"
+	LevelWarning  = "warning"
+	LevelError    = "error"
+	LevelCritical = "critical"
+)
+
+// levelNames maps level spellings, lower-cased, to a level
+var levelNames = map[string]string{
+	"trace": LevelTrace, "trc": LevelTrace,
+	"debug": LevelDebug, "dbg": LevelDebug,
+	"info": LevelInfo, "inf": LevelInfo, "information": LevelInfo, "informational": LevelInfo, "notice": LevelInfo,
+	"warn": LevelWarning, "warning": LevelWarning, "wrn": LevelWarning,
+	"error": LevelError, "err": LevelError, "eror": LevelError,
+	"critical": LevelCritical, "crit": LevelCritical, "fatal": LevelCritical, "panic": LevelCritical,
+	"alert": LevelCritical, "emerg": LevelCritical, "emergency": LevelCritical,
+}
+
+// Keys holding a level name, or a syslog severity (0-7) when numeric,
+// in the order they are looked for
+var levelKeys = []string{
+	"level", "lvl", "severity", "log.level", "loglevel", "log_level", "detected_level",
+	"severity_text", "severityText", "SeverityText", "levelname", "@l",
+}
+
+// Keys holding an OpenTelemetry severity number (1-24)
+var severityNumberKeys = []string{"severity_number", "severityNumber", "SeverityNumber"}
+
+// syslogLevels maps syslog severities, 0 (emergency) to 7 (debug)
+var syslogLevels = []string{
+	LevelCritical, LevelCritical, LevelCritical, LevelError, LevelWarning, LevelInfo, LevelInfo, LevelDebug,
+}
+
+// otelLevel maps an OpenTelemetry severity number: each level has four
+func otelLevel(n int) string {
+	levels := []string{LevelTrace, LevelDebug, LevelInfo, LevelWarning, LevelError, LevelCritical}
+	if n < 1 || n > 24 {
+		return ""
+	}
+	return levels[(n-1)/4]
+}
+
+// maxFieldDepth bounds how deeply nested JSON objects are flattened
+const maxFieldDepth = 5
+
+// ParseLine parses a JSON object or logfmt line into fields. Nested JSON
+// objects are flattened into dotted keys (log.level), and values that aren't
+// strings are kept as their JSON. Lines in neither format give nil.
+func ParseLine(line string) map[string]string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		return parseJSONLine(trimmed)
+	}
+	return parseLogfmt(trimmed)
+}
+
+func parseJSONLine(line string) map[string]string {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil
+	}
+	fields := make(map[string]string, len(raw))
+	flattenJSON(fields, "", raw, 0)
+	return fields
+}
+
+func flattenJSON(fields map[string]string, prefix string, obj map[string]any, depth int) {
+	for k, v := range obj {
+		key := prefix + k
+		switch v := v.(type) {
+		case string:
+			fields[key] = v
+		case json.Number:
+			fields[key] = v.String()
+		case nil:
+		case map[string]any:
+			if depth < maxFieldDepth {
+				flattenJSON(fields, key+".", v, depth+1)
+				continue
+			}
+			encoded, _ := json.Marshal(v)
+			fields[key] = string(encoded)
+		default:
+			encoded, _ := json.Marshal(v)
+			fields[key] = string(encoded)
+		}
+	}
+}
+
+// parseLogfmt parses key=value pairs, with values optionally double quoted.
+// Every token must be a pair, so prose with an "=" in it isn't taken for
+// logfmt.
+func parseLogfmt(line string) map[string]string {
+	fields := make(map[string]string)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		keyStart := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' && line[i] != '"' {
+			i++
+		}
+		if i == keyStart || i == len(line) || line[i] != '=' {
+			return nil
+		}
+		key := line[keyStart:i]
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+
+		valueStart := i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[valueStart:i]
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// detectLogLevel finds a line's level: from its labels, then from the fields
+// parsed from it, then from a level word at the start of the text
+func detectLogLevel(labels, fields map[string]string, line string) string {
+	if level := fieldLevel(labels); level != "" {
+		return level
+	}
+	if level := fieldLevel(fields); level != "" {
+		return level
+	}
+	return textLevel(line)
+}
+
+// fieldLevel reads the level from the first well-known key present. Level
+// names that aren't known are kept, lower-cased.
+func fieldLevel(fields map[string]string) string {
+	for _, key := range levelKeys {
+		value, ok := fields[key]
+		if !ok || value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err == nil {
+			if n >= 0 && n < len(syslogLevels) {
+				return syslogLevels[n]
+			}
+			continue
+		}
+		if level, ok := levelNames[strings.ToLower(value)]; ok {
+			return level
+		}
+		return strings.ToLower(value)
+	}
+	for _, key := range severityNumberKeys {
+		if n, err := strconv.Atoi(fields[key]); err == nil {
+			if level := otelLevel(n); level != "" {
+				return level
+			}
+		}
+	}
+	return ""
+}
+
+// textLevels are the level words recognised in unstructured lines, leaving
+// out ones also common as plain words, such as "alert" or "notice"
+var textLevels = map[string]string{
+	"trace": LevelTrace,
+	"debug": LevelDebug, "dbg": LevelDebug,
+	"info": LevelInfo,
+	"warn": LevelWarning, "warning": LevelWarning,
+	"error": LevelError, "err": LevelError,
+	"critical": LevelCritical, "crit": LevelCritical, "fatal": LevelCritical, "panic": LevelCritical,
+}
+
+// maxLevelTokens is how many leading words of a line can hold its level,
+// such as in "2026-01-02 15:04:05 [WARN] disk low"
+const maxLevelTokens = 4
+
+// textLevel looks for a level word among the first words of an unstructured
+// line. Words must match whole, and be upper case or set off with brackets
+// or a colon, so "no errors found" or "information" aren't taken for levels.
+func textLevel(line string) string {
+	words := strings.Fields(line)
+	for i, word := range words {
+		if i == maxLevelTokens {
+			break
+		}
+		name := strings.Trim(word, "[]()<>:|")
+		level, ok := textLevels[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		setOff := len(name) != len(word)
+		if setOff || strings.IndexFunc(name, unicode.IsLower) < 0 {
+			return level
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package datasource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want map[string]string
+	}{
+		{
+			name: "json",
+			line: `{"level":"info","msg":"done","status":200,"ok":true,"tags":["a","b"],"err":null}`,
+			want: map[string]string{"level": "info", "msg": "done", "status": "200", "ok": "true", "tags": `["a","b"]`},
+		},
+		{
+			name: "nested json",
+			line: `{"log":{"level":"warn","origin":{"file":"main.go"}},"message":"slow"}`,
+			want: map[string]string{"log.level": "warn", "log.origin.file": "main.go", "message": "slow"},
+		},
+		{
+			name: "logfmt",
+			line: `ts=2026-01-02T15:04:05Z level=error msg="request failed: \"timeout\"" duration=1.5s empty=`,
+			want: map[string]string{
+				"ts": "2026-01-02T15:04:05Z", "level": "error", "msg": `request failed: "timeout"`,
+				"duration": "1.5s", "empty": "",
+			},
+		},
+		{name: "plain text", line: "GET /index.html 200", want: nil},
+		{name: "text with pairs", line: "retrying with attempt=3", want: nil},
+		{name: "unterminated quote", line: `msg="oops`, want: nil},
+		{name: "invalid json", line: `{"level":`, want: nil},
+		{name: "empty", line: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseLine(tt.line)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLine(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldLevel_SeverityNumbers(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{map[string]string{"level": "0"}, "critical"},
+		{map[string]string{"severity": "4"}, "warning"},
+		{map[string]string{"lvl": "6"}, "info"},
+		{map[string]string{"level": "7"}, "debug"},
+		{map[string]string{"level": "42"}, ""},
+		{map[string]string{"SeverityNumber": "1"}, "trace"},
+		{map[string]string{"severityNumber": "9"}, "info"},
+		{map[string]string{"severity_number": "24"}, "critical"},
+		{map[string]string{"severity_number": "25"}, ""},
+		{map[string]string{"level": "Notice"}, "info"},
+		{map[string]string{"level": "verbose"}, "verbose"},
+	}
+
+	for _, tt := range tests {
+		if got := fieldLevel(tt.fields); got != tt.want {
+			t.Errorf("fieldLevel(%v) = %q, want %q", tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package datasource
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// LogStatsClient is a log datasource that can summarise a log query for
+// exploring it
+type LogStatsClient interface {
+	LogPager
+	LogMetricsClient
+
+	// VolumeQuery returns the metric query counting a log query's lines per
+	// step, by level
+	VolumeQuery(query string, step time.Duration) string
+}
+
+// Log stats settings
+const (
+	// maxVolumeBuckets bounds the points of each volume series when the step
+	// is picked from the time range
+	maxVolumeBuckets = 120
+	// fieldSampleLines is how many recent lines field stats are based on
+	fieldSampleLines = 1000
+	// MaxFieldValues is how many of a field's most common values are listed
+	MaxFieldValues = 10
+)
+
+// LogStats summarises the lines of a log query: how many there are over time,
+// by level, and the most common values of their fields or labels
+type LogStats struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// Volume has a series per level, labelled with just the level ("unknown"
+	// for lines without one)
+	Volume []MetricResult `json:"volume"`
+	Step   int64          `json:"step"` // seconds per volume point
+
+	Fields     []FieldStats `json:"fields"`
+	SampleSize int          `json:"sample_size"` // the recent lines Fields are based on
+}
+
+// FieldStats describes the values of one field or label in a sample of lines
+type FieldStats struct {
+	Name     string       `json:"name"`
+	Count    int          `json:"count"`    // lines with the field
+	Distinct int          `json:"distinct"` // distinct values
+	Values   []FieldValue `json:"values"`   // the most common, most common first
+}
+
+type FieldValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// VolumeStep picks a volume step for a time range: whole seconds, giving at
+// most maxVolumeBuckets points
+func VolumeStep(start, end time.Time) time.Duration {
+	seconds := int64(math.Ceil(end.Sub(start).Seconds() / maxVolumeBuckets))
+	return time.Duration(max(seconds, 1)) * time.Second
+}
+
+// QueryLogStats summarises a log query between start and end, counting its
+// volume per step
+func QueryLogStats(ctx context.Context, client LogStatsClient, query string, start, end time.Time, step time.Duration) (*LogStats, error) {
+	volume, err := client.QueryMetrics(ctx, client.VolumeQuery(query, step), start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	if volume.Status != "success" {
+		return &LogStats{Status: "error", Error: volume.Error}, nil
+	}
+
+	sample, err := LogPage(ctx, client, query, start, end, LogQueryOptions{Limit: fieldSampleLines})
+	if err != nil {
+		return nil, err
+	}
+	if sample.Status != "success" {
+		return &LogStats{Status: "error", Error: sample.Error}, nil
+	}
+
+	stats := &LogStats{
+		Status:     "success",
+		Volume:     volumeByLevel(volume),
+		Step:       int64(step.Seconds()),
+		Fields:     SummarizeFields(sample.Data.Logs, MaxFieldValues),
+		SampleSize: len(sample.Data.Logs),
+	}
+	return stats, nil
+}
+
+// volumeByLevel relabels volume series with just their level
+func volumeByLevel(result *QueryResult) []MetricResult {
+	series := []MetricResult{}
+	if result.Data == nil {
+		return series
+	}
+	for _, s := range result.Data.Result {
+		level := s.Metric["level"]
+		if level == "" {
+			level = "unknown"
+		}
+		series = append(series, MetricResult{Metric: map[string]string{"level": level}, Values: s.Values})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Metric["level"] < series[j].Metric["level"] })
+	return series
+}
+
+// SummarizeFields counts the values of each label and parsed field across
+// lines, listing the fields found on the most lines first with up to
+// topValues of their values. A label hides a parsed field of the same name.
+func SummarizeFields(logs []LogEntry, topValues int) []FieldStats {
+	counts := make(map[string]map[string]int)
+	count := func(name, value string) {
+		if counts[name] == nil {
+			counts[name] = make(map[string]int)
+		}
+		counts[name][value]++
+	}
+	for _, entry := range logs {
+		for name, value := range entry.Labels {
+			count(name, value)
+		}
+		for name, value := range entry.Fields {
+			if _, ok := entry.Labels[name]; !ok {
+				count(name, value)
+			}
+		}
+	}
+
+	fields := make([]FieldStats, 0, len(counts))
+	for name, values := range counts {
+		field := FieldStats{Name: name, Distinct: len(values), Values: make([]FieldValue, 0, len(values))}
+		for value, n := range values {
+			field.Count += n
+			field.Values = append(field.Values, FieldValue{Value: value, Count: n})
+		}
+		sort.Slice(field.Values, func(i, j int) bool {
+			a, b := field.Values[i], field.Values[j]
+			return a.Count > b.Count || a.Count == b.Count && a.Value < b.Value
+		})
+		if len(field.Values) > topValues {
+			field.Values = field.Values[:topValues]
+		}
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Name < b.Name
+	})
+	return fields
+}
@@ -0,0 +1,130 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVolumeStep(t *testing.T) {
+	end := time.Now()
+	tests := []struct {
+		span time.Duration
+		want time.Duration
+	}{
+		{time.Hour, 30 * time.Second},
+		{time.Hour + time.Second, 31 * time.Second},
+		{time.Minute, time.Second},
+		{0, time.Second},
+	}
+	for _, tt := range tests {
+		if got := VolumeStep(end.Add(-tt.span), end); got != tt.want {
+			t.Errorf("VolumeStep(%s) = %s, want %s", tt.span, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeFields(t *testing.T) {
+	logs := []LogEntry{
+		{Labels: map[string]string{"app": "api", "host": "a"}},
+		{Labels: map[string]string{"app": "api", "host": "b"}},
+		{Labels: map[string]string{"app": "web", "host": "c"}},
+		{Labels: map[string]string{"app": "api"}},
+	}
+	fields := SummarizeFields(logs, 2)
+	if len(fields) != 2 {
+		t.Fatalf("Expected 2 fields, got %+v", fields)
+	}
+
+	app := fields[0]
+	if app.Name != "app" || app.Count != 4 || app.Distinct != 2 {
+		t.Errorf("Unexpected app stats %+v", app)
+	}
+	if app.Values[0] != (FieldValue{"api", 3}) || app.Values[1] != (FieldValue{"web", 1}) {
+		t.Errorf("Unexpected app values %+v", app.Values)
+	}
+
+	host := fields[1]
+	if host.Name != "host" || host.Count != 3 || host.Distinct != 3 || len(host.Values) != 2 || host.Values[0].Value != "a" {
+		t.Errorf("Expected host values cut to the top 2, got %+v", host)
+	}
+}
+
+func TestLokiLogStats(t *testing.T) {
+	var volumeQuery, volumeStep string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if step := r.URL.Query().Get("step"); step != "" {
+			volumeQuery, volumeStep = r.URL.Query().Get("query"), step
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"level":"warn"},"values":[[1700000000,"2"]]},
+				{"metric":{},"values":[[1700000000,"5"]]}
+			]}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[
+			{"stream":{"app":"api","level":"warn"},"values":[["1700000000000000000","slow"],["1700000001000000000","slower"]]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewLokiClient(server.URL)
+	end := time.Now()
+	stats, err := QueryLogStats(context.Background(), client, `{app="api"}`, end.Add(-time.Hour), end, time.Minute)
+	if err != nil {
+		t.Fatalf("QueryLogStats failed: %v", err)
+	}
+	if volumeQuery != `sum by (level) (count_over_time({app="api"} [60s]))` || volumeStep != "60s" {
+		t.Errorf("Unexpected volume query %q step %s", volumeQuery, volumeStep)
+	}
+	if stats.Status != "success" || stats.Step != 60 || stats.SampleSize != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if len(stats.Volume) != 2 || stats.Volume[0].Metric["level"] != "unknown" || stats.Volume[1].Metric["level"] != "warn" {
+		t.Errorf("Expected volume series by level, got %+v", stats.Volume)
+	}
+	if len(stats.Fields) != 2 || stats.Fields[0].Name != "app" || stats.Fields[0].Values[0].Count != 2 {
+		t.Errorf("Unexpected fields %+v", stats.Fields)
+	}
+}
+
+func TestVictoriaLogsLogStatsError(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query().Get("query")
+		http.Error(w, "cannot parse query", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client, _ := NewVictoriaLogsClient(server.URL)
+	end := time.Now()
+	stats, err := QueryLogStats(context.Background(), client, "error", end.Add(-time.Hour), end, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected the error in the result, got %v", err)
+	}
+	if !strings.HasPrefix(gotQuery, "error | stats by (level) count()") {
+		t.Errorf("Unexpected volume query %q", gotQuery)
+	}
+	if stats.Status != "error" || stats.Error != "cannot parse query" {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestSummarizeFields_ParsedFields(t *testing.T) {
+	logs := []LogEntry{
+		{Labels: map[string]string{"app": "api"}, Fields: map[string]string{"app": "ignored", "status": "200"}},
+		{Labels: map[string]string{"app": "api"}, Fields: map[string]string{"status": "500"}},
+	}
+	fields := SummarizeFields(logs, MaxFieldValues)
+	if len(fields) != 2 {
+		t.Fatalf("Expected 2 fields, got %+v", fields)
+	}
+	if fields[0].Name != "app" || fields[0].Distinct != 1 || fields[0].Values[0] != (FieldValue{"api", 2}) {
+		t.Errorf("Expected the app label to hide the parsed field, got %+v", fields[0])
+	}
+	if fields[1].Name != "status" || fields[1].Count != 2 || fields[1].Distinct != 2 {
+		t.Errorf("Unexpected status stats %+v", fields[1])
+	}
+}
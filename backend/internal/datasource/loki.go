@@ -7,9 +7,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/coder/websocket"
 )
 
 // LokiClient queries Loki using LogQL
@@ -38,103 +41,227 @@ type lokiQueryResponse struct {
 }
 
 func (c *LokiClient) Query(ctx context.Context, query string, start, end time.Time, step time.Duration, limit int) (*QueryResult, error) {
+	return LogPage(ctx, c, query, start, end, LogQueryOptions{Limit: limit})
+}
+
+// QueryLogs pages through the lines matching a LogQL log query
+func (c *LokiClient) QueryLogs(ctx context.Context, query string, start, end time.Time, opts LogQueryOptions, emit func(LogEntry) error) (string, error) {
+	return pageLogs(ctx, c.fetchLogs, query, start, end, opts, emit)
+}
+
+// fetchLogs reads lines from query_range. Loki groups them by stream, so
+// they are merged into one ordered page here.
+func (c *LokiClient) fetchLogs(ctx context.Context, query string, start, end time.Time, forward bool, limit int,
+	emit func(LogEntry) error) error {
+	direction := LogBackward
+	if forward {
+		direction = LogForward
+	}
+
 	params := url.Values{}
 	params.Set("query", query)
 	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
 	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
-	if limit > 0 {
-		params.Set("limit", strconv.Itoa(limit))
-	} else {
-		params.Set("limit", "1000")
-	}
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("direction", direction)
 
 	reqURL := fmt.Sprintf("%s/loki/api/v1/query_range?%s", c.baseURL, params.Encode())
 	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query Loki: %w", err)
+		return fmt.Errorf("failed to query Loki: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &QueryError{Message: strings.TrimSpace(string(body))}
 	}
 
 	var lokiResp lokiQueryResponse
 	if err := json.Unmarshal(body, &lokiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	if lokiResp.Status != "success" {
-		return &QueryResult{
-			Status:     "error",
-			Error:      lokiResp.Error,
-			ResultType: "logs",
-		}, nil
+		return &QueryError{Message: lokiResp.Error}
 	}
 
 	// Convert Loki streams to log entries
-	logs := []LogEntry{}
+	type line struct {
+		nsec  int64
+		entry LogEntry
+	}
+	lines := []line{}
 	for _, stream := range lokiResp.Data.Result {
 		for _, entry := range stream.Values {
 			if len(entry) < 2 {
 				continue
 			}
-			ts := entry[0]
-			line := entry[1]
-
-			// Parse nanosecond timestamp to RFC3339
-			nsec, _ := strconv.ParseInt(ts, 10, 64)
-			timestamp := time.Unix(0, nsec).UTC().Format(time.RFC3339Nano)
-
-			// Try to detect log level from labels or line content
-			level := detectLogLevel(stream.Stream, line)
-
-			logs = append(logs, LogEntry{
-				Timestamp: timestamp,
-				Line:      line,
-				Labels:    stream.Stream,
-				Level:     level,
-			})
+			nsec, _ := strconv.ParseInt(entry[0], 10, 64)
+			lines = append(lines, line{nsec, lokiLogEntry(stream.Stream, entry[0], entry[1])})
 		}
 	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		if forward {
+			return lines[i].nsec < lines[j].nsec
+		}
+		return lines[i].nsec > lines[j].nsec
+	})
 
-	return &QueryResult{
-		Status:     "success",
-		ResultType: "logs",
-		Data: &QueryData{
-			ResultType: "streams",
-			Logs:       logs,
-		},
-	}, nil
+	for _, l := range lines {
+		if err := emit(l.entry); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func detectLogLevel(labels map[string]string, line string) string {
-	// Check labels first
-	if level, ok := labels["level"]; ok {
-		return strings.ToLower(level)
-	}
-	if level, ok := labels["severity"]; ok {
-		return strings.ToLower(level)
-	}
-
-	// Simple detection from line content
-	lower := strings.ToLower(line)
-	switch {
-	case strings.Contains(lower, "error") || strings.Contains(lower, "err="):
-		return "error"
-	case strings.Contains(lower, "warn"):
-		return "warning"
-	case strings.Contains(lower, "info"):
-		return "info"
-	case strings.Contains(lower, "debug"):
-		return "debug"
+type lokiTailResponse struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][]string        `json:"values"` // [timestamp_ns, line]
+	} `json:"streams"`
+	DroppedEntries []struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"dropped_entries"`
+}
+
+// Tail streams lines from Loki's tail WebSocket
+func (c *LokiClient) Tail(ctx context.Context, query string, start time.Time, batches chan<- TailBatch) error {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid Loki URL: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
 	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/loki/api/v1/tail"
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	u.RawQuery = params.Encode()
+
+	conn, resp, err := websocket.Dial(ctx, u.String(), &websocket.DialOptions{HTTPClient: c.client})
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("Loki returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("failed to tail Loki: %w", err)
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(4 * 1024 * 1024)
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			if ctx.Err() != nil || websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+				return nil
+			}
+			return fmt.Errorf("Loki tail failed: %w", err)
+		}
+
+		var tailResp lokiTailResponse
+		if err := json.Unmarshal(data, &tailResp); err != nil {
+			return fmt.Errorf("failed to parse tail response: %w", err)
+		}
+
+		batch := TailBatch{Entries: []LogEntry{}, Dropped: len(tailResp.DroppedEntries)}
+		for _, stream := range tailResp.Streams {
+			for _, entry := range stream.Values {
+				if len(entry) < 2 {
+					continue
+				}
+				batch.Entries = append(batch.Entries, lokiLogEntry(stream.Stream, entry[0], entry[1]))
+			}
+		}
+		if len(batch.Entries) == 0 && batch.Dropped == 0 {
+			continue
+		}
+		if !sendBatch(ctx, batches, batch) {
+			return nil
+		}
+	}
+}
+
+// lokiLogEntry converts a line of a Loki stream
+func lokiLogEntry(labels map[string]string, ts, line string) LogEntry {
+	// Parse nanosecond timestamp to RFC3339
+	nsec, _ := strconv.ParseInt(ts, 10, 64)
+	timestamp := time.Unix(0, nsec).UTC().Format(time.RFC3339Nano)
+
+	// Parse JSON or logfmt lines, and find the level from labels or fields
+	fields := ParseLine(line)
+
+	return LogEntry{
+		Timestamp: timestamp,
+		Line:      line,
+		Labels:    labels,
+		Fields:    fields,
+		Level:     detectLogLevel(labels, fields, line),
+	}
+}
+
+// QueryMetrics runs a LogQL metric query such as
+// sum by (app) (count_over_time({app="api"} |= "error" [5m]))
+func (c *LokiClient) QueryMetrics(ctx context.Context, query string, start, end time.Time, step time.Duration) (*QueryResult, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	params.Set("step", fmt.Sprintf("%ds", int(step.Seconds())))
+
+	reqURL := fmt.Sprintf("%s/loki/api/v1/query_range?%s", c.baseURL, params.Encode())
+	return getMatrix(ctx, c.client, reqURL, "Loki")
+}
+
+// VolumeQuery counts a log query's lines per step by their level label
+func (c *LokiClient) VolumeQuery(query string, step time.Duration) string {
+	return fmt.Sprintf("sum by (level) (count_over_time(%s [%ds]))", query, int(step.Seconds()))
+}
+
+// LogQuery returns the log query inside a LogQL range aggregation: from the
+// stream selector up to the range, without any unwrap stage
+func (c *LokiClient) LogQuery(metricQuery string) string {
+	start := strings.Index(metricQuery, "{")
+	if start < 0 {
 		return ""
 	}
+
+	// Find the range's "[", skipping quoted strings in the pipeline
+	var quote rune
+	escaped := false
+	for i, r := range metricQuery[start:] {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == '\\' && quote == '"' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '`':
+			quote = r
+		case r == '[':
+			query := metricQuery[start : start+i]
+			if unwrap := strings.Index(query, "| unwrap"); unwrap >= 0 {
+				query = query[:unwrap]
+			}
+			return strings.TrimSpace(query)
+		}
+	}
+	return ""
 }
@@ -0,0 +1,32 @@
+package datasource
+
+import (
+	"context"
+	"time"
+)
+
+// LogTailer is a log datasource that can stream lines as they arrive
+type LogTailer interface {
+	Client
+	// Tail sends the lines matching query, from start on, until ctx is done or
+	// the datasource ends the stream. Sends block, so a reader that falls
+	// behind slows the upstream read down rather than buffering without bound.
+	Tail(ctx context.Context, query string, start time.Time, batches chan<- TailBatch) error
+}
+
+// TailBatch is a set of lines received together while tailing
+type TailBatch struct {
+	Entries []LogEntry `json:"entries"`
+	// Dropped counts lines the datasource skipped because the tail fell behind
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// sendBatch hands a batch to the reader, giving up when ctx is done
+func sendBatch(ctx context.Context, batches chan<- TailBatch, batch TailBatch) bool {
+	select {
+	case batches <- batch:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
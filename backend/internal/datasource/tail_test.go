@@ -0,0 +1,97 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestLokiTail(t *testing.T) {
+	var gotPath, gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.Query().Get("query")
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		conn.Write(r.Context(), websocket.MessageText, []byte(`{"streams":[
+			{"stream":{"app":"api"},"values":[["1700000000000000000","level=error boom"],["1700000001000000000","ok"]]}
+		]}`))
+		conn.Write(r.Context(), websocket.MessageText, []byte(`{"streams":[],"dropped_entries":[{"labels":{},"timestamp":"1"}]}`))
+		conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer server.Close()
+
+	client, _ := NewLokiClient(server.URL)
+	batches := make(chan TailBatch, 10)
+	if err := client.Tail(context.Background(), `{app="api"}`, time.Now(), batches); err != nil {
+		t.Fatalf("Tail failed: %v", err)
+	}
+	close(batches)
+
+	if gotPath != "/loki/api/v1/tail" || gotQuery != `{app="api"}` {
+		t.Errorf("Unexpected request %s query=%s", gotPath, gotQuery)
+	}
+	first := <-batches
+	if len(first.Entries) != 2 || first.Entries[0].Line != "level=error boom" || first.Entries[0].Labels["app"] != "api" {
+		t.Fatalf("Unexpected first batch %+v", first)
+	}
+	if first.Entries[0].Timestamp != "2023-11-14T22:13:20Z" {
+		t.Errorf("Unexpected timestamp %s", first.Entries[0].Timestamp)
+	}
+	if second := <-batches; len(second.Entries) != 0 || second.Dropped != 1 {
+		t.Errorf("Expected the dropped entries to be reported, got %+v", second)
+	}
+}
+
+func TestVictoriaLogsTailStopsWithContext(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		for i := 0; ; i++ {
+			if _, err := fmt.Fprintf(w, `{"_msg":"line %d","_time":"2026-01-01T00:00:00Z","host":"a"}`+"\n", i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, _ := NewVictoriaLogsClient(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := make(chan TailBatch)
+	done := make(chan error, 1)
+	go func() { done <- client.Tail(ctx, "error", time.Now().Add(-time.Minute), batches) }()
+
+	for i := 0; i < 3; i++ {
+		batch := <-batches
+		if len(batch.Entries) != 1 || batch.Entries[0].Line != fmt.Sprintf("line %d", i) || batch.Entries[0].Labels["host"] != "a" {
+			t.Fatalf("Unexpected batch %+v", batch)
+		}
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tail didn't stop with its context")
+	}
+	if gotPath != "/select/logsql/tail" {
+		t.Errorf("Unexpected path %s", gotPath)
+	}
+}
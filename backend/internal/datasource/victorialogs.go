@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -35,93 +36,173 @@ type vlLogEntry struct {
 }
 
 func (c *VictoriaLogsClient) Query(ctx context.Context, query string, start, end time.Time, step time.Duration, limit int) (*QueryResult, error) {
-	params := url.Values{}
-	params.Set("query", query)
-	params.Set("start", start.UTC().Format(time.RFC3339))
-	params.Set("end", end.UTC().Format(time.RFC3339))
-	if limit > 0 {
-		params.Set("limit", strconv.Itoa(limit))
-	} else {
-		params.Set("limit", "1000")
+	return LogPage(ctx, c, query, start, end, LogQueryOptions{Limit: limit})
+}
+
+// QueryLogs pages through the lines matching a LogsQL query
+func (c *VictoriaLogsClient) QueryLogs(ctx context.Context, query string, start, end time.Time, opts LogQueryOptions, emit func(LogEntry) error) (string, error) {
+	return pageLogs(ctx, c.fetchLogs, query, start, end, opts, emit)
+}
+
+// fetchLogs reads lines as Victoria Logs sends them, sorted by time with a
+// pipe, so they are never all held in memory
+func (c *VictoriaLogsClient) fetchLogs(ctx context.Context, query string, start, end time.Time, forward bool, limit int,
+	emit func(LogEntry) error) error {
+	order := " desc"
+	if forward {
+		order = ""
 	}
 
+	params := url.Values{}
+	params.Set("query", fmt.Sprintf("%s | sort by (_time)%s limit %d", query, order, limit))
+	params.Set("start", start.UTC().Format(time.RFC3339Nano))
+	params.Set("end", end.UTC().Format(time.RFC3339Nano))
+	params.Set("limit", strconv.Itoa(limit))
+
 	reqURL := fmt.Sprintf("%s/select/logsql/query?%s", c.baseURL, params.Encode())
 	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query Victoria Logs: %w", err)
+		return fmt.Errorf("failed to query Victoria Logs: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return &QueryResult{
-			Status:     "error",
-			Error:      fmt.Sprintf("Victoria Logs returned status %d", resp.StatusCode),
-			ResultType: "logs",
-		}, nil
+		return &QueryError{Message: fmt.Sprintf("Victoria Logs returned status %d", resp.StatusCode)}
 	}
 
 	// Victoria Logs returns JSONL format - one JSON object per line
-	logs := []LogEntry{}
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024) // 1MB max line size
 	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
+		entry, ok := parseVLLine(scanner.Bytes())
+		if !ok {
 			continue
 		}
+		if err := emit(entry); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil
+}
+
+// Tail streams lines from Victoria Logs' live tailing endpoint, which sends
+// JSONL as lines arrive
+func (c *VictoriaLogsClient) Tail(ctx context.Context, query string, start time.Time, batches chan<- TailBatch) error {
+	params := url.Values{}
+	params.Set("query", query)
+	if offset := time.Since(start); offset > 0 {
+		params.Set("start_offset", fmt.Sprintf("%ds", int(offset.Seconds())))
+	}
+
+	reqURL := fmt.Sprintf("%s/select/logsql/tail?%s", c.baseURL, params.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
 
-		var raw map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+	// The stream stays open, so only the context bounds it
+	client := &http.Client{Transport: c.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to tail Victoria Logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Victoria Logs returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024) // 1MB max line size
+	for scanner.Scan() {
+		entry, ok := parseVLLine(scanner.Bytes())
+		if !ok {
 			continue
 		}
-
-		entry := LogEntry{
-			Labels: make(map[string]string),
+		if !sendBatch(ctx, batches, TailBatch{Entries: []LogEntry{entry}}) {
+			return nil
 		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Victoria Logs tail failed: %w", err)
+	}
+	return nil
+}
 
-		// Extract known fields
-		if msg, ok := raw["_msg"].(string); ok {
-			entry.Line = msg
+// parseVLLine converts a line of Victoria Logs JSONL output
+func parseVLLine(line []byte) (LogEntry, bool) {
+	var raw map[string]interface{}
+	if len(line) == 0 || json.Unmarshal(line, &raw) != nil {
+		return LogEntry{}, false
+	}
+	entry := LogEntry{
+		Labels: make(map[string]string),
+	}
+
+	// Extract known fields
+	if msg, ok := raw["_msg"].(string); ok {
+		entry.Line = msg
+	}
+	if t, ok := raw["_time"].(string); ok {
+		entry.Timestamp = t
+	}
+
+	// Build labels from remaining fields
+	for k, v := range raw {
+		if k == "_msg" || k == "_time" {
+			continue
 		}
-		if t, ok := raw["_time"].(string); ok {
-			entry.Timestamp = t
+		if str, ok := v.(string); ok {
+			entry.Labels[k] = str
 		}
+	}
 
-		// Build labels from remaining fields
-		for k, v := range raw {
-			if k == "_msg" || k == "_time" {
-				continue
-			}
-			if str, ok := v.(string); ok {
-				entry.Labels[k] = str
-			}
-		}
+	// Parse JSON or logfmt messages, and find the level from fields
+	entry.Fields = ParseLine(entry.Line)
+	entry.Level = detectLogLevel(entry.Labels, entry.Fields, entry.Line)
 
-		// Detect level
-		level := ""
-		if l, ok := entry.Labels["level"]; ok {
-			level = strings.ToLower(l)
-		} else if l, ok := entry.Labels["severity"]; ok {
-			level = strings.ToLower(l)
-		} else {
-			level = detectLogLevel(entry.Labels, entry.Line)
-		}
-		entry.Level = level
+	return entry, true
+}
 
-		logs = append(logs, entry)
-	}
+// statsPipe finds the start of a LogsQL stats pipe
+var statsPipe = regexp.MustCompile(`\|\s*stats\b`)
 
-	return &QueryResult{
-		Status:     "success",
-		ResultType: "logs",
-		Data: &QueryData{
-			ResultType: "streams",
-			Logs:       logs,
-		},
-	}, nil
+// QueryMetrics runs a LogsQL query ending in a stats pipe, such as
+// error | stats by (host) count() as errors
+func (c *VictoriaLogsClient) QueryMetrics(ctx context.Context, query string, start, end time.Time, step time.Duration) (*QueryResult, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", start.UTC().Format(time.RFC3339))
+	params.Set("end", end.UTC().Format(time.RFC3339))
+	params.Set("step", fmt.Sprintf("%ds", int(step.Seconds())))
+
+	reqURL := fmt.Sprintf("%s/select/logsql/stats_query_range?%s", c.baseURL, params.Encode())
+	return getMatrix(ctx, c.client, reqURL, "Victoria Logs")
+}
+
+// VolumeQuery counts a log query's lines by their level field. Run as a
+// stats range query, each step is its own _time bucket.
+func (c *VictoriaLogsClient) VolumeQuery(query string, step time.Duration) string {
+	return query + " | stats by (level) count() as logs"
+}
+
+// LogQuery returns the filters before a LogsQL query's stats pipe
+func (c *VictoriaLogsClient) LogQuery(metricQuery string) string {
+	loc := statsPipe.FindStringIndex(metricQuery)
+	if loc == nil {
+		return ""
+	}
+	query := strings.TrimSpace(metricQuery[:loc[0]])
+	if query == "" {
+		return "*"
+	}
+	return query
 }
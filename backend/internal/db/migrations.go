@@ -141,6 +141,320 @@ func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
 		`CREATE INDEX IF NOT EXISTS idx_datasources_org_id ON datasources(organization_id)`,
 		// Add datasource_id to panels (nullable, for non-default datasource)
 		`ALTER TABLE panels ADD COLUMN IF NOT EXISTS datasource_id UUID REFERENCES datasources(id) ON DELETE SET NULL`,
+		// Generic OIDC SSO: discovery issuer, scopes and claim mapping per org
+		`ALTER TABLE sso_configs
+			ADD COLUMN IF NOT EXISTS issuer_url VARCHAR(500),
+			ADD COLUMN IF NOT EXISTS scopes TEXT[],
+			ADD COLUMN IF NOT EXISTS claim_mapping JSONB`,
+		// SAML 2.0 SSO: IdP entity ID, SSO URL, signing certificate and request binding per org
+		`ALTER TABLE sso_configs
+			ADD COLUMN IF NOT EXISTS idp_entity_id VARCHAR(500),
+			ADD COLUMN IF NOT EXISTS idp_sso_url VARCHAR(500),
+			ADD COLUMN IF NOT EXISTS idp_certificate TEXT,
+			ADD COLUMN IF NOT EXISTS saml_binding VARCHAR(20)`,
+		`ALTER TABLE sso_configs DROP CONSTRAINT IF EXISTS sso_configs_provider_check`,
+		`ALTER TABLE sso_configs ADD CONSTRAINT sso_configs_provider_check
+			CHECK (provider IN ('google', 'microsoft', 'oidc', 'saml'))`,
+		// LDAP / Active Directory login: per-org directory and group-to-role mappings
+		`CREATE TABLE IF NOT EXISTS ldap_configs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL UNIQUE REFERENCES organizations(id) ON DELETE CASCADE,
+			url VARCHAR(500) NOT NULL,
+			start_tls BOOLEAN DEFAULT false,
+			insecure_skip_verify BOOLEAN DEFAULT false,
+			root_ca TEXT,
+			bind_dn VARCHAR(500) NOT NULL DEFAULT '',
+			bind_password VARCHAR(500) NOT NULL DEFAULT '',
+			base_dn VARCHAR(500) NOT NULL,
+			user_filter VARCHAR(500) NOT NULL DEFAULT '',
+			email_attribute VARCHAR(100) NOT NULL DEFAULT '',
+			name_attribute VARCHAR(100) NOT NULL DEFAULT '',
+			group_attribute VARCHAR(100) NOT NULL DEFAULT '',
+			group_mappings JSONB NOT NULL DEFAULT '[]',
+			default_role VARCHAR(50) CHECK (default_role IN ('admin', 'editor', 'viewer')),
+			enabled BOOLEAN DEFAULT true,
+			last_synced_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT NOW(),
+			updated_at TIMESTAMP DEFAULT NOW()
+		)`,
+		// SSO settings: per-org group-to-role rules and password login policy
+		`CREATE TABLE IF NOT EXISTS sso_settings (
+			organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+			enforce_sso BOOLEAN NOT NULL DEFAULT false,
+			role_mappings JSONB NOT NULL DEFAULT '[]',
+			default_role VARCHAR(50) DEFAULT 'viewer' CHECK (default_role IN ('admin', 'editor', 'viewer')),
+			updated_at TIMESTAMP DEFAULT NOW()
+		)`,
+		// MFA: TOTP and WebAuthn factors are stored as user auth methods
+		`ALTER TABLE user_auth_methods
+			ADD COLUMN IF NOT EXISTS name VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS secret TEXT,
+			ADD COLUMN IF NOT EXISTS credential JSONB,
+			ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS last_used_step BIGINT,
+			ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP`,
+		// MFA recovery codes: single-use, stored hashed
+		`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT NOW(),
+			UNIQUE(user_id, code_hash)
+		)`,
+		`ALTER TABLE sso_settings ADD COLUMN IF NOT EXISTS require_admin_mfa BOOLEAN NOT NULL DEFAULT false`,
+		// Email verification: users that exist when the column is added count as
+		// verified, new password users start unverified
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP DEFAULT NOW()`,
+		`ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT`,
+		// Organization invitations; only a hash of the emailed token is stored
+		`CREATE TABLE IF NOT EXISTS organization_invitations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			role VARCHAR(50) NOT NULL CHECK (role IN ('admin', 'editor', 'viewer')),
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			expires_at TIMESTAMP NOT NULL,
+			accepted_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_organization_invitations_org_id ON organization_invitations(organization_id)`,
+		// Per-org datasource query limits overriding the server defaults; 0 is unlimited
+		`CREATE TABLE IF NOT EXISTS organization_quotas (
+			organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+			user_queries_per_minute INTEGER NOT NULL CHECK (user_queries_per_minute >= 0),
+			org_queries_per_minute INTEGER NOT NULL CHECK (org_queries_per_minute >= 0),
+			max_concurrent_queries INTEGER NOT NULL CHECK (max_concurrent_queries >= 0),
+			daily_query_budget INTEGER NOT NULL CHECK (daily_query_budget >= 0),
+			updated_at TIMESTAMP DEFAULT NOW()
+		)`,
+		// Refresh tokens for deployments without Valkey (TOKEN_STORE=postgres).
+		// Rotated tokens keep used_at until they expire, to detect replays.
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			session_id UUID NOT NULL,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			name VARCHAR(255),
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id)`,
+		// Audit log of administrative changes. Rows outlive the organizations and
+		// users they mention, and can't be updated or deleted.
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID,
+			actor_id UUID,
+			actor_email VARCHAR(255) NOT NULL DEFAULT '',
+			action VARCHAR(100) NOT NULL,
+			target_type VARCHAR(50) NOT NULL,
+			target_id VARCHAR(255) NOT NULL DEFAULT '',
+			before JSONB,
+			after JSONB,
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_org_created ON audit_log(organization_id, created_at DESC, id DESC)`,
+		`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log`,
+		`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+			FOR EACH ROW EXECUTE FUNCTION audit_log_append_only()`,
+		// JWT signing keys shared by all instances when key rotation is enabled.
+		// Retired keys keep verifying tokens until expires_at.
+		`CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+			kid VARCHAR(64) PRIMARY KEY,
+			private_key TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP
+		)`,
+		// Alert rules and the state of the series they match
+		`CREATE TABLE IF NOT EXISTS alert_rules (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			datasource_id UUID NOT NULL REFERENCES datasources(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			query TEXT NOT NULL,
+			condition JSONB NOT NULL,
+			interval_seconds INTEGER NOT NULL,
+			lookback_seconds INTEGER NOT NULL,
+			for_seconds INTEGER NOT NULL DEFAULT 0,
+			labels JSONB NOT NULL DEFAULT '{}',
+			annotations JSONB NOT NULL DEFAULT '{}',
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			health VARCHAR(20) NOT NULL DEFAULT 'unknown',
+			last_error TEXT NOT NULL DEFAULT '',
+			last_evaluated_at TIMESTAMP,
+			next_evaluation_at TIMESTAMP NOT NULL,
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_alert_rules_org_id ON alert_rules(organization_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_alert_rules_next_evaluation ON alert_rules(next_evaluation_at) WHERE enabled`,
+		`CREATE TABLE IF NOT EXISTS alert_instances (
+			rule_id UUID NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			fingerprint VARCHAR(64) NOT NULL,
+			labels JSONB NOT NULL DEFAULT '{}',
+			annotations JSONB NOT NULL DEFAULT '{}',
+			state VARCHAR(20) NOT NULL,
+			value DOUBLE PRECISION NOT NULL DEFAULT 0,
+			active_at TIMESTAMP NOT NULL,
+			fired_at TIMESTAMP,
+			resolved_at TIMESTAMP,
+			last_evaluated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (rule_id, fingerprint)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_alert_instances_org_id ON alert_instances(organization_id)`,
+		// Alert notifications: channels, the routes sending alerts to them, the
+		// state of each route's alert groups and the delivery history
+		`CREATE TABLE IF NOT EXISTS notification_channels (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			type VARCHAR(50) NOT NULL,
+			settings JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_channels_org_id ON notification_channels(organization_id)`,
+		`CREATE TABLE IF NOT EXISTS notification_routes (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			channel_id UUID NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+			matchers JSONB NOT NULL DEFAULT '[]',
+			group_by TEXT[] NOT NULL DEFAULT '{}',
+			group_wait_seconds INTEGER NOT NULL,
+			group_interval_seconds INTEGER NOT NULL,
+			repeat_interval_seconds INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_routes_org_id ON notification_routes(organization_id)`,
+		`CREATE TABLE IF NOT EXISTS notification_groups (
+			route_id UUID NOT NULL REFERENCES notification_routes(id) ON DELETE CASCADE,
+			group_key TEXT NOT NULL,
+			first_seen_at TIMESTAMP NOT NULL,
+			last_notified_at TIMESTAMP,
+			firing_fingerprints TEXT[] NOT NULL DEFAULT '{}',
+			PRIMARY KEY (route_id, group_key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_deliveries (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			channel_id UUID NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+			route_id UUID REFERENCES notification_routes(id) ON DELETE SET NULL,
+			group_key TEXT NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			payload JSONB NOT NULL,
+			next_attempt_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			delivered_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_deliveries_pending ON notification_deliveries(next_attempt_at) WHERE status = 'pending'`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_deliveries_org_created ON notification_deliveries(organization_id, created_at DESC)`,
+		// Silences and weekly maintenance windows mute matching alerts
+		`CREATE TABLE IF NOT EXISTS silences (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			matchers JSONB NOT NULL,
+			starts_at TIMESTAMP NOT NULL,
+			ends_at TIMESTAMP NOT NULL,
+			comment TEXT NOT NULL,
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_by_email VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_silences_org_ends ON silences(organization_id, ends_at)`,
+		`CREATE TABLE IF NOT EXISTS maintenance_windows (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			matchers JSONB NOT NULL,
+			weekdays INTEGER[] NOT NULL DEFAULT '{}',
+			start_time VARCHAR(5) NOT NULL,
+			duration_minutes INTEGER NOT NULL,
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_maintenance_windows_org_id ON maintenance_windows(organization_id)`,
+		`ALTER TABLE alert_instances ADD COLUMN IF NOT EXISTS silenced BOOLEAN NOT NULL DEFAULT false`,
+		// Alert rules on log datasources, with the lines behind each alert
+		`ALTER TABLE alert_rules ADD COLUMN IF NOT EXISTS query_type VARCHAR(16) NOT NULL DEFAULT 'metrics'`,
+		`ALTER TABLE alert_instances ADD COLUMN IF NOT EXISTS samples JSONB`,
+		// Annotations, and the queries deriving them from datasources per dashboard
+		`CREATE TABLE IF NOT EXISTS annotations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			dashboard_id UUID REFERENCES dashboards(id) ON DELETE CASCADE,
+			panel_id UUID REFERENCES panels(id) ON DELETE CASCADE,
+			time TIMESTAMP NOT NULL,
+			time_end TIMESTAMP,
+			text TEXT NOT NULL,
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_annotations_org_time ON annotations(organization_id, time)`,
+		`CREATE INDEX IF NOT EXISTS idx_annotations_tags ON annotations USING GIN (tags)`,
+		`CREATE TABLE IF NOT EXISTS annotation_queries (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			dashboard_id UUID NOT NULL REFERENCES dashboards(id) ON DELETE CASCADE,
+			datasource_id UUID NOT NULL REFERENCES datasources(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			query TEXT NOT NULL,
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_annotation_queries_dashboard_id ON annotation_queries(dashboard_id)`,
+		// Generic OIDC, SAML and LDAP identities are qualified with the org whose
+		// config they signed in through, as "<org id>|<issuer>|<subject>".
+		// Existing links move to the one org of the user whose config has their
+		// issuer; links that match several orgs stay unqualified and no longer
+		// sign in, so those users have to link their identity again.
+		`ALTER TABLE user_auth_methods ALTER COLUMN provider_user_id TYPE TEXT`,
+		`WITH candidates AS (
+			SELECT uam.id, om.organization_id
+			FROM user_auth_methods uam
+			JOIN organization_memberships om ON om.user_id = uam.user_id
+			LEFT JOIN sso_configs c ON c.organization_id = om.organization_id AND c.provider = uam.provider
+			LEFT JOIN ldap_configs l ON l.organization_id = om.organization_id AND uam.provider = 'ldap'
+			WHERE uam.provider IN ('oidc', 'saml', 'ldap')
+			  AND uam.provider_user_id !~ '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\|'
+			  AND starts_with(uam.provider_user_id, CASE uam.provider
+			      WHEN 'oidc' THEN c.issuer_url
+			      WHEN 'saml' THEN c.idp_entity_id
+			      ELSE l.url END || '|')
+		), unambiguous AS (
+			SELECT id, MIN(organization_id::text) AS organization_id
+			FROM candidates GROUP BY id HAVING COUNT(*) = 1
+		)
+		UPDATE user_auth_methods uam
+		SET provider_user_id = u.organization_id || '|' || uam.provider_user_id, updated_at = NOW()
+		FROM unambiguous u WHERE uam.id = u.id`,
+		// Admins still allowed to sign in with a password while SSO is enforced
+		`ALTER TABLE sso_settings ADD COLUMN IF NOT EXISTS break_glass_user_ids UUID[] NOT NULL DEFAULT '{}'`,
 	}
 
 	for _, migration := range migrations {
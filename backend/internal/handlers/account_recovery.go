@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/mailer"
+)
+
+// EmailRequest identifies an account by email address
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyEmailRequest carries the token from a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ResetPasswordRequest sets a new password using the token from a reset link
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// emailLink builds a frontend link carrying a token
+func (h *AuthHandler) emailLink(path, token string) string {
+	return h.frontendURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail emails the user a link to confirm their address
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) {
+	if h.emailTokens == nil || h.mailer == nil {
+		return
+	}
+
+	token, err := h.emailTokens.Issue(ctx, auth.PurposeVerifyEmail, userID, email, auth.EmailVerificationExpiry)
+	if err != nil {
+		log.Printf("Failed to issue verification token: %v", err)
+		return
+	}
+
+	deliverEmail(h.mailer, email, mailer.TemplateVerifyEmail, mailer.LinkData{Link: h.emailLink("/verify-email", token)})
+}
+
+// VerifyEmail marks the user's email as verified using the emailed token
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if h.emailTokens == nil {
+		http.Error(w, `{"error":"email verification not enabled (Valkey not available)"}`, http.StatusNotImplemented)
+		return
+	}
+
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rateLimited(ctx, w, h.limiter, 20, 15*time.Minute, "verify_email:ip:"+clientIP(r)) {
+		return
+	}
+
+	data, err := h.emailTokens.Consume(ctx, auth.PurposeVerifyEmail, req.Token)
+	if err == auth.ErrInvalidEmailToken {
+		http.Error(w, `{"error":"invalid or expired token"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to verify token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// The token only verifies the address it was sent to
+	result, err := h.pool.Exec(ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		 WHERE id = $1 AND email = $2`,
+		data.UserID, data.Email,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to verify email"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		http.Error(w, `{"error":"invalid or expired token"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "email verified"})
+}
+
+// ResendVerification sends a new verification link. It responds the same way
+// whether or not the account exists.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if h.emailTokens == nil || h.mailer == nil {
+		http.Error(w, `{"error":"email verification not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, `{"error":"email is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rateLimited(ctx, w, h.limiter, 5, time.Hour, "verify_resend:ip:"+clientIP(r), "verify_resend:email:"+accountKey(req.Email)) {
+		return
+	}
+
+	var userID uuid.UUID
+	var email string
+	err := h.pool.QueryRow(ctx,
+		`SELECT id, email FROM users WHERE email = $1 AND email_verified_at IS NULL`,
+		req.Email,
+	).Scan(&userID, &email)
+	if err != nil && err != pgx.ErrNoRows {
+		http.Error(w, `{"error":"failed to find user"}`, http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		h.sendVerificationEmail(ctx, userID, email)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "if the account exists and is unverified, a verification email has been sent"})
+}
+
+// ForgotPassword emails a password reset link. It responds the same way
+// whether or not the account exists.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if h.emailTokens == nil || h.mailer == nil {
+		http.Error(w, `{"error":"password reset not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, `{"error":"email is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rateLimited(ctx, w, h.limiter, 5, time.Hour, "password_forgot:ip:"+clientIP(r), "password_forgot:email:"+accountKey(req.Email)) {
+		return
+	}
+
+	// Only accounts with a password can reset it; SSO-only users sign in with their IdP
+	var userID uuid.UUID
+	var email string
+	err := h.pool.QueryRow(ctx,
+		`SELECT id, email FROM users WHERE email = $1 AND password_hash IS NOT NULL`,
+		req.Email,
+	).Scan(&userID, &email)
+	if err != nil && err != pgx.ErrNoRows {
+		http.Error(w, `{"error":"failed to find user"}`, http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		token, err := h.emailTokens.Issue(ctx, auth.PurposePasswordReset, userID, email, auth.PasswordResetExpiry)
+		if err != nil {
+			http.Error(w, `{"error":"failed to create reset token"}`, http.StatusInternalServerError)
+			return
+		}
+		deliverEmail(h.mailer, email, mailer.TemplatePasswordReset, mailer.LinkData{Link: h.emailLink("/reset-password", token)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "if the account exists, a password reset email has been sent"})
+}
+
+// ResetPassword sets a new password using the emailed token and signs the user
+// out everywhere
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if h.emailTokens == nil {
+		http.Error(w, `{"error":"password reset not enabled (Valkey not available)"}`, http.StatusNotImplemented)
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rateLimited(ctx, w, h.limiter, 20, 15*time.Minute, "password_reset:ip:"+clientIP(r)) {
+		return
+	}
+
+	// Validate before redeeming so a rejected password doesn't burn the token
+	if err := validatePassword(req.Password); err != nil {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.emailTokens.Consume(ctx, auth.PurposePasswordReset, req.Token)
+	if err == auth.ErrInvalidEmailToken {
+		http.Error(w, `{"error":"invalid or expired token"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to verify token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Hash only for a valid token, so guessing tokens costs the server no bcrypt work
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, `{"error":"failed to process password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Following the link also proves ownership of the address
+	result, err := h.pool.Exec(ctx,
+		`UPDATE users SET password_hash = $3, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		 WHERE id = $1 AND email = $2`,
+		data.UserID, data.Email, passwordHash,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to update password"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		http.Error(w, `{"error":"invalid or expired token"}`, http.StatusBadRequest)
+		return
+	}
+
+	if h.refreshTokenManager != nil {
+		if err := h.refreshTokenManager.RevokeAllUserTokens(ctx, data.UserID); err != nil {
+			log.Printf("Failed to revoke sessions after password reset: %v", err)
+		}
+	}
+	if err := h.jwtManager.Denylist().RevokeUser(ctx, data.UserID); err != nil {
+		log.Printf("Failed to revoke access tokens after password reset: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "password updated"})
+}
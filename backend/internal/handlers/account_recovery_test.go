@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/mailer"
+	"github.com/redis/go-redis/v9"
+)
+
+var emailTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// tokenFromEmail waits for the next email and returns the token in its link
+func tokenFromEmail(t *testing.T, m *mailer.CaptureMailer) string {
+	t.Helper()
+	select {
+	case msg := <-m.Sent:
+		match := emailTokenPattern.FindStringSubmatch(msg.Body)
+		if match == nil {
+			t.Fatalf("No token in email body: %s", msg.Body)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatalf("Invalid token in email: %v", err)
+		}
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for email")
+	}
+	return ""
+}
+
+func setupAccountRecoveryTest(t *testing.T) (*AuthHandler, *mailer.CaptureMailer, func()) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	m := mailer.NewCaptureMailer()
+	handler := NewAuthHandler(testPool, testJWTManager, rdb, auth.NewRefreshTokenManager(auth.NewValkeyTokenStore(rdb)), m)
+
+	return handler, m, func() {
+		rdb.Close()
+		mr.Close()
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	handler, m, cleanup := setupAccountRecoveryTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM users WHERE email = 'testreset@example.com'")
+	defer testPool.Exec(ctx, "DELETE FROM users WHERE email = 'testreset@example.com'")
+
+	regBody := `{"email":"testreset@example.com","password":"TestPassword123!"}`
+	regReq := httptest.NewRequest("POST", "/api/auth/register", bytes.NewBufferString(regBody))
+	regW := httptest.NewRecorder()
+	handler.Register(regW, regReq)
+	if regW.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d", regW.Code)
+	}
+	tokenFromEmail(t, m) // verification email
+
+	// Unknown accounts get the same response and no email
+	req := httptest.NewRequest("POST", "/api/auth/password/forgot", bytes.NewBufferString(`{"email":"testnobody@example.com"}`))
+	w := httptest.NewRecorder()
+	handler.ForgotPassword(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/auth/password/forgot", bytes.NewBufferString(`{"email":"testreset@example.com"}`))
+	w = httptest.NewRecorder()
+	handler.ForgotPassword(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	token := tokenFromEmail(t, m)
+
+	reset := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/auth/password/reset", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		handler.ResetPassword(w, req)
+		return w
+	}
+
+	// A weak password is rejected without using up the token
+	if w := reset(`{"token":"` + token + `","password":"weak"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for weak password, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := reset(`{"token":"` + token + `","password":"NewPassword456!"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := reset(`{"token":"` + token + `","password":"OtherPassword789!"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a reused token, got %d: %s", w.Code, w.Body.String())
+	}
+
+	loginReq := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email":"testreset@example.com","password":"NewPassword456!"}`))
+	loginW := httptest.NewRecorder()
+	handler.Login(loginW, loginReq)
+	if loginW.Code != http.StatusOK {
+		t.Errorf("Expected login with the new password to succeed, got %d: %s", loginW.Code, loginW.Body.String())
+	}
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	handler, m, cleanup := setupAccountRecoveryTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM users WHERE email = 'testverify@example.com'")
+	defer testPool.Exec(ctx, "DELETE FROM users WHERE email = 'testverify@example.com'")
+
+	regBody := `{"email":"testverify@example.com","password":"TestPassword123!"}`
+	regReq := httptest.NewRequest("POST", "/api/auth/register", bytes.NewBufferString(regBody))
+	regW := httptest.NewRecorder()
+	handler.Register(regW, regReq)
+	if regW.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d", regW.Code)
+	}
+	first := tokenFromEmail(t, m)
+
+	// Resending revokes the earlier link
+	req := httptest.NewRequest("POST", "/api/auth/verify-email/resend", bytes.NewBufferString(`{"email":"testverify@example.com"}`))
+	w := httptest.NewRecorder()
+	handler.ResendVerification(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	second := tokenFromEmail(t, m)
+
+	verify := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/auth/verify-email", bytes.NewBufferString(`{"token":"`+token+`"}`))
+		w := httptest.NewRecorder()
+		handler.VerifyEmail(w, req)
+		return w
+	}
+
+	if w := verify(first); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a superseded token, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := verify(second); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var verified bool
+	testPool.QueryRow(ctx, `SELECT email_verified_at IS NOT NULL FROM users WHERE email = 'testverify@example.com'`).Scan(&verified)
+	if !verified {
+		t.Error("Expected email to be verified")
+	}
+}
+
+func TestForgotPasswordRateLimited(t *testing.T) {
+	handler, _, cleanup := setupAccountRecoveryTest(t)
+	defer cleanup()
+
+	var last int
+	for i := 0; i < 6; i++ {
+		req := httptest.NewRequest("POST", "/api/auth/password/forgot", bytes.NewBufferString(`{"email":"testratelimit@example.com"}`))
+		w := httptest.NewRecorder()
+		handler.ForgotPassword(w, req)
+		last = w.Code
+		if i < 5 && w.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202 for attempt %d, got %d", i+1, w.Code)
+		}
+		if i == 5 && w.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header")
+		}
+	}
+	if last != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 after the limit, got %d", last)
+	}
+}
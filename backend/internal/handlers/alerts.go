@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/quota"
+)
+
+type AlertRuleHandler struct {
+	pool   *pgxpool.Pool
+	quotas *quota.Manager
+	audit  *audit.Logger
+}
+
+func NewAlertRuleHandler(pool *pgxpool.Pool, quotas *quota.Manager, auditLog *audit.Logger) *AlertRuleHandler {
+	return &AlertRuleHandler{pool: pool, quotas: quotas, audit: auditLog}
+}
+
+// alertRuleSnapshot is the audit log's view of an alert rule, without the
+// fields the scheduler updates
+const alertRuleSnapshot = `SELECT to_jsonb(a) - 'health' - 'last_error' - 'last_evaluated_at' - 'next_evaluation_at'
+	FROM alert_rules a WHERE id = $1`
+
+// AlertRuleTestResponse is the outcome of a dry-run evaluation
+type AlertRuleTestResponse struct {
+	Results []alerting.SeriesResult `json:"results"`
+}
+
+func (h *AlertRuleHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
+	var role string
+	err := h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
+		userID, orgID,
+	).Scan(&role)
+	return role, err
+}
+
+// newAlertRule builds a rule from a create request, filling in defaults
+func newAlertRule(orgID uuid.UUID, req models.CreateAlertRuleRequest) models.AlertRule {
+	rule := models.AlertRule{
+		OrganizationID:  orgID,
+		DatasourceID:    req.DatasourceID,
+		Name:            req.Name,
+		Query:           req.Query,
+		QueryType:       req.QueryType,
+		Condition:       req.Condition,
+		IntervalSeconds: req.IntervalSeconds,
+		LookbackSeconds: req.LookbackSeconds,
+		ForSeconds:      req.ForSeconds,
+		Labels:          req.Labels,
+		Annotations:     req.Annotations,
+		Enabled:         true,
+		Health:          models.AlertHealthUnknown,
+	}
+	if rule.QueryType == "" {
+		rule.QueryType = models.AlertQueryMetrics
+	}
+	if rule.QueryType == models.AlertQueryLogs && rule.Condition.Reducer == "" {
+		rule.Condition.Reducer = "count"
+	}
+	if rule.IntervalSeconds == 0 {
+		rule.IntervalSeconds = models.DefaultAlertIntervalSeconds
+	}
+	if rule.LookbackSeconds == 0 {
+		rule.LookbackSeconds = models.DefaultAlertLookbackSeconds
+	}
+	if rule.Labels == nil {
+		rule.Labels = map[string]string{}
+	}
+	if rule.Annotations == nil {
+		rule.Annotations = map[string]string{}
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	return rule
+}
+
+// ruleDataSource loads the rule's datasource, which must be in the rule's
+// organization and, for logs rules, a log source. The error is the message
+// for a 400 response.
+func (h *AlertRuleHandler) ruleDataSource(ctx context.Context, rule *models.AlertRule) (*models.DataSource, string) {
+	ds, err := alerting.LoadDataSource(ctx, h.pool, rule.DatasourceID)
+	if err != nil || ds.OrganizationID != rule.OrganizationID {
+		return nil, "datasource not found"
+	}
+	if rule.QueryType == models.AlertQueryLogs && ds.Type.IsMetrics() {
+		return nil, "logs rules need a Loki or Victoria Logs datasource"
+	}
+	return ds, ""
+}
+
+// Create creates an alert rule for an organization
+func (h *AlertRuleHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	rule := newAlertRule(orgID, req)
+	if err := rule.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	role, err := h.checkOrgMembership(ctx, userID, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+	if role == "viewer" {
+		http.Error(w, `{"error":"viewers cannot create alert rules"}`, http.StatusForbidden)
+		return
+	}
+
+	if _, msg := h.ruleDataSource(ctx, &rule); msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+
+	created, err := alerting.ScanRule(h.pool.QueryRow(ctx,
+		`INSERT INTO alert_rules (organization_id, datasource_id, name, query, condition, interval_seconds,
+			lookback_seconds, for_seconds, labels, annotations, enabled, next_evaluation_at, created_by, query_type)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		 RETURNING `+alerting.RuleColumns,
+		orgID, rule.DatasourceID, rule.Name, rule.Query, rule.Condition, rule.IntervalSeconds,
+		rule.LookbackSeconds, rule.ForSeconds, rule.Labels, rule.Annotations, rule.Enabled, time.Now().UTC(), userID,
+		rule.QueryType,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create alert rule"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "alert_rule.create", "alert_rule", created.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, alertRuleSnapshot, created.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// List lists the alert rules of an organization
+func (h *AlertRuleHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+alerting.RuleColumns+` FROM alert_rules WHERE organization_id = $1 ORDER BY name ASC`, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch alert rules"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rules := []models.AlertRule{}
+	for rows.Next() {
+		rule, err := alerting.ScanRule(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan alert rule"}`, http.StatusInternalServerError)
+			return
+		}
+		rules = append(rules, *rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// loadRule fetches a rule by the id in the path and the caller's role in its
+// organization, writing the error response when either fails
+func (h *AlertRuleHandler) loadRule(ctx context.Context, w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*models.AlertRule, string, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid alert rule id"}`, http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	rule, err := alerting.ScanRule(h.pool.QueryRow(ctx,
+		`SELECT `+alerting.RuleColumns+` FROM alert_rules WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"alert rule not found"}`, http.StatusNotFound)
+		return nil, "", false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch alert rule"}`, http.StatusInternalServerError)
+		return nil, "", false
+	}
+
+	role, err := h.checkOrgMembership(ctx, userID, rule.OrganizationID)
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return nil, "", false
+	}
+	return rule, role, true
+}
+
+// Get returns a single alert rule
+func (h *AlertRuleHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rule, _, ok := h.loadRule(ctx, w, r, userID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// Update updates an alert rule. The rule is evaluated again on the next tick.
+func (h *AlertRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rule, role, ok := h.loadRule(ctx, w, r, userID)
+	if !ok {
+		return
+	}
+	if role == "viewer" {
+		http.Error(w, `{"error":"viewers cannot update alert rules"}`, http.StatusForbidden)
+		return
+	}
+
+	if req.DatasourceID != nil {
+		rule.DatasourceID = *req.DatasourceID
+	}
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Query != nil {
+		rule.Query = *req.Query
+	}
+	if req.QueryType != nil {
+		rule.QueryType = *req.QueryType
+	}
+	if req.Condition != nil {
+		rule.Condition = *req.Condition
+	}
+	if req.IntervalSeconds != nil {
+		rule.IntervalSeconds = *req.IntervalSeconds
+	}
+	if req.LookbackSeconds != nil {
+		rule.LookbackSeconds = *req.LookbackSeconds
+	}
+	if req.ForSeconds != nil {
+		rule.ForSeconds = *req.ForSeconds
+	}
+	if req.Labels != nil {
+		rule.Labels = req.Labels
+	}
+	if req.Annotations != nil {
+		rule.Annotations = req.Annotations
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if _, msg := h.ruleDataSource(ctx, rule); msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, alertRuleSnapshot, rule.ID)
+	updated, err := alerting.ScanRule(h.pool.QueryRow(ctx,
+		`UPDATE alert_rules
+		 SET datasource_id = $2, name = $3, query = $4, condition = $5, interval_seconds = $6,
+		     lookback_seconds = $7, for_seconds = $8, labels = $9, annotations = $10, enabled = $11,
+		     next_evaluation_at = $12, query_type = $13, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+alerting.RuleColumns,
+		rule.ID, rule.DatasourceID, rule.Name, rule.Query, rule.Condition, rule.IntervalSeconds,
+		rule.LookbackSeconds, rule.ForSeconds, rule.Labels, rule.Annotations, rule.Enabled, time.Now().UTC(),
+		rule.QueryType,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to update alert rule"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &rule.OrganizationID, "alert_rule.update", "alert_rule", rule.ID.String(),
+		before, auditSnapshot(ctx, h.pool, alertRuleSnapshot, rule.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// Delete deletes an alert rule and its alerts
+func (h *AlertRuleHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rule, role, ok := h.loadRule(ctx, w, r, userID)
+	if !ok {
+		return
+	}
+	if role == "viewer" {
+		http.Error(w, `{"error":"viewers cannot delete alert rules"}`, http.StatusForbidden)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, alertRuleSnapshot, rule.ID)
+	if _, err := h.pool.Exec(ctx, `DELETE FROM alert_rules WHERE id = $1`, rule.ID); err != nil {
+		http.Error(w, `{"error":"failed to delete alert rule"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &rule.OrganizationID, "alert_rule.delete", "alert_rule", rule.ID.String(), before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Test evaluates a rule once without saving it or its alerts
+func (h *AlertRuleHandler) Test(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	rule := newAlertRule(orgID, req)
+	if rule.Name == "" {
+		rule.Name = "test"
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	ds, msg := h.ruleDataSource(ctx, &rule)
+	if msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+
+	// Dry runs count against the org's query limits like any other query
+	if h.quotas != nil {
+		release, err := h.quotas.Acquire(ctx, orgID, userID, ds.ID)
+		if err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+		defer release()
+	}
+
+	client, err := datasource.NewClient(*ds)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "failed to create datasource client: " + err.Error()})
+		return
+	}
+	results, err := alerting.Evaluate(ctx, client, &rule, time.Now().UTC())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "evaluation failed: " + err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AlertRuleTestResponse{Results: results})
+}
+
+// ListAlerts lists an organization's pending, firing and recently resolved
+// alerts, optionally filtered by rule_id and state
+func (h *AlertRuleHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var ruleID *uuid.UUID
+	if v := r.URL.Query().Get("rule_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, `{"error":"invalid rule_id"}`, http.StatusBadRequest)
+			return
+		}
+		ruleID = &id
+	}
+	state := models.AlertState(r.URL.Query().Get("state"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	instances, err := alerting.ListInstances(ctx, h.pool, orgID, ruleID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch alerts"}`, http.StatusInternalServerError)
+		return
+	}
+	if state != "" {
+		filtered := []models.AlertInstance{}
+		for _, inst := range instances {
+			if inst.State == state {
+				filtered = append(filtered, inst)
+			}
+		}
+		instances = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(instances)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestAlertRuleHandler_Create_Unauthorized(t *testing.T) {
+	handler := &AlertRuleHandler{pool: nil}
+
+	body := bytes.NewBufferString(`{"name":"test"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/orgs/test/alert-rules", body)
+	req.SetPathValue("orgId", "not-a-uuid")
+	rr := httptest.NewRecorder()
+
+	handler.Create(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestNewAlertRuleDefaults(t *testing.T) {
+	rule := newAlertRule(uuid.New(), models.CreateAlertRuleRequest{})
+	if rule.IntervalSeconds != models.DefaultAlertIntervalSeconds || rule.LookbackSeconds != models.DefaultAlertLookbackSeconds {
+		t.Errorf("Expected default timing, got %d/%d", rule.IntervalSeconds, rule.LookbackSeconds)
+	}
+	if !rule.Enabled || rule.Labels == nil || rule.Annotations == nil {
+		t.Errorf("Expected an enabled rule with empty labels and annotations, got %+v", rule)
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	_, authHandler, cleanup := setupOrgTestWithRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-alerts-org'")
+	defer testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-alerts-org'")
+
+	vm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"instance":"a"},"values":[[1,"97"]]},
+			{"metric":{"instance":"b"},"values":[[1,"12"]]}
+		]}}`))
+	}))
+	defer vm.Close()
+
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, nil)
+	dsHandler := NewDataSourceHandler(testPool, nil, nil, NewDerivedAnnotations(testPool, nil))
+	alertHandler := NewAlertRuleHandler(testPool, nil, nil)
+
+	admin := createTestUser(t, authHandler, "testalertsadmin@example.com")
+	outsider := createTestUser(t, authHandler, "testalertsoutsider@example.com")
+
+	call := func(h http.HandlerFunc, method, path, body, token string, pathValues map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range pathValues {
+			req.SetPathValue(k, v)
+		}
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, h)(w, req)
+		return w
+	}
+
+	w := call(orgHandler.Create, "POST", "/api/orgs", `{"name":"Alerts Org","slug":"test-alerts-org"}`, admin.AccessToken, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create org: %d %s", w.Code, w.Body.String())
+	}
+	var org models.Organization
+	json.NewDecoder(w.Body).Decode(&org)
+	orgID := org.ID.String()
+	orgPath := map[string]string{"orgId": orgID}
+
+	w = call(dsHandler.Create, "POST", "/api/orgs/"+orgID+"/datasources",
+		`{"name":"vm","type":"victoriametrics","url":"`+vm.URL+`"}`, admin.AccessToken, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create datasource: %d %s", w.Code, w.Body.String())
+	}
+	var ds models.DataSource
+	json.NewDecoder(w.Body).Decode(&ds)
+
+	ruleBody := `{"datasource_id":"` + ds.ID.String() + `","name":"HighCPU","query":"cpu",
+		"condition":{"reducer":"last","operator":">","threshold":90},
+		"labels":{"severity":"page"},"annotations":{"summary":"{{ $labels.instance }} at {{ $value }}"}}`
+
+	// Dry run
+	w = call(alertHandler.Test, "POST", "/api/orgs/"+orgID+"/alert-rules/test", ruleBody, admin.AccessToken, orgPath)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Dry run failed: %d %s", w.Code, w.Body.String())
+	}
+	var test AlertRuleTestResponse
+	json.NewDecoder(w.Body).Decode(&test)
+	if len(test.Results) != 2 || !test.Results[0].Active || test.Results[1].Active {
+		t.Fatalf("Unexpected dry run results %+v", test.Results)
+	}
+
+	// Validation and membership
+	w = call(alertHandler.Create, "POST", "/api/orgs/"+orgID+"/alert-rules",
+		`{"datasource_id":"`+ds.ID.String()+`","name":"x","query":"up","condition":{"reducer":"median","operator":">"}}`,
+		admin.AccessToken, orgPath)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid reducer to be rejected, got %d", w.Code)
+	}
+	w = call(alertHandler.Create, "POST", "/api/orgs/"+orgID+"/alert-rules",
+		`{"datasource_id":"`+ds.ID.String()+`","name":"x","query":"error","query_type":"logs","condition":{"operator":">"}}`,
+		admin.AccessToken, orgPath)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a logs rule on a metrics datasource to be rejected, got %d", w.Code)
+	}
+	w = call(alertHandler.Create, "POST", "/api/orgs/"+orgID+"/alert-rules", ruleBody, outsider.AccessToken, orgPath)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected non-members to be rejected, got %d", w.Code)
+	}
+
+	w = call(alertHandler.Create, "POST", "/api/orgs/"+orgID+"/alert-rules", ruleBody, admin.AccessToken, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create rule: %d %s", w.Code, w.Body.String())
+	}
+	var rule models.AlertRule
+	json.NewDecoder(w.Body).Decode(&rule)
+	if rule.IntervalSeconds != models.DefaultAlertIntervalSeconds || rule.Health != models.AlertHealthUnknown ||
+		rule.QueryType != models.AlertQueryMetrics {
+		t.Errorf("Unexpected rule defaults %+v", rule)
+	}
+
+	// The new rule is due at once
+	if err := alerting.NewScheduler(testPool).RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+
+	w = call(alertHandler.ListAlerts, "GET", "/api/orgs/"+orgID+"/alerts?state=firing", "", admin.AccessToken, orgPath)
+	var alerts []models.AlertInstance
+	json.NewDecoder(w.Body).Decode(&alerts)
+	if len(alerts) != 1 {
+		t.Fatalf("Expected one firing alert, got %d: %s", len(alerts), w.Body.String())
+	}
+	if alerts[0].Labels["instance"] != "a" || alerts[0].Annotations["summary"] != "a at 97" {
+		t.Errorf("Unexpected alert %+v", alerts[0])
+	}
+
+	w = call(alertHandler.Get, "GET", "/api/alert-rules/"+rule.ID.String(), "", admin.AccessToken, map[string]string{"id": rule.ID.String()})
+	json.NewDecoder(w.Body).Decode(&rule)
+	if rule.Health != models.AlertHealthOK || rule.LastEvaluatedAt == nil {
+		t.Errorf("Expected the rule to be healthy after evaluation, got %+v", rule)
+	}
+
+	w = call(alertHandler.Update, "PUT", "/api/alert-rules/"+rule.ID.String(), `{"enabled":false}`,
+		admin.AccessToken, map[string]string{"id": rule.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to update rule: %d %s", w.Code, w.Body.String())
+	}
+
+	w = call(alertHandler.Delete, "DELETE", "/api/alert-rules/"+rule.ID.String(), "",
+		admin.AccessToken, map[string]string{"id": rule.ID.String()})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Failed to delete rule: %d %s", w.Code, w.Body.String())
+	}
+	w = call(alertHandler.ListAlerts, "GET", "/api/orgs/"+orgID+"/alerts", "", admin.AccessToken, orgPath)
+	json.NewDecoder(w.Body).Decode(&alerts)
+	if len(alerts) != 0 {
+		t.Errorf("Expected the rule's alerts to be deleted with it, got %d", len(alerts))
+	}
+}
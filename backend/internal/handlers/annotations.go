@@ -0,0 +1,950 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/datasource"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/quota"
+)
+
+type AnnotationHandler struct {
+	pool    *pgxpool.Pool
+	quotas  *quota.Manager
+	audit   *audit.Logger
+	derived *DerivedAnnotations
+}
+
+func NewAnnotationHandler(pool *pgxpool.Pool, quotas *quota.Manager, auditLog *audit.Logger, derived *DerivedAnnotations) *AnnotationHandler {
+	return &AnnotationHandler{pool: pool, quotas: quotas, audit: auditLog, derived: derived}
+}
+
+// Audit log views of annotations and annotation queries
+const (
+	annotationSnapshot      = `SELECT to_jsonb(a) FROM annotations a WHERE id = $1`
+	annotationQuerySnapshot = `SELECT to_jsonb(q) FROM annotation_queries q WHERE id = $1`
+)
+
+// Annotation list bounds
+const (
+	defaultAnnotationLimit = 100
+	maxAnnotationLimit     = 1000
+)
+
+const annotationColumns = `id, organization_id, dashboard_id, panel_id, time, time_end, text, tags, created_by,
+	created_at, updated_at`
+
+func scanAnnotation(row pgx.Row) (*models.Annotation, error) {
+	var a models.Annotation
+	err := row.Scan(&a.ID, &a.OrganizationID, &a.DashboardID, &a.PanelID, &a.Time, &a.TimeEnd, &a.Text, &a.Tags,
+		&a.CreatedBy, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+const annotationQueryColumns = `id, organization_id, dashboard_id, datasource_id, name, query, tags, enabled, created_by,
+	created_at, updated_at`
+
+func scanAnnotationQuery(row pgx.Row) (*models.AnnotationQuery, error) {
+	var q models.AnnotationQuery
+	err := row.Scan(&q.ID, &q.OrganizationID, &q.DashboardID, &q.DatasourceID, &q.Name, &q.Query, &q.Tags, &q.Enabled,
+		&q.CreatedBy, &q.CreatedAt, &q.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func (h *AnnotationHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
+	var role string
+	err := h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
+		userID, orgID,
+	).Scan(&role)
+	return role, err
+}
+
+// checkEditor writes the error response unless the user is an admin or
+// editor of the org
+func (h *AnnotationHandler) checkEditor(ctx context.Context, w http.ResponseWriter, userID, orgID uuid.UUID, action string) bool {
+	role, err := h.checkOrgMembership(ctx, userID, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return false
+	}
+	if role == "viewer" {
+		http.Error(w, fmt.Sprintf(`{"error":"viewers cannot %s"}`, action), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkBinding checks that an annotation's dashboard is in its organization
+// and its panel on that dashboard. The error is the message for a 400 response.
+func (h *AnnotationHandler) checkBinding(ctx context.Context, a *models.Annotation) string {
+	if a.DashboardID == nil {
+		return ""
+	}
+	var exists bool
+	err := h.pool.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM dashboards WHERE id = $1 AND organization_id = $2)`,
+		a.DashboardID, a.OrganizationID,
+	).Scan(&exists)
+	if err != nil || !exists {
+		return "dashboard not found"
+	}
+	if a.PanelID == nil {
+		return ""
+	}
+	err = h.pool.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM panels WHERE id = $1 AND dashboard_id = $2)`, a.PanelID, a.DashboardID,
+	).Scan(&exists)
+	if err != nil || !exists {
+		return "panel not found on this dashboard"
+	}
+	return ""
+}
+
+// loadAnnotation fetches the annotation with the id in the path, writing the
+// error response when it doesn't exist
+func (h *AnnotationHandler) loadAnnotation(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Annotation, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid annotation id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	a, err := scanAnnotation(h.pool.QueryRow(ctx, `SELECT `+annotationColumns+` FROM annotations WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"annotation not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch annotation"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return a, true
+}
+
+// CreateAnnotation creates an annotation for an organization, optionally
+// bound to a dashboard or panel
+func (h *AnnotationHandler) CreateAnnotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	a := models.Annotation{
+		OrganizationID: orgID,
+		DashboardID:    req.DashboardID,
+		PanelID:        req.PanelID,
+		Time:           time.Now().UTC(),
+		Text:           req.Text,
+		Tags:           req.Tags,
+	}
+	if req.Time != nil {
+		a.Time = req.Time.UTC()
+	}
+	if req.TimeEnd != nil {
+		end := req.TimeEnd.UTC()
+		a.TimeEnd = &end
+	}
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	if err := a.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !h.checkEditor(ctx, w, userID, orgID, "create annotations") {
+		return
+	}
+	if msg := h.checkBinding(ctx, &a); msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+
+	created, err := scanAnnotation(h.pool.QueryRow(ctx,
+		`INSERT INTO annotations (organization_id, dashboard_id, panel_id, time, time_end, text, tags, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING `+annotationColumns,
+		orgID, a.DashboardID, a.PanelID, a.Time, a.TimeEnd, a.Text, a.Tags, userID,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create annotation"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "annotation.create", "annotation", created.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, annotationSnapshot, created.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListAnnotations lists an organization's annotations, newest first. They can
+// be filtered by time range (from and to, in Unix seconds), dashboard_id,
+// panel_id and tags (comma separated, all must match).
+func (h *AnnotationHandler) ListAnnotations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	var from, to *time.Time
+	for name, dst := range map[string]**time.Time{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			sec, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error":"invalid %s"}`, name), http.StatusBadRequest)
+				return
+			}
+			t := time.Unix(sec, 0).UTC()
+			*dst = &t
+		}
+	}
+	var dashboardID, panelID *uuid.UUID
+	for name, dst := range map[string]**uuid.UUID{"dashboard_id": &dashboardID, "panel_id": &panelID} {
+		if v := q.Get(name); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error":"invalid %s"}`, name), http.StatusBadRequest)
+				return
+			}
+			*dst = &id
+		}
+	}
+	tags := []string{}
+	if v := q.Get("tags"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	limit := defaultAnnotationLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAnnotationLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+annotationColumns+` FROM annotations
+		 WHERE organization_id = $1
+		   AND ($2::timestamp IS NULL OR COALESCE(time_end, time) >= $2)
+		   AND ($3::timestamp IS NULL OR time <= $3)
+		   AND ($4::uuid IS NULL OR dashboard_id = $4)
+		   AND ($5::uuid IS NULL OR panel_id = $5)
+		   AND tags @> $6
+		 ORDER BY time DESC
+		 LIMIT $7`,
+		orgID, from, to, dashboardID, panelID, tags, limit,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch annotations"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	annotations := []models.Annotation{}
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan annotation"}`, http.StatusInternalServerError)
+			return
+		}
+		annotations = append(annotations, *a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(annotations)
+}
+
+// GetAnnotation returns a single annotation
+func (h *AnnotationHandler) GetAnnotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	a, ok := h.loadAnnotation(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.checkOrgMembership(ctx, userID, a.OrganizationID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// UpdateAnnotation updates an annotation's time, text or tags
+func (h *AnnotationHandler) UpdateAnnotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	a, ok := h.loadAnnotation(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, a.OrganizationID, "update annotations") {
+		return
+	}
+
+	if req.Time != nil {
+		a.Time = req.Time.UTC()
+	}
+	if req.TimeEnd != nil {
+		end := req.TimeEnd.UTC()
+		a.TimeEnd = &end
+	}
+	if req.Text != nil {
+		a.Text = *req.Text
+	}
+	if req.Tags != nil {
+		a.Tags = req.Tags
+	}
+	if err := a.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, annotationSnapshot, a.ID)
+	updated, err := scanAnnotation(h.pool.QueryRow(ctx,
+		`UPDATE annotations SET time = $2, time_end = $3, text = $4, tags = $5, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+annotationColumns,
+		a.ID, a.Time, a.TimeEnd, a.Text, a.Tags,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to update annotation"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &a.OrganizationID, "annotation.update", "annotation", a.ID.String(),
+		before, auditSnapshot(ctx, h.pool, annotationSnapshot, a.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteAnnotation deletes an annotation
+func (h *AnnotationHandler) DeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	a, ok := h.loadAnnotation(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, a.OrganizationID, "delete annotations") {
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, annotationSnapshot, a.ID)
+	if _, err := h.pool.Exec(ctx, `DELETE FROM annotations WHERE id = $1`, a.ID); err != nil {
+		http.Error(w, `{"error":"failed to delete annotation"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &a.OrganizationID, "annotation.delete", "annotation", a.ID.String(), before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dashboardOrg returns the organization of the dashboard with the id in the
+// path, writing the error response when it doesn't exist
+func (h *AnnotationHandler) dashboardOrg(ctx context.Context, w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	dashboardID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid dashboard id"}`, http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+	var orgID *uuid.UUID
+	err = h.pool.QueryRow(ctx, `SELECT organization_id FROM dashboards WHERE id = $1`, dashboardID).Scan(&orgID)
+	if err != nil || orgID == nil {
+		http.Error(w, `{"error":"dashboard not found"}`, http.StatusNotFound)
+		return uuid.Nil, uuid.Nil, false
+	}
+	return dashboardID, *orgID, true
+}
+
+// checkQueryDataSource checks that an annotation query's datasource is in its
+// organization
+func (h *AnnotationHandler) checkQueryDataSource(ctx context.Context, q *models.AnnotationQuery) bool {
+	ds, err := alerting.LoadDataSource(ctx, h.pool, q.DatasourceID)
+	return err == nil && ds.OrganizationID == q.OrganizationID
+}
+
+// loadAnnotationQuery fetches the annotation query with the id in the path,
+// writing the error response when it doesn't exist
+func (h *AnnotationHandler) loadAnnotationQuery(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.AnnotationQuery, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid annotation query id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	q, err := scanAnnotationQuery(h.pool.QueryRow(ctx,
+		`SELECT `+annotationQueryColumns+` FROM annotation_queries WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"annotation query not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch annotation query"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return q, true
+}
+
+// CreateQuery adds an annotation query to a dashboard
+func (h *AnnotationHandler) CreateQuery(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateAnnotationQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	dashboardID, orgID, ok := h.dashboardOrg(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	q := models.AnnotationQuery{
+		OrganizationID: orgID,
+		DashboardID:    dashboardID,
+		DatasourceID:   req.DatasourceID,
+		Name:           req.Name,
+		Query:          req.Query,
+		Tags:           req.Tags,
+		Enabled:        true,
+	}
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
+	if req.Enabled != nil {
+		q.Enabled = *req.Enabled
+	}
+	if err := q.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkEditor(ctx, w, userID, orgID, "create annotation queries") {
+		return
+	}
+	if !h.checkQueryDataSource(ctx, &q) {
+		http.Error(w, `{"error":"datasource not found"}`, http.StatusBadRequest)
+		return
+	}
+
+	created, err := scanAnnotationQuery(h.pool.QueryRow(ctx,
+		`INSERT INTO annotation_queries (organization_id, dashboard_id, datasource_id, name, query, tags, enabled, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING `+annotationQueryColumns,
+		orgID, dashboardID, q.DatasourceID, q.Name, q.Query, q.Tags, q.Enabled, userID,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create annotation query"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "annotation_query.create", "annotation_query", created.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, annotationQuerySnapshot, created.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListQueries lists a dashboard's annotation queries
+func (h *AnnotationHandler) ListQueries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	dashboardID, orgID, ok := h.dashboardOrg(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+annotationQueryColumns+` FROM annotation_queries WHERE dashboard_id = $1 ORDER BY name ASC`,
+		dashboardID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch annotation queries"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	queries := []models.AnnotationQuery{}
+	for rows.Next() {
+		q, err := scanAnnotationQuery(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan annotation query"}`, http.StatusInternalServerError)
+			return
+		}
+		queries = append(queries, *q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queries)
+}
+
+// UpdateQuery updates an annotation query
+func (h *AnnotationHandler) UpdateQuery(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateAnnotationQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	q, ok := h.loadAnnotationQuery(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, q.OrganizationID, "update annotation queries") {
+		return
+	}
+
+	if req.DatasourceID != nil {
+		q.DatasourceID = *req.DatasourceID
+	}
+	if req.Name != nil {
+		q.Name = *req.Name
+	}
+	if req.Query != nil {
+		q.Query = *req.Query
+	}
+	if req.Tags != nil {
+		q.Tags = req.Tags
+	}
+	if req.Enabled != nil {
+		q.Enabled = *req.Enabled
+	}
+	if err := q.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !h.checkQueryDataSource(ctx, q) {
+		http.Error(w, `{"error":"datasource not found"}`, http.StatusBadRequest)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, annotationQuerySnapshot, q.ID)
+	updated, err := scanAnnotationQuery(h.pool.QueryRow(ctx,
+		`UPDATE annotation_queries
+		 SET datasource_id = $2, name = $3, query = $4, tags = $5, enabled = $6, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+annotationQueryColumns,
+		q.ID, q.DatasourceID, q.Name, q.Query, q.Tags, q.Enabled,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to update annotation query"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &q.OrganizationID, "annotation_query.update", "annotation_query", q.ID.String(),
+		before, auditSnapshot(ctx, h.pool, annotationQuerySnapshot, q.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteQuery deletes an annotation query
+func (h *AnnotationHandler) DeleteQuery(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	q, ok := h.loadAnnotationQuery(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, q.OrganizationID, "delete annotation queries") {
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, annotationQuerySnapshot, q.ID)
+	if _, err := h.pool.Exec(ctx, `DELETE FROM annotation_queries WHERE id = $1`, q.ID); err != nil {
+		http.Error(w, `{"error":"failed to delete annotation query"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &q.OrganizationID, "annotation_query.delete", "annotation_query", q.ID.String(), before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DashboardAnnotations returns the annotations to draw on a dashboard's
+// panels between from and to (Unix seconds, the last hour by default): the
+// organization's stored annotations for the dashboard, with panel_id set on
+// those for one panel, and the events found by its enabled annotation queries.
+// Panels also get their annotations with their data (see panelAnnotations);
+// this serves clients drawing them for the whole dashboard at once. A failing
+// query, or one over the org's limits, is skipped rather than failing the rest.
+func (h *AnnotationHandler) DashboardAnnotations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	end := time.Now()
+	start := end.Add(-time.Hour)
+	for name, dst := range map[string]*time.Time{"from": &start, "to": &end} {
+		if v := q.Get(name); v != "" {
+			sec, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error":"invalid %s"}`, name), http.StatusBadRequest)
+				return
+			}
+			*dst = time.Unix(sec, 0)
+		}
+	}
+	if end.Before(start) {
+		http.Error(w, `{"error":"to must not be before from"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	dashboardID, orgID, ok := h.dashboardOrg(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	events, err := storedAnnotations(ctx, h.pool, orgID, dashboardID, start, end)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch annotations"}`, http.StatusInternalServerError)
+		return
+	}
+	events = append(events, h.derived.events(ctx, userID, orgID, dashboardID, start, end)...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// panelAnnotations collects the annotations to draw on a panel between start
+// and end: the organization's stored annotations that apply to the dashboard
+// and panel, and the events found by the dashboard's annotation queries, which
+// the dashboard's panels share. Annotations never fail the panel's own query,
+// so errors are only logged.
+func panelAnnotations(ctx context.Context, pool *pgxpool.Pool, derived *DerivedAnnotations, userID, orgID, dashboardID uuid.UUID,
+	panelID *uuid.UUID, start, end time.Time) []models.AnnotationEvent {
+	stored, err := storedAnnotations(ctx, pool, orgID, dashboardID, start, end)
+	if err != nil {
+		log.Printf("Failed to load annotations for dashboard %s: %v", dashboardID, err)
+	}
+
+	events := []models.AnnotationEvent{}
+	for _, e := range stored {
+		if e.PanelID == nil || (panelID != nil && *e.PanelID == *panelID) {
+			events = append(events, e)
+		}
+	}
+	return append(events, derived.events(ctx, userID, orgID, dashboardID, start, end)...)
+}
+
+// storedAnnotations loads the organization's annotations that apply to the
+// dashboard between start and end, oldest first
+func storedAnnotations(ctx context.Context, pool *pgxpool.Pool, orgID, dashboardID uuid.UUID, start, end time.Time) ([]models.AnnotationEvent, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT `+annotationColumns+` FROM annotations
+		 WHERE organization_id = $1
+		   AND (dashboard_id IS NULL OR dashboard_id = $2)
+		   AND COALESCE(time_end, time) >= $3 AND time <= $4
+		 ORDER BY time ASC
+		 LIMIT $5`,
+		orgID, dashboardID, start.UTC(), end.UTC(), maxAnnotationLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AnnotationEvent{}
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, models.AnnotationEvent{
+			Time:         a.Time,
+			TimeEnd:      a.TimeEnd,
+			Text:         a.Text,
+			Tags:         a.Tags,
+			PanelID:      a.PanelID,
+			AnnotationID: &a.ID,
+		})
+	}
+	return events, rows.Err()
+}
+
+// derivedAnnotationTTL is how long the events found by a dashboard's
+// annotation queries are shared by its panels
+const derivedAnnotationTTL = 30 * time.Second
+
+// DerivedAnnotations runs a dashboard's annotation queries once per time range
+// and shares the events with every panel of the dashboard asking for them
+// until they expire, so a dashboard doesn't run its queries once per panel.
+// The datasource and annotation handlers must share one, so that clients
+// using both don't run the queries twice.
+type DerivedAnnotations struct {
+	pool   *pgxpool.Pool
+	quotas *quota.Manager
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*derivedAnnotationEntry
+}
+
+type derivedAnnotationEntry struct {
+	done    chan struct{} // closed once events is set
+	events  []models.AnnotationEvent
+	expires time.Time
+}
+
+func NewDerivedAnnotations(pool *pgxpool.Pool, quotas *quota.Manager) *DerivedAnnotations {
+	return &DerivedAnnotations{pool: pool, quotas: quotas, now: time.Now, entries: map[string]*derivedAnnotationEntry{}}
+}
+
+// annotationStep returns the resolution annotation queries run at between
+// start and end. It depends only on the range, so that panels of different
+// widths share the events.
+func annotationStep(start, end time.Time) time.Duration {
+	step := end.Sub(start) / 500
+	if step < 15*time.Second {
+		step = 15 * time.Second
+	}
+	return step.Truncate(time.Second)
+}
+
+// events returns the events the dashboard's enabled annotation queries find
+// between start and end. The range is widened to whole steps, so panels
+// refreshed a few seconds apart share one run. The first caller runs the
+// queries against its own quota; concurrent callers wait for its result.
+func (d *DerivedAnnotations) events(ctx context.Context, userID, orgID, dashboardID uuid.UUID, start, end time.Time) []models.AnnotationEvent {
+	step := annotationStep(start, end)
+	start, end = start.Truncate(step), end.Truncate(step).Add(step)
+	key := fmt.Sprintf("%s|%s|%d|%d", orgID, dashboardID, start.Unix(), end.Unix())
+
+	d.mu.Lock()
+	now := d.now()
+	for k, e := range d.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(d.entries, k)
+		}
+	}
+	entry, found := d.entries[key]
+	if !found {
+		entry = &derivedAnnotationEntry{done: make(chan struct{})}
+		d.entries[key] = entry
+	}
+	d.mu.Unlock()
+
+	if !found {
+		// The run outlives this request, since other panels may be waiting for it
+		runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		events := d.run(runCtx, userID, orgID, dashboardID, start, end, step)
+		cancel()
+
+		d.mu.Lock()
+		entry.events = events
+		entry.expires = d.now().Add(derivedAnnotationTTL)
+		d.mu.Unlock()
+		close(entry.done)
+	}
+
+	select {
+	case <-entry.done:
+		return entry.events
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// run runs each of the dashboard's enabled annotation queries, skipping those
+// that fail or are over the org's limits
+func (d *DerivedAnnotations) run(ctx context.Context, userID, orgID, dashboardID uuid.UUID, start, end time.Time, step time.Duration) []models.AnnotationEvent {
+	events := []models.AnnotationEvent{}
+
+	rows, err := d.pool.Query(ctx,
+		`SELECT `+annotationQueryColumns+` FROM annotation_queries
+		 WHERE dashboard_id = $1 AND organization_id = $2 AND enabled`,
+		dashboardID, orgID,
+	)
+	if err != nil {
+		log.Printf("Failed to load annotation queries for dashboard %s: %v", dashboardID, err)
+		return events
+	}
+	var queries []*models.AnnotationQuery
+	for rows.Next() {
+		q, err := scanAnnotationQuery(rows)
+		if err != nil {
+			log.Printf("Failed to scan annotation query: %v", err)
+			break
+		}
+		queries = append(queries, q)
+	}
+	rows.Close()
+
+	for _, q := range queries {
+		derived, err := runAnnotationQuery(ctx, d.pool, d.quotas, userID, q, start, end, step)
+		if err != nil {
+			log.Printf("Annotation query %s failed: %v", q.ID, err)
+			continue
+		}
+		events = append(events, derived...)
+	}
+	return events
+}
+
+// runAnnotationQuery runs one annotation query and tags its events
+func runAnnotationQuery(ctx context.Context, pool *pgxpool.Pool, quotas *quota.Manager, userID uuid.UUID,
+	q *models.AnnotationQuery, start, end time.Time, step time.Duration) ([]models.AnnotationEvent, error) {
+	ds, err := alerting.LoadDataSource(ctx, pool, q.DatasourceID)
+	if err != nil {
+		return nil, err
+	}
+	if quotas != nil {
+		release, err := quotas.Acquire(ctx, q.OrganizationID, userID, ds.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	client, err := datasource.NewClient(*ds)
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Query(ctx, q.Query, start, end, step, datasource.MaxAnnotationEvents)
+	if err != nil {
+		return nil, err
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("%s", result.Error)
+	}
+
+	events := datasource.AnnotationEvents(result)
+	for i := range events {
+		events[i].Text = q.Name + ": " + events[i].Text
+		events[i].Tags = q.Tags
+		events[i].QueryID = &q.ID
+	}
+	return events, nil
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestAnnotationHandler_CreateAnnotation_Unauthorized(t *testing.T) {
+	handler := &AnnotationHandler{pool: nil}
+
+	body := bytes.NewBufferString(`{"text":"deploy"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/orgs/test/annotations", body)
+	req.SetPathValue("orgId", "not-a-uuid")
+	rr := httptest.NewRecorder()
+
+	handler.CreateAnnotation(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	_, authHandler, cleanup := setupOrgTestWithRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-annotations-org'")
+	defer testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-annotations-org'")
+
+	var vmCalls atomic.Int32
+	vm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vmCalls.Add(1)
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"build_info","version":"x"},"values":[[1700000000,"1"],[1700000060,"2"]]}
+		]}}`))
+	}))
+	defer vm.Close()
+
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, nil)
+	dashboardHandler := NewDashboardHandler(testPool, nil)
+	annotations := NewDerivedAnnotations(testPool, nil)
+	dsHandler := NewDataSourceHandler(testPool, nil, nil, annotations)
+	handler := NewAnnotationHandler(testPool, nil, nil, annotations)
+
+	admin := createTestUser(t, authHandler, "testannotationsadmin@example.com")
+	outsider := createTestUser(t, authHandler, "testannotationsoutsider@example.com")
+
+	call := func(h http.HandlerFunc, method, path, body, token string, pathValues map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range pathValues {
+			req.SetPathValue(k, v)
+		}
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, h)(w, req)
+		return w
+	}
+
+	w := call(orgHandler.Create, "POST", "/api/orgs", `{"name":"Annotations Org","slug":"test-annotations-org"}`, admin.AccessToken, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create org: %d %s", w.Code, w.Body.String())
+	}
+	var org models.Organization
+	json.NewDecoder(w.Body).Decode(&org)
+	orgID := org.ID.String()
+	orgPath := map[string]string{"orgId": orgID}
+
+	w = call(dashboardHandler.Create, "POST", "/api/orgs/"+orgID+"/dashboards", `{"title":"Deploys"}`, admin.AccessToken, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create dashboard: %d %s", w.Code, w.Body.String())
+	}
+	var dashboard models.Dashboard
+	json.NewDecoder(w.Body).Decode(&dashboard)
+	dashboardID := dashboard.ID.String()
+
+	w = call(dsHandler.Create, "POST", "/api/orgs/"+orgID+"/datasources",
+		`{"name":"vm","type":"victoriametrics","url":"`+vm.URL+`"}`, admin.AccessToken, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create datasource: %d %s", w.Code, w.Body.String())
+	}
+	var ds models.DataSource
+	json.NewDecoder(w.Body).Decode(&ds)
+
+	// An org-wide range and a dashboard annotation
+	w = call(handler.CreateAnnotation, "POST", "/api/orgs/"+orgID+"/annotations",
+		`{"time":"2023-11-14T22:00:00Z","time_end":"2023-11-14T23:00:00Z","text":"Incident","tags":["incident"]}`,
+		admin.AccessToken, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create annotation: %d %s", w.Code, w.Body.String())
+	}
+	var incident models.Annotation
+	json.NewDecoder(w.Body).Decode(&incident)
+
+	w = call(handler.CreateAnnotation, "POST", "/api/orgs/"+orgID+"/annotations",
+		`{"dashboard_id":"`+dashboardID+`","time":"2023-11-14T22:15:00Z","text":"Deploy","tags":["deploy"]}`,
+		admin.AccessToken, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create dashboard annotation: %d %s", w.Code, w.Body.String())
+	}
+
+	w = call(handler.CreateAnnotation, "POST", "/api/orgs/"+orgID+"/annotations",
+		`{"time":"2023-11-14T22:00:00Z","time_end":"2023-11-14T21:00:00Z","text":"Backwards"}`, admin.AccessToken, orgPath)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a backwards range to be rejected, got %d", w.Code)
+	}
+
+	w = call(handler.CreateAnnotation, "POST", "/api/orgs/"+orgID+"/annotations", `{"text":"Nope"}`, outsider.AccessToken, orgPath)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected non-members to be rejected, got %d", w.Code)
+	}
+
+	// Tag filter
+	w = call(handler.ListAnnotations, "GET", "/api/orgs/"+orgID+"/annotations?tags=deploy", "", admin.AccessToken, orgPath)
+	var listed []models.Annotation
+	json.NewDecoder(w.Body).Decode(&listed)
+	if w.Code != http.StatusOK || len(listed) != 1 || listed[0].Text != "Deploy" {
+		t.Fatalf("Unexpected tag filtered list: %d %+v", w.Code, listed)
+	}
+
+	w = call(handler.UpdateAnnotation, "PUT", "/api/annotations/"+incident.ID.String(), `{"text":"Major incident"}`,
+		admin.AccessToken, map[string]string{"id": incident.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to update annotation: %d %s", w.Code, w.Body.String())
+	}
+
+	w = call(handler.CreateQuery, "POST", "/api/dashboards/"+dashboardID+"/annotation-queries",
+		`{"datasource_id":"`+ds.ID.String()+`","name":"Releases","query":"build_info","tags":["release"]}`,
+		admin.AccessToken, map[string]string{"id": dashboardID})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create annotation query: %d %s", w.Code, w.Body.String())
+	}
+
+	// The dashboard's annotations carry both stored and derived events
+	w = call(handler.DashboardAnnotations, "GET", "/api/dashboards/"+dashboardID+"/annotations?from=1699999200&to=1700006400",
+		"", admin.AccessToken, map[string]string{"id": dashboardID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to fetch dashboard annotations: %d %s", w.Code, w.Body.String())
+	}
+	var events []models.AnnotationEvent
+	json.NewDecoder(w.Body).Decode(&events)
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 dashboard annotations, got %+v", events)
+	}
+	var derived int
+	for _, a := range events {
+		if a.QueryID != nil {
+			derived++
+			if a.Text != `Releases: build_info{version="x"} changed from 1 to 2` || len(a.Tags) != 1 || a.Tags[0] != "release" {
+				t.Errorf("Unexpected derived annotation %+v", a)
+			}
+		}
+	}
+	if derived != 1 {
+		t.Errorf("Expected 1 derived annotation, got %d", derived)
+	}
+
+	w = call(handler.DashboardAnnotations, "GET", "/api/dashboards/"+dashboardID+"/annotations", "",
+		outsider.AccessToken, map[string]string{"id": dashboardID})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected non-members to be rejected, got %d", w.Code)
+	}
+
+	// Panels get the annotations with their data, and share the dashboard's
+	// annotation query runs with each other and the dashboard annotations above
+	panelQuery := `{"query":"up","start":1699999200,"end":1700006400,"dashboard_id":"` + dashboardID + `"}`
+	vmCalls.Store(0)
+	for i := 0; i < 3; i++ {
+		w = call(dsHandler.Query, "POST", "/api/datasources/"+ds.ID.String()+"/query",
+			panelQuery, admin.AccessToken, map[string]string{"id": ds.ID.String()})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Query failed: %d %s", w.Code, w.Body.String())
+		}
+		var result struct {
+			Annotations []models.AnnotationEvent `json:"annotations"`
+		}
+		json.NewDecoder(w.Body).Decode(&result)
+		if len(result.Annotations) != 3 {
+			t.Errorf("Expected the panel's data to carry 3 annotations, got %+v", result.Annotations)
+		}
+	}
+	if n := vmCalls.Load(); n != 3 {
+		t.Errorf("Expected only the 3 panel queries to reach the datasource, got %d calls", n)
+	}
+
+	w = call(handler.DeleteAnnotation, "DELETE", "/api/annotations/"+incident.ID.String(), "",
+		admin.AccessToken, map[string]string{"id": incident.ID.String()})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Failed to delete annotation: %d %s", w.Code, w.Body.String())
+	}
+}
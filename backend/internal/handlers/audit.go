@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+)
+
+type AuditHandler struct {
+	pool  *pgxpool.Pool
+	audit *audit.Logger
+}
+
+func NewAuditHandler(pool *pgxpool.Pool, auditLog *audit.Logger) *AuditHandler {
+	return &AuditHandler{pool: pool, audit: auditLog}
+}
+
+// AuditLogResponse is a page of audit entries
+type AuditLogResponse struct {
+	Entries    []audit.Entry `json:"entries"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// List returns the organization's audit log, newest first (admin only).
+// Filters: action (or a prefix like "datasource."), actor_id, target_type,
+// target_id, since and until (RFC 3339); pages with limit and cursor.
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Cursor:     q.Get("cursor"),
+	}
+	if v := q.Get("actor_id"); v != "" {
+		actorID, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, `{"error":"invalid actor_id"}`, http.StatusBadRequest)
+			return
+		}
+		filter.ActorID = &actorID
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, `{"error":"invalid `+name+`, expected RFC 3339"}`, http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	role, ok := tokenOrgRole(ctx, userID, orgID)
+	if !ok {
+		err = h.pool.QueryRow(ctx,
+			`SELECT role FROM organization_memberships WHERE organization_id = $1 AND user_id = $2`,
+			orgID, userID,
+		).Scan(&role)
+		if err != nil {
+			http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+			return
+		}
+	}
+	if role != "admin" {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return
+	}
+
+	entries, next, err := h.audit.List(ctx, orgID, filter)
+	if err == audit.ErrInvalidCursor {
+		http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch audit log"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditLogResponse{Entries: entries, NextCursor: next})
+}
+
+// recordAudit logs a change made by the request's user. before and after are
+// reduced to the fields that changed; either may be nil. Failures are logged
+// rather than failing a change that has already been made.
+func recordAudit(r *http.Request, auditLog *audit.Logger, orgID *uuid.UUID, action, targetType, targetID string, before, after any) {
+	if auditLog == nil {
+		return
+	}
+
+	entry := &audit.Entry{
+		OrganizationID: orgID,
+		Action:         action,
+		TargetType:     targetType,
+		TargetID:       targetID,
+	}
+	if userID, ok := auth.GetUserID(r.Context()); ok {
+		entry.ActorID = &userID
+	}
+	entry.ActorEmail, _ = auth.GetUserEmail(r.Context())
+	client := clientInfo(r)
+	entry.IP, entry.UserAgent = client.IP, client.UserAgent
+
+	var err error
+	entry.Before, entry.After, err = audit.Diff(before, after)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		err = auditLog.Record(ctx, entry)
+	}
+	if err != nil {
+		log.Printf("Failed to record audit entry %s for %s %s: %v", action, targetType, targetID, err)
+	}
+}
+
+// auditSnapshot returns the row selected by query as a JSON object, or nil if
+// there is none. The query selects a single to_jsonb(...) column.
+func auditSnapshot(ctx context.Context, pool *pgxpool.Pool, query string, args ...any) json.RawMessage {
+	var snapshot []byte
+	if err := pool.QueryRow(ctx, query, args...).Scan(&snapshot); err != nil {
+		return nil
+	}
+	return snapshot
+}
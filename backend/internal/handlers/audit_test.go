@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	_, authHandler, cleanup := setupOrgTestWithRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-audit-org'")
+	defer testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-audit-org'")
+
+	auditLog := audit.NewLogger(testPool, nil)
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, auditLog)
+	dsHandler := NewDataSourceHandler(testPool, nil, auditLog, NewDerivedAnnotations(testPool, nil))
+	auditHandler := NewAuditHandler(testPool, auditLog)
+
+	admin := createTestUser(t, authHandler, "testauditadmin@example.com")
+	outsider := createTestUser(t, authHandler, "testauditoutsider@example.com")
+
+	call := func(h http.HandlerFunc, method, path, body, token string, pathValues map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", "AuditTest/1.0")
+		for k, v := range pathValues {
+			req.SetPathValue(k, v)
+		}
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, h)(w, req)
+		return w
+	}
+
+	w := call(orgHandler.Create, "POST", "/api/orgs", `{"name":"Audit Org","slug":"test-audit-org"}`, admin.AccessToken, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create org: %d %s", w.Code, w.Body.String())
+	}
+	var org models.Organization
+	json.NewDecoder(w.Body).Decode(&org)
+	orgID := org.ID.String()
+
+	w = call(dsHandler.Create, "POST", "/api/orgs/"+orgID+"/datasources",
+		`{"name":"prom","type":"prometheus","url":"http://a:9090","auth_type":"bearer","auth_config":{"token":"secret-1"}}`,
+		admin.AccessToken, map[string]string{"orgId": orgID})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create datasource: %d %s", w.Code, w.Body.String())
+	}
+	var ds models.DataSource
+	json.NewDecoder(w.Body).Decode(&ds)
+
+	w = call(dsHandler.Update, "PUT", "/api/datasources/"+ds.ID.String(), `{"url":"http://b:9090"}`,
+		admin.AccessToken, map[string]string{"id": ds.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to update datasource: %d %s", w.Code, w.Body.String())
+	}
+	w = call(dsHandler.Delete, "DELETE", "/api/datasources/"+ds.ID.String(), "",
+		admin.AccessToken, map[string]string{"id": ds.ID.String()})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Failed to delete datasource: %d %s", w.Code, w.Body.String())
+	}
+
+	list := func(query, token string) (*httptest.ResponseRecorder, AuditLogResponse) {
+		w := call(auditHandler.List, "GET", "/api/orgs/"+orgID+"/audit"+query, "", token, map[string]string{"id": orgID})
+		var resp AuditLogResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	w, resp := list("", admin.AccessToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var actions []string
+	for _, e := range resp.Entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"datasource.delete", "datasource.update", "datasource.create", "organization.create"}
+	if len(actions) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, actions)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, actions)
+		}
+	}
+
+	update := resp.Entries[1]
+	if update.ActorEmail != "testauditadmin@example.com" || update.UserAgent != "AuditTest/1.0" || update.TargetID != ds.ID.String() {
+		t.Errorf("Unexpected entry metadata: %+v", update)
+	}
+	var before, after map[string]any
+	json.Unmarshal(update.Before, &before)
+	json.Unmarshal(update.After, &after)
+	if before["url"] != "http://a:9090" || after["url"] != "http://b:9090" {
+		t.Errorf("Expected the URL change, got %s -> %s", update.Before, update.After)
+	}
+	if _, ok := after["name"]; ok {
+		t.Errorf("Expected unchanged fields to be left out, got %s", update.After)
+	}
+	if bytes.Contains(resp.Entries[2].After, []byte("secret-1")) {
+		t.Errorf("Expected the datasource credentials to be redacted, got %s", resp.Entries[2].After)
+	}
+
+	// Filtering by action prefix, two entries per page
+	_, page := list("?action=datasource.&limit=2", admin.AccessToken)
+	if len(page.Entries) != 2 || page.NextCursor == "" {
+		t.Fatalf("Expected a full first page with a cursor, got %d entries", len(page.Entries))
+	}
+	_, page = list("?action=datasource.&limit=2&cursor="+page.NextCursor, admin.AccessToken)
+	if len(page.Entries) != 1 || page.Entries[0].Action != "datasource.create" || page.NextCursor != "" {
+		t.Errorf("Expected the last datasource entry on the second page, got %+v", page)
+	}
+
+	if w, _ := list("?cursor=bogus", admin.AccessToken); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid cursor, got %d", w.Code)
+	}
+	if w, _ := list("", outsider.AccessToken); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a non-member, got %d", w.Code)
+	}
+
+	// Entries can't be changed or removed
+	if _, err := testPool.Exec(ctx, `UPDATE audit_log SET action = 'x' WHERE organization_id = $1`, org.ID); err == nil {
+		t.Error("Expected updating the audit log to fail")
+	}
+	if _, err := testPool.Exec(ctx, `DELETE FROM audit_log WHERE organization_id = $1`, org.ID); err == nil {
+		t.Error("Expected deleting from the audit log to fail")
+	}
+}
@@ -3,8 +3,12 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"net/mail"
+	"os"
+	"strings"
 	"time"
 	"unicode"
 
@@ -12,6 +16,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/mailer"
+	"github.com/janhoon/dash/backend/internal/ratelimit"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -19,17 +25,44 @@ type AuthHandler struct {
 	pool                *pgxpool.Pool
 	jwtManager          *auth.JWTManager
 	refreshTokenManager *auth.RefreshTokenManager
+	rdb                 *redis.Client
+	limiter             *ratelimit.Limiter
+
+	// Email verification and password reset; disabled without Valkey or a mailer
+	emailTokens         *auth.EmailTokenManager
+	mailer              mailer.Mailer
+	frontendURL         string
+	requireVerification bool
 }
 
-func NewAuthHandler(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client) *AuthHandler {
-	var rtm *auth.RefreshTokenManager
+func NewAuthHandler(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client, rtm *auth.RefreshTokenManager, m mailer.Mailer) *AuthHandler {
+	var emailTokens *auth.EmailTokenManager
 	if rdb != nil {
-		rtm = auth.NewRefreshTokenManager(rdb)
+		emailTokens = auth.NewEmailTokenManager(rdb)
 	}
+
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:5173"
+	}
+
+	// Only require verification when users can actually receive the email
+	requireVerification := os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+	if requireVerification && (emailTokens == nil || m == nil) {
+		log.Printf("Warning: REQUIRE_EMAIL_VERIFICATION ignored, email verification needs Valkey and a mailer")
+		requireVerification = false
+	}
+
 	return &AuthHandler{
 		pool:                pool,
 		jwtManager:          jwtManager,
 		refreshTokenManager: rtm,
+		rdb:                 rdb,
+		limiter:             ratelimit.New(rdb),
+		emailTokens:         emailTokens,
+		mailer:              m,
+		frontendURL:         frontendURL,
+		requireVerification: requireVerification,
 	}
 }
 
@@ -61,11 +94,12 @@ type RefreshRequest struct {
 
 // UserResponse represents the user profile response
 type UserResponse struct {
-	ID        uuid.UUID                `json:"id"`
-	Email     string                   `json:"email"`
-	Name      *string                  `json:"name,omitempty"`
-	CreatedAt time.Time                `json:"created_at"`
-	Orgs      []OrganizationMembership `json:"organizations"`
+	ID            uuid.UUID                `json:"id"`
+	Email         string                   `json:"email"`
+	EmailVerified bool                     `json:"email_verified"`
+	Name          *string                  `json:"name,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	Orgs          []OrganizationMembership `json:"organizations"`
 }
 
 // OrganizationMembership represents org membership in user response
@@ -96,6 +130,13 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rateLimited(ctx, w, h.limiter, 10, time.Hour, "register:ip:"+clientIP(r)) {
+		return
+	}
+
 	// Hash password
 	passwordHash, err := auth.HashPassword(req.Password)
 	if err != nil {
@@ -103,9 +144,6 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
 	// Check if user already exists
 	var existingID uuid.UUID
 	err = h.pool.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, req.Email).Scan(&existingID)
@@ -134,6 +172,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.sendVerificationEmail(ctx, userID, userEmail)
+
+	// Without a verified email the user has to follow the link before signing in
+	if h.requireVerification {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"verification_required": true,
+			"message":               "check your email to verify your account",
+		})
+		return
+	}
+
 	// Generate JWT
 	name := ""
 	if userName != nil {
@@ -159,7 +210,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := h.refreshTokenManager.StoreRefreshToken(ctx, refreshToken, userID, userEmail, name); err != nil {
+		if err := h.refreshTokenManager.StoreRefreshToken(ctx, refreshToken, userID, userEmail, name, clientInfo(r)); err != nil {
 			http.Error(w, `{"error":"failed to store refresh token"}`, http.StatusInternalServerError)
 			return
 		}
@@ -188,19 +239,32 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	account := accountKey(req.Email)
+	if rateLimited(ctx, w, h.limiter, 30, 15*time.Minute, "login:ip:"+clientIP(r)) ||
+		rateLimited(ctx, w, h.limiter, 20, 15*time.Minute, "login:account:"+account) {
+		return
+	}
+
+	// Accounts are locked out for a while after repeated wrong passwords
+	if d := h.limiter.Locked(ctx, "login:"+account); d > 0 {
+		writeTooManyRequests(w, d, "too many failed login attempts, try again later")
+		return
+	}
+
 	// Find user by email
 	var userID uuid.UUID
 	var userEmail string
 	var passwordHash *string
 	var userName *string
+	var emailVerified bool
 
 	err := h.pool.QueryRow(ctx,
-		`SELECT id, email, password_hash, name FROM users WHERE email = $1`,
+		`SELECT id, email, password_hash, name, email_verified_at IS NOT NULL FROM users WHERE email = $1`,
 		req.Email,
-	).Scan(&userID, &userEmail, &passwordHash, &userName)
+	).Scan(&userID, &userEmail, &passwordHash, &userName, &emailVerified)
 
 	if err == pgx.ErrNoRows {
-		http.Error(w, `{"error":"invalid credentials"}`, http.StatusUnauthorized)
+		h.loginFailed(ctx, w, account)
 		return
 	}
 	if err != nil {
@@ -210,22 +274,44 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Check if user has password auth (might be SSO-only)
 	if passwordHash == nil {
-		http.Error(w, `{"error":"invalid credentials"}`, http.StatusUnauthorized)
+		h.loginFailed(ctx, w, account)
 		return
 	}
 
 	// Verify password
 	valid, err := auth.VerifyPassword(req.Password, *passwordHash)
 	if err != nil || !valid {
-		http.Error(w, `{"error":"invalid credentials"}`, http.StatusUnauthorized)
+		h.loginFailed(ctx, w, account)
+		return
+	}
+	h.limiter.Reset(ctx, "login:"+account)
+
+	if h.requireVerification && !emailVerified {
+		http.Error(w, `{"error":"email address not verified"}`, http.StatusForbidden)
+		return
+	}
+
+	// Organizations enforcing SSO forbid password login for their members
+	disabled, err := passwordLoginDisabled(ctx, h.pool, userID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to check login policy"}`, http.StatusInternalServerError)
+		return
+	}
+	if disabled {
+		http.Error(w, `{"error":"password login is disabled by your organization, sign in with SSO"}`, http.StatusForbidden)
 		return
 	}
 
-	// Generate JWT
 	name := ""
 	if userName != nil {
 		name = *userName
 	}
+
+	if requireMFA(ctx, w, h.pool, h.jwtManager, userID, userEmail, name) {
+		return
+	}
+
+	// Generate JWT
 	accessToken, err := h.jwtManager.GenerateAccessToken(userID, userEmail, name)
 	if err != nil {
 		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
@@ -246,7 +332,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := h.refreshTokenManager.StoreRefreshToken(ctx, refreshToken, userID, userEmail, name); err != nil {
+		if err := h.refreshTokenManager.StoreRefreshToken(ctx, refreshToken, userID, userEmail, name, clientInfo(r)); err != nil {
 			http.Error(w, `{"error":"failed to store refresh token"}`, http.StatusInternalServerError)
 			return
 		}
@@ -258,6 +344,13 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// loginFailed records a failed password login against the account and
+// rejects the request
+func (h *AuthHandler) loginFailed(ctx context.Context, w http.ResponseWriter, account string) {
+	h.limiter.RecordFailure(ctx, "login:"+account, loginLockout)
+	http.Error(w, `{"error":"invalid credentials"}`, http.StatusUnauthorized)
+}
+
 // Me returns the current user's profile
 func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
@@ -272,9 +365,9 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 	// Get user
 	var user UserResponse
 	err := h.pool.QueryRow(ctx,
-		`SELECT id, email, name, created_at FROM users WHERE id = $1`,
+		`SELECT id, email, email_verified_at IS NOT NULL, name, created_at FROM users WHERE id = $1`,
 		userID,
-	).Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.EmailVerified, &user.Name, &user.CreatedAt)
 
 	if err == pgx.ErrNoRows {
 		http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
@@ -341,9 +434,17 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	if rateLimited(ctx, w, h.limiter, 60, time.Minute, "refresh:ip:"+clientIP(r)) {
+		return
+	}
+
 	// Rotate the refresh token (invalidates old, creates new)
-	newRefreshToken, data, err := h.refreshTokenManager.RotateRefreshToken(ctx, req.RefreshToken)
+	newRefreshToken, data, err := h.refreshTokenManager.RotateRefreshToken(ctx, req.RefreshToken, clientInfo(r))
 	if err != nil {
+		if err == auth.ErrRefreshTokenReused {
+			http.Error(w, `{"error":"refresh token reused, session revoked"}`, http.StatusUnauthorized)
+			return
+		}
 		if err == auth.ErrInvalidRefreshToken {
 			http.Error(w, `{"error":"invalid refresh token"}`, http.StatusUnauthorized)
 			return
@@ -394,6 +495,15 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The access token presented with the request stops working too
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := h.jwtManager.VerifyAccessToken(token); err == nil {
+			if err := h.jwtManager.Denylist().RevokeToken(ctx, claims); err != nil {
+				log.Printf("Failed to revoke access token on logout: %v", err)
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "logged out successfully"})
@@ -419,12 +529,157 @@ func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"failed to logout from all devices"}`, http.StatusInternalServerError)
 		return
 	}
+	if err := h.jwtManager.Denylist().RevokeUser(ctx, userID); err != nil {
+		http.Error(w, `{"error":"failed to logout from all devices"}`, http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "logged out from all devices"})
 }
 
+// OrgTokenRequest selects the organization for an org-scoped access token
+type OrgTokenRequest struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+// OrgToken issues an access token that carries the user's role in an
+// organization, saving a membership lookup on org-scoped requests
+func (h *AuthHandler) OrgToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	email, _ := auth.GetUserEmail(r.Context())
+	name, _ := auth.GetUserName(r.Context())
+
+	var req OrgTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrganizationID == uuid.Nil {
+		http.Error(w, `{"error":"organization_id is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var role string
+	err := h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE organization_id = $1 AND user_id = $2`,
+		req.OrganizationID, userID,
+	).Scan(&role)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to check membership"}`, http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := h.jwtManager.GenerateOrgAccessToken(userID, email, name, req.OrganizationID, role)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   900, // 15 minutes
+	})
+}
+
+// JWKS publishes the public keys that verify access tokens, so other services
+// can validate them without sharing a secret
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(auth.JWKSMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(h.jwtManager.JWKS())
+}
+
+// SessionResponse describes one of the user's signed-in devices
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// ListSessions returns the current user's active sessions
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if h.refreshTokenManager == nil {
+		http.Error(w, `{"error":"refresh tokens not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := h.refreshTokenManager.ListSessions(ctx, userID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to list sessions"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, SessionResponse{
+			ID:         s.SessionID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeSession signs the current user out of one of their sessions
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if h.refreshTokenManager == nil {
+		http.Error(w, `{"error":"refresh tokens not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid session id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.refreshTokenManager.RevokeSession(ctx, userID, sessionID); err != nil {
+		if err == auth.ErrSessionNotFound {
+			http.Error(w, `{"error":"session not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error":"failed to revoke session"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // validatePassword checks password requirements
 func validatePassword(password string) error {
 	if len(password) < 8 {
@@ -468,9 +723,18 @@ func (e *passwordError) Error() string {
 type AuthMethodResponse struct {
 	ID        uuid.UUID `json:"id"`
 	Provider  string    `json:"provider"`
+	Name      *string   `json:"name,omitempty"`
 	CreatedAt time.Time `json:"created_at,omitempty"`
 }
 
+// UnlinkAuthMethodRequest proves the user is present when removing a second
+// factor: their password, a TOTP code or a recovery code
+type UnlinkAuthMethodRequest struct {
+	Password     string `json:"password,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
 // GetAuthMethods lists all auth methods for the current user
 func (h *AuthHandler) GetAuthMethods(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
@@ -485,7 +749,9 @@ func (h *AuthHandler) GetAuthMethods(w http.ResponseWriter, r *http.Request) {
 	// Get SSO auth methods
 	methods := []AuthMethodResponse{}
 	rows, err := h.pool.Query(ctx,
-		`SELECT id, provider, created_at FROM user_auth_methods WHERE user_id = $1 ORDER BY created_at ASC`,
+		`SELECT id, provider, name, created_at FROM user_auth_methods
+		 WHERE user_id = $1 AND (provider NOT IN ('totp', 'webauthn') OR confirmed_at IS NOT NULL)
+		 ORDER BY created_at ASC`,
 		userID,
 	)
 	if err != nil {
@@ -496,7 +762,7 @@ func (h *AuthHandler) GetAuthMethods(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var method AuthMethodResponse
-		if err := rows.Scan(&method.ID, &method.Provider, &method.CreatedAt); err != nil {
+		if err := rows.Scan(&method.ID, &method.Provider, &method.Name, &method.CreatedAt); err != nil {
 			http.Error(w, `{"error":"failed to scan auth method"}`, http.StatusInternalServerError)
 			return
 		}
@@ -526,7 +792,70 @@ func (h *AuthHandler) GetAuthMethods(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(methods)
 }
 
-// UnlinkAuthMethod removes an auth method from the current user
+// verifyFactorRemoval checks that the user may remove the second factor
+// methodID. It writes the error response and returns false if not.
+func (h *AuthHandler) verifyFactorRemoval(ctx context.Context, w http.ResponseWriter, r *http.Request, userID, methodID uuid.UUID) bool {
+	var req UnlinkAuthMethodRequest
+	if err := decodeOptional(r, &req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return false
+	}
+	if req.Password == "" && req.Code == "" && req.RecoveryCode == "" {
+		http.Error(w, `{"error":"password, code or recovery_code is required to remove a second factor"}`, http.StatusBadRequest)
+		return false
+	}
+
+	var others int
+	err := h.pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM user_auth_methods
+		 WHERE user_id = $1 AND id <> $2 AND provider IN ('totp', 'webauthn') AND confirmed_at IS NOT NULL`,
+		userID, methodID,
+	).Scan(&others)
+	if err != nil {
+		http.Error(w, `{"error":"failed to count second factors"}`, http.StatusInternalServerError)
+		return false
+	}
+	if others == 0 {
+		required, err := adminMFARequired(ctx, h.pool, userID)
+		if err != nil {
+			http.Error(w, `{"error":"failed to check mfa policy"}`, http.StatusInternalServerError)
+			return false
+		}
+		if required {
+			http.Error(w, `{"error":"an organization you administer requires mfa"}`, http.StatusBadRequest)
+			return false
+		}
+	}
+
+	// Guesses are limited like MFA logins
+	if rateLimited(ctx, w, h.limiter, 10, 15*time.Minute, "mfa_stepup:user:"+userID.String()) {
+		return false
+	}
+
+	if req.Password == "" {
+		return checkSecondFactor(ctx, w, h.pool, userID, req.Code, req.RecoveryCode)
+	}
+	var passwordHash *string
+	if err := h.pool.QueryRow(ctx, `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&passwordHash); err != nil {
+		http.Error(w, `{"error":"failed to check password"}`, http.StatusInternalServerError)
+		return false
+	}
+	if passwordHash == nil {
+		http.Error(w, `{"error":"invalid password"}`, http.StatusUnauthorized)
+		return false
+	}
+	valid, err := auth.VerifyPassword(req.Password, *passwordHash)
+	if err != nil || !valid {
+		http.Error(w, `{"error":"invalid password"}`, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// UnlinkAuthMethod removes an auth method from the current user. Removing a
+// second factor takes the password, a TOTP code or a recovery code as well, so
+// an access token alone can't turn off MFA, and the last factor can't be
+// removed while an organization requires the user to have one.
 func (h *AuthHandler) UnlinkAuthMethod(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -543,24 +872,49 @@ func (h *AuthHandler) UnlinkAuthMethod(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	// Count total auth methods
-	var methodCount int
-	err = h.pool.QueryRow(ctx,
-		`SELECT
-			(SELECT COUNT(*) FROM user_auth_methods WHERE user_id = $1) +
-			(SELECT CASE WHEN password_hash IS NOT NULL THEN 1 ELSE 0 END FROM users WHERE id = $1)`,
-		userID,
-	).Scan(&methodCount)
-	if err != nil {
-		http.Error(w, `{"error":"failed to count auth methods"}`, http.StatusInternalServerError)
-		return
+	// Second factors (TOTP, WebAuthn) are not a way to sign in on their own
+	isFactor := false
+	if methodID != uuid.Nil {
+		var provider string
+		err = h.pool.QueryRow(ctx,
+			`SELECT provider FROM user_auth_methods WHERE id = $1 AND user_id = $2`,
+			methodID, userID,
+		).Scan(&provider)
+		if err == pgx.ErrNoRows {
+			http.Error(w, `{"error":"auth method not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"failed to get auth method"}`, http.StatusInternalServerError)
+			return
+		}
+		isFactor = provider == "totp" || provider == "webauthn"
 	}
 
-	if methodCount <= 1 {
-		http.Error(w, `{"error":"cannot remove last auth method"}`, http.StatusBadRequest)
+	if isFactor && !h.verifyFactorRemoval(ctx, w, r, userID, methodID) {
 		return
 	}
 
+	if !isFactor {
+		// Count total login methods
+		var methodCount int
+		err = h.pool.QueryRow(ctx,
+			`SELECT
+				(SELECT COUNT(*) FROM user_auth_methods WHERE user_id = $1 AND provider NOT IN ('totp', 'webauthn')) +
+				(SELECT CASE WHEN password_hash IS NOT NULL THEN 1 ELSE 0 END FROM users WHERE id = $1)`,
+			userID,
+		).Scan(&methodCount)
+		if err != nil {
+			http.Error(w, `{"error":"failed to count auth methods"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if methodCount <= 1 {
+			http.Error(w, `{"error":"cannot remove last auth method"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Handle password (uuid.Nil) or SSO method
 	if methodID == uuid.Nil {
 		result, err := h.pool.Exec(ctx,
@@ -588,6 +942,21 @@ func (h *AuthHandler) UnlinkAuthMethod(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error":"auth method not found"}`, http.StatusNotFound)
 			return
 		}
+
+		// Recovery codes go with the last second factor
+		if isFactor {
+			_, err = h.pool.Exec(ctx,
+				`DELETE FROM user_recovery_codes WHERE user_id = $1 AND NOT EXISTS (
+					SELECT 1 FROM user_auth_methods
+					WHERE user_id = $1 AND provider IN ('totp', 'webauthn') AND confirmed_at IS NOT NULL
+				)`,
+				userID,
+			)
+			if err != nil {
+				http.Error(w, `{"error":"failed to remove recovery codes"}`, http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/janhoon/dash/backend/internal/auth"
 	"github.com/janhoon/dash/backend/internal/db"
@@ -48,7 +51,10 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
-	testAuthHandler = NewAuthHandler(testPool, testJWTManager, nil)
+	testAuthHandler = NewAuthHandler(testPool, testJWTManager, nil, nil, nil)
+	// Every test request comes from the same address, so the shared handler
+	// runs without rate limits; tests that cover limits build their own
+	testAuthHandler.limiter = nil
 
 	// Run tests
 	code := m.Run()
@@ -239,6 +245,48 @@ func TestLoginWrongPassword(t *testing.T) {
 	}
 }
 
+func TestLoginLockout(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	// A fresh handler so earlier tests don't count against the limits
+	handler := NewAuthHandler(testPool, testJWTManager, nil, nil, nil)
+
+	testPool.Exec(context.Background(), "DELETE FROM users WHERE email = 'testlockout@example.com'")
+
+	regBody := `{"email":"testlockout@example.com","password":"TestPassword123!"}`
+	regReq := httptest.NewRequest("POST", "/api/auth/register", bytes.NewBufferString(regBody))
+	regW := httptest.NewRecorder()
+	handler.Register(regW, regReq)
+	if regW.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d", regW.Code)
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := `{"email":"TestLockout@example.com","password":"` + password + `"}`
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+		return w
+	}
+
+	for i := 0; i < loginLockout.Threshold; i++ {
+		if w := login("WrongPassword123!"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 for failure %d, got %d", i+1, w.Code)
+		}
+	}
+
+	// Locked out, even with the right password
+	w := login("TestPassword123!")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 while locked out, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+}
+
 func TestLoginNonexistentUser(t *testing.T) {
 	if testPool == nil {
 		t.Skip("Database not available")
@@ -343,7 +391,7 @@ func setupTestWithRedis(t *testing.T) (*AuthHandler, func()) {
 		Addr: mr.Addr(),
 	})
 
-	handler := NewAuthHandler(testPool, testJWTManager, rdb)
+	handler := NewAuthHandler(testPool, testJWTManager, rdb, auth.NewRefreshTokenManager(auth.NewValkeyTokenStore(rdb)), nil)
 
 	cleanup := func() {
 		rdb.Close()
@@ -655,3 +703,252 @@ func TestUnlinkLastAuthMethodFails(t *testing.T) {
 		t.Errorf("Expected status 400 for unlinking last method, got %d: %s", unlinkW.Code, unlinkW.Body.String())
 	}
 }
+
+func TestSessionsWithPostgresTokenStore(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	handler := NewAuthHandler(testPool, testJWTManager, nil, auth.NewRefreshTokenManager(auth.NewPostgresTokenStore(testPool)), nil)
+	handler.limiter = nil
+
+	testPool.Exec(context.Background(), "DELETE FROM users WHERE email = 'testsessions@example.com'")
+
+	post := func(h http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("User-Agent", "TestBrowser/1.0")
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return post(handler.Refresh, "/api/auth/refresh", `{"refresh_token":"`+token+`"}`)
+	}
+
+	regW := post(handler.Register, "/api/auth/register", `{"email":"testsessions@example.com","password":"TestPassword123!","name":"Test User"}`)
+	if regW.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d", regW.Code)
+	}
+	var first AuthResponse
+	json.NewDecoder(regW.Body).Decode(&first)
+
+	var second AuthResponse
+	loginW := post(handler.Login, "/api/auth/login", `{"email":"testsessions@example.com","password":"TestPassword123!"}`)
+	json.NewDecoder(loginW.Body).Decode(&second)
+
+	listSessions := func() []SessionResponse {
+		req := httptest.NewRequest("GET", "/api/auth/me/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+first.AccessToken)
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, handler.ListSessions)(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var sessions []SessionResponse
+		json.NewDecoder(w.Body).Decode(&sessions)
+		return sessions
+	}
+
+	sessions := listSessions()
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].UserAgent != "TestBrowser/1.0" || sessions[0].IP == "" {
+		t.Errorf("Expected the device to be recorded, got %+v", sessions[0])
+	}
+
+	// Refresh works without Valkey, and replaying the old token revokes its session
+	if w := refresh(first.RefreshToken); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := refresh(first.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a replayed token, got %d", w.Code)
+	}
+	sessions = listSessions()
+	if len(sessions) != 1 {
+		t.Fatalf("Expected the replayed session to be revoked, got %d sessions", len(sessions))
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/auth/me/sessions/"+sessions[0].ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+first.AccessToken)
+	req.SetPathValue("id", sessions[0].ID.String())
+	w := httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, handler.RevokeSession)(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := refresh(second.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 after revoking the session, got %d", w.Code)
+	}
+}
+
+func TestOrgToken(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	var orgID, userID uuid.UUID
+	testPool.QueryRow(ctx, `INSERT INTO organizations (name, slug) VALUES ('Test Org Token', 'test-org-token') RETURNING id`).Scan(&orgID)
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	testPool.QueryRow(ctx, `INSERT INTO users (email) VALUES ('testorgtoken@example.com') RETURNING id`).Scan(&userID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	token, _ := testJWTManager.GenerateAccessToken(userID, "testorgtoken@example.com", "")
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/auth/token/org", bytes.NewBufferString(`{"organization_id":"`+orgID.String()+`"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, testAuthHandler.OrgToken)(w, req)
+		return w
+	}
+
+	if w := request(); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a non-member, got %d", w.Code)
+	}
+
+	testPool.Exec(ctx, `INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'editor')`, userID, orgID)
+	w := request()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response AuthResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	claims, err := testJWTManager.VerifyAccessToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected a valid access token: %v", err)
+	}
+	if claims.OrgID == nil || *claims.OrgID != orgID || claims.OrgRole != "editor" {
+		t.Errorf("Expected the token to carry the org and role, got %v %q", claims.OrgID, claims.OrgRole)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	testAuthHandler.JWKS(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Header().Get("Cache-Control") == "" {
+		t.Error("Expected a Cache-Control header")
+	}
+
+	var jwks auth.JWKSet
+	json.NewDecoder(w.Body).Decode(&jwks)
+	if len(jwks.Keys) == 0 || jwks.Keys[0].Kid == "" {
+		t.Errorf("Expected published keys with IDs, got %+v", jwks.Keys)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM jwt_signing_keys")
+	defer testPool.Exec(ctx, "DELETE FROM jwt_signing_keys")
+
+	a, _ := auth.GenerateJWTManager()
+	b, _ := auth.GenerateJWTManager()
+	encryptionKey := bytes.Repeat([]byte{7}, 32)
+	rotatorA, err := auth.NewKeyRotator(testPool, a, encryptionKey, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create rotator: %v", err)
+	}
+	rotatorB, _ := auth.NewKeyRotator(testPool, b, encryptionKey, time.Hour, time.Hour)
+
+	// The first instance's key is shared with the others
+	if err := rotatorA.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := rotatorB.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	token, _ := a.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+	if _, err := b.VerifyAccessToken(token); err != nil {
+		t.Fatalf("Expected instances to share the signing key, got %v", err)
+	}
+
+	// Private keys are only stored encrypted
+	var stored string
+	testPool.QueryRow(ctx, "SELECT private_key FROM jwt_signing_keys LIMIT 1").Scan(&stored)
+	if strings.Contains(stored, "PRIVATE KEY") {
+		t.Error("Expected the stored private key to be encrypted")
+	}
+	otherKey := bytes.Repeat([]byte{8}, 32)
+	if rotatorC, _ := auth.NewKeyRotator(testPool, b, otherKey, time.Hour, time.Hour); rotatorC.Rotate(ctx) == nil {
+		t.Error("Expected keys sealed with another encryption key to be rejected")
+	}
+
+	// Once the interval has passed a new key is added and the old one retired
+	testPool.Exec(ctx, "UPDATE jwt_signing_keys SET created_at = created_at - INTERVAL '2 hours'")
+	if err := rotatorB.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := rotatorA.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if keys := a.JWKS().Keys; len(keys) != 2 {
+		t.Fatalf("Expected the old and new keys to be published, got %d", len(keys))
+	}
+	if _, err := b.VerifyAccessToken(token); err != nil {
+		t.Errorf("Expected tokens from the retired key to verify during the overlap, got %v", err)
+	}
+
+	// After the overlap the retired key is dropped
+	testPool.Exec(ctx, "UPDATE jwt_signing_keys SET expires_at = $1 WHERE expires_at IS NOT NULL", time.Now().UTC().Add(-time.Minute))
+	if err := rotatorA.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if keys := a.JWKS().Keys; len(keys) != 1 {
+		t.Errorf("Expected only the new key to be published, got %d", len(keys))
+	}
+	if _, err := a.VerifyAccessToken(token); err == nil {
+		t.Error("Expected tokens from the dropped key to be rejected")
+	}
+}
+
+func TestJWTKeyRotationKeepsAdditionalPublicKeys(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM jwt_signing_keys")
+	defer testPool.Exec(ctx, "DELETE FROM jwt_signing_keys")
+
+	// The key used before a manual rotation is only listed as a public key
+	previous, _ := auth.GenerateJWTManager()
+	previousPEM, err := previous.GetPublicKeyPEM()
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+	t.Setenv("JWT_PRIVATE_KEY", "")
+	t.Setenv("JWT_PUBLIC_KEY", "")
+	t.Setenv("JWT_ADDITIONAL_PUBLIC_KEYS", previousPEM)
+	manager, err := auth.NewJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+	token, _ := previous.GenerateAccessToken(uuid.New(), "test@example.com", "Test")
+
+	rotator, err := auth.NewKeyRotator(testPool, manager, bytes.Repeat([]byte{7}, 32), time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create rotator: %v", err)
+	}
+	if err := rotator.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if _, err := manager.VerifyAccessToken(token); err != nil {
+		t.Errorf("Expected tokens from an additional public key to verify after rotation, got %v", err)
+	}
+	if keys := manager.JWKS().Keys; len(keys) != 2 {
+		t.Errorf("Expected the additional public key to stay published, got %d keys", len(keys))
+	}
+}
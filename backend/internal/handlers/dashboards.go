@@ -8,20 +8,26 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
 	"github.com/janhoon/dash/backend/internal/auth"
 	"github.com/janhoon/dash/backend/internal/models"
 )
 
 type DashboardHandler struct {
-	pool *pgxpool.Pool
+	pool  *pgxpool.Pool
+	audit *audit.Logger
 }
 
-func NewDashboardHandler(pool *pgxpool.Pool) *DashboardHandler {
-	return &DashboardHandler{pool: pool}
+func NewDashboardHandler(pool *pgxpool.Pool, auditLog *audit.Logger) *DashboardHandler {
+	return &DashboardHandler{pool: pool, audit: auditLog}
 }
 
 // checkOrgMembership verifies the user is a member of the organization
 func (h *DashboardHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
 	var role string
 	err := h.pool.QueryRow(ctx,
 		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
@@ -299,6 +305,7 @@ func (h *DashboardHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	before := auditSnapshot(ctx, h.pool, `SELECT to_jsonb(d) FROM dashboards d WHERE id = $1`, id)
 	result, err := h.pool.Exec(ctx, `DELETE FROM dashboards WHERE id = $1`, id)
 	if err != nil {
 		http.Error(w, `{"error":"failed to delete dashboard"}`, http.StatusInternalServerError)
@@ -309,6 +316,7 @@ func (h *DashboardHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"dashboard not found"}`, http.StatusNotFound)
 		return
 	}
+	recordAudit(r, h.audit, orgID, "dashboard.delete", "dashboard", id.String(), before, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -3,28 +3,42 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
 	"github.com/janhoon/dash/backend/internal/auth"
 	"github.com/janhoon/dash/backend/internal/datasource"
 	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/quota"
 )
 
 type DataSourceHandler struct {
-	pool *pgxpool.Pool
+	pool        *pgxpool.Pool
+	quotas      *quota.Manager
+	audit       *audit.Logger
+	annotations *DerivedAnnotations
 }
 
-func NewDataSourceHandler(pool *pgxpool.Pool) *DataSourceHandler {
-	return &DataSourceHandler{pool: pool}
+func NewDataSourceHandler(pool *pgxpool.Pool, quotas *quota.Manager, auditLog *audit.Logger, annotations *DerivedAnnotations) *DataSourceHandler {
+	return &DataSourceHandler{pool: pool, quotas: quotas, audit: auditLog, annotations: annotations}
 }
 
+// dataSourceSnapshot is the audit log's view of a datasource row
+const dataSourceSnapshot = `SELECT to_jsonb(d) FROM datasources d WHERE id = $1`
+
 func (h *DataSourceHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
 	var role string
 	err := h.pool.QueryRow(ctx,
 		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
@@ -101,6 +115,8 @@ func (h *DataSourceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf(`{"error":"failed to create datasource: %s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, h.audit, &orgID, "datasource.create", "datasource", ds.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, dataSourceSnapshot, ds.ID))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -238,6 +254,7 @@ func (h *DataSourceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := auditSnapshot(ctx, h.pool, dataSourceSnapshot, id)
 	var ds models.DataSource
 	err = h.pool.QueryRow(ctx,
 		`UPDATE datasources
@@ -256,6 +273,8 @@ func (h *DataSourceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"failed to update datasource"}`, http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, h.audit, &orgID, "datasource.update", "datasource", id.String(),
+		before, auditSnapshot(ctx, h.pool, dataSourceSnapshot, id))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ds)
@@ -295,6 +314,7 @@ func (h *DataSourceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := auditSnapshot(ctx, h.pool, dataSourceSnapshot, id)
 	result, err := h.pool.Exec(ctx, `DELETE FROM datasources WHERE id = $1`, id)
 	if err != nil {
 		http.Error(w, `{"error":"failed to delete datasource"}`, http.StatusInternalServerError)
@@ -304,6 +324,7 @@ func (h *DataSourceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"datasource not found"}`, http.StatusNotFound)
 		return
 	}
+	recordAudit(r, h.audit, &orgID, "datasource.delete", "datasource", id.String(), before, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -380,6 +401,23 @@ func (h *DataSourceHandler) Query(w http.ResponseWriter, r *http.Request) {
 		step = time.Duration(queryReq.Step) * time.Second
 	}
 
+	logOpts := datasource.LogQueryOptions{Limit: queryReq.Limit, Direction: queryReq.Direction, Cursor: queryReq.Cursor}
+	if err := logOpts.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: err.Error()})
+		return
+	}
+
+	// Queries count against the org's rate limits, concurrency caps and budget
+	if h.quotas != nil {
+		release, err := h.quotas.Acquire(ctx, ds.OrganizationID, userID, ds.ID)
+		if err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+		defer release()
+	}
+
 	// Execute query via client
 	client, err := datasource.NewClient(ds)
 	if err != nil {
@@ -388,16 +426,163 @@ func (h *DataSourceHandler) Query(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := client.Query(ctx, queryReq.Query, start, end, step, queryReq.Limit)
+	// Log queries page with a cursor, and stream as NDJSON when asked to
+	var result *datasource.QueryResult
+	if pager, ok := client.(datasource.LogPager); ok {
+		if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+			streamLogs(ctx, w, pager, queryReq.Query, start, end, logOpts)
+			return
+		}
+		result, err = datasource.LogPage(ctx, pager, queryReq.Query, start, end, logOpts)
+	} else {
+		result, err = client.Query(ctx, queryReq.Query, start, end, step, queryReq.Limit)
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "query failed: " + err.Error()})
 		return
 	}
 
+	if queryReq.DashboardID != nil && result.Status == "success" {
+		result.Annotations = panelAnnotations(ctx, h.pool, h.annotations, userID, ds.OrganizationID, *queryReq.DashboardID,
+			queryReq.PanelID, start, end)
+	}
+
 	json.NewEncoder(w).Encode(result)
 }
 
+const ndjsonContentType = "application/x-ndjson"
+
+// streamLogs writes a page of log lines as NDJSON while they are read from
+// the datasource: one LogEntry per line, then a QueryResult without data
+// carrying the status, any error and the next cursor
+func streamLogs(ctx context.Context, w http.ResponseWriter, pager datasource.LogPager, query string, start, end time.Time,
+	opts datasource.LogQueryOptions) {
+	// The stream may outlast the server's write timeout; the query's context
+	// bounds it instead
+	rc := http.NewResponseController(w)
+	if deadline, ok := ctx.Deadline(); ok {
+		rc.SetWriteDeadline(deadline)
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	enc := json.NewEncoder(w)
+	written := 0
+	next, err := pager.QueryLogs(ctx, query, start, end, opts, func(entry datasource.LogEntry) error {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+		if written++; written%100 == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+
+	trailer := datasource.QueryResult{Status: "success", ResultType: "logs", NextCursor: next}
+	if err != nil {
+		trailer = datasource.QueryResult{Status: "error", ResultType: "logs", Error: err.Error()}
+		var queryErr *datasource.QueryError
+		if !errors.As(err, &queryErr) {
+			trailer.Error = "query failed: " + err.Error()
+		}
+	}
+	enc.Encode(trailer)
+}
+
+// LogStats summarises a log query for the Explore view: its volume over time
+// by level, and the most common values of its lines' fields or labels. The
+// body is a query request; without a step, one is picked from the range.
+func (h *DataSourceHandler) LogStats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid datasource id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var ds models.DataSource
+	err = h.pool.QueryRow(ctx,
+		`SELECT id, organization_id, name, type, url, is_default, auth_type, auth_config, created_at, updated_at
+		 FROM datasources WHERE id = $1`, id,
+	).Scan(&ds.ID, &ds.OrganizationID, &ds.Name, &ds.Type, &ds.URL, &ds.IsDefault, &ds.AuthType, &ds.AuthConfig, &ds.CreatedAt, &ds.UpdatedAt)
+	if err != nil {
+		http.Error(w, `{"error":"datasource not found"}`, http.StatusNotFound)
+		return
+	}
+
+	_, err = h.checkOrgMembership(ctx, userID, ds.OrganizationID)
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var queryReq datasource.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&queryReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "invalid request body"})
+		return
+	}
+	if queryReq.Query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "query is required"})
+		return
+	}
+
+	end := time.Now()
+	start := end.Add(-1 * time.Hour)
+	if queryReq.Start > 0 {
+		start = time.Unix(queryReq.Start, 0)
+	}
+	if queryReq.End > 0 {
+		end = time.Unix(queryReq.End, 0)
+	}
+	step := datasource.VolumeStep(start, end)
+	if queryReq.Step > 0 {
+		step = time.Duration(queryReq.Step) * time.Second
+	}
+
+	client, err := datasource.NewClient(ds)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "failed to create datasource client: " + err.Error()})
+		return
+	}
+	statsClient, ok := client.(datasource.LogStatsClient)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "log stats need a Loki or Victoria Logs datasource"})
+		return
+	}
+
+	if h.quotas != nil {
+		release, err := h.quotas.Acquire(ctx, ds.OrganizationID, userID, ds.ID)
+		if err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+		defer release()
+	}
+
+	stats, err := datasource.QueryLogStats(ctx, statsClient, queryReq.Query, start, end, step)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "query failed: " + err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
+
 // QueryByParams handles GET-based query with query parameters (backwards compatible with existing Prometheus handler)
 func (h *DataSourceHandler) QueryByParams(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
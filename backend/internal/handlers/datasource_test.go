@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/janhoon/dash/backend/internal/datasource"
 	"github.com/janhoon/dash/backend/internal/models"
 )
 
@@ -101,6 +106,21 @@ func TestDataSourceHandler_Query_InvalidUUID(t *testing.T) {
 	}
 }
 
+func TestDataSourceHandler_LogStats_Unauthorized(t *testing.T) {
+	handler := &DataSourceHandler{pool: nil}
+
+	body := bytes.NewBufferString(`{"query":"{app=\"api\"}"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/datasources/invalid-uuid/log-stats", body)
+	req.SetPathValue("id", "invalid-uuid")
+	rr := httptest.NewRecorder()
+
+	handler.LogStats(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
 func TestDataSourceHandler_List_InvalidOrgID(t *testing.T) {
 	handler := &DataSourceHandler{pool: nil}
 
@@ -250,3 +270,52 @@ func TestCreateDataSourceRequest_AllTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestStreamLogsNDJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, `{"_msg":"line %d","_time":"2026-01-01T00:00:0%dZ"}`+"\n", i, i)
+		}
+	}))
+	defer server.Close()
+
+	client, _ := datasource.NewVictoriaLogsClient(server.URL)
+	rr := httptest.NewRecorder()
+	streamLogs(context.Background(), rr, client, "*", time.Unix(0, 0), time.Now(), datasource.LogQueryOptions{Limit: 3})
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Unexpected content type %s", ct)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 4 {
+		t.Fatalf("Expected 3 entries and a trailer, got %v", lines)
+	}
+	var entry datasource.LogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil || entry.Line != "line 0" {
+		t.Errorf("Unexpected first entry %s", lines[0])
+	}
+	var trailer datasource.QueryResult
+	if err := json.Unmarshal([]byte(lines[3]), &trailer); err != nil || trailer.Status != "success" || trailer.NextCursor == "" {
+		t.Errorf("Unexpected trailer %s", lines[3])
+	}
+}
+
+func TestStreamLogsReportsQueryErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "parse error", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client, _ := datasource.NewLokiClient(server.URL)
+	rr := httptest.NewRecorder()
+	streamLogs(context.Background(), rr, client, "{", time.Unix(0, 0), time.Now(), datasource.LogQueryOptions{})
+
+	var trailer datasource.QueryResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &trailer); err != nil || trailer.Status != "error" || trailer.Error != "parse error" {
+		t.Errorf("Unexpected trailer %s", rr.Body.String())
+	}
+}
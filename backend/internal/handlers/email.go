@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/mailer"
+)
+
+// emailSendTimeout bounds delivery of a single transactional email
+const emailSendTimeout = 30 * time.Second
+
+// deliverEmail renders a template and sends it in the background, so response
+// times don't depend on the mail server or reveal whether an account exists.
+// It does nothing when no mailer is configured.
+func deliverEmail(m mailer.Mailer, to, template string, data interface{}) {
+	if m == nil {
+		return
+	}
+
+	msg, err := mailer.Render(to, template, data)
+	if err != nil {
+		log.Printf("Failed to render %s email: %v", template, err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+		defer cancel()
+		if err := m.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %s email: %v", template, err)
+		}
+	}()
+}
@@ -0,0 +1,702 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/ratelimit"
+	"github.com/janhoon/dash/backend/internal/sso"
+	"github.com/redis/go-redis/v9"
+)
+
+const ldapConfigColumns = `id, organization_id, url, start_tls, insecure_skip_verify, root_ca, bind_dn, bind_password,
+	base_dn, user_filter, email_attribute, name_attribute, group_attribute, group_mappings, default_role,
+	enabled, last_synced_at, created_at, updated_at`
+
+// LDAPHandler handles LDAP / Active Directory login and group-to-role sync
+type LDAPHandler struct {
+	ssoBase
+	refreshTokenManager *auth.RefreshTokenManager
+	limiter             *ratelimit.Limiter
+
+	// global is the directory configured through LDAP_* environment variables.
+	// It applies to globalOrg unless that org has its own config.
+	global    *models.LDAPConfig
+	globalOrg string
+}
+
+func NewLDAPHandler(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client, rtm *auth.RefreshTokenManager, auditLog *audit.Logger) *LDAPHandler {
+	global, err := ldapConfigFromEnv()
+	if err != nil {
+		log.Printf("Warning: ignoring global LDAP config: %v", err)
+	}
+
+	return &LDAPHandler{
+		ssoBase:             newSSOBase(pool, jwtManager, models.SSOLDAP, auditLog),
+		refreshTokenManager: rtm,
+		limiter:             ratelimit.New(rdb),
+		global:              global,
+		globalOrg:           os.Getenv("LDAP_ORG"),
+	}
+}
+
+// ldapConfigFromEnv reads the global directory config. LDAP_URL unset means none.
+func ldapConfigFromEnv() (*models.LDAPConfig, error) {
+	url := os.Getenv("LDAP_URL")
+	if url == "" {
+		return nil, nil
+	}
+	if os.Getenv("LDAP_ORG") == "" {
+		return nil, errors.New("LDAP_ORG is required with LDAP_URL")
+	}
+
+	cfg := &models.LDAPConfig{
+		URL:                url,
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         os.Getenv("LDAP_USER_FILTER"),
+		EmailAttribute:     os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		NameAttribute:      os.Getenv("LDAP_NAME_ATTRIBUTE"),
+		GroupAttribute:     os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		Enabled:            true,
+	}
+	if rootCA := os.Getenv("LDAP_ROOT_CA"); rootCA != "" {
+		cfg.RootCA = &rootCA
+	}
+	if mappings := os.Getenv("LDAP_GROUP_MAPPINGS"); mappings != "" {
+		if err := json.Unmarshal([]byte(mappings), &cfg.GroupMappings); err != nil {
+			return nil, fmt.Errorf("invalid LDAP_GROUP_MAPPINGS: %w", err)
+		}
+	}
+	if role := models.MembershipRole(os.Getenv("LDAP_DEFAULT_ROLE")); role != "" {
+		cfg.DefaultRole = &role
+	}
+
+	if err := validateLDAPConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validateLDAPConfig checks role mappings and that a provider can be built
+func validateLDAPConfig(cfg *models.LDAPConfig) error {
+	for _, mapping := range cfg.GroupMappings {
+		if mapping.Group == "" || !sso.ValidRole(mapping.Role) {
+			return errors.New("group mappings need a group and a role of admin, editor or viewer")
+		}
+	}
+	if cfg.DefaultRole != nil && !sso.ValidRole(*cfg.DefaultRole) {
+		return errors.New("default_role must be admin, editor or viewer")
+	}
+	_, err := ldapProvider(cfg)
+	return err
+}
+
+// ldapProvider creates the directory client for a stored config
+func ldapProvider(cfg *models.LDAPConfig) (*sso.LDAPProvider, error) {
+	ldapConfig := sso.LDAPConfig{
+		URL:                cfg.URL,
+		StartTLS:           cfg.StartTLS,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		BindDN:             cfg.BindDN,
+		BindPassword:       cfg.BindPassword,
+		BaseDN:             cfg.BaseDN,
+		UserFilter:         cfg.UserFilter,
+		EmailAttribute:     cfg.EmailAttribute,
+		NameAttribute:      cfg.NameAttribute,
+		GroupAttribute:     cfg.GroupAttribute,
+	}
+	if cfg.RootCA != nil {
+		ldapConfig.RootCA = *cfg.RootCA
+	}
+	return sso.NewLDAPProvider(ldapConfig)
+}
+
+// ldapRole returns the role the config grants a directory user, or "" for none
+func ldapRole(cfg *models.LDAPConfig, identity *sso.Identity) models.MembershipRole {
+	var fallback models.MembershipRole
+	if cfg.DefaultRole != nil {
+		fallback = *cfg.DefaultRole
+	}
+	return sso.ResolveIdentityRole(identity, cfg.GroupMappings, fallback)
+}
+
+func scanLDAPConfig(row pgx.Row) (*models.LDAPConfig, error) {
+	var cfg models.LDAPConfig
+	err := row.Scan(&cfg.ID, &cfg.OrganizationID, &cfg.URL, &cfg.StartTLS, &cfg.InsecureSkipVerify, &cfg.RootCA,
+		&cfg.BindDN, &cfg.BindPassword, &cfg.BaseDN, &cfg.UserFilter, &cfg.EmailAttribute, &cfg.NameAttribute,
+		&cfg.GroupAttribute, &cfg.GroupMappings, &cfg.DefaultRole, &cfg.Enabled, &cfg.LastSyncedAt,
+		&cfg.CreatedAt, &cfg.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadLDAPConfig returns the organization ID and enabled LDAP config for an org
+// slug, falling back to the global config for LDAP_ORG
+func (h *LDAPHandler) loadLDAPConfig(ctx context.Context, orgSlug string) (uuid.UUID, *models.LDAPConfig, error) {
+	var orgID uuid.UUID
+	err := h.pool.QueryRow(ctx, `SELECT id FROM organizations WHERE slug = $1`, orgSlug).Scan(&orgID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return uuid.Nil, nil, fmt.Errorf("organization not found")
+		}
+		return uuid.Nil, nil, err
+	}
+
+	cfg, err := scanLDAPConfig(h.pool.QueryRow(ctx,
+		`SELECT `+ldapConfigColumns+` FROM ldap_configs WHERE organization_id = $1`, orgID))
+	if err == pgx.ErrNoRows {
+		if h.global == nil || h.globalOrg != orgSlug {
+			return uuid.Nil, nil, fmt.Errorf("LDAP not configured for this organization")
+		}
+		global := *h.global
+		global.OrganizationID = orgID
+		return orgID, &global, nil
+	}
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	if !cfg.Enabled {
+		return uuid.Nil, nil, fmt.Errorf("LDAP is not enabled for this organization")
+	}
+	return orgID, cfg, nil
+}
+
+// LDAPLoginRequest represents the LDAP login request body. Org may be omitted
+// when a global directory is configured.
+type LDAPLoginRequest struct {
+	Org      string `json:"org"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Login authenticates against the organization's directory and issues tokens.
+// Directory users sign in to the account their entry is linked to, or to a new
+// one; an existing account with the same email must link the entry first.
+func (h *LDAPHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req LDAPLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	orgID, identity, role, ok := h.authenticate(ctx, w, r, &req)
+	if !ok {
+		return
+	}
+
+	userID, userEmail, userName, err := h.provisionUser(ctx, orgID, identity, role, provisionOptions{})
+	if err != nil {
+		writeProvisionError(w, err)
+		return
+	}
+
+	// Directory passwords are a first factor like any other
+	if requireMFA(ctx, w, h.pool, h.jwtManager, userID, userEmail, userName) {
+		return
+	}
+
+	accessToken, err := h.jwtManager.GenerateAccessToken(userID, userEmail, userName)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := AuthResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   900, // 15 minutes in seconds
+	}
+
+	// Generate refresh token if manager is available
+	if h.refreshTokenManager != nil {
+		refreshToken, err := auth.GenerateRefreshToken()
+		if err != nil {
+			http.Error(w, `{"error":"failed to generate refresh token"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.refreshTokenManager.StoreRefreshToken(ctx, refreshToken, userID, userEmail, userName, clientInfo(r)); err != nil {
+			http.Error(w, `{"error":"failed to store refresh token"}`, http.StatusInternalServerError)
+			return
+		}
+
+		response.RefreshToken = refreshToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Link links the signed-in user's account to their entry in the organization's
+// directory, checked by signing in to it
+func (h *LDAPHandler) Link(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req LDAPLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	orgID, identity, role, ok := h.authenticate(ctx, w, r, &req)
+	if !ok {
+		return
+	}
+
+	if _, _, _, err := h.provisionUser(ctx, orgID, identity, role, provisionOptions{linkUserID: &userID}); err != nil {
+		writeProvisionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate signs in to the org's directory as the requested user and
+// resolves their role, writing the error response and returning false on failure.
+// Attempts are limited like password logins, as each one binds to the directory.
+func (h *LDAPHandler) authenticate(ctx context.Context, w http.ResponseWriter, r *http.Request, req *LDAPLoginRequest) (uuid.UUID, *sso.Identity, models.MembershipRole, bool) {
+	if req.Username == "" || req.Password == "" {
+		http.Error(w, `{"error":"username and password are required"}`, http.StatusBadRequest)
+		return uuid.Nil, nil, "", false
+	}
+	if req.Org == "" {
+		req.Org = h.globalOrg
+	}
+	if req.Org == "" {
+		http.Error(w, `{"error":"org is required"}`, http.StatusBadRequest)
+		return uuid.Nil, nil, "", false
+	}
+
+	account := req.Org + "|" + accountKey(req.Username)
+	if rateLimited(ctx, w, h.limiter, 30, 15*time.Minute, "ldap:ip:"+clientIP(r)) ||
+		rateLimited(ctx, w, h.limiter, 20, 15*time.Minute, "ldap:account:"+account) {
+		return uuid.Nil, nil, "", false
+	}
+
+	// Directory entries are locked out for a while after repeated wrong passwords
+	if d := h.limiter.Locked(ctx, "ldap:"+account); d > 0 {
+		writeTooManyRequests(w, d, "too many failed login attempts, try again later")
+		return uuid.Nil, nil, "", false
+	}
+
+	orgID, cfg, err := h.loadLDAPConfig(ctx, req.Org)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return uuid.Nil, nil, "", false
+	}
+
+	provider, err := ldapProvider(cfg)
+	if err != nil {
+		http.Error(w, `{"error":"invalid LDAP configuration"}`, http.StatusInternalServerError)
+		return uuid.Nil, nil, "", false
+	}
+
+	identity, err := provider.Authenticate(req.Username, req.Password)
+	if err == sso.ErrInvalidCredentials {
+		h.limiter.RecordFailure(ctx, "ldap:"+account, loginLockout)
+		http.Error(w, `{"error":"invalid credentials"}`, http.StatusUnauthorized)
+		return uuid.Nil, nil, "", false
+	}
+	if err != nil {
+		log.Printf("LDAP login for org %s failed: %v", req.Org, err)
+		http.Error(w, `{"error":"LDAP server unavailable"}`, http.StatusBadGateway)
+		return uuid.Nil, nil, "", false
+	}
+	h.limiter.Reset(ctx, "ldap:"+account)
+
+	if err := identity.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return uuid.Nil, nil, "", false
+	}
+
+	role := ldapRole(cfg, identity)
+	if role == "" {
+		http.Error(w, `{"error":"not authorized for this organization"}`, http.StatusForbidden)
+		return uuid.Nil, nil, "", false
+	}
+	return orgID, identity, role, true
+}
+
+// LDAPConfigRequest represents the request body for configuring LDAP. An empty
+// bind_password keeps the stored one.
+type LDAPConfigRequest struct {
+	URL                string                    `json:"url"`
+	StartTLS           bool                      `json:"start_tls"`
+	InsecureSkipVerify bool                      `json:"insecure_skip_verify"`
+	RootCA             *string                   `json:"root_ca,omitempty"`
+	BindDN             string                    `json:"bind_dn"`
+	BindPassword       string                    `json:"bind_password"`
+	BaseDN             string                    `json:"base_dn"`
+	UserFilter         string                    `json:"user_filter"`
+	EmailAttribute     string                    `json:"email_attribute"`
+	NameAttribute      string                    `json:"name_attribute"`
+	GroupAttribute     string                    `json:"group_attribute"`
+	GroupMappings      []models.GroupRoleMapping `json:"group_mappings"`
+	DefaultRole        *models.MembershipRole    `json:"default_role,omitempty"`
+	Enabled            *bool                     `json:"enabled,omitempty"`
+}
+
+// ConfigureLDAP creates or updates LDAP configuration for an organization
+func (h *LDAPHandler) ConfigureLDAP(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	var req LDAPConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.URL == "" || req.BaseDN == "" {
+		http.Error(w, `{"error":"url and base_dn are required"}`, http.StatusBadRequest)
+		return
+	}
+	if req.GroupMappings == nil {
+		req.GroupMappings = []models.GroupRoleMapping{}
+	}
+
+	cfg := &models.LDAPConfig{
+		URL:                strings.TrimSuffix(req.URL, "/"),
+		StartTLS:           req.StartTLS,
+		InsecureSkipVerify: req.InsecureSkipVerify,
+		RootCA:             req.RootCA,
+		BindDN:             req.BindDN,
+		BindPassword:       req.BindPassword,
+		BaseDN:             req.BaseDN,
+		UserFilter:         req.UserFilter,
+		EmailAttribute:     req.EmailAttribute,
+		NameAttribute:      req.NameAttribute,
+		GroupAttribute:     req.GroupAttribute,
+		GroupMappings:      req.GroupMappings,
+		DefaultRole:        req.DefaultRole,
+	}
+	if err := validateLDAPConfig(cfg); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	before := h.configSnapshot(ctx, orgID)
+
+	// Upsert LDAP config
+	saved, err := scanLDAPConfig(h.pool.QueryRow(ctx,
+		`INSERT INTO ldap_configs (organization_id, url, start_tls, insecure_skip_verify, root_ca, bind_dn, bind_password,
+		                           base_dn, user_filter, email_attribute, name_attribute, group_attribute,
+		                           group_mappings, default_role, enabled)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		 ON CONFLICT (organization_id) DO UPDATE
+		 SET url = $2, start_tls = $3, insecure_skip_verify = $4, root_ca = $5, bind_dn = $6,
+		     bind_password = COALESCE(NULLIF($7, ''), ldap_configs.bind_password),
+		     base_dn = $8, user_filter = $9, email_attribute = $10, name_attribute = $11, group_attribute = $12,
+		     group_mappings = $13, default_role = $14, enabled = $15, updated_at = NOW()
+		 RETURNING `+ldapConfigColumns,
+		orgID, cfg.URL, cfg.StartTLS, cfg.InsecureSkipVerify, cfg.RootCA, cfg.BindDN, cfg.BindPassword,
+		cfg.BaseDN, cfg.UserFilter, cfg.EmailAttribute, cfg.NameAttribute, cfg.GroupAttribute,
+		cfg.GroupMappings, cfg.DefaultRole, enabled,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to save LDAP config"}`, http.StatusInternalServerError)
+		return
+	}
+	h.auditConfigChange(ctx, r, orgID, before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// GetLDAPConfig returns the LDAP configuration for an organization
+func (h *LDAPHandler) GetLDAPConfig(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	cfg, err := scanLDAPConfig(h.pool.QueryRow(ctx,
+		`SELECT `+ldapConfigColumns+` FROM ldap_configs WHERE organization_id = $1`, orgID))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"LDAP not configured"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get LDAP config"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// LDAPSyncResult reports the membership changes made by a group resync
+type LDAPSyncResult struct {
+	Checked int `json:"checked"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+}
+
+// SyncGroups re-reads group membership for an organization's LDAP users now
+func (h *LDAPHandler) SyncGroups(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	var orgSlug string
+	if err := h.pool.QueryRow(ctx, `SELECT slug FROM organizations WHERE id = $1`, orgID).Scan(&orgSlug); err != nil {
+		http.Error(w, `{"error":"organization not found"}`, http.StatusNotFound)
+		return
+	}
+
+	_, cfg, err := h.loadLDAPConfig(ctx, orgSlug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.syncOrg(ctx, orgID, cfg)
+	if err != nil {
+		log.Printf("LDAP group sync for org %s failed: %v", orgSlug, err)
+		http.Error(w, `{"error":"LDAP group sync failed"}`, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// syncOrg recomputes the role of every org member linked to the directory.
+// Members who left the directory or no longer map to a role are removed.
+func (h *LDAPHandler) syncOrg(ctx context.Context, orgID uuid.UUID, cfg *models.LDAPConfig) (*LDAPSyncResult, error) {
+	provider, err := ldapProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := orgID.String() + "|" + provider.Issuer() + "|"
+	rows, err := h.pool.Query(ctx,
+		`SELECT om.user_id, om.role, uam.provider_user_id
+		 FROM organization_memberships om
+		 JOIN user_auth_methods uam ON uam.user_id = om.user_id AND uam.provider = $2
+		 WHERE om.organization_id = $1 AND starts_with(uam.provider_user_id, $3)`,
+		orgID, models.SSOLDAP, prefix,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	type member struct {
+		userID uuid.UUID
+		role   models.MembershipRole
+		dn     string
+	}
+	var members []member
+	for rows.Next() {
+		var m member
+		var providerUserID string
+		if err := rows.Scan(&m.userID, &m.role, &providerUserID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.dn = strings.TrimPrefix(providerUserID, prefix)
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &LDAPSyncResult{}
+	for _, m := range members {
+		var role models.MembershipRole
+		identity, err := provider.Lookup(m.dn)
+		switch {
+		case err == sso.ErrLDAPUserNotFound:
+		case err != nil:
+			return result, err
+		default:
+			role = ldapRole(cfg, identity)
+		}
+		result.Checked++
+
+		if role == "" {
+			if _, err := h.pool.Exec(ctx,
+				`DELETE FROM organization_memberships WHERE organization_id = $1 AND user_id = $2`,
+				orgID, m.userID,
+			); err != nil {
+				return result, err
+			}
+			if err := h.jwtManager.Denylist().RevokeMembership(ctx, orgID, m.userID); err != nil {
+				log.Printf("Failed to revoke org tokens after LDAP sync removal: %v", err)
+			}
+			result.Removed++
+			continue
+		}
+
+		if role != m.role {
+			if _, err := h.pool.Exec(ctx,
+				`UPDATE organization_memberships SET role = $3, updated_at = NOW() WHERE organization_id = $1 AND user_id = $2`,
+				orgID, m.userID, role,
+			); err != nil {
+				return result, err
+			}
+			if err := h.jwtManager.Denylist().RevokeMembership(ctx, orgID, m.userID); err != nil {
+				log.Printf("Failed to revoke org tokens after LDAP sync role change: %v", err)
+			}
+			result.Updated++
+		}
+	}
+
+	if cfg.ID != uuid.Nil {
+		if _, err := h.pool.Exec(ctx, `UPDATE ldap_configs SET last_synced_at = NOW() WHERE id = $1`, cfg.ID); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// syncAll resyncs every enabled directory, including the global one
+func (h *LDAPHandler) syncAll(ctx context.Context) {
+	rows, err := h.pool.Query(ctx,
+		`SELECT o.slug FROM ldap_configs lc JOIN organizations o ON o.id = lc.organization_id WHERE lc.enabled = true`)
+	if err != nil {
+		log.Printf("LDAP group sync: failed to list configs: %v", err)
+		return
+	}
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err == nil {
+			slugs = append(slugs, slug)
+		}
+	}
+	rows.Close()
+
+	if h.global != nil && h.globalOrg != "" && !containsString(slugs, h.globalOrg) {
+		slugs = append(slugs, h.globalOrg)
+	}
+
+	for _, slug := range slugs {
+		orgID, cfg, err := h.loadLDAPConfig(ctx, slug)
+		if err != nil {
+			log.Printf("LDAP group sync for org %s: %v", slug, err)
+			continue
+		}
+		if _, err := h.syncOrg(ctx, orgID, cfg); err != nil {
+			log.Printf("LDAP group sync for org %s failed: %v", slug, err)
+		}
+	}
+}
+
+// StartGroupSync resyncs LDAP group memberships every interval until ctx is done
+func (h *LDAPHandler) StartGroupSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				syncCtx, cancel := context.WithTimeout(ctx, interval)
+				h.syncAll(syncCtx)
+				cancel()
+			}
+		}
+	}()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
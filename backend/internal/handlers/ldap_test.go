@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+// startLDAPTestDirectory starts an in-process directory where alice is in the
+// admins group and bob is in no group; both use password "password"
+func startLDAPTestDirectory(t *testing.T) *testdirectory.Directory {
+	t.Helper()
+	d := testdirectory.Start(t)
+	users := testdirectory.NewUsers(t, []string{"alice"}, testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"admins"})...))
+	users = append(users, testdirectory.NewUsers(t, []string{"bob", "svc"})...)
+	d.SetUsers(users...)
+	d.SetGroups(testdirectory.NewGroup(t, "admins", []string{"alice"}))
+	return d
+}
+
+func TestLDAPLoginAndGroupSync(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	d := startLDAPTestDirectory(t)
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org LDAP', 'test-org-ldap') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE email IN ('alice@example.com', 'bob@example.com')`)
+
+	mappings, _ := json.Marshal([]models.GroupRoleMapping{
+		{Group: "cn=admins," + testdirectory.DefaultGroupDN, Role: models.RoleAdmin},
+	})
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO ldap_configs (organization_id, url, root_ca, bind_dn, bind_password, base_dn, user_filter,
+		                           email_attribute, name_attribute, group_mappings, default_role)
+		 VALUES ($1, $2, $3, $4, 'password', $5, '(cn={username})', 'email', 'name', $6, 'viewer')`,
+		orgID, fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port()), d.Cert(),
+		"cn=svc,"+testdirectory.DefaultUserDN, testdirectory.DefaultUserDN, mappings,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create LDAP config: %v", err)
+	}
+
+	handler := NewLDAPHandler(testPool, testJWTManager, nil, nil, nil)
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(LDAPLoginRequest{Org: "test-org-ldap", Username: username, Password: password})
+		req := httptest.NewRequest("POST", "/api/auth/ldap/login", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+		return w
+	}
+
+	if w := login("alice", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 for wrong password, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := login("alice", "password")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.AccessToken == "" {
+		t.Error("Expected access token in response")
+	}
+
+	if w := login("bob", "password"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for bob, got %d: %s", w.Code, w.Body.String())
+	}
+
+	roleOf := func(email string) string {
+		var role string
+		err := testPool.QueryRow(ctx,
+			`SELECT om.role FROM organization_memberships om JOIN users u ON u.id = om.user_id
+			 WHERE om.organization_id = $1 AND u.email = $2`,
+			orgID, email,
+		).Scan(&role)
+		if err != nil {
+			return ""
+		}
+		return role
+	}
+
+	if role := roleOf("alice@example.com"); role != "admin" {
+		t.Errorf("Expected alice to be admin via group mapping, got '%s'", role)
+	}
+	if role := roleOf("bob@example.com"); role != "viewer" {
+		t.Errorf("Expected bob to get the default role, got '%s'", role)
+	}
+
+	// alice leaves the admins group and bob leaves the directory
+	d.SetGroups()
+	d.SetUsers(append(
+		testdirectory.NewUsers(t, []string{"alice"}),
+		testdirectory.NewUsers(t, []string{"svc"})...,
+	)...)
+
+	var aliceID uuid.UUID
+	if err := testPool.QueryRow(ctx, `SELECT id FROM users WHERE email = 'alice@example.com'`).Scan(&aliceID); err != nil {
+		t.Fatalf("Failed to find alice: %v", err)
+	}
+	token, err := testJWTManager.GenerateAccessToken(aliceID, "alice@example.com", "alice")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/ldap/sync", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w = httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, handler.SyncGroups)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for sync, got %d: %s", w.Code, w.Body.String())
+	}
+	var result LDAPSyncResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode sync result: %v", err)
+	}
+	if result.Updated != 1 || result.Removed != 1 {
+		t.Errorf("Expected 1 updated and 1 removed, got %+v", result)
+	}
+
+	if role := roleOf("alice@example.com"); role != "viewer" {
+		t.Errorf("Expected alice to be downgraded to viewer, got '%s'", role)
+	}
+	if role := roleOf("bob@example.com"); role != "" {
+		t.Errorf("Expected bob's membership to be removed, got '%s'", role)
+	}
+}
+
+func TestLDAPConfigureRequiresAdmin(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org LDAP Config', 'test-org-ldap-config') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testldapeditor@example.com', 'Test LDAP Editor') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'editor')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "testldapeditor@example.com", "Test LDAP Editor")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewLDAPHandler(testPool, testJWTManager, nil, nil, nil)
+
+	body := `{"url":"ldaps://ldap.example.com","base_dn":"dc=example,dc=com"}`
+	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/ldap", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w := httptest.NewRecorder()
+
+	auth.RequireAuth(testJWTManager, handler.ConfigureLDAP)(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for non-admin, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLDAPLoginDoesNotLinkAccountsByEmail(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	d := startLDAPTestDirectory(t)
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org LDAP Link', 'test-org-ldap-link') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('bob@example.com', 'Bob') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO ldap_configs (organization_id, url, root_ca, bind_dn, bind_password, base_dn, user_filter,
+		                           email_attribute, name_attribute, default_role)
+		 VALUES ($1, $2, $3, $4, 'password', $5, '(cn={username})', 'email', 'name', 'viewer')`,
+		orgID, fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port()), d.Cert(),
+		"cn=svc,"+testdirectory.DefaultUserDN, testdirectory.DefaultUserDN,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create LDAP config: %v", err)
+	}
+
+	handler := NewLDAPHandler(testPool, testJWTManager, nil, nil, nil)
+	body, _ := json.Marshal(LDAPLoginRequest{Org: "test-org-ldap-link", Username: "bob", Password: "password"})
+
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/auth/ldap/login", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+		return w
+	}
+
+	// The directory's email matches an account the entry isn't linked to
+	if w := login(); w.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409 for an unlinked account, got %d: %s", w.Code, w.Body.String())
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "bob@example.com", "Bob")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/api/auth/ldap/link", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, handler.Link)(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 for link, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := login(); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 once linked, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// With a second factor, the directory password only gets an MFA challenge
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO user_auth_methods (user_id, provider, provider_user_id, name, secret, confirmed_at)
+		 VALUES ($1, 'totp', $1::text, 'Authenticator app', 'JBSWY3DPEHPK3PXP', NOW())`,
+		userID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add TOTP: %v", err)
+	}
+
+	w = login()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var challenge struct {
+		MFAChallengeResponse
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !challenge.MFARequired || challenge.MFAToken == "" || challenge.AccessToken != "" {
+		t.Errorf("Expected an MFA challenge instead of tokens, got %+v", challenge)
+	}
+}
+
+func TestLDAPLoginLockout(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	d := startLDAPTestDirectory(t)
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org LDAP Lockout', 'test-org-ldap-lockout') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO ldap_configs (organization_id, url, root_ca, bind_dn, bind_password, base_dn, user_filter,
+		                           email_attribute, name_attribute, default_role)
+		 VALUES ($1, $2, $3, $4, 'password', $5, '(cn={username})', 'email', 'name', 'viewer')`,
+		orgID, fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port()), d.Cert(),
+		"cn=svc,"+testdirectory.DefaultUserDN, testdirectory.DefaultUserDN,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create LDAP config: %v", err)
+	}
+
+	handler := NewLDAPHandler(testPool, testJWTManager, nil, nil, nil)
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(LDAPLoginRequest{Org: "test-org-ldap-lockout", Username: username, Password: password})
+		req := httptest.NewRequest("POST", "/api/auth/ldap/login", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+		return w
+	}
+
+	for i := 0; i < loginLockout.Threshold; i++ {
+		if w := login("alice", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 for failure %d, got %d", i+1, w.Code)
+		}
+	}
+
+	// Locked out, even with the right password, before binding to the directory
+	w := login("Alice", "password")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 while locked out, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+}
@@ -0,0 +1,889 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/ratelimit"
+	"github.com/janhoon/dash/backend/internal/sso"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mfaIssuer = "Dash"
+
+	// webAuthnSessionTTL bounds a WebAuthn ceremony between begin and finish
+	webAuthnSessionTTL = 5 * time.Minute
+)
+
+// MFAHandler manages TOTP and WebAuthn second factors and completes MFA logins
+type MFAHandler struct {
+	pool                *pgxpool.Pool
+	jwtManager          *auth.JWTManager
+	refreshTokenManager *auth.RefreshTokenManager
+	webAuthn            *webauthn.WebAuthn
+	sessions            sso.StateStore
+	limiter             *ratelimit.Limiter
+}
+
+func NewMFAHandler(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client, rtm *auth.RefreshTokenManager) *MFAHandler {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:5173"
+	}
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		if u, err := url.Parse(frontendURL); err == nil {
+			rpID = u.Hostname()
+		}
+	}
+	origins := []string{frontendURL}
+	if v := os.Getenv("WEBAUTHN_RP_ORIGINS"); v != "" {
+		origins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: mfaIssuer,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		log.Printf("Warning: WebAuthn disabled: %v", err)
+	}
+
+	return &MFAHandler{
+		pool:                pool,
+		jwtManager:          jwtManager,
+		refreshTokenManager: rtm,
+		webAuthn:            wa,
+		sessions:            sso.NewStateStore(rdb),
+		limiter:             ratelimit.New(rdb),
+	}
+}
+
+// MFAChallengeResponse is returned by password login instead of tokens when a
+// second factor is needed
+type MFAChallengeResponse struct {
+	MFARequired           bool     `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired bool     `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string   `json:"mfa_token"`
+	Methods               []string `json:"methods,omitempty"`
+}
+
+// MFARequest carries the MFA token for enrolment during login; it is omitted
+// when a signed-in user manages their factors
+type MFARequest struct {
+	MFAToken string `json:"mfa_token,omitempty"`
+}
+
+// TOTPSetupResponse holds the secret to load into an authenticator app
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// TOTPConfirmRequest confirms TOTP enrolment with a first code
+type TOTPConfirmRequest struct {
+	MFAToken string `json:"mfa_token,omitempty"`
+	Code     string `json:"code"`
+}
+
+// MFAEnrollResponse is returned when a factor is enrolled. Recovery codes are
+// only included for the first factor, tokens only when enrolling during login.
+type MFAEnrollResponse struct {
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	*AuthResponse
+}
+
+// MFAVerifyRequest completes a login with a TOTP code or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// WebAuthnFinishRequest carries the browser's credential response
+type WebAuthnFinishRequest struct {
+	MFAToken   string          `json:"mfa_token,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// RecoveryCodesResponse lists freshly generated recovery codes
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaSubject is the user an MFA request acts for
+type mfaSubject struct {
+	UserID uuid.UUID
+	Email  string
+	Name   string
+	// duringLogin is set when the request was authorized by an MFA token, so
+	// completing it should sign the user in
+	duringLogin bool
+}
+
+// webAuthnUser adapts a Dash user to the webauthn.User interface
+type webAuthnUser struct {
+	id          uuid.UUID
+	email       string
+	name        string
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte                         { return u.id[:] }
+func (u *webAuthnUser) WebAuthnName() string                       { return u.email }
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.name != "" {
+		return u.name
+	}
+	return u.email
+}
+
+// userMFAMethods returns the confirmed second factor types of a user
+func userMFAMethods(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT DISTINCT provider FROM user_auth_methods
+		 WHERE user_id = $1 AND provider IN ('totp', 'webauthn') AND confirmed_at IS NOT NULL
+		 ORDER BY provider`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := []string{}
+	for rows.Next() {
+		var method string
+		if err := rows.Scan(&method); err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+	return methods, rows.Err()
+}
+
+// adminMFARequired reports whether the user is an admin of an organization
+// that requires MFA for admins
+func adminMFARequired(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (bool, error) {
+	var required bool
+	err := pool.QueryRow(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM organization_memberships om
+			JOIN sso_settings s ON s.organization_id = om.organization_id AND s.require_admin_mfa
+			WHERE om.user_id = $1 AND om.role = 'admin'
+		)`,
+		userID,
+	).Scan(&required)
+	return required, err
+}
+
+// requireMFA answers a login that passed its first factor with an MFA
+// challenge instead of tokens when the user has a second factor, or is an
+// admin required to set one up. It returns true if it wrote a response.
+func requireMFA(ctx context.Context, w http.ResponseWriter, pool *pgxpool.Pool, jwtManager *auth.JWTManager, userID uuid.UUID, email, name string) bool {
+	challenge, ok := mfaChallenge(ctx, w, pool, jwtManager, userID, email, name)
+	if !ok || challenge == nil {
+		return !ok
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+	return true
+}
+
+// mfaChallenge returns the MFA challenge a login that passed its first factor
+// must complete, or nil if the user needs no second factor. It writes the
+// error response and returns false on failure.
+func mfaChallenge(ctx context.Context, w http.ResponseWriter, pool *pgxpool.Pool, jwtManager *auth.JWTManager, userID uuid.UUID, email, name string) (*MFAChallengeResponse, bool) {
+	methods, err := userMFAMethods(ctx, pool, userID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to check mfa"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	enroll := false
+	if len(methods) == 0 {
+		enroll, err = adminMFARequired(ctx, pool, userID)
+		if err != nil {
+			http.Error(w, `{"error":"failed to check login policy"}`, http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	if len(methods) == 0 && !enroll {
+		return nil, true
+	}
+
+	mfaToken, err := jwtManager.GenerateMFAToken(userID, email, name, enroll)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return &MFAChallengeResponse{
+		MFARequired:           !enroll,
+		MFAEnrollmentRequired: enroll,
+		MFAToken:              mfaToken,
+		Methods:               methods,
+	}, true
+}
+
+// checkSecondFactor accepts a valid TOTP code or an unused recovery code of the
+// user, using it up. It writes the error response and returns false otherwise.
+func checkSecondFactor(ctx context.Context, w http.ResponseWriter, pool *pgxpool.Pool, userID uuid.UUID, code, recoveryCode string) bool {
+	if recoveryCode != "" {
+		result, err := pool.Exec(ctx,
+			`UPDATE user_recovery_codes SET used_at = NOW()
+			 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+			userID, auth.HashRecoveryCode(recoveryCode),
+		)
+		if err != nil {
+			http.Error(w, `{"error":"failed to check recovery code"}`, http.StatusInternalServerError)
+			return false
+		}
+		if result.RowsAffected() == 0 {
+			http.Error(w, `{"error":"invalid recovery code"}`, http.StatusUnauthorized)
+			return false
+		}
+	} else {
+		var secret string
+		err := pool.QueryRow(ctx,
+			`SELECT secret FROM user_auth_methods
+			 WHERE user_id = $1 AND provider = 'totp' AND confirmed_at IS NOT NULL`,
+			userID,
+		).Scan(&secret)
+		if err == pgx.ErrNoRows {
+			http.Error(w, `{"error":"totp is not enabled"}`, http.StatusBadRequest)
+			return false
+		}
+		if err != nil {
+			http.Error(w, `{"error":"failed to get totp secret"}`, http.StatusInternalServerError)
+			return false
+		}
+
+		step, valid := auth.ValidateTOTP(secret, code, time.Now())
+		if !valid {
+			http.Error(w, `{"error":"invalid code"}`, http.StatusUnauthorized)
+			return false
+		}
+
+		// Each code is accepted once: the step must be newer than the last one used
+		result, err := pool.Exec(ctx,
+			`UPDATE user_auth_methods SET last_used_step = $2, last_used_at = NOW()
+			 WHERE user_id = $1 AND provider = 'totp' AND (last_used_step IS NULL OR last_used_step < $2)`,
+			userID, step,
+		)
+		if err != nil {
+			http.Error(w, `{"error":"failed to record totp use"}`, http.StatusInternalServerError)
+			return false
+		}
+		if result.RowsAffected() == 0 {
+			http.Error(w, `{"error":"code already used"}`, http.StatusUnauthorized)
+			return false
+		}
+	}
+	return true
+}
+
+// decodeOptional decodes a JSON body that may be empty
+func decodeOptional(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// enrollingUser resolves who is enrolling a factor: a signed-in user, or a user
+// whose login is blocked until they enrol, identified by an enrolment MFA token.
+// A login MFA token for a user who already has a factor cannot add another one,
+// so a stolen password is not enough to register an attacker's authenticator.
+func (h *MFAHandler) enrollingUser(ctx context.Context, w http.ResponseWriter, r *http.Request, mfaToken string) (*mfaSubject, bool) {
+	if mfaToken == "" {
+		tokenString, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			http.Error(w, `{"error":"authorization header required"}`, http.StatusUnauthorized)
+			return nil, false
+		}
+		// Tokens revoked by logout or a password reset can't change factors
+		claims, err := h.jwtManager.AuthenticateAccessToken(ctx, tokenString)
+		if err == auth.ErrRevokedToken {
+			http.Error(w, `{"error":"token has been revoked"}`, http.StatusUnauthorized)
+			return nil, false
+		}
+		if err != nil {
+			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+			return nil, false
+		}
+		return &mfaSubject{UserID: claims.UserID, Email: claims.Email, Name: claims.Name}, true
+	}
+
+	claims, err := h.jwtManager.VerifyMFAToken(mfaToken)
+	if err != nil || !claims.Enroll {
+		http.Error(w, `{"error":"invalid mfa token"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	methods, err := userMFAMethods(ctx, h.pool, claims.UserID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get mfa methods"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(methods) > 0 {
+		http.Error(w, `{"error":"mfa is already enrolled, complete the login instead"}`, http.StatusForbidden)
+		return nil, false
+	}
+	return &mfaSubject{UserID: claims.UserID, Email: claims.Email, Name: claims.Name, duringLogin: true}, true
+}
+
+// loginUser verifies the MFA token of a login waiting for its second factor
+func (h *MFAHandler) loginUser(w http.ResponseWriter, mfaToken string) (*auth.MFAClaims, bool) {
+	claims, err := h.jwtManager.VerifyMFAToken(mfaToken)
+	if err != nil {
+		if err == auth.ErrExpiredToken {
+			http.Error(w, `{"error":"mfa token has expired, sign in again"}`, http.StatusUnauthorized)
+			return nil, false
+		}
+		http.Error(w, `{"error":"invalid mfa token"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	return claims, true
+}
+
+// issueTokens creates the access and refresh tokens that complete a login
+func (h *MFAHandler) issueTokens(ctx context.Context, r *http.Request, userID uuid.UUID, email, name string) (*AuthResponse, error) {
+	accessToken, err := h.jwtManager.GenerateAccessToken(userID, email, name)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AuthResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   900, // 15 minutes in seconds
+	}
+
+	if h.refreshTokenManager != nil {
+		refreshToken, err := auth.GenerateRefreshToken()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.refreshTokenManager.StoreRefreshToken(ctx, refreshToken, userID, email, name, clientInfo(r)); err != nil {
+			return nil, err
+		}
+		response.RefreshToken = refreshToken
+	}
+
+	return response, nil
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes, invalidating the old ones
+func (h *MFAHandler) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, auth.HashRecoveryCode(code),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit(ctx)
+}
+
+// completeEnrollment builds the response for a newly enrolled factor
+func (h *MFAHandler) completeEnrollment(ctx context.Context, w http.ResponseWriter, r *http.Request, subject *mfaSubject, firstFactor bool) {
+	response := MFAEnrollResponse{}
+	if firstFactor {
+		codes, err := h.replaceRecoveryCodes(ctx, subject.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"failed to generate recovery codes"}`, http.StatusInternalServerError)
+			return
+		}
+		response.RecoveryCodes = codes
+	}
+	if subject.duringLogin {
+		tokens, err := h.issueTokens(ctx, r, subject.UserID, subject.Email, subject.Name)
+		if err != nil {
+			http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+			return
+		}
+		response.AuthResponse = tokens
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// loadWebAuthnUser loads a user's registered WebAuthn credentials
+func (h *MFAHandler) loadWebAuthnUser(ctx context.Context, userID uuid.UUID, email, name string) (*webAuthnUser, error) {
+	rows, err := h.pool.Query(ctx,
+		`SELECT credential FROM user_auth_methods
+		 WHERE user_id = $1 AND provider = 'webauthn' AND credential IS NOT NULL`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	user := &webAuthnUser{id: userID, email: email, name: name}
+	for rows.Next() {
+		var credential webauthn.Credential
+		if err := rows.Scan(&credential); err != nil {
+			return nil, err
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+	return user, rows.Err()
+}
+
+// SetupTOTP starts TOTP enrolment and returns the secret for the authenticator app
+func (h *MFAHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	var req MFARequest
+	if err := decodeOptional(r, &req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	subject, ok := h.enrollingUser(ctx, w, r, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate secret"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// One TOTP factor per user; an unconfirmed setup is replaced
+	var methodID uuid.UUID
+	err = h.pool.QueryRow(ctx,
+		`INSERT INTO user_auth_methods (user_id, provider, provider_user_id, name, secret)
+		 VALUES ($1, 'totp', $2, 'Authenticator app', $3)
+		 ON CONFLICT (provider, provider_user_id) DO UPDATE
+		 SET secret = $3, updated_at = NOW()
+		 WHERE user_auth_methods.confirmed_at IS NULL
+		 RETURNING id`,
+		subject.UserID, subject.UserID.String(), secret,
+	).Scan(&methodID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"totp is already enabled"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to save totp secret"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURL: auth.TOTPURL(mfaIssuer, subject.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves their app generates valid codes
+func (h *MFAHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	subject, ok := h.enrollingUser(ctx, w, r, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	var secret string
+	err := h.pool.QueryRow(ctx,
+		`SELECT secret FROM user_auth_methods
+		 WHERE user_id = $1 AND provider = 'totp' AND confirmed_at IS NULL`,
+		subject.UserID,
+	).Scan(&secret)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"no pending totp setup"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get totp setup"}`, http.StatusInternalServerError)
+		return
+	}
+
+	step, valid := auth.ValidateTOTP(secret, req.Code, time.Now())
+	if !valid {
+		http.Error(w, `{"error":"invalid code"}`, http.StatusUnauthorized)
+		return
+	}
+
+	methods, err := userMFAMethods(ctx, h.pool, subject.UserID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get mfa methods"}`, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.pool.Exec(ctx,
+		`UPDATE user_auth_methods SET confirmed_at = NOW(), last_used_step = $2, last_used_at = NOW(), updated_at = NOW()
+		 WHERE user_id = $1 AND provider = 'totp' AND confirmed_at IS NULL`,
+		subject.UserID, step,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to enable totp"}`, http.StatusInternalServerError)
+		return
+	}
+
+	h.completeEnrollment(ctx, w, r, subject, len(methods) == 0)
+}
+
+// BeginWebAuthnRegistration returns the options for navigator.credentials.create
+func (h *MFAHandler) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if h.webAuthn == nil {
+		http.Error(w, `{"error":"webauthn is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req MFARequest
+	if err := decodeOptional(r, &req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	subject, ok := h.enrollingUser(ctx, w, r, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	user, err := h.loadWebAuthnUser(ctx, subject.UserID, subject.Email, subject.Name)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get webauthn credentials"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Don't let the same authenticator register twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := h.webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		http.Error(w, `{"error":"failed to start webauthn registration"}`, http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(session)
+	if err := h.sessions.Save(ctx, "webauthn_register:"+subject.UserID.String(), data, webAuthnSessionTTL); err != nil {
+		http.Error(w, `{"error":"failed to save webauthn session"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creation)
+}
+
+// FinishWebAuthnRegistration verifies and stores a new WebAuthn credential
+func (h *MFAHandler) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if h.webAuthn == nil {
+		http.Error(w, `{"error":"webauthn is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req WebAuthnFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	subject, ok := h.enrollingUser(ctx, w, r, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	var session webauthn.SessionData
+	data, err := h.sessions.Take(ctx, "webauthn_register:"+subject.UserID.String())
+	if err == nil {
+		err = json.Unmarshal(data, &session)
+	}
+	if err != nil {
+		http.Error(w, `{"error":"webauthn registration expired, start again"}`, http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		http.Error(w, `{"error":"invalid webauthn credential"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.loadWebAuthnUser(ctx, subject.UserID, subject.Email, subject.Name)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get webauthn credentials"}`, http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.webAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		http.Error(w, `{"error":"webauthn registration failed"}`, http.StatusBadRequest)
+		return
+	}
+
+	methods, err := userMFAMethods(ctx, h.pool, subject.UserID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get mfa methods"}`, http.StatusInternalServerError)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Security key"
+	}
+	_, err = h.pool.Exec(ctx,
+		`INSERT INTO user_auth_methods (user_id, provider, provider_user_id, name, credential, confirmed_at)
+		 VALUES ($1, 'webauthn', $2, $3, $4, NOW())`,
+		subject.UserID, base64.RawURLEncoding.EncodeToString(credential.ID), name, credential,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to save webauthn credential"}`, http.StatusInternalServerError)
+		return
+	}
+
+	h.completeEnrollment(ctx, w, r, subject, len(methods) == 0)
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	methods, err := userMFAMethods(ctx, h.pool, userID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get mfa methods"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(methods) == 0 {
+		http.Error(w, `{"error":"enable mfa before generating recovery codes"}`, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.replaceRecoveryCodes(ctx, userID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate recovery codes"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Verify completes an MFA login with a TOTP code or a recovery code
+func (h *MFAHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	var req MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		http.Error(w, `{"error":"code or recovery_code is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := h.loginUser(w, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Codes are short, so limit guesses per user as well as per IP
+	if rateLimited(ctx, w, h.limiter, 30, 15*time.Minute, "mfa_verify:ip:"+clientIP(r)) ||
+		rateLimited(ctx, w, h.limiter, 10, 15*time.Minute, "mfa_verify:user:"+claims.UserID.String()) {
+		return
+	}
+
+	if !checkSecondFactor(ctx, w, h.pool, claims.UserID, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	response, err := h.issueTokens(ctx, r, claims.UserID, claims.Email, claims.Name)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// BeginWebAuthnLogin returns the options for navigator.credentials.get
+func (h *MFAHandler) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if h.webAuthn == nil {
+		http.Error(w, `{"error":"webauthn is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := h.loginUser(w, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.loadWebAuthnUser(ctx, claims.UserID, claims.Email, claims.Name)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get webauthn credentials"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(user.credentials) == 0 {
+		http.Error(w, `{"error":"no webauthn credentials registered"}`, http.StatusBadRequest)
+		return
+	}
+
+	assertion, session, err := h.webAuthn.BeginLogin(user)
+	if err != nil {
+		http.Error(w, `{"error":"failed to start webauthn login"}`, http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(session)
+	if err := h.sessions.Save(ctx, "webauthn_login:"+claims.UserID.String(), data, webAuthnSessionTTL); err != nil {
+		http.Error(w, `{"error":"failed to save webauthn session"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assertion)
+}
+
+// FinishWebAuthnLogin completes an MFA login with a WebAuthn assertion
+func (h *MFAHandler) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if h.webAuthn == nil {
+		http.Error(w, `{"error":"webauthn is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req WebAuthnFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := h.loginUser(w, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var session webauthn.SessionData
+	data, err := h.sessions.Take(ctx, "webauthn_login:"+claims.UserID.String())
+	if err == nil {
+		err = json.Unmarshal(data, &session)
+	}
+	if err != nil {
+		http.Error(w, `{"error":"webauthn login expired, start again"}`, http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		http.Error(w, `{"error":"invalid webauthn credential"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.loadWebAuthnUser(ctx, claims.UserID, claims.Email, claims.Name)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get webauthn credentials"}`, http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.webAuthn.ValidateLogin(user, session, parsed)
+	if err != nil {
+		http.Error(w, `{"error":"webauthn verification failed"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Persist the new sign count so cloned authenticators can be detected
+	_, err = h.pool.Exec(ctx,
+		`UPDATE user_auth_methods SET credential = $3, last_used_at = NOW()
+		 WHERE user_id = $1 AND provider = 'webauthn' AND provider_user_id = $2`,
+		claims.UserID, base64.RawURLEncoding.EncodeToString(credential.ID), credential,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to update webauthn credential"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response, err := h.issueTokens(ctx, r, claims.UserID, claims.Email, claims.Name)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
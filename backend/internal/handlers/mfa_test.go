@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+)
+
+// registerMFATestUser registers a password user and returns its ID
+func registerMFATestUser(t *testing.T, email string) uuid.UUID {
+	t.Helper()
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM users WHERE email = $1", email)
+
+	regBody := `{"email":"` + email + `","password":"TestPassword123!","name":"Test MFA"}`
+	regReq := httptest.NewRequest("POST", "/api/auth/register", bytes.NewBufferString(regBody))
+	regW := httptest.NewRecorder()
+	testAuthHandler.Register(regW, regReq)
+	if regW.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d", regW.Code)
+	}
+
+	var userID uuid.UUID
+	if err := testPool.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, email).Scan(&userID); err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	return userID
+}
+
+func passwordLogin(t *testing.T, email string) *httptest.ResponseRecorder {
+	t.Helper()
+	loginBody := `{"email":"` + email + `","password":"TestPassword123!"}`
+	loginReq := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(loginBody))
+	loginW := httptest.NewRecorder()
+	testAuthHandler.Login(loginW, loginReq)
+	return loginW
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	email := "testmfatotp@example.com"
+	userID := registerMFATestUser(t, email)
+	defer testPool.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+
+	token, err := testJWTManager.GenerateAccessToken(userID, email, "Test MFA")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewMFAHandler(testPool, testJWTManager, nil, nil)
+
+	// Setup returns a secret but doesn't enable MFA until confirmed
+	req := httptest.NewRequest("POST", "/api/auth/mfa/totp/setup", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.SetupTOTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var setup TOTPSetupResponse
+	if err := json.NewDecoder(w.Body).Decode(&setup); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if setup.Secret == "" || setup.OTPAuthURL == "" {
+		t.Fatalf("Expected secret and otpauth URL, got %+v", setup)
+	}
+
+	if w := passwordLogin(t, email); w.Code != http.StatusOK || bytes.Contains(w.Body.Bytes(), []byte("mfa_required")) {
+		t.Fatalf("Expected a normal login before TOTP is confirmed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	confirm := func(code string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(TOTPConfirmRequest{Code: code})
+		req := httptest.NewRequest("POST", "/api/auth/mfa/totp/confirm", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ConfirmTOTP(w, req)
+		return w
+	}
+
+	if w := confirm("000000"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 for a wrong code, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Confirm with the previous step's code so the login below can use the current one
+	code, _ := auth.TOTPCode(setup.Secret, time.Now().Add(-30*time.Second))
+	w = confirm(code)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var enrolled MFAEnrollResponse
+	if err := json.NewDecoder(w.Body).Decode(&enrolled); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(enrolled.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", auth.RecoveryCodeCount, len(enrolled.RecoveryCodes))
+	}
+	if enrolled.AuthResponse != nil {
+		t.Error("Expected no tokens when enrolling with an access token")
+	}
+
+	// Password login now returns an MFA challenge
+	w = passwordLogin(t, email)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var challenge MFAChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("Expected an MFA challenge, got %+v", challenge)
+	}
+	if len(challenge.Methods) != 1 || challenge.Methods[0] != "totp" {
+		t.Errorf("Expected methods [totp], got %v", challenge.Methods)
+	}
+
+	// The MFA token is not an access token
+	if _, err := testJWTManager.VerifyAccessToken(challenge.MFAToken); err == nil {
+		t.Error("Expected the MFA token to be rejected as an access token")
+	}
+
+	verify := func(body MFAVerifyRequest) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/auth/mfa/verify", bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		handler.Verify(w, req)
+		return w
+	}
+
+	code, _ = auth.TOTPCode(setup.Secret, time.Now())
+	w = verify(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.AccessToken == "" {
+		t.Error("Expected access token in response")
+	}
+
+	// The same code can't be replayed
+	if w := verify(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: code}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a reused code, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Recovery codes work once
+	recovery := enrolled.RecoveryCodes[0]
+	if w := verify(MFAVerifyRequest{MFAToken: challenge.MFAToken, RecoveryCode: recovery}); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a recovery code, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := verify(MFAVerifyRequest{MFAToken: challenge.MFAToken, RecoveryCode: recovery}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a used recovery code, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A login MFA token can't enrol another factor
+	body, _ := json.Marshal(MFARequest{MFAToken: challenge.MFAToken})
+	req = httptest.NewRequest("POST", "/api/auth/mfa/webauthn/register/begin", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.BeginWebAuthnRegistration(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a login MFA token, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminMFAPolicyRequiresEnrollment(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	email := "testmfaadmin@example.com"
+	userID := registerMFATestUser(t, email)
+	defer testPool.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org MFA', 'test-org-mfa') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'admin')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_settings (organization_id, require_admin_mfa) VALUES ($1, true)`, orgID)
+	if err != nil {
+		t.Fatalf("Failed to require admin MFA: %v", err)
+	}
+
+	w := passwordLogin(t, email)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var challenge MFAChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !challenge.MFAEnrollmentRequired || challenge.MFAToken == "" {
+		t.Fatalf("Expected an MFA enrolment challenge, got %+v", challenge)
+	}
+
+	handler := NewMFAHandler(testPool, testJWTManager, nil, nil)
+
+	body, _ := json.Marshal(MFARequest{MFAToken: challenge.MFAToken})
+	req := httptest.NewRequest("POST", "/api/auth/mfa/totp/setup", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.SetupTOTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var setup TOTPSetupResponse
+	if err := json.NewDecoder(w.Body).Decode(&setup); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Enrolling with the MFA token completes the login
+	code, _ := auth.TOTPCode(setup.Secret, time.Now())
+	body, _ = json.Marshal(TOTPConfirmRequest{MFAToken: challenge.MFAToken, Code: code})
+	req = httptest.NewRequest("POST", "/api/auth/mfa/totp/confirm", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ConfirmTOTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var enrolled MFAEnrollResponse
+	if err := json.NewDecoder(w.Body).Decode(&enrolled); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if enrolled.AuthResponse == nil || enrolled.AccessToken == "" {
+		t.Error("Expected tokens when enrolling during login")
+	}
+	if len(enrolled.RecoveryCodes) == 0 {
+		t.Error("Expected recovery codes")
+	}
+
+	// The enrolment token can't be reused to add another factor
+	body, _ = json.Marshal(MFARequest{MFAToken: challenge.MFAToken})
+	req = httptest.NewRequest("POST", "/api/auth/mfa/totp/setup", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.SetupTOTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 after enrolment, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMFAEnrollmentRejectsRevokedToken(t *testing.T) {
+	jwtManager, err := auth.GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+	jwtManager.SetDenylist(auth.NewDenylist(nil))
+	handler := NewMFAHandler(nil, jwtManager, nil, nil)
+
+	userID := uuid.New()
+	token, err := jwtManager.GenerateAccessToken(userID, "testmfarevoked@example.com", "Test")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, _ := jwtManager.VerifyAccessToken(token)
+	if err := jwtManager.Denylist().RevokeToken(context.Background(), claims); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+
+	// Every enrolment route authenticates the signed-in user the same way
+	for name, route := range map[string]http.HandlerFunc{
+		"totp setup":             handler.SetupTOTP,
+		"totp confirm":           handler.ConfirmTOTP,
+		"webauthn register":      handler.BeginWebAuthnRegistration,
+		"webauthn register done": handler.FinishWebAuthnRegistration,
+	} {
+		req := httptest.NewRequest("POST", "/api/auth/mfa", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		route(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for %s with a revoked token, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRemovingSecondFactorRequiresStepUp(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	email := "testmfaremove@example.com"
+	userID := registerMFATestUser(t, email)
+	defer testPool.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+
+	token, err := testJWTManager.GenerateAccessToken(userID, email, "Test MFA")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// Enrol TOTP
+	handler := NewMFAHandler(testPool, testJWTManager, nil, nil)
+	req := httptest.NewRequest("POST", "/api/auth/mfa/totp/setup", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.SetupTOTP(w, req)
+	var setup TOTPSetupResponse
+	json.NewDecoder(w.Body).Decode(&setup)
+	code, _ := auth.TOTPCode(setup.Secret, time.Now().Add(-30*time.Second))
+	body, _ := json.Marshal(TOTPConfirmRequest{Code: code})
+	req = httptest.NewRequest("POST", "/api/auth/mfa/totp/confirm", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	handler.ConfirmTOTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to enrol TOTP: %d %s", w.Code, w.Body.String())
+	}
+	var enrolled MFAEnrollResponse
+	json.NewDecoder(w.Body).Decode(&enrolled)
+
+	var methodID uuid.UUID
+	if err := testPool.QueryRow(ctx,
+		`SELECT id FROM user_auth_methods WHERE user_id = $1 AND provider = 'totp'`, userID,
+	).Scan(&methodID); err != nil {
+		t.Fatalf("Failed to find TOTP method: %v", err)
+	}
+
+	authHandler := NewAuthHandler(testPool, testJWTManager, nil, nil, nil)
+	authHandler.limiter = nil
+	unlink := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/auth/me/methods/"+methodID.String(), bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.SetPathValue("id", methodID.String())
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, authHandler.UnlinkAuthMethod)(w, req)
+		return w
+	}
+
+	// An access token alone can't remove a factor
+	if w := unlink(""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without step-up, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := unlink(`{"password":"WrongPassword123!"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong password, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := unlink(`{"code":"000000"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong code, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Nor can the last factor of an admin whose org requires MFA
+	var orgID uuid.UUID
+	if err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org MFA Remove', 'test-org-mfa-remove') RETURNING id`,
+	).Scan(&orgID); err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	testPool.Exec(ctx, `INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'admin')`, userID, orgID)
+	testPool.Exec(ctx, `INSERT INTO sso_settings (organization_id, require_admin_mfa) VALUES ($1, true)`, orgID)
+	if w := unlink(`{"recovery_code":"` + enrolled.RecoveryCodes[0] + `"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for the last factor under an MFA policy, got %d: %s", w.Code, w.Body.String())
+	}
+	testPool.Exec(ctx, `DELETE FROM sso_settings WHERE organization_id = $1`, orgID)
+
+	if w := unlink(`{"recovery_code":"` + enrolled.RecoveryCodes[0] + `"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected a recovery code to allow removal, got %d: %s", w.Code, w.Body.String())
+	}
+	if methods, _ := userMFAMethods(ctx, testPool, userID); len(methods) != 0 {
+		t.Errorf("Expected the factor to be removed, got %v", methods)
+	}
+}
@@ -0,0 +1,671 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/notify"
+)
+
+type NotificationHandler struct {
+	pool       *pgxpool.Pool
+	dispatcher *notify.Dispatcher
+	audit      *audit.Logger
+}
+
+func NewNotificationHandler(pool *pgxpool.Pool, dispatcher *notify.Dispatcher, auditLog *audit.Logger) *NotificationHandler {
+	return &NotificationHandler{pool: pool, dispatcher: dispatcher, audit: auditLog}
+}
+
+// notificationRouteSnapshot is the audit log's view of a route
+const notificationRouteSnapshot = `SELECT to_jsonb(r) FROM notification_routes r WHERE id = $1`
+
+func (h *NotificationHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
+	var role string
+	err := h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
+		userID, orgID,
+	).Scan(&role)
+	return role, err
+}
+
+// checkAdmin writes the error response unless the user is an admin of the org
+func (h *NotificationHandler) checkAdmin(ctx context.Context, w http.ResponseWriter, userID, orgID uuid.UUID, action string) bool {
+	role, err := h.checkOrgMembership(ctx, userID, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return false
+	}
+	if role != "admin" {
+		http.Error(w, fmt.Sprintf(`{"error":"only admins can %s"}`, action), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// redactChannel blanks the channel's write-only settings before it is returned
+func redactChannel(ch *models.NotificationChannel) *models.NotificationChannel {
+	ch.Settings = ch.Settings.Redacted(ch.Type)
+	return ch
+}
+
+// channelSnapshot is the audit log's view of a channel. The audit log is
+// readable by org admins and can't be purged, so write-only settings are left
+// out like they are in API responses.
+func channelSnapshot(ch *models.NotificationChannel) json.RawMessage {
+	redacted := *ch
+	redacted.Settings = ch.Settings.Redacted(ch.Type)
+	snapshot, err := json.Marshal(&redacted)
+	if err != nil {
+		return nil
+	}
+	return snapshot
+}
+
+// loadChannel fetches the channel with the id in the path, writing the error
+// response when it doesn't exist
+func (h *NotificationHandler) loadChannel(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.NotificationChannel, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid channel id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	ch, err := notify.ScanChannel(h.pool.QueryRow(ctx,
+		`SELECT `+notify.ChannelColumns+` FROM notification_channels WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"channel not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch channel"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return ch, true
+}
+
+// CreateChannel creates a notification channel for an organization
+func (h *NotificationHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateNotificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, `{"error":"name is required"}`, http.StatusBadRequest)
+		return
+	}
+	if err := req.Settings.Validate(req.Type); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !h.checkAdmin(ctx, w, userID, orgID, "create notification channels") {
+		return
+	}
+
+	ch, err := notify.ScanChannel(h.pool.QueryRow(ctx,
+		`INSERT INTO notification_channels (organization_id, name, type, settings)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING `+notify.ChannelColumns,
+		orgID, req.Name, req.Type, req.Settings,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create channel"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "notification_channel.create", "notification_channel", ch.ID.String(),
+		nil, channelSnapshot(ch))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(redactChannel(ch))
+}
+
+// ListChannels lists the notification channels of an organization
+func (h *NotificationHandler) ListChannels(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+notify.ChannelColumns+` FROM notification_channels WHERE organization_id = $1 ORDER BY name ASC`, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch channels"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	channels := []models.NotificationChannel{}
+	for rows.Next() {
+		ch, err := notify.ScanChannel(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan channel"}`, http.StatusInternalServerError)
+			return
+		}
+		channels = append(channels, *redactChannel(ch))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// GetChannel returns a single notification channel
+func (h *NotificationHandler) GetChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	ch, ok := h.loadChannel(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.checkOrgMembership(ctx, userID, ch.OrganizationID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactChannel(ch))
+}
+
+// UpdateChannel updates a notification channel's name or settings
+func (h *NotificationHandler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateNotificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	ch, ok := h.loadChannel(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkAdmin(ctx, w, userID, ch.OrganizationID, "update notification channels") {
+		return
+	}
+	before := channelSnapshot(ch)
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			http.Error(w, `{"error":"name is required"}`, http.StatusBadRequest)
+			return
+		}
+		ch.Name = *req.Name
+	}
+	if req.Settings != nil {
+		req.Settings.KeepWriteOnly(ch.Type, ch.Settings)
+		ch.Settings = *req.Settings
+	}
+	if err := ch.Settings.Validate(ch.Type); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := notify.ScanChannel(h.pool.QueryRow(ctx,
+		`UPDATE notification_channels SET name = $2, settings = $3, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+notify.ChannelColumns,
+		ch.ID, ch.Name, ch.Settings,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to update channel"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &ch.OrganizationID, "notification_channel.update", "notification_channel", ch.ID.String(),
+		before, channelSnapshot(updated))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactChannel(updated))
+}
+
+// DeleteChannel deletes a notification channel with its routes and history
+func (h *NotificationHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	ch, ok := h.loadChannel(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkAdmin(ctx, w, userID, ch.OrganizationID, "delete notification channels") {
+		return
+	}
+
+	before := channelSnapshot(ch)
+	if _, err := h.pool.Exec(ctx, `DELETE FROM notification_channels WHERE id = $1`, ch.ID); err != nil {
+		http.Error(w, `{"error":"failed to delete channel"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &ch.OrganizationID, "notification_channel.delete", "notification_channel", ch.ID.String(), before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestChannel sends a sample notification to a channel and returns the
+// delivery, with 502 if it failed
+func (h *NotificationHandler) TestChannel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	ch, ok := h.loadChannel(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkAdmin(ctx, w, userID, ch.OrganizationID, "test notification channels") {
+		return
+	}
+
+	delivery, err := h.dispatcher.SendTest(ctx, ch)
+	if delivery == nil {
+		http.Error(w, `{"error":"failed to record delivery"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// routeFromRequest builds a route from a create request, filling in defaults
+func routeFromRequest(orgID uuid.UUID, req models.CreateNotificationRouteRequest) models.NotificationRoute {
+	route := models.NotificationRoute{
+		OrganizationID:        orgID,
+		ChannelID:             req.ChannelID,
+		Matchers:              req.Matchers,
+		GroupBy:               req.GroupBy,
+		GroupWaitSeconds:      models.DefaultGroupWaitSeconds,
+		GroupIntervalSeconds:  models.DefaultGroupIntervalSeconds,
+		RepeatIntervalSeconds: models.DefaultRepeatIntervalSeconds,
+	}
+	if route.Matchers == nil {
+		route.Matchers = models.LabelMatchers{}
+	}
+	if route.GroupBy == nil {
+		route.GroupBy = []string{}
+	}
+	if req.GroupWaitSeconds != nil {
+		route.GroupWaitSeconds = *req.GroupWaitSeconds
+	}
+	if req.GroupIntervalSeconds != nil {
+		route.GroupIntervalSeconds = *req.GroupIntervalSeconds
+	}
+	if req.RepeatIntervalSeconds != nil {
+		route.RepeatIntervalSeconds = *req.RepeatIntervalSeconds
+	}
+	return route
+}
+
+// checkRouteChannel reports whether the route's channel belongs to its org
+func (h *NotificationHandler) checkRouteChannel(ctx context.Context, route *models.NotificationRoute) bool {
+	var exists bool
+	err := h.pool.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM notification_channels WHERE id = $1 AND organization_id = $2)`,
+		route.ChannelID, route.OrganizationID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// CreateRoute creates a notification route for an organization
+func (h *NotificationHandler) CreateRoute(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateNotificationRouteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	route := routeFromRequest(orgID, req)
+	if err := route.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !h.checkAdmin(ctx, w, userID, orgID, "create notification routes") {
+		return
+	}
+	if !h.checkRouteChannel(ctx, &route) {
+		http.Error(w, `{"error":"channel not found"}`, http.StatusBadRequest)
+		return
+	}
+
+	created, err := notify.ScanRoute(h.pool.QueryRow(ctx,
+		`INSERT INTO notification_routes (organization_id, channel_id, matchers, group_by, group_wait_seconds,
+			group_interval_seconds, repeat_interval_seconds)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING `+notify.RouteColumns,
+		orgID, route.ChannelID, route.Matchers, route.GroupBy, route.GroupWaitSeconds,
+		route.GroupIntervalSeconds, route.RepeatIntervalSeconds,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create route"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "notification_route.create", "notification_route", created.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, notificationRouteSnapshot, created.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListRoutes lists the notification routes of an organization
+func (h *NotificationHandler) ListRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+notify.RouteColumns+` FROM notification_routes WHERE organization_id = $1 ORDER BY created_at ASC`, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch routes"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	routes := []models.NotificationRoute{}
+	for rows.Next() {
+		route, err := notify.ScanRoute(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan route"}`, http.StatusInternalServerError)
+			return
+		}
+		routes = append(routes, *route)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routes)
+}
+
+// loadRoute fetches the route with the id in the path, writing the error
+// response when it doesn't exist
+func (h *NotificationHandler) loadRoute(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.NotificationRoute, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid route id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	route, err := notify.ScanRoute(h.pool.QueryRow(ctx,
+		`SELECT `+notify.RouteColumns+` FROM notification_routes WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"route not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch route"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return route, true
+}
+
+// UpdateRoute updates a notification route
+func (h *NotificationHandler) UpdateRoute(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateNotificationRouteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	route, ok := h.loadRoute(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkAdmin(ctx, w, userID, route.OrganizationID, "update notification routes") {
+		return
+	}
+
+	if req.ChannelID != nil {
+		route.ChannelID = *req.ChannelID
+	}
+	if req.Matchers != nil {
+		route.Matchers = req.Matchers
+	}
+	if req.GroupBy != nil {
+		route.GroupBy = req.GroupBy
+	}
+	if req.GroupWaitSeconds != nil {
+		route.GroupWaitSeconds = *req.GroupWaitSeconds
+	}
+	if req.GroupIntervalSeconds != nil {
+		route.GroupIntervalSeconds = *req.GroupIntervalSeconds
+	}
+	if req.RepeatIntervalSeconds != nil {
+		route.RepeatIntervalSeconds = *req.RepeatIntervalSeconds
+	}
+	if err := route.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !h.checkRouteChannel(ctx, route) {
+		http.Error(w, `{"error":"channel not found"}`, http.StatusBadRequest)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, notificationRouteSnapshot, route.ID)
+	updated, err := notify.ScanRoute(h.pool.QueryRow(ctx,
+		`UPDATE notification_routes
+		 SET channel_id = $2, matchers = $3, group_by = $4, group_wait_seconds = $5,
+		     group_interval_seconds = $6, repeat_interval_seconds = $7, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+notify.RouteColumns,
+		route.ID, route.ChannelID, route.Matchers, route.GroupBy, route.GroupWaitSeconds,
+		route.GroupIntervalSeconds, route.RepeatIntervalSeconds,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to update route"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &route.OrganizationID, "notification_route.update", "notification_route", route.ID.String(),
+		before, auditSnapshot(ctx, h.pool, notificationRouteSnapshot, route.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteRoute deletes a notification route
+func (h *NotificationHandler) DeleteRoute(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	route, ok := h.loadRoute(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkAdmin(ctx, w, userID, route.OrganizationID, "delete notification routes") {
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, notificationRouteSnapshot, route.ID)
+	if _, err := h.pool.Exec(ctx, `DELETE FROM notification_routes WHERE id = $1`, route.ID); err != nil {
+		http.Error(w, `{"error":"failed to delete route"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &route.OrganizationID, "notification_route.delete", "notification_route", route.ID.String(), before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns an organization's most recent deliveries, optionally
+// filtered by channel_id and status
+func (h *NotificationHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	var channelID *uuid.UUID
+	if v := q.Get("channel_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, `{"error":"invalid channel_id"}`, http.StatusBadRequest)
+			return
+		}
+		channelID = &id
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, `{"error":"limit must be between 1 and 1000"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+notify.DeliveryColumns+` FROM notification_deliveries
+		 WHERE organization_id = $1 AND ($2::uuid IS NULL OR channel_id = $2) AND ($3 = '' OR status = $3)
+		 ORDER BY created_at DESC LIMIT $4`,
+		orgID, channelID, q.Get("status"), limit,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch deliveries"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.NotificationDelivery{}
+	for rows.Next() {
+		delivery, err := notify.ScanDelivery(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan delivery"}`, http.StatusInternalServerError)
+			return
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
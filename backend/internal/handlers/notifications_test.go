@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/notify"
+)
+
+func TestNotificationHandler_CreateChannel_Unauthorized(t *testing.T) {
+	handler := &NotificationHandler{pool: nil}
+
+	body := bytes.NewBufferString(`{"name":"ops","type":"webhook"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/orgs/test/notification-channels", body)
+	req.SetPathValue("orgId", "not-a-uuid")
+	rr := httptest.NewRecorder()
+
+	handler.CreateChannel(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	_, authHandler, cleanup := setupOrgTestWithRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-notify-org'")
+	defer testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-notify-org'")
+
+	received := make(chan notify.Notification, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var n notify.Notification
+		json.Unmarshal(body, &n)
+		received <- n
+	}))
+	defer hook.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	dispatcher := notify.NewDispatcher(testPool, nil)
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, nil)
+	handler := NewNotificationHandler(testPool, dispatcher, audit.NewLogger(testPool, nil))
+
+	admin := createTestUser(t, authHandler, "testnotifyadmin@example.com")
+
+	call := func(h http.HandlerFunc, method, path, body string, pathValues map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+admin.AccessToken)
+		for k, v := range pathValues {
+			req.SetPathValue(k, v)
+		}
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, h)(w, req)
+		return w
+	}
+
+	w := call(orgHandler.Create, "POST", "/api/orgs", `{"name":"Notify Org","slug":"test-notify-org"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create org: %d %s", w.Code, w.Body.String())
+	}
+	var org models.Organization
+	json.NewDecoder(w.Body).Decode(&org)
+	orgID := org.ID.String()
+	orgPath := map[string]string{"orgId": orgID}
+
+	createChannel := func(name, url string) models.NotificationChannel {
+		w := call(handler.CreateChannel, "POST", "/api/orgs/"+orgID+"/notification-channels",
+			`{"name":"`+name+`","type":"webhook","settings":{"url":"`+url+`","secret":"s3cret"}}`, orgPath)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create channel: %d %s", w.Code, w.Body.String())
+		}
+		var ch models.NotificationChannel
+		json.NewDecoder(w.Body).Decode(&ch)
+		return ch
+	}
+	ok := createChannel("ops", hook.URL)
+	bad := createChannel("broken", broken.URL)
+	if ok.Settings.Secret != "" {
+		t.Error("Expected the webhook secret to be write-only")
+	}
+
+	// Settings sent back as read keep the stored secret
+	w = call(handler.UpdateChannel, "PUT", "/api/notification-channels/"+ok.ID.String(),
+		`{"settings":{"url":"`+hook.URL+`"}}`, map[string]string{"id": ok.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to update channel: %d %s", w.Code, w.Body.String())
+	}
+	var secret string
+	testPool.QueryRow(ctx, `SELECT settings->>'secret' FROM notification_channels WHERE id = $1`, ok.ID).Scan(&secret)
+	if secret != "s3cret" {
+		t.Errorf("Expected the stored secret to be kept, got %q", secret)
+	}
+
+	// Nor does the secret reach the audit log
+	var leaked int
+	testPool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM audit_log
+		 WHERE target_type = 'notification_channel' AND organization_id = $1
+		   AND (before::text LIKE '%s3cret%' OR after::text LIKE '%s3cret%')`,
+		org.ID,
+	).Scan(&leaked)
+	if leaked > 0 {
+		t.Errorf("Expected the webhook secret to stay out of the audit log, found it in %d entries", leaked)
+	}
+
+	w = call(handler.CreateChannel, "POST", "/api/orgs/"+orgID+"/notification-channels",
+		`{"name":"x","type":"webhook","settings":{}}`, orgPath)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a webhook without a URL to be rejected, got %d", w.Code)
+	}
+
+	// Test notifications are delivered at once
+	w = call(handler.TestChannel, "POST", "/api/notification-channels/"+ok.ID.String()+"/test", "", map[string]string{"id": ok.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Test notification failed: %d %s", w.Code, w.Body.String())
+	}
+	if n := <-received; n.GroupKey != "test" || n.CommonLabels["alertname"] != "TestAlert" {
+		t.Errorf("Unexpected test notification %+v", n)
+	}
+	w = call(handler.TestChannel, "POST", "/api/notification-channels/"+bad.ID.String()+"/test", "", map[string]string{"id": bad.ID.String()})
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected a failed test to return 502, got %d", w.Code)
+	}
+
+	for _, ch := range []models.NotificationChannel{ok, bad} {
+		w = call(handler.CreateRoute, "POST", "/api/orgs/"+orgID+"/notification-routes",
+			`{"channel_id":"`+ch.ID.String()+`","matchers":[{"name":"severity","operator":"=","value":"page"}],
+			  "group_by":["alertname"],"group_wait_seconds":0}`, orgPath)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create route: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	// A firing alert for the routes to pick up
+	var dsID, ruleID string
+	if err := testPool.QueryRow(ctx,
+		`INSERT INTO datasources (organization_id, name, type, url) VALUES ($1, 'vm', 'victoriametrics', 'http://vm') RETURNING id`,
+		org.ID).Scan(&dsID); err != nil {
+		t.Fatalf("Failed to create datasource: %v", err)
+	}
+	if err := testPool.QueryRow(ctx,
+		`INSERT INTO alert_rules (organization_id, datasource_id, name, query, condition, interval_seconds,
+			lookback_seconds, enabled, next_evaluation_at)
+		 VALUES ($1, $2, 'HighCPU', 'cpu', '{"reducer":"last","operator":">","threshold":90}', 60, 300, false, $3)
+		 RETURNING id`,
+		org.ID, dsID, time.Now().UTC()).Scan(&ruleID); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	now := time.Now().UTC()
+	if _, err := testPool.Exec(ctx,
+		`INSERT INTO alert_instances (rule_id, organization_id, fingerprint, labels, annotations, state, value,
+			active_at, fired_at, last_evaluated_at)
+		 VALUES ($1, $2, 'fp1', '{"alertname":"HighCPU","severity":"page"}', '{}', 'firing', 97, $3, $3, $3)`,
+		ruleID, org.ID, now); err != nil {
+		t.Fatalf("Failed to create alert: %v", err)
+	}
+
+	if err := dispatcher.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	select {
+	case n := <-received:
+		if n.Status != "firing" || len(n.Alerts) != 1 || n.GroupLabels["alertname"] != "HighCPU" {
+			t.Errorf("Unexpected notification %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notification")
+	}
+
+	// Nothing new to say on the next run
+	if err := dispatcher.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	select {
+	case n := <-received:
+		t.Errorf("Expected no repeat notification, got %+v", n)
+	default:
+	}
+
+	list := func(query string) []models.NotificationDelivery {
+		w := call(handler.ListDeliveries, "GET", "/api/orgs/"+orgID+"/notification-deliveries"+query, "", orgPath)
+		var deliveries []models.NotificationDelivery
+		json.NewDecoder(w.Body).Decode(&deliveries)
+		return deliveries
+	}
+	failed := list("?channel_id=" + bad.ID.String() + "&status=pending")
+	if len(failed) != 1 || failed[0].Attempts != 1 || failed[0].LastError == "" || failed[0].NextAttemptAt == nil {
+		t.Fatalf("Expected the broken channel's delivery to await a retry, got %+v", failed)
+	}
+	delivered := list("?channel_id=" + ok.ID.String() + "&status=delivered")
+	if len(delivered) != 2 {
+		t.Errorf("Expected the test and alert deliveries, got %d", len(delivered))
+	}
+}
+
+func TestChannelSnapshotLeavesOutWriteOnlySettings(t *testing.T) {
+	webhook := &models.NotificationChannel{
+		Name: "ops",
+		Type: models.ChannelWebhook,
+		Settings: models.NotificationChannelSettings{
+			URL:     "https://hooks.example.com/alerts",
+			Secret:  "webhook-signing-key",
+			Headers: map[string]string{"X-Api-Key": "header-value"},
+		},
+	}
+	slack := &models.NotificationChannel{
+		Name:     "chat",
+		Type:     models.ChannelSlack,
+		Settings: models.NotificationChannelSettings{URL: "https://hooks.slack.com/services/T0/B0/slack-token"},
+	}
+
+	for _, ch := range []*models.NotificationChannel{webhook, slack} {
+		_, after, err := audit.Diff(nil, channelSnapshot(ch))
+		if err != nil {
+			t.Fatalf("Failed to diff snapshot: %v", err)
+		}
+		for _, secret := range []string{"webhook-signing-key", "header-value", "slack-token"} {
+			if bytes.Contains(after, []byte(secret)) {
+				t.Errorf("Expected %q to stay out of the %s channel's audit entry, got %s", secret, ch.Type, after)
+			}
+		}
+	}
+
+	// The webhook URL isn't a secret and is kept, as is the channel itself
+	if snapshot := channelSnapshot(webhook); !bytes.Contains(snapshot, []byte("hooks.example.com")) {
+		t.Errorf("Expected the webhook URL in the snapshot, got %s", snapshot)
+	}
+	if webhook.Settings.Secret != "webhook-signing-key" {
+		t.Error("Expected the snapshot to leave the channel unchanged")
+	}
+}
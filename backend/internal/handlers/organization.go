@@ -2,49 +2,76 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
 	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/mailer"
 	"github.com/janhoon/dash/backend/internal/models"
-	"github.com/redis/go-redis/v9"
 )
 
+// invitationTTL is how long an invitation link stays valid
+const invitationTTL = 7 * 24 * time.Hour
+
 type OrganizationHandler struct {
-	pool *pgxpool.Pool
-	rdb  *redis.Client
+	pool        *pgxpool.Pool
+	mailer      mailer.Mailer
+	frontendURL string
+	denylist    *auth.Denylist // revokes org-scoped tokens when membership changes
+	audit       *audit.Logger
 }
 
-func NewOrganizationHandler(pool *pgxpool.Pool, rdb *redis.Client) *OrganizationHandler {
-	return &OrganizationHandler{pool: pool, rdb: rdb}
+func NewOrganizationHandler(pool *pgxpool.Pool, m mailer.Mailer, denylist *auth.Denylist, auditLog *audit.Logger) *OrganizationHandler {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:5173"
+	}
+	return &OrganizationHandler{pool: pool, mailer: m, frontendURL: frontendURL, denylist: denylist, audit: auditLog}
 }
 
+// Snapshots of rows for the audit log
+const (
+	organizationSnapshot = `SELECT to_jsonb(o) FROM organizations o WHERE id = $1`
+	invitationSnapshot   = `SELECT to_jsonb(i) - 'token_hash' FROM organization_invitations i WHERE id = $1`
+)
+
 // InvitationResponse represents the invitation response
 type InvitationResponse struct {
+	ID        uuid.UUID `json:"id"`
 	Token     string    `json:"token"`
 	Email     string    `json:"email"`
 	Role      string    `json:"role"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// PendingInvitation is an invitation listed for org admins; the token is not shown
+type PendingInvitation struct {
+	ID        uuid.UUID  `json:"id"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	InvitedBy *uuid.UUID `json:"invited_by,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 // CreateInvitationRequest represents invitation request body
 type CreateInvitationRequest struct {
 	Email string             `json:"email"`
 	Role  models.MembershipRole `json:"role"`
 }
 
-// InvitationData stored in Valkey
-type InvitationData struct {
-	OrganizationID uuid.UUID `json:"organization_id"`
-	Email          string    `json:"email"`
-	Role           string    `json:"role"`
-}
-
 // MemberResponse represents a member in the organization
 type MemberResponse struct {
 	ID        uuid.UUID `json:"id"`
@@ -135,6 +162,8 @@ func (h *OrganizationHandler) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"failed to commit transaction"}`, http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, h.audit, &org.ID, "organization.create", "organization", org.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, organizationSnapshot, org.ID))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -275,6 +304,8 @@ func (h *OrganizationHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := auditSnapshot(ctx, h.pool, organizationSnapshot, orgID)
+
 	// Build dynamic update query
 	var org models.Organization
 	if req.Name != nil && req.Slug != nil {
@@ -314,6 +345,10 @@ func (h *OrganizationHandler) Update(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"failed to update organization"}`, http.StatusInternalServerError)
 		return
 	}
+	if req.Name != nil || req.Slug != nil {
+		recordAudit(r, h.audit, &orgID, "organization.update", "organization", orgID.String(),
+			before, auditSnapshot(ctx, h.pool, organizationSnapshot, orgID))
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(org)
@@ -342,6 +377,8 @@ func (h *OrganizationHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := auditSnapshot(ctx, h.pool, organizationSnapshot, orgID)
+
 	// Delete organization (cascades to memberships, dashboards, etc.)
 	result, err := h.pool.Exec(ctx,
 		`DELETE FROM organizations WHERE id = $1`,
@@ -356,6 +393,7 @@ func (h *OrganizationHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"organization not found"}`, http.StatusNotFound)
 		return
 	}
+	recordAudit(r, h.audit, &orgID, "organization.delete", "organization", orgID.String(), before, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -364,11 +402,6 @@ func (h *OrganizationHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 // CreateInvitation creates an invitation to join the organization (admin only)
 func (h *OrganizationHandler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
-	if h.rdb == nil {
-		http.Error(w, `{"error":"invitations not enabled (Valkey not available)"}`, http.StatusNotImplemented)
-		return
-	}
-
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
@@ -396,6 +429,7 @@ func (h *OrganizationHandler) CreateInvitation(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	req.Email = strings.TrimSpace(req.Email)
 	if req.Email == "" {
 		http.Error(w, `{"error":"email is required"}`, http.StatusBadRequest)
 		return
@@ -415,7 +449,7 @@ func (h *OrganizationHandler) CreateInvitation(w http.ResponseWriter, r *http.Re
 	err = h.pool.QueryRow(ctx,
 		`SELECT om.id FROM organization_memberships om
 		 JOIN users u ON u.id = om.user_id
-		 WHERE om.organization_id = $1 AND u.email = $2`,
+		 WHERE om.organization_id = $1 AND LOWER(u.email) = LOWER($2)`,
 		orgID, req.Email,
 	).Scan(&existingMembership)
 	if err == nil {
@@ -427,33 +461,62 @@ func (h *OrganizationHandler) CreateInvitation(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Generate invitation token
 	token, err := auth.GenerateRefreshToken() // Reuse the secure token generator
 	if err != nil {
 		http.Error(w, `{"error":"failed to generate invitation token"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Store invitation in Valkey with 7-day TTL
-	invitationData := InvitationData{
-		OrganizationID: orgID,
-		Email:          req.Email,
-		Role:           string(req.Role),
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		http.Error(w, `{"error":"failed to store invitation"}`, http.StatusInternalServerError)
+		return
 	}
-	dataJSON, _ := json.Marshal(invitationData)
+	defer tx.Rollback(ctx)
 
-	key := "invitation:" + token
-	ttl := 7 * 24 * time.Hour
-	if err := h.rdb.Set(ctx, key, dataJSON, ttl).Err(); err != nil {
+	// A new invitation supersedes any pending one for the same address
+	_, err = tx.Exec(ctx,
+		`UPDATE organization_invitations SET revoked_at = NOW()
+		 WHERE organization_id = $1 AND LOWER(email) = LOWER($2)
+		 AND accepted_at IS NULL AND revoked_at IS NULL`,
+		orgID, req.Email,
+	)
+	if err != nil {
 		http.Error(w, `{"error":"failed to store invitation"}`, http.StatusInternalServerError)
 		return
 	}
 
-	response := InvitationResponse{
-		Token:     token,
+	invitation := PendingInvitation{
 		Email:     req.Email,
 		Role:      string(req.Role),
-		ExpiresAt: time.Now().Add(ttl),
+		InvitedBy: &userID,
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO organization_invitations (organization_id, email, role, token_hash, invited_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, expires_at, created_at`,
+		orgID, req.Email, req.Role, hashInvitationToken(token), userID, time.Now().Add(invitationTTL),
+	).Scan(&invitation.ID, &invitation.ExpiresAt, &invitation.CreatedAt)
+	if err != nil {
+		http.Error(w, `{"error":"failed to store invitation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, `{"error":"failed to store invitation"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "invitation.create", "invitation", invitation.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, invitationSnapshot, invitation.ID))
+
+	h.sendInvitationEmail(ctx, r, orgID, &invitation, token)
+
+	response := InvitationResponse{
+		ID:        invitation.ID,
+		Token:     token,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		ExpiresAt: invitation.ExpiresAt,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -461,49 +524,259 @@ func (h *OrganizationHandler) CreateInvitation(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(response)
 }
 
-// AcceptInvitation accepts an invitation and creates membership
-func (h *OrganizationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
-	if h.rdb == nil {
-		http.Error(w, `{"error":"invitations not enabled (Valkey not available)"}`, http.StatusNotImplemented)
+// hashInvitationToken hashes an invitation token for storage. Tokens are random,
+// so a fast hash is sufficient.
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// orgName returns the organization's display name for emails
+func (h *OrganizationHandler) orgName(ctx context.Context, orgID uuid.UUID) string {
+	var name string
+	if err := h.pool.QueryRow(ctx, `SELECT name FROM organizations WHERE id = $1`, orgID).Scan(&name); err != nil {
+		return "your organization"
+	}
+	return name
+}
+
+// actorName names the signed-in user in emails sent on their behalf
+func actorName(r *http.Request) string {
+	if name, ok := auth.GetUserName(r.Context()); ok && name != "" {
+		return name
+	}
+	if email, ok := auth.GetUserEmail(r.Context()); ok && email != "" {
+		return email
+	}
+	return "An administrator"
+}
+
+// sendInvitationEmail emails the invitation link to the invitee
+func (h *OrganizationHandler) sendInvitationEmail(ctx context.Context, r *http.Request, orgID uuid.UUID, invitation *PendingInvitation, token string) {
+	deliverEmail(h.mailer, invitation.Email, mailer.TemplateInvitation, mailer.InvitationData{
+		OrgName:     h.orgName(ctx, orgID),
+		InviterName: actorName(r),
+		Role:        invitation.Role,
+		Link:        h.frontendURL + "/invitations/accept?token=" + url.QueryEscape(token),
+		ExpiresAt:   invitation.ExpiresAt,
+	})
+}
+
+// ListInvitations lists the organization's pending invitations (admin only)
+func (h *OrganizationHandler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check admin role
+	if !h.isOrgAdmin(ctx, orgID, userID) {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT id, email, role, invited_by, expires_at, created_at
+		 FROM organization_invitations
+		 WHERE organization_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		 ORDER BY created_at DESC`,
+		orgID,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to list invitations"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invitations := []PendingInvitation{}
+	for rows.Next() {
+		var invitation PendingInvitation
+		if err := rows.Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.CreatedAt); err != nil {
+			http.Error(w, `{"error":"failed to scan invitation"}`, http.StatusInternalServerError)
+			return
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// ResendInvitation issues a fresh link for a pending invitation, invalidating the
+// old one, and emails it again (admin only)
+func (h *OrganizationHandler) ResendInvitation(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	token := r.PathValue("token")
-	if token == "" {
-		http.Error(w, `{"error":"invitation token required"}`, http.StatusBadRequest)
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	invitationID, err := uuid.Parse(r.PathValue("invitationId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid invitation id"}`, http.StatusBadRequest)
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	// Get invitation from Valkey
-	key := "invitation:" + token
-	dataJSON, err := h.rdb.Get(ctx, key).Bytes()
-	if err == redis.Nil {
+	// Check admin role
+	if !h.isOrgAdmin(ctx, orgID, userID) {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return
+	}
+
+	token, err := auth.GenerateRefreshToken()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate invitation token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Replacing the hash invalidates the old link; resending also restarts the expiry
+	before := auditSnapshot(ctx, h.pool, invitationSnapshot, invitationID)
+	var invitation PendingInvitation
+	err = h.pool.QueryRow(ctx,
+		`UPDATE organization_invitations SET token_hash = $3, expires_at = $4
+		 WHERE id = $1 AND organization_id = $2
+		 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		 RETURNING id, email, role, invited_by, expires_at, created_at`,
+		invitationID, orgID, hashInvitationToken(token), time.Now().Add(invitationTTL),
+	).Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.CreatedAt)
+	if err == pgx.ErrNoRows {
 		http.Error(w, `{"error":"invitation not found or expired"}`, http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to update invitation"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "invitation.resend", "invitation", invitation.ID.String(),
+		before, auditSnapshot(ctx, h.pool, invitationSnapshot, invitation.ID))
+
+	h.sendInvitationEmail(ctx, r, orgID, &invitation, token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InvitationResponse{
+		ID:        invitation.ID,
+		Token:     token,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		ExpiresAt: invitation.ExpiresAt,
+	})
+}
+
+// RevokeInvitation cancels a pending invitation (admin only)
+func (h *OrganizationHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	invitationID, err := uuid.Parse(r.PathValue("invitationId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid invitation id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check admin role
+	if !h.isOrgAdmin(ctx, orgID, userID) {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, invitationSnapshot, invitationID)
+	result, err := h.pool.Exec(ctx,
+		`UPDATE organization_invitations SET revoked_at = NOW()
+		 WHERE id = $1 AND organization_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL`,
+		invitationID, orgID,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed to revoke invitation"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		http.Error(w, `{"error":"invitation not found"}`, http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "invitation.revoke", "invitation", invitationID.String(),
+		before, auditSnapshot(ctx, h.pool, invitationSnapshot, invitationID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "invitation revoked"})
+}
+
+// AcceptInvitation accepts an invitation and creates membership. Only the
+// invited email address can accept it.
+func (h *OrganizationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	token := r.PathValue("token")
+	if token == "" {
+		http.Error(w, `{"error":"invitation token required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
 	if err != nil {
 		http.Error(w, `{"error":"failed to get invitation"}`, http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback(ctx)
 
-	var invitationData InvitationData
-	if err := json.Unmarshal(dataJSON, &invitationData); err != nil {
-		http.Error(w, `{"error":"invalid invitation data"}`, http.StatusInternalServerError)
+	// Lock the invitation so it can only be accepted once
+	var invitationID, orgID uuid.UUID
+	var invitedEmail, role string
+	err = tx.QueryRow(ctx,
+		`SELECT id, organization_id, email, role FROM organization_invitations
+		 WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		 FOR UPDATE`,
+		hashInvitationToken(token),
+	).Scan(&invitationID, &orgID, &invitedEmail, &role)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"invitation not found or expired"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get invitation"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Verify email matches the invitation
 	var userEmail string
-	err = h.pool.QueryRow(ctx,
+	err = tx.QueryRow(ctx,
 		`SELECT email FROM users WHERE id = $1`,
 		userID,
 	).Scan(&userEmail)
@@ -512,27 +785,60 @@ func (h *OrganizationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if userEmail != invitationData.Email {
+	if !strings.EqualFold(userEmail, invitedEmail) {
 		http.Error(w, `{"error":"invitation is for a different email address"}`, http.StatusForbidden)
 		return
 	}
 
+	// An existing member accepting an invitation has their role replaced
+	var previousRole string
+	err = tx.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE organization_id = $1 AND user_id = $2 FOR UPDATE`,
+		orgID, userID,
+	).Scan(&previousRole)
+	if err != nil && err != pgx.ErrNoRows {
+		http.Error(w, `{"error":"failed to check membership"}`, http.StatusInternalServerError)
+		return
+	}
+
 	// Create membership
 	var membership models.OrganizationMembership
-	err = h.pool.QueryRow(ctx,
+	err = tx.QueryRow(ctx,
 		`INSERT INTO organization_memberships (organization_id, user_id, role)
 		 VALUES ($1, $2, $3)
 		 ON CONFLICT (organization_id, user_id) DO UPDATE SET role = $3, updated_at = NOW()
 		 RETURNING id, organization_id, user_id, role, created_at, updated_at`,
-		invitationData.OrganizationID, userID, invitationData.Role,
+		orgID, userID, role,
 	).Scan(&membership.ID, &membership.OrganizationID, &membership.UserID, &membership.Role, &membership.CreatedAt, &membership.UpdatedAt)
 	if err != nil {
 		http.Error(w, `{"error":"failed to create membership"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Delete the used invitation
-	h.rdb.Del(ctx, key)
+	if _, err := tx.Exec(ctx, `UPDATE organization_invitations SET accepted_at = NOW() WHERE id = $1`, invitationID); err != nil {
+		http.Error(w, `{"error":"failed to accept invitation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, `{"error":"failed to accept invitation"}`, http.StatusInternalServerError)
+		return
+	}
+	if previousRole != "" && previousRole != role {
+		if err := h.denylist.RevokeMembership(ctx, orgID, userID); err != nil {
+			log.Printf("Failed to revoke org tokens after role change: %v", err)
+		}
+	}
+	recordAudit(r, h.audit, &orgID, "member.add", "member", userID.String(),
+		nil, map[string]string{"email": userEmail, "role": role, "invitation_id": invitationID.String()})
+
+	name, _ := auth.GetUserName(r.Context())
+	deliverEmail(h.mailer, userEmail, mailer.TemplateWelcome, mailer.WelcomeData{
+		Name:    name,
+		OrgName: h.orgName(ctx, orgID),
+		Role:    role,
+		Link:    h.frontendURL,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -640,6 +946,23 @@ func (h *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	var targetRole, targetEmail string
+	var targetName *string
+	err = h.pool.QueryRow(ctx,
+		`SELECT om.role, u.email, u.name FROM organization_memberships om
+		 JOIN users u ON u.id = om.user_id
+		 WHERE om.organization_id = $1 AND om.user_id = $2`,
+		orgID, memberUserID,
+	).Scan(&targetRole, &targetEmail, &targetName)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"member not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get member role"}`, http.StatusInternalServerError)
+		return
+	}
+
 	// Prevent removing last admin
 	if req.Role != models.RoleAdmin {
 		var adminCount int
@@ -653,20 +976,6 @@ func (h *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Re
 		}
 
 		// Check if target user is the only admin
-		var targetRole string
-		err = h.pool.QueryRow(ctx,
-			`SELECT role FROM organization_memberships WHERE organization_id = $1 AND user_id = $2`,
-			orgID, memberUserID,
-		).Scan(&targetRole)
-		if err == pgx.ErrNoRows {
-			http.Error(w, `{"error":"member not found"}`, http.StatusNotFound)
-			return
-		}
-		if err != nil {
-			http.Error(w, `{"error":"failed to get member role"}`, http.StatusInternalServerError)
-			return
-		}
-
 		if targetRole == "admin" && adminCount <= 1 {
 			http.Error(w, `{"error":"cannot demote the last admin"}`, http.StatusBadRequest)
 			return
@@ -689,6 +998,28 @@ func (h *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if targetRole != string(req.Role) {
+		if err := h.denylist.RevokeMembership(ctx, orgID, memberUserID); err != nil {
+			log.Printf("Failed to revoke org tokens after role change: %v", err)
+		}
+		recordAudit(r, h.audit, &orgID, "member.role_update", "member", memberUserID.String(),
+			map[string]string{"email": targetEmail, "role": targetRole},
+			map[string]string{"email": targetEmail, "role": string(req.Role)})
+
+		name := ""
+		if targetName != nil {
+			name = *targetName
+		}
+		deliverEmail(h.mailer, targetEmail, mailer.TemplateRoleChanged, mailer.RoleChangedData{
+			Name:      name,
+			OrgName:   h.orgName(ctx, orgID),
+			OldRole:   targetRole,
+			NewRole:   string(req.Role),
+			ChangedBy: actorName(r),
+			Link:      h.frontendURL,
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "role updated"})
@@ -770,6 +1101,12 @@ func (h *OrganizationHandler) RemoveMember(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if err := h.denylist.RevokeMembership(ctx, orgID, memberUserID); err != nil {
+		log.Printf("Failed to revoke org tokens after member removal: %v", err)
+	}
+	recordAudit(r, h.audit, &orgID, "member.remove", "member", memberUserID.String(),
+		map[string]string{"role": targetRole}, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "member removed"})
@@ -777,6 +1114,10 @@ func (h *OrganizationHandler) RemoveMember(w http.ResponseWriter, r *http.Reques
 
 // isOrgAdmin checks if a user is an admin of the organization
 func (h *OrganizationHandler) isOrgAdmin(ctx context.Context, orgID, userID uuid.UUID) bool {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role == "admin"
+	}
+
 	var role string
 	err := h.pool.QueryRow(ctx,
 		`SELECT role FROM organization_memberships WHERE organization_id = $1 AND user_id = $2`,
@@ -785,6 +1126,15 @@ func (h *OrganizationHandler) isOrgAdmin(ctx context.Context, orgID, userID uuid
 	return err == nil && role == "admin"
 }
 
+// tokenOrgRole returns the role carried by the request's org-scoped token, if
+// it was issued to userID for orgID
+func tokenOrgRole(ctx context.Context, userID, orgID uuid.UUID) (string, bool) {
+	if tokenUser, ok := auth.GetUserID(ctx); !ok || tokenUser != userID {
+		return "", false
+	}
+	return auth.GetOrgRole(ctx, orgID)
+}
+
 // isDuplicateKeyError checks if the error is a duplicate key violation
 func isDuplicateKeyError(err error) bool {
 	if err == nil {
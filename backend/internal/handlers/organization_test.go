@@ -10,7 +10,9 @@ import (
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/audit"
 	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/mailer"
 	"github.com/janhoon/dash/backend/internal/models"
 	"github.com/redis/go-redis/v9"
 )
@@ -29,8 +31,8 @@ func setupOrgTestWithRedis(t *testing.T) (*OrganizationHandler, *AuthHandler, fu
 		Addr: mr.Addr(),
 	})
 
-	orgHandler := NewOrganizationHandler(testPool, rdb)
-	authHandler := NewAuthHandler(testPool, testJWTManager, rdb)
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, nil)
+	authHandler := NewAuthHandler(testPool, testJWTManager, rdb, auth.NewRefreshTokenManager(auth.NewValkeyTokenStore(rdb)), nil)
 
 	cleanup := func() {
 		rdb.Close()
@@ -879,3 +881,147 @@ func TestInviteAlreadyMemberFails(t *testing.T) {
 
 // Ensure uuid is used
 var _ = uuid.New
+
+func TestManageInvitations(t *testing.T) {
+	orgHandler, authHandler, cleanup := setupOrgTestWithRedis(t)
+	defer cleanup()
+
+	m := mailer.NewCaptureMailer()
+	orgHandler.mailer = m
+	orgHandler.audit = audit.NewLogger(testPool, nil)
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'manage-invites-org'")
+
+	userResp := createTestUser(t, authHandler, "testmanageinvites@example.com")
+
+	createReq := httptest.NewRequest("POST", "/api/orgs", bytes.NewBufferString(`{"name":"Manage Invites Org","slug":"manage-invites-org"}`))
+	createReq.Header.Set("Authorization", "Bearer "+userResp.AccessToken)
+	createW := httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, orgHandler.Create)(createW, createReq)
+
+	var createdOrg models.Organization
+	json.NewDecoder(createW.Body).Decode(&createdOrg)
+	orgID := createdOrg.ID.String()
+
+	do := func(handler http.HandlerFunc, method, path, body, invitationID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+userResp.AccessToken)
+		req.SetPathValue("id", orgID)
+		if invitationID != "" {
+			req.SetPathValue("invitationId", invitationID)
+		}
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, handler)(w, req)
+		return w
+	}
+
+	inviteW := do(orgHandler.CreateInvitation, "POST", "/api/orgs/"+orgID+"/invitations", `{"email":"managed@example.com","role":"viewer"}`, "")
+	if inviteW.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", inviteW.Code, inviteW.Body.String())
+	}
+	var invitation InvitationResponse
+	json.NewDecoder(inviteW.Body).Decode(&invitation)
+
+	if token := tokenFromEmail(t, m); token != invitation.Token {
+		t.Errorf("Expected the emailed token to match the invitation")
+	}
+
+	listW := do(orgHandler.ListInvitations, "GET", "/api/orgs/"+orgID+"/invitations", "", "")
+	var pending []PendingInvitation
+	json.NewDecoder(listW.Body).Decode(&pending)
+	if len(pending) != 1 || pending[0].ID != invitation.ID {
+		t.Fatalf("Expected the pending invitation to be listed, got %+v", pending)
+	}
+
+	resendW := do(orgHandler.ResendInvitation, "POST", "/api/orgs/"+orgID+"/invitations/"+invitation.ID.String()+"/resend", "", invitation.ID.String())
+	if resendW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resendW.Code, resendW.Body.String())
+	}
+	var resent InvitationResponse
+	json.NewDecoder(resendW.Body).Decode(&resent)
+	if resent.Token == invitation.Token {
+		t.Error("Expected resending to issue a new token")
+	}
+	if token := tokenFromEmail(t, m); token != resent.Token {
+		t.Errorf("Expected the resent email to carry the new token")
+	}
+	var resends int
+	testPool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM audit_log WHERE action = 'invitation.resend' AND target_id = $1`, invitation.ID.String(),
+	).Scan(&resends)
+	if resends != 1 {
+		t.Errorf("Expected the resend to be audited, found %d entries", resends)
+	}
+
+	revokeW := do(orgHandler.RevokeInvitation, "DELETE", "/api/orgs/"+orgID+"/invitations/"+invitation.ID.String(), "", invitation.ID.String())
+	if revokeW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", revokeW.Code, revokeW.Body.String())
+	}
+
+	listW = do(orgHandler.ListInvitations, "GET", "/api/orgs/"+orgID+"/invitations", "", "")
+	pending = nil
+	json.NewDecoder(listW.Body).Decode(&pending)
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending invitations after revoking, got %d", len(pending))
+	}
+}
+
+func TestInvitationsWithoutValkey(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, nil)
+	authHandler := NewAuthHandler(testPool, testJWTManager, nil, nil, nil)
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'no-valkey-invites-org'")
+
+	adminResp := createTestUser(t, authHandler, "testnovalkeyadmin@example.com")
+	inviteeResp := createTestUser(t, authHandler, "testnovalkeyinvitee@example.com")
+
+	createReq := httptest.NewRequest("POST", "/api/orgs", bytes.NewBufferString(`{"name":"No Valkey Invites Org","slug":"no-valkey-invites-org"}`))
+	createReq.Header.Set("Authorization", "Bearer "+adminResp.AccessToken)
+	createW := httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, orgHandler.Create)(createW, createReq)
+
+	var createdOrg models.Organization
+	json.NewDecoder(createW.Body).Decode(&createdOrg)
+
+	inviteReq := httptest.NewRequest("POST", "/api/orgs/"+createdOrg.ID.String()+"/invitations", bytes.NewBufferString(`{"email":"TestNoValkeyInvitee@example.com","role":"editor"}`))
+	inviteReq.Header.Set("Authorization", "Bearer "+adminResp.AccessToken)
+	inviteReq.SetPathValue("id", createdOrg.ID.String())
+	inviteW := httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, orgHandler.CreateInvitation)(inviteW, inviteReq)
+	if inviteW.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", inviteW.Code, inviteW.Body.String())
+	}
+
+	var invitation InvitationResponse
+	json.NewDecoder(inviteW.Body).Decode(&invitation)
+
+	// Only the hash of the token is stored
+	var tokenHash string
+	testPool.QueryRow(ctx, `SELECT token_hash FROM organization_invitations WHERE id = $1`, invitation.ID).Scan(&tokenHash)
+	if tokenHash == "" || tokenHash == invitation.Token {
+		t.Errorf("Expected the stored token to be hashed, got %q", tokenHash)
+	}
+
+	accept := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/invitations/"+invitation.Token+"/accept", nil)
+		req.Header.Set("Authorization", "Bearer "+inviteeResp.AccessToken)
+		req.SetPathValue("token", invitation.Token)
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, orgHandler.AcceptInvitation)(w, req)
+		return w
+	}
+
+	// The invited address is matched case-insensitively
+	if w := accept(); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := accept(); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an accepted invitation, got %d: %s", w.Code, w.Body.String())
+	}
+}
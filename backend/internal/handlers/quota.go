@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/quota"
+)
+
+// LimitErrorResponse is the body of a 429 from API rate limits and query quotas
+type LimitErrorResponse struct {
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	Scope      string `json:"scope"`
+	Limit      int    `json:"limit"`
+	RetryAfter int    `json:"retry_after"` // seconds
+}
+
+// writeLimitExceeded writes a structured 429 response
+func writeLimitExceeded(w http.ResponseWriter, code, scope string, limit int, retryAfter time.Duration) {
+	seconds := retryAfterSeconds(retryAfter)
+	message := "rate limit exceeded"
+	if code == "quota_exceeded" {
+		message = "daily query budget exhausted"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(LimitErrorResponse{
+		Status:     "error",
+		Error:      message,
+		Code:       code,
+		Scope:      scope,
+		Limit:      limit,
+		RetryAfter: seconds,
+	})
+}
+
+// writeQuotaError writes the response for a query refused by quota.Manager
+func writeQuotaError(w http.ResponseWriter, err error) {
+	var exceeded *quota.Exceeded
+	if !errors.As(err, &exceeded) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Error: "failed to check query limits"})
+		return
+	}
+
+	code := "rate_limited"
+	if exceeded.Scope == quota.ScopeDailyBudget {
+		code = "quota_exceeded"
+	}
+	writeLimitExceeded(w, code, exceeded.Scope, exceeded.Limit, exceeded.RetryAfter)
+}
+
+// QuotaHandler manages an organization's query limits and reports its usage
+type QuotaHandler struct {
+	pool   *pgxpool.Pool
+	quotas *quota.Manager
+}
+
+func NewQuotaHandler(pool *pgxpool.Pool, quotas *quota.Manager) *QuotaHandler {
+	return &QuotaHandler{pool: pool, quotas: quotas}
+}
+
+// QuotasResponse is an organization's effective query limits
+type QuotasResponse struct {
+	quota.Limits
+	Custom   bool         `json:"custom"`
+	Defaults quota.Limits `json:"defaults"`
+}
+
+// memberRole returns the user's role in the organization, writing an error
+// response if they aren't a member
+func (h *QuotaHandler) memberRole(ctx context.Context, w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, string, bool) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return uuid.Nil, uuid.Nil, "", false
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, "", false
+	}
+
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return orgID, userID, role, true
+	}
+
+	var role string
+	err = h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE organization_id = $1 AND user_id = $2`,
+		orgID, userID,
+	).Scan(&role)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return uuid.Nil, uuid.Nil, "", false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to check membership"}`, http.StatusInternalServerError)
+		return uuid.Nil, uuid.Nil, "", false
+	}
+	return orgID, userID, role, true
+}
+
+// quotasResponse loads the organization's effective limits
+func (h *QuotaHandler) quotasResponse(ctx context.Context, orgID uuid.UUID) (*QuotasResponse, error) {
+	limits, err := h.quotas.Limits(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	var custom bool
+	if err := h.pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM organization_quotas WHERE organization_id = $1)`, orgID,
+	).Scan(&custom); err != nil {
+		return nil, err
+	}
+	return &QuotasResponse{Limits: limits, Custom: custom, Defaults: h.quotas.Defaults()}, nil
+}
+
+// GetQuotas returns the organization's query limits (members)
+func (h *QuotaHandler) GetQuotas(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	orgID, _, _, ok := h.memberRole(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.quotasResponse(ctx, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get quotas"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateQuotas sets the organization's own query limits (admin only). They can
+// only tighten the server defaults: zero and values above a default get the
+// default, which the response shows.
+func (h *QuotaHandler) UpdateQuotas(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	orgID, _, role, ok := h.memberRole(ctx, w, r)
+	if !ok {
+		return
+	}
+	if role != "admin" {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return
+	}
+
+	var limits quota.Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := limits.Validate(); err != nil {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.quotas.SetLimits(ctx, orgID, limits); err != nil {
+		http.Error(w, `{"error":"failed to update quotas"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response, err := h.quotasResponse(ctx, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get quotas"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResetQuotas drops the organization's own limits so the server defaults apply
+// (admin only)
+func (h *QuotaHandler) ResetQuotas(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	orgID, _, role, ok := h.memberRole(ctx, w, r)
+	if !ok {
+		return
+	}
+	if role != "admin" {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return
+	}
+
+	if err := h.quotas.ResetLimits(ctx, orgID); err != nil {
+		http.Error(w, `{"error":"failed to reset quotas"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUsage reports the organization's current query usage against its limits
+// (members)
+func (h *QuotaHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	orgID, userID, _, ok := h.memberRole(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := h.pool.Query(ctx, `SELECT id FROM datasources WHERE organization_id = $1`, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to list datasources"}`, http.StatusInternalServerError)
+		return
+	}
+	datasourceIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		http.Error(w, `{"error":"failed to list datasources"}`, http.StatusInternalServerError)
+		return
+	}
+
+	usage, err := h.quotas.Usage(ctx, orgID, userID, datasourceIDs)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get usage"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
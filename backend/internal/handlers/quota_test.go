@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/quota"
+)
+
+func TestWriteQuotaError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeQuotaError(w, &quota.Exceeded{Scope: quota.ScopeDailyBudget, Limit: 1000, RetryAfter: 90 * time.Minute})
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "5400" {
+		t.Errorf("Expected Retry-After 5400, got %q", got)
+	}
+
+	var body LimitErrorResponse
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.Code != "quota_exceeded" || body.Scope != quota.ScopeDailyBudget || body.Limit != 1000 || body.RetryAfter != 5400 {
+		t.Errorf("Unexpected error body: %+v", body)
+	}
+}
+
+func TestAPIRateLimit(t *testing.T) {
+	t.Setenv("API_RATE_LIMIT_PER_MINUTE", "2")
+
+	jwtManager, err := auth.GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+	handler := APIRateLimit(jwtManager, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	token, _ := jwtManager.GenerateAccessToken(uuid.New(), "testapilimit@example.com", "")
+	request := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("/api/dashboards/1", token); w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to pass, got %d", i+1, w.Code)
+		}
+	}
+	w := request("/api/dashboards/1", token)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	var body LimitErrorResponse
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.Scope != "user" || body.Limit != 2 {
+		t.Errorf("Unexpected error body: %+v", body)
+	}
+
+	// Anonymous requests are limited by IP separately, and health checks not at all
+	if w := request("/api/dashboards/1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected an anonymous request to pass, got %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := request("/api/health", token); w.Code != http.StatusOK {
+			t.Fatalf("Expected health checks not to be limited, got %d", w.Code)
+		}
+	}
+}
+
+func TestQuotasAndUsage(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org Quotas', 'test-org-quotas') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var adminID, viewerID uuid.UUID
+	testPool.QueryRow(ctx, `INSERT INTO users (email) VALUES ('testquotaadmin@example.com') RETURNING id`).Scan(&adminID)
+	testPool.QueryRow(ctx, `INSERT INTO users (email) VALUES ('testquotaviewer@example.com') RETURNING id`).Scan(&viewerID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id IN ($1, $2)`, adminID, viewerID)
+	testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $3, 'admin'), ($2, $3, 'viewer')`,
+		adminID, viewerID, orgID,
+	)
+
+	adminToken, _ := testJWTManager.GenerateAccessToken(adminID, "testquotaadmin@example.com", "")
+	viewerToken, _ := testJWTManager.GenerateAccessToken(viewerID, "testquotaviewer@example.com", "")
+
+	handler := NewQuotaHandler(testPool, quota.NewManager(testPool, nil))
+	do := func(h http.HandlerFunc, method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/orgs/"+orgID.String()+"/quotas", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.SetPathValue("id", orgID.String())
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, h)(w, req)
+		return w
+	}
+
+	limits := `{"user_queries_per_minute":10,"org_queries_per_minute":50,"max_concurrent_queries":2,"daily_query_budget":500}`
+	if w := do(handler.UpdateQuotas, "PUT", viewerToken, limits); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a viewer, got %d", w.Code)
+	}
+	if w := do(handler.UpdateQuotas, "PUT", adminToken, `{"daily_query_budget":-1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative limit, got %d", w.Code)
+	}
+	w := do(handler.UpdateQuotas, "PUT", adminToken, limits)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var quotas QuotasResponse
+	json.NewDecoder(w.Body).Decode(&quotas)
+	if !quotas.Custom || quotas.DailyQueryBudget != 500 {
+		t.Errorf("Expected the custom limits to be returned, got %+v", quotas)
+	}
+
+	// Admins can tighten the server's limits but not lift them
+	w = do(handler.UpdateQuotas, "PUT", adminToken, `{"user_queries_per_minute":0,"org_queries_per_minute":1000000,"max_concurrent_queries":2,"daily_query_budget":500}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	json.NewDecoder(w.Body).Decode(&quotas)
+	if quotas.UserQueriesPerMinute != quotas.Defaults.UserQueriesPerMinute ||
+		quotas.OrgQueriesPerMinute != quotas.Defaults.OrgQueriesPerMinute || quotas.MaxConcurrentQueries != 2 {
+		t.Errorf("Expected the limits to be kept within the defaults, got %+v", quotas)
+	}
+
+	w = do(handler.GetUsage, "GET", viewerToken, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var usage quota.Usage
+	json.NewDecoder(w.Body).Decode(&usage)
+	if usage.DailyBudgetRemaining == nil || *usage.DailyBudgetRemaining != 500 {
+		t.Errorf("Expected the full budget to remain, got %+v", usage)
+	}
+
+	if w := do(handler.ResetQuotas, "DELETE", adminToken, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+	w = do(handler.GetQuotas, "GET", viewerToken, "")
+	json.NewDecoder(w.Body).Decode(&quotas)
+	if quotas.Custom {
+		t.Error("Expected the defaults to apply after reset")
+	}
+}
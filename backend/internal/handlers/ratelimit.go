@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+// loginLockout locks an account after repeated failed password logins: 5
+// failures lock it for 30s, doubling with each further failure up to 15 minutes
+var loginLockout = ratelimit.LockoutPolicy{
+	Threshold:  5,
+	Base:       30 * time.Second,
+	Max:        15 * time.Minute,
+	ResetAfter: time.Hour,
+}
+
+// clientIP returns the remote address of the request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientInfo describes the requesting device for session listings
+func clientInfo(r *http.Request) auth.ClientInfo {
+	return auth.ClientInfo{UserAgent: r.UserAgent(), IP: clientIP(r)}
+}
+
+// accountKey normalizes an email address for per-account limits
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// retryAfterSeconds rounds a delay up to whole seconds for Retry-After
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// writeTooManyRequests writes a 429 response telling the client when to retry
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	http.Error(w, `{"error":"`+message+`"}`, http.StatusTooManyRequests)
+}
+
+// rateLimited writes a 429 response when any of the keys is over its limit
+func rateLimited(ctx context.Context, w http.ResponseWriter, limiter *ratelimit.Limiter, limit int, window time.Duration, keys ...string) bool {
+	for _, key := range keys {
+		if res := limiter.Allow(ctx, key, limit, window); !res.Allowed {
+			writeTooManyRequests(w, res.RetryAfter, "too many requests, try again later")
+			return true
+		}
+	}
+	return false
+}
+
+// APIRateLimit limits every API request per user, or per client IP for
+// requests without a valid token, to API_RATE_LIMIT_PER_MINUTE (600 by
+// default, 0 disables it). There are no API keys yet; when they are added
+// they get a bucket of their own here and in quota.Manager.
+func APIRateLimit(jwtManager *auth.JWTManager, rdb *redis.Client) func(http.Handler) http.Handler {
+	perMinute := 600
+	if v, err := strconv.Atoi(os.Getenv("API_RATE_LIMIT_PER_MINUTE")); err == nil && v >= 0 {
+		perMinute = v
+	}
+	limiter := ratelimit.New(rdb)
+
+	return func(next http.Handler) http.Handler {
+		if perMinute == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/api/health" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scope, key := "ip", "api:ip:"+clientIP(r)
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if claims, err := jwtManager.VerifyAccessToken(token); err == nil {
+					scope, key = "user", "api:user:"+claims.UserID.String()
+				}
+			}
+
+			if res := limiter.Allow(r.Context(), key, perMinute, time.Minute); !res.Allowed {
+				writeLimitExceeded(w, "rate_limited", scope, perMinute, res.RetryAfter)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+type SilenceHandler struct {
+	pool  *pgxpool.Pool
+	audit *audit.Logger
+}
+
+func NewSilenceHandler(pool *pgxpool.Pool, auditLog *audit.Logger) *SilenceHandler {
+	return &SilenceHandler{pool: pool, audit: auditLog}
+}
+
+// Audit log views of silences and maintenance windows
+const (
+	silenceSnapshot           = `SELECT to_jsonb(s) FROM silences s WHERE id = $1`
+	maintenanceWindowSnapshot = `SELECT to_jsonb(m) FROM maintenance_windows m WHERE id = $1`
+)
+
+func (h *SilenceHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
+	var role string
+	err := h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
+		userID, orgID,
+	).Scan(&role)
+	return role, err
+}
+
+// checkEditor writes the error response unless the user is an admin or
+// editor of the org
+func (h *SilenceHandler) checkEditor(ctx context.Context, w http.ResponseWriter, userID, orgID uuid.UUID, action string) bool {
+	role, err := h.checkOrgMembership(ctx, userID, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return false
+	}
+	if role == "viewer" {
+		http.Error(w, fmt.Sprintf(`{"error":"viewers cannot %s"}`, action), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// loadSilence fetches the silence with the id in the path, writing the error
+// response when it doesn't exist
+func (h *SilenceHandler) loadSilence(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Silence, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid silence id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	silence, err := alerting.ScanSilence(h.pool.QueryRow(ctx,
+		`SELECT `+alerting.SilenceColumns+` FROM silences WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"silence not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch silence"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return silence, true
+}
+
+// CreateSilence silences an organization's alerts matching the request's
+// matchers until ends_at
+func (h *SilenceHandler) CreateSilence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateSilenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	silence := models.Silence{
+		OrganizationID: orgID,
+		Matchers:       req.Matchers,
+		StartsAt:       now,
+		EndsAt:         req.EndsAt.UTC(),
+		Comment:        req.Comment,
+	}
+	if req.StartsAt != nil {
+		silence.StartsAt = req.StartsAt.UTC()
+	}
+	if err := silence.Validate(now); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !h.checkEditor(ctx, w, userID, orgID, "create silences") {
+		return
+	}
+
+	created, err := alerting.ScanSilence(h.pool.QueryRow(ctx,
+		`INSERT INTO silences (organization_id, matchers, starts_at, ends_at, comment, created_by, created_by_email)
+		 VALUES ($1, $2, $3, $4, $5, $6, COALESCE((SELECT email FROM users WHERE id = $6), ''))
+		 RETURNING `+alerting.SilenceColumns,
+		orgID, silence.Matchers, silence.StartsAt, silence.EndsAt, silence.Comment, userID,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create silence"}`, http.StatusInternalServerError)
+		return
+	}
+	created.State = created.StateAt(now)
+	recordAudit(r, h.audit, &orgID, "silence.create", "silence", created.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, silenceSnapshot, created.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListSilences lists an organization's silences, optionally filtered by state
+func (h *SilenceHandler) ListSilences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", models.SilencePending, models.SilenceActive, models.SilenceExpired:
+	default:
+		http.Error(w, `{"error":"state must be pending, active or expired"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+alerting.SilenceColumns+` FROM silences WHERE organization_id = $1 ORDER BY ends_at DESC`, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch silences"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	silences := []models.Silence{}
+	for rows.Next() {
+		silence, err := alerting.ScanSilence(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan silence"}`, http.StatusInternalServerError)
+			return
+		}
+		silence.State = silence.StateAt(now)
+		if state == "" || silence.State == state {
+			silences = append(silences, *silence)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(silences)
+}
+
+// GetSilence returns a single silence
+func (h *SilenceHandler) GetSilence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	silence, ok := h.loadSilence(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.checkOrgMembership(ctx, userID, silence.OrganizationID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+	silence.State = silence.StateAt(time.Now().UTC())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(silence)
+}
+
+// ExpireSilence ends a silence now. Silences are kept once expired so the
+// history of who muted what stays visible.
+func (h *SilenceHandler) ExpireSilence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	silence, ok := h.loadSilence(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, silence.OrganizationID, "expire silences") {
+		return
+	}
+
+	now := time.Now().UTC()
+	if silence.StateAt(now) == models.SilenceExpired {
+		http.Error(w, `{"error":"silence has already expired"}`, http.StatusConflict)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, silenceSnapshot, silence.ID)
+	expired, err := alerting.ScanSilence(h.pool.QueryRow(ctx,
+		`UPDATE silences SET starts_at = LEAST(starts_at, $2), ends_at = $2
+		 WHERE id = $1
+		 RETURNING `+alerting.SilenceColumns,
+		silence.ID, now,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to expire silence"}`, http.StatusInternalServerError)
+		return
+	}
+	expired.State = expired.StateAt(now)
+	recordAudit(r, h.audit, &silence.OrganizationID, "silence.expire", "silence", silence.ID.String(),
+		before, auditSnapshot(ctx, h.pool, silenceSnapshot, silence.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(expired)
+}
+
+// PreviewSilence returns the organization's pending and firing alerts that
+// the request's matchers would silence
+func (h *SilenceHandler) PreviewSilence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.SilencePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Matchers) == 0 {
+		http.Error(w, `{"error":"at least one matcher is required"}`, http.StatusBadRequest)
+		return
+	}
+	if err := req.Matchers.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	instances, err := alerting.ListInstances(ctx, h.pool, orgID, nil)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch alerts"}`, http.StatusInternalServerError)
+		return
+	}
+	matched := []models.AlertInstance{}
+	for _, inst := range instances {
+		if inst.State != models.AlertResolved && req.Matchers.Matches(inst.Labels) {
+			matched = append(matched, inst)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched)
+}
+
+// loadMaintenanceWindow fetches the maintenance window with the id in the
+// path, writing the error response when it doesn't exist
+func (h *SilenceHandler) loadMaintenanceWindow(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.MaintenanceWindow, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid maintenance window id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	window, err := alerting.ScanMaintenanceWindow(h.pool.QueryRow(ctx,
+		`SELECT `+alerting.MaintenanceWindowColumns+` FROM maintenance_windows WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"maintenance window not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch maintenance window"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return window, true
+}
+
+// CreateMaintenanceWindow creates a recurring maintenance window for an
+// organization
+func (h *SilenceHandler) CreateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateMaintenanceWindowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	window := models.MaintenanceWindow{
+		OrganizationID:  orgID,
+		Name:            req.Name,
+		Matchers:        req.Matchers,
+		Weekdays:        req.Weekdays,
+		StartTime:       req.StartTime,
+		DurationMinutes: req.DurationMinutes,
+		Timezone:        req.Timezone,
+	}
+	if window.Weekdays == nil {
+		window.Weekdays = []int{}
+	}
+	if window.Timezone == "" {
+		window.Timezone = "UTC"
+	}
+	if err := window.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !h.checkEditor(ctx, w, userID, orgID, "create maintenance windows") {
+		return
+	}
+
+	created, err := alerting.ScanMaintenanceWindow(h.pool.QueryRow(ctx,
+		`INSERT INTO maintenance_windows (organization_id, name, matchers, weekdays, start_time, duration_minutes,
+			timezone, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING `+alerting.MaintenanceWindowColumns,
+		orgID, window.Name, window.Matchers, window.Weekdays, window.StartTime, window.DurationMinutes,
+		window.Timezone, userID,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to create maintenance window"}`, http.StatusInternalServerError)
+		return
+	}
+	created.Active = created.ActiveAt(time.Now())
+	recordAudit(r, h.audit, &orgID, "maintenance_window.create", "maintenance_window", created.ID.String(),
+		nil, auditSnapshot(ctx, h.pool, maintenanceWindowSnapshot, created.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListMaintenanceWindows lists an organization's maintenance windows and
+// whether each is in effect now
+func (h *SilenceHandler) ListMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgId"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.checkOrgMembership(ctx, userID, orgID); err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.pool.Query(ctx,
+		`SELECT `+alerting.MaintenanceWindowColumns+` FROM maintenance_windows WHERE organization_id = $1 ORDER BY name ASC`,
+		orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to fetch maintenance windows"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	windows := []models.MaintenanceWindow{}
+	for rows.Next() {
+		window, err := alerting.ScanMaintenanceWindow(rows)
+		if err != nil {
+			http.Error(w, `{"error":"failed to scan maintenance window"}`, http.StatusInternalServerError)
+			return
+		}
+		window.Active = window.ActiveAt(now)
+		windows = append(windows, *window)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(windows)
+}
+
+// UpdateMaintenanceWindow updates a maintenance window
+func (h *SilenceHandler) UpdateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateMaintenanceWindowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	window, ok := h.loadMaintenanceWindow(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, window.OrganizationID, "update maintenance windows") {
+		return
+	}
+
+	if req.Name != nil {
+		window.Name = *req.Name
+	}
+	if req.Matchers != nil {
+		window.Matchers = req.Matchers
+	}
+	if req.Weekdays != nil {
+		window.Weekdays = req.Weekdays
+	}
+	if req.StartTime != nil {
+		window.StartTime = *req.StartTime
+	}
+	if req.DurationMinutes != nil {
+		window.DurationMinutes = *req.DurationMinutes
+	}
+	if req.Timezone != nil {
+		window.Timezone = *req.Timezone
+	}
+	if err := window.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, maintenanceWindowSnapshot, window.ID)
+	updated, err := alerting.ScanMaintenanceWindow(h.pool.QueryRow(ctx,
+		`UPDATE maintenance_windows
+		 SET name = $2, matchers = $3, weekdays = $4, start_time = $5, duration_minutes = $6, timezone = $7,
+		     updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+alerting.MaintenanceWindowColumns,
+		window.ID, window.Name, window.Matchers, window.Weekdays, window.StartTime, window.DurationMinutes,
+		window.Timezone,
+	))
+	if err != nil {
+		http.Error(w, `{"error":"failed to update maintenance window"}`, http.StatusInternalServerError)
+		return
+	}
+	updated.Active = updated.ActiveAt(time.Now())
+	recordAudit(r, h.audit, &window.OrganizationID, "maintenance_window.update", "maintenance_window", window.ID.String(),
+		before, auditSnapshot(ctx, h.pool, maintenanceWindowSnapshot, window.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteMaintenanceWindow deletes a maintenance window
+func (h *SilenceHandler) DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	window, ok := h.loadMaintenanceWindow(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.checkEditor(ctx, w, userID, window.OrganizationID, "delete maintenance windows") {
+		return
+	}
+
+	before := auditSnapshot(ctx, h.pool, maintenanceWindowSnapshot, window.ID)
+	if _, err := h.pool.Exec(ctx, `DELETE FROM maintenance_windows WHERE id = $1`, window.ID); err != nil {
+		http.Error(w, `{"error":"failed to delete maintenance window"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &window.OrganizationID, "maintenance_window.delete", "maintenance_window", window.ID.String(),
+		before, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
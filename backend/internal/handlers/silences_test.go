@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/notify"
+)
+
+func TestSilenceHandler_CreateSilence_Unauthorized(t *testing.T) {
+	handler := &SilenceHandler{pool: nil}
+
+	body := bytes.NewBufferString(`{"matchers":[],"comment":"x"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/orgs/test/silences", body)
+	req.SetPathValue("orgId", "not-a-uuid")
+	rr := httptest.NewRecorder()
+
+	handler.CreateSilence(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestSilences(t *testing.T) {
+	_, authHandler, cleanup := setupOrgTestWithRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-silence-org'")
+	defer testPool.Exec(ctx, "DELETE FROM organizations WHERE slug = 'test-silence-org'")
+
+	received := make(chan notify.Notification, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notify.Notification
+		json.NewDecoder(r.Body).Decode(&n)
+		received <- n
+	}))
+	defer hook.Close()
+
+	dispatcher := notify.NewDispatcher(testPool, nil)
+	orgHandler := NewOrganizationHandler(testPool, nil, nil, nil)
+	notificationHandler := NewNotificationHandler(testPool, dispatcher, nil)
+	handler := NewSilenceHandler(testPool, nil)
+
+	admin := createTestUser(t, authHandler, "testsilenceadmin@example.com")
+
+	call := func(h http.HandlerFunc, method, path, body string, pathValues map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+admin.AccessToken)
+		for k, v := range pathValues {
+			req.SetPathValue(k, v)
+		}
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, h)(w, req)
+		return w
+	}
+
+	w := call(orgHandler.Create, "POST", "/api/orgs", `{"name":"Silence Org","slug":"test-silence-org"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create org: %d %s", w.Code, w.Body.String())
+	}
+	var org models.Organization
+	json.NewDecoder(w.Body).Decode(&org)
+	orgID := org.ID.String()
+	orgPath := map[string]string{"orgId": orgID}
+
+	w = call(notificationHandler.CreateChannel, "POST", "/api/orgs/"+orgID+"/notification-channels",
+		`{"name":"ops","type":"webhook","settings":{"url":"`+hook.URL+`"}}`, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create channel: %d %s", w.Code, w.Body.String())
+	}
+	var ch models.NotificationChannel
+	json.NewDecoder(w.Body).Decode(&ch)
+	w = call(notificationHandler.CreateRoute, "POST", "/api/orgs/"+orgID+"/notification-routes",
+		`{"channel_id":"`+ch.ID.String()+`","group_wait_seconds":0}`, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create route: %d %s", w.Code, w.Body.String())
+	}
+
+	var dsID, ruleID string
+	if err := testPool.QueryRow(ctx,
+		`INSERT INTO datasources (organization_id, name, type, url) VALUES ($1, 'vm', 'victoriametrics', 'http://vm') RETURNING id`,
+		org.ID).Scan(&dsID); err != nil {
+		t.Fatalf("Failed to create datasource: %v", err)
+	}
+	if err := testPool.QueryRow(ctx,
+		`INSERT INTO alert_rules (organization_id, datasource_id, name, query, condition, interval_seconds,
+			lookback_seconds, enabled, next_evaluation_at)
+		 VALUES ($1, $2, 'HighCPU', 'cpu', '{"reducer":"last","operator":">","threshold":90}', 60, 300, false, $3)
+		 RETURNING id`,
+		org.ID, dsID, time.Now().UTC()).Scan(&ruleID); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	now := time.Now().UTC()
+	for _, fp := range []string{"staging", "prod"} {
+		if _, err := testPool.Exec(ctx,
+			`INSERT INTO alert_instances (rule_id, organization_id, fingerprint, labels, annotations, state, value,
+				active_at, fired_at, last_evaluated_at)
+			 VALUES ($1, $2, $3, jsonb_build_object('alertname', 'HighCPU', 'env', $3::text), '{}', 'firing', 97, $4, $4, $4)`,
+			ruleID, org.ID, fp, now); err != nil {
+			t.Fatalf("Failed to create alert: %v", err)
+		}
+	}
+
+	matchers := `[{"name":"env","operator":"=","value":"staging"}]`
+
+	// Preview shows which alerts the silence would catch
+	w = call(handler.PreviewSilence, "POST", "/api/orgs/"+orgID+"/silences/preview", `{"matchers":`+matchers+`}`, orgPath)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Preview failed: %d %s", w.Code, w.Body.String())
+	}
+	var preview []models.AlertInstance
+	json.NewDecoder(w.Body).Decode(&preview)
+	if len(preview) != 1 || preview[0].Fingerprint != "staging" {
+		t.Fatalf("Expected the staging alert in the preview, got %+v", preview)
+	}
+
+	w = call(handler.CreateSilence, "POST", "/api/orgs/"+orgID+"/silences",
+		`{"matchers":`+matchers+`,"ends_at":"`+now.Add(time.Hour).Format(time.RFC3339)+`"}`, orgPath)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a silence without a comment to be rejected, got %d", w.Code)
+	}
+	w = call(handler.CreateSilence, "POST", "/api/orgs/"+orgID+"/silences",
+		`{"matchers":`+matchers+`,"ends_at":"`+now.Add(time.Hour).Format(time.RFC3339)+`","comment":"load test"}`, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create silence: %d %s", w.Code, w.Body.String())
+	}
+	var silence models.Silence
+	json.NewDecoder(w.Body).Decode(&silence)
+	if silence.State != models.SilenceActive || silence.CreatedByEmail != "testsilenceadmin@example.com" {
+		t.Errorf("Unexpected silence %+v", silence)
+	}
+
+	// Only the unsilenced alert is notified
+	if err := dispatcher.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	select {
+	case n := <-received:
+		if len(n.Alerts) != 1 || n.Alerts[0].Labels["env"] != "prod" {
+			t.Errorf("Expected only the prod alert, got %+v", n.Alerts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notification")
+	}
+
+	w = call(handler.ExpireSilence, "POST", "/api/silences/"+silence.ID.String()+"/expire", "", map[string]string{"id": silence.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to expire silence: %d %s", w.Code, w.Body.String())
+	}
+	w = call(handler.ListSilences, "GET", "/api/orgs/"+orgID+"/silences?state=expired", "", orgPath)
+	var expired []models.Silence
+	json.NewDecoder(w.Body).Decode(&expired)
+	if len(expired) != 1 || expired[0].ID != silence.ID {
+		t.Errorf("Expected the expired silence, got %+v", expired)
+	}
+
+	// A window covering every day around the clock is always in effect
+	w = call(handler.CreateMaintenanceWindow, "POST", "/api/orgs/"+orgID+"/maintenance-windows",
+		`{"name":"always","matchers":`+matchers+`,"start_time":"00:00","duration_minutes":1440}`, orgPath)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create maintenance window: %d %s", w.Code, w.Body.String())
+	}
+	var window models.MaintenanceWindow
+	json.NewDecoder(w.Body).Decode(&window)
+	if !window.Active || window.Timezone != "UTC" {
+		t.Errorf("Unexpected maintenance window %+v", window)
+	}
+
+	w = call(handler.UpdateMaintenanceWindow, "PUT", "/api/maintenance-windows/"+window.ID.String(),
+		`{"timezone":"Nowhere/Special"}`, map[string]string{"id": window.ID.String()})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown timezone to be rejected, got %d", w.Code)
+	}
+	w = call(handler.DeleteMaintenanceWindow, "DELETE", "/api/maintenance-windows/"+window.ID.String(), "",
+		map[string]string{"id": window.ID.String()})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Failed to delete maintenance window: %d %s", w.Code, w.Body.String())
+	}
+}
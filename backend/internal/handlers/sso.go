@@ -0,0 +1,648 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+)
+
+// ssoStateTTL bounds how long a user may take to sign in at the provider
+const ssoStateTTL = 5 * time.Minute
+
+// ssoState is kept server-side for the OAuth round trip, keyed by the state
+// parameter, which the browser also holds in a cookie
+type ssoState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	OrgSlug      string `json:"org"`
+	RedirectTo   string `json:"redirect_to,omitempty"`
+	// LinkUserID is the signed-in user who started the flow to link the
+	// identity to their account
+	LinkUserID *uuid.UUID `json:"link_user_id,omitempty"`
+}
+
+var (
+	// errSSOAccountExists is returned when an identity from a provider that
+	// can't vouch for emails has the email of an account it isn't linked to
+	errSSOAccountExists = errors.New("an account with this email already exists, sign in and link this identity to it")
+	// errSSOIdentityLinked is returned when linking an identity that is
+	// already linked to another account
+	errSSOIdentityLinked = errors.New("this identity is already linked to another account")
+)
+
+// ssoBase holds what every SSO protocol needs to resolve an organization's
+// config, provision the signed-in user and hand a token to the frontend
+type ssoBase struct {
+	pool       *pgxpool.Pool
+	jwtManager *auth.JWTManager
+	baseURL    string
+	provider   models.SSOProvider
+	audit      *audit.Logger
+
+	// trustEmail lets verified emails sign in to existing accounts with the
+	// same email. Only providers whose issuer we fix (Google) can be trusted
+	// with this; an org's own identity provider can claim any email.
+	trustEmail bool
+
+	// frontendURL receives tokens after login unless an allowed redirect_to is given
+	frontendURL       string
+	redirectAllowList []string
+}
+
+func newSSOBase(pool *pgxpool.Pool, jwtManager *auth.JWTManager, provider models.SSOProvider, auditLog *audit.Logger) ssoBase {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:5173"
+	}
+
+	// SSO_REDIRECT_ALLOWLIST is a comma-separated list of URL prefixes that
+	// redirect_to may point at, in addition to the frontend
+	allowList := []string{frontendURL}
+	for _, prefix := range strings.Split(os.Getenv("SSO_REDIRECT_ALLOWLIST"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			allowList = append(allowList, prefix)
+		}
+	}
+
+	return ssoBase{
+		pool:              pool,
+		jwtManager:        jwtManager,
+		baseURL:           baseURL,
+		provider:          provider,
+		audit:             auditLog,
+		frontendURL:       frontendURL,
+		redirectAllowList: allowList,
+	}
+}
+
+// redirectAllowed reports whether target is an absolute URL under one of the
+// allow-listed prefixes, comparing scheme and host exactly and paths by segment
+func (f *ssoBase) redirectAllowed(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil || u.Fragment != "" || u.Host == "" ||
+		(u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	for _, prefix := range f.redirectAllowList {
+		allowed, err := url.Parse(prefix)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(u.Scheme, allowed.Scheme) || !strings.EqualFold(u.Host, allowed.Host) {
+			continue
+		}
+		allowedPath := strings.TrimSuffix(allowed.Path, "/")
+		if allowedPath == "" || u.Path == allowedPath || strings.HasPrefix(u.Path, allowedPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loginRedirectURL returns where to send the browser after login: redirect_to
+// if it is allowed, otherwise the frontend's callback page
+func (f *ssoBase) loginRedirectURL(redirectTo string) string {
+	if redirectTo != "" && f.redirectAllowed(redirectTo) {
+		return redirectTo
+	}
+	return f.frontendURL + "/auth/callback"
+}
+
+// ssoFlow implements the OIDC login and callback round trip shared by all
+// OIDC providers. Provider handlers embed it and supply the relying party config.
+type ssoFlow struct {
+	ssoBase
+	stateCookie string
+	states      sso.StateStore
+
+	// oidcConfig builds the relying party configuration from the stored SSO config
+	oidcConfig func(cfg *models.SSOConfig, redirectURL string) (sso.OIDCConfig, error)
+	// normalize optionally fixes up provider quirks before the identity is validated
+	normalize func(identity *sso.Identity)
+}
+
+// newSSOFlow creates the flow; login state is kept in Valkey, or in memory when rdb is nil
+func newSSOFlow(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client, provider models.SSOProvider, stateCookie string, auditLog *audit.Logger) ssoFlow {
+	return ssoFlow{
+		ssoBase:     newSSOBase(pool, jwtManager, provider, auditLog),
+		stateCookie: stateCookie,
+		states:      sso.NewStateStore(rdb),
+	}
+}
+
+// configSnapshot returns the org's stored config for this provider as JSON, for
+// the audit log
+func (f *ssoBase) configSnapshot(ctx context.Context, orgID uuid.UUID) json.RawMessage {
+	if f.provider == models.SSOLDAP {
+		return auditSnapshot(ctx, f.pool, `SELECT to_jsonb(c) FROM ldap_configs c WHERE organization_id = $1`, orgID)
+	}
+	return auditSnapshot(ctx, f.pool,
+		`SELECT to_jsonb(c) FROM sso_configs c WHERE organization_id = $1 AND provider = $2`, orgID, f.provider)
+}
+
+// auditConfigChange records a change from before to the org's current config
+func (f *ssoBase) auditConfigChange(ctx context.Context, r *http.Request, orgID uuid.UUID, before json.RawMessage) {
+	recordAudit(r, f.audit, &orgID, "sso.configure", "sso_config", string(f.provider), before, f.configSnapshot(ctx, orgID))
+}
+
+// generateState creates a cryptographically secure state parameter
+func generateState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// loadConfig returns the organization ID and enabled SSO config for an org slug
+func (f *ssoBase) loadConfig(ctx context.Context, orgSlug string) (uuid.UUID, *models.SSOConfig, error) {
+	var orgID uuid.UUID
+	err := f.pool.QueryRow(ctx, `SELECT id FROM organizations WHERE slug = $1`, orgSlug).Scan(&orgID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return uuid.Nil, nil, fmt.Errorf("organization not found")
+		}
+		return uuid.Nil, nil, err
+	}
+
+	var cfg models.SSOConfig
+	err = f.pool.QueryRow(ctx,
+		`SELECT id, organization_id, provider, client_id, client_secret, tenant_id,
+		        issuer_url, scopes, claim_mapping, idp_entity_id, idp_sso_url, idp_certificate, saml_binding,
+		        enabled, created_at, updated_at
+		 FROM sso_configs
+		 WHERE organization_id = $1 AND provider = $2`,
+		orgID, f.provider,
+	).Scan(&cfg.ID, &cfg.OrganizationID, &cfg.Provider, &cfg.ClientID, &cfg.ClientSecret, &cfg.TenantID,
+		&cfg.IssuerURL, &cfg.Scopes, &cfg.ClaimMapping, &cfg.IDPEntityID, &cfg.IDPSSOURL, &cfg.IDPCertificate, &cfg.SAMLBinding,
+		&cfg.Enabled, &cfg.CreatedAt, &cfg.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return uuid.Nil, nil, fmt.Errorf("%s SSO not configured for this organization", f.provider)
+		}
+		return uuid.Nil, nil, err
+	}
+
+	if !cfg.Enabled {
+		return uuid.Nil, nil, fmt.Errorf("%s SSO is not enabled for this organization", f.provider)
+	}
+
+	return orgID, &cfg, nil
+}
+
+// oidcProvider creates the relying party for an organization's SSO config
+func (f *ssoFlow) oidcProvider(ctx context.Context, orgSlug string) (uuid.UUID, *sso.OIDCProvider, error) {
+	orgID, cfg, err := f.loadConfig(ctx, orgSlug)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	oidcConfig, err := f.oidcConfig(cfg, fmt.Sprintf("%s/api/auth/%s/callback", f.baseURL, f.provider))
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	provider, err := sso.NewOIDCProvider(ctx, oidcConfig)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	return orgID, provider, nil
+}
+
+// Login initiates the OAuth flow for the provider, using PKCE (S256)
+func (f *ssoFlow) Login(w http.ResponseWriter, r *http.Request) {
+	orgSlug := r.URL.Query().Get("org")
+	if orgSlug == "" {
+		http.Error(w, `{"error":"org parameter is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	redirectTo := r.URL.Query().Get("redirect_to")
+	if redirectTo != "" && !f.redirectAllowed(redirectTo) {
+		http.Error(w, `{"error":"redirect_to is not allowed"}`, http.StatusBadRequest)
+		return
+	}
+
+	authURL, ok := f.begin(w, r, ssoState{OrgSlug: orgSlug, RedirectTo: redirectTo})
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// SSOLinkResponse is where to send the browser to link an identity
+type SSOLinkResponse struct {
+	URL string `json:"url"`
+}
+
+// Link starts the OAuth flow to link the signed-in user's account to their
+// identity at the org's provider, returning the authorization URL. Identities
+// from providers that aren't trusted with emails must be linked this way
+// before they can sign in to an existing account.
+func (f *ssoFlow) Link(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgSlug := r.URL.Query().Get("org")
+	if orgSlug == "" {
+		http.Error(w, `{"error":"org parameter is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	authURL, ok := f.begin(w, r, ssoState{OrgSlug: orgSlug, LinkUserID: &userID})
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SSOLinkResponse{URL: authURL})
+}
+
+// begin stores the login state server-side, binds it to the browser with a
+// cookie and returns the provider's authorization URL, writing the error
+// response and returning false on failure
+func (f *ssoFlow) begin(w http.ResponseWriter, r *http.Request, stateData ssoState) (string, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	_, provider, err := f.oidcProvider(ctx, stateData.OrgSlug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return "", false
+	}
+
+	state, err := generateState()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate state"}`, http.StatusInternalServerError)
+		return "", false
+	}
+	stateData.Nonce, err = generateState()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate nonce"}`, http.StatusInternalServerError)
+		return "", false
+	}
+	stateData.CodeVerifier = oauth2.GenerateVerifier()
+
+	// Keep nonce, code verifier and org server-side; only the state travels
+	data, _ := json.Marshal(stateData)
+	if err := f.states.Save(ctx, state, data, ssoStateTTL); err != nil {
+		http.Error(w, `{"error":"failed to store state"}`, http.StatusInternalServerError)
+		return "", false
+	}
+
+	// Bind the state to this browser so a callback started elsewhere is rejected
+	http.SetCookie(w, &http.Cookie{
+		Name:     f.stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(ssoStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(f.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return provider.AuthCodeURL(state, stateData.Nonce, oauth2.S256ChallengeOption(stateData.CodeVerifier)), true
+}
+
+// Callback handles the OAuth callback, provisions the user and issues an access token
+func (f *ssoFlow) Callback(w http.ResponseWriter, r *http.Request) {
+	stateCookie, err := r.Cookie(f.stateCookie)
+	if err != nil {
+		http.Error(w, `{"error":"missing state cookie"}`, http.StatusBadRequest)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if state == "" || state != stateCookie.Value {
+		http.Error(w, `{"error":"state mismatch"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Clear state cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     f.stateCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Redeem the state; it cannot be used again
+	stateDataBytes, err := f.states.Take(ctx, state)
+	if err == sso.ErrStateNotFound {
+		http.Error(w, `{"error":"invalid or expired state"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to load state"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var stateData ssoState
+	if err := json.Unmarshal(stateDataBytes, &stateData); err != nil ||
+		stateData.Nonce == "" || stateData.CodeVerifier == "" || stateData.OrgSlug == "" {
+		http.Error(w, `{"error":"invalid state format"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Check for errors from the provider
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		if errDesc := r.URL.Query().Get("error_description"); errDesc != "" {
+			errParam += " - " + errDesc
+		}
+		http.Error(w, fmt.Sprintf(`{"error":"oauth error: %s"}`, errParam), http.StatusBadRequest)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, `{"error":"missing authorization code"}`, http.StatusBadRequest)
+		return
+	}
+
+	orgID, provider, err := f.oidcProvider(ctx, stateData.OrgSlug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, code, stateData.Nonce, oauth2.VerifierOption(stateData.CodeVerifier))
+	if err != nil {
+		if errors.Is(err, sso.ErrNonceMismatch) {
+			http.Error(w, `{"error":"nonce mismatch"}`, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, `{"error":"failed to verify identity"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if f.normalize != nil {
+		f.normalize(identity)
+	}
+	if err := identity.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if f.trustEmail && !identity.EmailVerified {
+		http.Error(w, `{"error":"email not verified"}`, http.StatusBadRequest)
+		return
+	}
+
+	f.completeLogin(ctx, w, r, orgID, identity, stateData.RedirectTo, stateData.LinkUserID)
+}
+
+// completeLogin provisions the user for a verified identity, applying the org's
+// role mapping rules, and redirects to redirectTo (if allowed) or the frontend
+// with an access token, or with an MFA token when the login needs a second
+// factor. A non-nil linkUserID links the identity to that user.
+func (f *ssoBase) completeLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, orgID uuid.UUID, identity *sso.Identity, redirectTo string, linkUserID *uuid.UUID) {
+	settings, err := loadSSOSettings(ctx, f.pool, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to load SSO settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	role, ok := ssoRole(settings, f.provider, identity)
+	if !ok {
+		http.Error(w, `{"error":"not authorized for this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	userID, userEmail, userName, err := f.provisionUser(ctx, orgID, identity, role, provisionOptions{
+		trustEmail: f.trustEmail,
+		linkUserID: linkUserID,
+	})
+	if err != nil {
+		writeProvisionError(w, err)
+		return
+	}
+
+	status := http.StatusTemporaryRedirect
+	if r.Method == http.MethodPost {
+		// Responses posted to us (SAML ACS) must not be replayed as a POST to the frontend
+		status = http.StatusSeeOther
+	}
+
+	// The IdP only vouches for the first factor; users with a second factor,
+	// or admins required to set one up, finish the login with an MFA token
+	challenge, ok := mfaChallenge(ctx, w, f.pool, f.jwtManager, userID, userEmail, userName)
+	if !ok {
+		return
+	}
+	if challenge != nil {
+		fragment := url.Values{"mfa_token": {challenge.MFAToken}}
+		if challenge.MFARequired {
+			fragment.Set("mfa_required", "true")
+			fragment.Set("methods", strings.Join(challenge.Methods, ","))
+		} else {
+			fragment.Set("mfa_enrollment_required", "true")
+		}
+		http.Redirect(w, r, f.loginRedirectURL(redirectTo)+"#"+fragment.Encode(), status)
+		return
+	}
+
+	accessToken, err := f.jwtManager.GenerateAccessToken(userID, userEmail, userName)
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Redirect with token in hash (client-side only)
+	redirectURL := fmt.Sprintf("%s#access_token=%s&token_type=Bearer", f.loginRedirectURL(redirectTo), accessToken)
+	http.Redirect(w, r, redirectURL, status)
+}
+
+// providerUserID returns the value stored in user_auth_methods for an identity.
+// Generic OIDC, SAML and LDAP subjects are only unique per issuer, so they are
+// qualified with it. Their issuer is whatever the org admin configured, so
+// another org could claim the same one; the org ID keeps each org's
+// identities apart.
+func (f *ssoBase) providerUserID(orgID uuid.UUID, identity *sso.Identity) string {
+	if f.provider == models.SSOOIDC || f.provider == models.SSOSAML || f.provider == models.SSOLDAP {
+		return orgID.String() + "|" + identity.Issuer + "|" + identity.Subject
+	}
+	return identity.Subject
+}
+
+// provisionOptions control how an SSO identity is matched to an account
+type provisionOptions struct {
+	// trustEmail signs a verified email in to the existing account with that
+	// email, and marks the email of new accounts verified
+	trustEmail bool
+	// linkUserID links the identity to this user's account
+	linkUserID *uuid.UUID
+}
+
+// writeProvisionError writes the response for a provisionUser error
+func writeProvisionError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if err == errSSOAccountExists || err == errSSOIdentityLinked {
+		status = http.StatusConflict
+	}
+	http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), status)
+}
+
+// provisionUser finds or creates the user for an SSO identity, adds them to the
+// organization and links the provider identity to their account. An empty role
+// adds new members as viewers and leaves existing memberships alone; otherwise
+// the membership is set to role.
+//
+// An identity signs in to the account it is linked to. Unlinked identities are
+// linked to opts.linkUserID, or with opts.trustEmail to the account with their
+// verified email; otherwise they get a new account, unless one already has
+// their email.
+func (f *ssoBase) provisionUser(ctx context.Context, orgID uuid.UUID, identity *sso.Identity, role models.MembershipRole, opts provisionOptions) (uuid.UUID, string, string, error) {
+	providerUserID := f.providerUserID(orgID, identity)
+
+	var userID uuid.UUID
+	var userEmail string
+	var userName *string
+
+	err := f.pool.QueryRow(ctx,
+		`SELECT u.id, u.email, u.name FROM users u
+		 JOIN user_auth_methods uam ON uam.user_id = u.id
+		 WHERE uam.provider = $1 AND uam.provider_user_id = $2`,
+		f.provider, providerUserID,
+	).Scan(&userID, &userEmail, &userName)
+	switch {
+	case err == nil:
+		if opts.linkUserID != nil && userID != *opts.linkUserID {
+			return uuid.Nil, "", "", errSSOIdentityLinked
+		}
+	case err != pgx.ErrNoRows:
+		return uuid.Nil, "", "", errors.New("failed to check user")
+	case opts.linkUserID != nil:
+		err = f.pool.QueryRow(ctx,
+			`SELECT id, email, name FROM users WHERE id = $1`,
+			*opts.linkUserID,
+		).Scan(&userID, &userEmail, &userName)
+		if err != nil {
+			return uuid.Nil, "", "", errors.New("failed to check user")
+		}
+	default:
+		// Only trusted providers may sign in to an account by its email
+		err = f.pool.QueryRow(ctx,
+			`SELECT id, email, name FROM users WHERE email = $1`,
+			identity.Email,
+		).Scan(&userID, &userEmail, &userName)
+		if err == nil && !opts.trustEmail {
+			return uuid.Nil, "", "", errSSOAccountExists
+		}
+		if err == pgx.ErrNoRows {
+			// Create new user
+			name := identity.Name
+			verified := opts.trustEmail && identity.EmailVerified
+			err = f.pool.QueryRow(ctx,
+				`INSERT INTO users (email, name, email_verified_at)
+				 VALUES ($1, $2, CASE WHEN $3 THEN NOW() END)
+				 RETURNING id, email, name`,
+				identity.Email, &name, verified,
+			).Scan(&userID, &userEmail, &userName)
+			if err != nil {
+				return uuid.Nil, "", "", errors.New("failed to create user")
+			}
+		} else if err != nil {
+			return uuid.Nil, "", "", errors.New("failed to check user")
+		}
+	}
+
+	if role == "" {
+		// Add user as viewer to organization if not already a member
+		_, err = f.pool.Exec(ctx,
+			`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'viewer')
+			 ON CONFLICT (organization_id, user_id) DO NOTHING`,
+			userID, orgID,
+		)
+	} else {
+		// The role mapped from the IdP replaces the current one, revoking the
+		// user's org-scoped tokens if it changed
+		var previousRole *string
+		err = f.pool.QueryRow(ctx,
+			`WITH previous AS (
+			     SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2
+			 )
+			 INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, $3)
+			 ON CONFLICT (organization_id, user_id) DO UPDATE SET role = $3, updated_at = NOW()
+			 RETURNING (SELECT role FROM previous)`,
+			userID, orgID, role,
+		).Scan(&previousRole)
+		if err == nil && previousRole != nil && *previousRole != string(role) {
+			if err := f.jwtManager.Denylist().RevokeMembership(ctx, orgID, userID); err != nil {
+				log.Printf("Failed to revoke org tokens after role change: %v", err)
+			}
+		}
+	}
+	if err != nil {
+		return uuid.Nil, "", "", errors.New("failed to add user to organization")
+	}
+
+	// Add or update user auth method
+	_, err = f.pool.Exec(ctx,
+		`INSERT INTO user_auth_methods (user_id, provider, provider_user_id)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (provider, provider_user_id) DO UPDATE SET updated_at = NOW()`,
+		userID, f.provider, providerUserID,
+	)
+	if err != nil {
+		return uuid.Nil, "", "", fmt.Errorf("failed to link %s account", f.provider)
+	}
+
+	name := ""
+	if userName != nil {
+		name = *userName
+	}
+	return userID, userEmail, name, nil
+}
+
+// requireSSOAdmin checks that the user is an admin of the org, writing the
+// error response and returning false if not
+func requireSSOAdmin(ctx context.Context, pool *pgxpool.Pool, w http.ResponseWriter, userID, orgID uuid.UUID) bool {
+	var role string
+	err := pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
+		userID, orgID,
+	).Scan(&role)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to check membership"}`, http.StatusInternalServerError)
+		return false
+	}
+	if role != "admin" {
+		http.Error(w, `{"error":"admin access required"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -56,7 +57,7 @@ func TestGoogleSSOConfigureRequiresAdmin(t *testing.T) {
 	}
 
 	// Create handler
-	handler := NewGoogleSSOHandler(testPool, testJWTManager)
+	handler := NewGoogleSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try to configure SSO as non-admin
 	body := `{"client_id":"test-client-id","client_secret":"test-secret"}`
@@ -119,7 +120,7 @@ func TestGoogleSSOConfigureAsAdmin(t *testing.T) {
 	}
 
 	// Create handler
-	handler := NewGoogleSSOHandler(testPool, testJWTManager)
+	handler := NewGoogleSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Configure SSO as admin
 	body := `{"client_id":"test-client-id","client_secret":"test-secret"}`
@@ -204,7 +205,7 @@ func TestGoogleSSOGetConfig(t *testing.T) {
 	}
 
 	// Create handler
-	handler := NewGoogleSSOHandler(testPool, testJWTManager)
+	handler := NewGoogleSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Get SSO config
 	req := httptest.NewRequest("GET", "/api/orgs/"+orgID.String()+"/sso/google", nil)
@@ -234,7 +235,7 @@ func TestGoogleSSOLoginRequiresOrg(t *testing.T) {
 		t.Skip("Database not available")
 	}
 
-	handler := NewGoogleSSOHandler(testPool, testJWTManager)
+	handler := NewGoogleSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try login without org parameter
 	req := httptest.NewRequest("GET", "/api/auth/google/login", nil)
@@ -264,7 +265,7 @@ func TestGoogleSSOLoginOrgNotConfigured(t *testing.T) {
 	}
 	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
 
-	handler := NewGoogleSSOHandler(testPool, testJWTManager)
+	handler := NewGoogleSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try login with org that doesn't have SSO configured
 	req := httptest.NewRequest("GET", "/api/auth/google/login?org=test-org-no-sso", nil)
@@ -305,7 +306,7 @@ func TestGoogleSSOLoginRedirectsToGoogle(t *testing.T) {
 		t.Fatalf("Failed to create SSO config: %v", err)
 	}
 
-	handler := NewGoogleSSOHandler(testPool, testJWTManager)
+	handler := NewGoogleSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try login - should redirect to Google
 	req := httptest.NewRequest("GET", "/api/auth/google/login?org=test-org-sso-redirect", nil)
@@ -339,4 +340,9 @@ func TestGoogleSSOLoginRedirectsToGoogle(t *testing.T) {
 	if stateCookie == nil {
 		t.Error("Expected oauth_state cookie to be set")
 	}
+
+	// PKCE challenge must be sent
+	if !strings.Contains(location, "code_challenge_method=S256") || !strings.Contains(location, "code_challenge=") {
+		t.Errorf("Expected S256 PKCE challenge in redirect, got: %s", location)
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -56,10 +57,10 @@ func TestMicrosoftSSOConfigureRequiresAdmin(t *testing.T) {
 	}
 
 	// Create handler
-	handler := NewMicrosoftSSOHandler(testPool, testJWTManager)
+	handler := NewMicrosoftSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try to configure SSO as non-admin
-	body := `{"tenant_id":"test-tenant","client_id":"test-client-id","client_secret":"test-secret"}`
+	body := `{"tenant_id":"3f2504e0-4f89-41d3-9a0c-0305e82c3301","client_id":"test-client-id","client_secret":"test-secret"}`
 	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/microsoft", bytes.NewBufferString(body))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -119,10 +120,23 @@ func TestMicrosoftSSOConfigureAsAdmin(t *testing.T) {
 	}
 
 	// Create handler
-	handler := NewMicrosoftSSOHandler(testPool, testJWTManager)
+	handler := NewMicrosoftSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// Multi-tenant aliases would let any Entra tenant sign in
+	for _, tenant := range []string{"common", "organizations", "contoso.onmicrosoft.com"} {
+		body := `{"tenant_id":"` + tenant + `","client_id":"test-client-id","client_secret":"test-secret"}`
+		req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/microsoft", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.SetPathValue("id", orgID.String())
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, handler.ConfigureSSO)(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for tenant %q, got %d: %s", tenant, w.Code, w.Body.String())
+		}
+	}
 
 	// Configure SSO as admin
-	body := `{"tenant_id":"test-tenant","client_id":"test-client-id","client_secret":"test-secret"}`
+	body := `{"tenant_id":"3f2504e0-4f89-41d3-9a0c-0305e82c3301","client_id":"test-client-id","client_secret":"test-secret"}`
 	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/microsoft", bytes.NewBufferString(body))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -141,8 +155,8 @@ func TestMicrosoftSSOConfigureAsAdmin(t *testing.T) {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	if response.TenantID != "test-tenant" {
-		t.Errorf("Expected tenant_id 'test-tenant', got '%s'", response.TenantID)
+	if response.TenantID != "3f2504e0-4f89-41d3-9a0c-0305e82c3301" {
+		t.Errorf("Expected the tenant ID, got '%s'", response.TenantID)
 	}
 	if response.ClientID != "test-client-id" {
 		t.Errorf("Expected client_id 'test-client-id', got '%s'", response.ClientID)
@@ -207,7 +221,7 @@ func TestMicrosoftSSOGetConfig(t *testing.T) {
 	}
 
 	// Create handler
-	handler := NewMicrosoftSSOHandler(testPool, testJWTManager)
+	handler := NewMicrosoftSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Get SSO config
 	req := httptest.NewRequest("GET", "/api/orgs/"+orgID.String()+"/sso/microsoft", nil)
@@ -240,7 +254,7 @@ func TestMicrosoftSSOLoginRequiresOrg(t *testing.T) {
 		t.Skip("Database not available")
 	}
 
-	handler := NewMicrosoftSSOHandler(testPool, testJWTManager)
+	handler := NewMicrosoftSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try login without org parameter
 	req := httptest.NewRequest("GET", "/api/auth/microsoft/login", nil)
@@ -270,7 +284,7 @@ func TestMicrosoftSSOLoginOrgNotConfigured(t *testing.T) {
 	}
 	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
 
-	handler := NewMicrosoftSSOHandler(testPool, testJWTManager)
+	handler := NewMicrosoftSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try login with org that doesn't have SSO configured
 	req := httptest.NewRequest("GET", "/api/auth/microsoft/login?org=test-org-no-ms-sso", nil)
@@ -304,14 +318,14 @@ func TestMicrosoftSSOLoginRedirectsToMicrosoft(t *testing.T) {
 	// Create SSO config
 	_, err = testPool.Exec(ctx,
 		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, tenant_id, enabled)
-		 VALUES ($1, 'microsoft', 'redirect-client-id', 'redirect-secret', 'test-tenant-id', true)`,
+		 VALUES ($1, 'microsoft', 'redirect-client-id', 'redirect-secret', '3f2504e0-4f89-41d3-9a0c-0305e82c3301', true)`,
 		orgID,
 	)
 	if err != nil {
 		t.Fatalf("Failed to create SSO config: %v", err)
 	}
 
-	handler := NewMicrosoftSSOHandler(testPool, testJWTManager)
+	handler := NewMicrosoftSSOHandler(testPool, testJWTManager, nil, nil)
 
 	// Try login - should redirect to Microsoft
 	req := httptest.NewRequest("GET", "/api/auth/microsoft/login?org=test-org-ms-sso-redirect", nil)
@@ -329,7 +343,7 @@ func TestMicrosoftSSOLoginRedirectsToMicrosoft(t *testing.T) {
 	}
 
 	// Check it's a Microsoft URL
-	if len(location) < 40 || location[:40] != "https://login.microsoftonline.com/test-t" {
+	if !strings.HasPrefix(location, "https://login.microsoftonline.com/3f2504e0-4f89-41d3-9a0c-0305e82c3301/") {
 		t.Errorf("Expected redirect to Microsoft, got: %s", location)
 	}
 
@@ -345,4 +359,9 @@ func TestMicrosoftSSOLoginRedirectsToMicrosoft(t *testing.T) {
 	if stateCookie == nil {
 		t.Error("Expected ms_oauth_state cookie to be set")
 	}
+
+	// PKCE challenge must be sent
+	if !strings.Contains(location, "code_challenge_method=S256") || !strings.Contains(location, "code_challenge=") {
+		t.Errorf("Expected S256 PKCE challenge in redirect, got: %s", location)
+	}
 }
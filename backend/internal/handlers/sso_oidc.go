@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso"
+	"github.com/redis/go-redis/v9"
+)
+
+// OIDCSSOHandler handles SSO against any OpenID Connect provider (Keycloak, Okta, ...)
+type OIDCSSOHandler struct {
+	ssoFlow
+}
+
+func NewOIDCSSOHandler(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client, auditLog *audit.Logger) *OIDCSSOHandler {
+	h := &OIDCSSOHandler{ssoFlow: newSSOFlow(pool, jwtManager, rdb, models.SSOOIDC, "oidc_oauth_state", auditLog)}
+	h.oidcConfig = genericOIDCConfig
+	return h
+}
+
+// OIDCSSOConfigRequest represents the request body for configuring generic OIDC SSO
+type OIDCSSOConfigRequest struct {
+	IssuerURL    string                  `json:"issuer_url"`
+	ClientID     string                  `json:"client_id"`
+	ClientSecret string                  `json:"client_secret"`
+	Scopes       []string                `json:"scopes,omitempty"`
+	ClaimMapping *models.SSOClaimMapping `json:"claim_mapping,omitempty"`
+	Enabled      *bool                   `json:"enabled,omitempty"`
+}
+
+// OIDCSSOConfigResponse represents the response for generic OIDC SSO config
+type OIDCSSOConfigResponse struct {
+	IssuerURL    string                 `json:"issuer_url"`
+	ClientID     string                 `json:"client_id"`
+	Scopes       []string               `json:"scopes"`
+	ClaimMapping models.SSOClaimMapping `json:"claim_mapping"`
+	Enabled      bool                   `json:"enabled"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// genericOIDCConfig builds a discovery-based config from the stored SSO config
+func genericOIDCConfig(cfg *models.SSOConfig, redirectURL string) (sso.OIDCConfig, error) {
+	if cfg.IssuerURL == nil || *cfg.IssuerURL == "" {
+		return sso.OIDCConfig{}, fmt.Errorf("oidc SSO issuer_url not configured")
+	}
+
+	oidcConfig := sso.OIDCConfig{
+		IssuerURL:    *cfg.IssuerURL,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       cfg.Scopes,
+	}
+	if cfg.ClaimMapping != nil {
+		oidcConfig.Claims = *cfg.ClaimMapping
+	}
+	return oidcConfig, nil
+}
+
+// ConfigureSSO creates or updates generic OIDC SSO configuration for an organization
+func (h *OIDCSSOHandler) ConfigureSSO(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	var req OIDCSSOConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	req.IssuerURL = strings.TrimSuffix(req.IssuerURL, "/")
+	if req.IssuerURL == "" || req.ClientID == "" || req.ClientSecret == "" {
+		http.Error(w, `{"error":"issuer_url, client_id and client_secret are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	// ID tokens are only issued for the openid scope
+	if len(req.Scopes) > 0 && !slices.Contains(req.Scopes, "openid") {
+		req.Scopes = append([]string{"openid"}, req.Scopes...)
+	}
+
+	claimMapping := models.SSOClaimMapping{}
+	if req.ClaimMapping != nil {
+		claimMapping = *req.ClaimMapping
+	}
+
+	// Validate the issuer by running discovery before saving
+	if _, err := sso.NewOIDCProvider(ctx, sso.OIDCConfig{IssuerURL: req.IssuerURL, ClientID: req.ClientID}); err != nil {
+		http.Error(w, `{"error":"failed to discover OIDC provider at issuer_url"}`, http.StatusBadRequest)
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	before := h.configSnapshot(ctx, orgID)
+
+	// Upsert SSO config
+	var config OIDCSSOConfigResponse
+	var scopes []string
+	err = h.pool.QueryRow(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, issuer_url, scopes, claim_mapping, enabled)
+		 VALUES ($1, 'oidc', $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (organization_id, provider) DO UPDATE
+		 SET client_id = $2, client_secret = $3, issuer_url = $4, scopes = $5, claim_mapping = $6, enabled = $7, updated_at = NOW()
+		 RETURNING issuer_url, client_id, scopes, enabled, created_at, updated_at`,
+		orgID, req.ClientID, req.ClientSecret, req.IssuerURL, req.Scopes, claimMapping, enabled,
+	).Scan(&config.IssuerURL, &config.ClientID, &scopes, &config.Enabled, &config.CreatedAt, &config.UpdatedAt)
+	if err != nil {
+		http.Error(w, `{"error":"failed to save SSO config"}`, http.StatusInternalServerError)
+		return
+	}
+	h.auditConfigChange(ctx, r, orgID, before)
+	config.Scopes = effectiveScopes(scopes)
+	config.ClaimMapping = claimMapping
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// GetSSOConfig returns the generic OIDC SSO configuration for an organization
+func (h *OIDCSSOHandler) GetSSOConfig(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	// Get SSO config
+	var config OIDCSSOConfigResponse
+	var issuerURL *string
+	var scopes []string
+	var claimMapping *models.SSOClaimMapping
+	err = h.pool.QueryRow(ctx,
+		`SELECT issuer_url, client_id, scopes, claim_mapping, enabled, created_at, updated_at FROM sso_configs
+		 WHERE organization_id = $1 AND provider = 'oidc'`,
+		orgID,
+	).Scan(&issuerURL, &config.ClientID, &scopes, &claimMapping, &config.Enabled, &config.CreatedAt, &config.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"oidc SSO not configured"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get SSO config"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if issuerURL != nil {
+		config.IssuerURL = *issuerURL
+	}
+	config.Scopes = effectiveScopes(scopes)
+	if claimMapping != nil {
+		config.ClaimMapping = *claimMapping
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// effectiveScopes returns the scopes requested at login for a stored config
+func effectiveScopes(scopes []string) []string {
+	if len(scopes) == 0 {
+		return sso.DefaultScopes
+	}
+	return scopes
+}
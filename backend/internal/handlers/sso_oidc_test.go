@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/sso/ssotest"
+)
+
+func TestOIDCSSOConfigureRequiresAdmin(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org OIDC', 'test-org-oidc') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testoidcviewer@example.com', 'Test OIDC Viewer') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'viewer')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "testoidcviewer@example.com", "Test OIDC Viewer")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewOIDCSSOHandler(testPool, testJWTManager, nil, nil)
+
+	body := `{"issuer_url":"http://idp.invalid","client_id":"test-client-id","client_secret":"test-secret"}`
+	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/oidc", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w := httptest.NewRecorder()
+
+	auth.RequireAuth(testJWTManager, handler.ConfigureSSO)(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for non-admin, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCSSOConfigureAsAdmin(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewIdP("test-client-id")
+	if err != nil {
+		t.Fatalf("Failed to start stub IdP: %v", err)
+	}
+	defer idp.Close()
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org OIDC Admin', 'test-org-oidc-admin') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testoidcadmin@example.com', 'Test OIDC Admin') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'admin')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "testoidcadmin@example.com", "Test OIDC Admin")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewOIDCSSOHandler(testPool, testJWTManager, nil, nil)
+
+	body := `{"issuer_url":"` + idp.Issuer() + `","client_id":"test-client-id","client_secret":"test-secret",
+		"scopes":["email","groups"],"claim_mapping":{"groups":"realm_access.roles"}}`
+	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/oidc", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w := httptest.NewRecorder()
+
+	auth.RequireAuth(testJWTManager, handler.ConfigureSSO)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response OIDCSSOConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.IssuerURL != idp.Issuer() {
+		t.Errorf("Expected issuer_url '%s', got '%s'", idp.Issuer(), response.IssuerURL)
+	}
+	if len(response.Scopes) != 3 || response.Scopes[0] != "openid" {
+		t.Errorf("Expected openid to be prepended to scopes, got %v", response.Scopes)
+	}
+	if response.ClaimMapping.Groups != "realm_access.roles" {
+		t.Errorf("Expected groups claim mapping to be saved, got '%s'", response.ClaimMapping.Groups)
+	}
+}
+
+func TestOIDCSSOLoginAndCallback(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewIdP("callback-client-id")
+	if err != nil {
+		t.Fatalf("Failed to start stub IdP: %v", err)
+	}
+	defer idp.Close()
+	idp.SetClaims(map[string]interface{}{
+		"sub":            "oidc-user-1",
+		"email":          "testoidccallback@example.com",
+		"email_verified": true,
+		"name":           "Test OIDC Callback",
+	})
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org OIDC Callback', 'test-org-oidc-callback') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE email = 'testoidccallback@example.com'`)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, issuer_url, enabled)
+		 VALUES ($1, 'oidc', 'callback-client-id', 'callback-secret', $2, true)`,
+		orgID, idp.Issuer(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	handler := NewOIDCSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// Login should redirect to the IdP's discovered authorization endpoint
+	req := httptest.NewRequest("GET", "/api/auth/oidc/login?org=test-org-oidc-callback", nil)
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, idp.Issuer()+"/authorize") {
+		t.Fatalf("Expected redirect to stub IdP, got: %s", location)
+	}
+
+	var stateCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "oidc_oauth_state" {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("Expected oidc_oauth_state cookie to be set")
+	}
+
+	// Sign in at the IdP, which redirects back with a code
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(location)
+	if err != nil {
+		t.Fatalf("Failed to call IdP authorize endpoint: %v", err)
+	}
+	resp.Body.Close()
+	callbackURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid callback URL: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callbackURL.RawQuery, nil)
+	req.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	handler.Callback(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Location"), "#access_token=") {
+		t.Errorf("Expected access token in redirect, got: %s", w.Header().Get("Location"))
+	}
+
+	// The state is single use
+	req = httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callbackURL.RawQuery, nil)
+	req.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	handler.Callback(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for replayed state, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// User should be provisioned as a viewer with the issuer-qualified subject linked
+	var role, providerUserID string
+	err = testPool.QueryRow(ctx,
+		`SELECT om.role, uam.provider_user_id FROM users u
+		 JOIN organization_memberships om ON om.user_id = u.id AND om.organization_id = $1
+		 JOIN user_auth_methods uam ON uam.user_id = u.id AND uam.provider = 'oidc'
+		 WHERE u.email = 'testoidccallback@example.com'`,
+		orgID,
+	).Scan(&role, &providerUserID)
+	if err != nil {
+		t.Fatalf("Failed to find provisioned user: %v", err)
+	}
+	if role != "viewer" {
+		t.Errorf("Expected role 'viewer', got '%s'", role)
+	}
+	if providerUserID != orgID.String()+"|"+idp.Issuer()+"|oidc-user-1" {
+		t.Errorf("Expected org- and issuer-qualified provider user id, got '%s'", providerUserID)
+	}
+}
+
+func TestOIDCSSODoesNotLinkAccountsByEmail(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewIdP("link-client-id")
+	if err != nil {
+		t.Fatalf("Failed to start stub IdP: %v", err)
+	}
+	defer idp.Close()
+	idp.SetClaims(map[string]interface{}{
+		"sub":            "oidc-link-user",
+		"email":          "testoidclink@example.com",
+		"email_verified": true,
+		"name":           "Test OIDC Link",
+	})
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org OIDC Link', 'test-org-oidc-link') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testoidclink@example.com', 'Test OIDC Link') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, issuer_url, enabled)
+		 VALUES ($1, 'oidc', 'link-client-id', 'link-secret', $2, true)`,
+		orgID, idp.Issuer(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	handler := NewOIDCSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// callback follows an authorization URL through the IdP and back
+	callback := func(location string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(location)
+		if err != nil {
+			t.Fatalf("Failed to call IdP authorize endpoint: %v", err)
+		}
+		resp.Body.Close()
+		callbackURL, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("Invalid callback URL: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callbackURL.RawQuery, nil)
+		req.AddCookie(stateCookie)
+		w := httptest.NewRecorder()
+		handler.Callback(w, req)
+		return w
+	}
+	stateCookieOf := func(w *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "oidc_oauth_state" {
+				return c
+			}
+		}
+		t.Fatal("Expected oidc_oauth_state cookie to be set")
+		return nil
+	}
+
+	// The org's IdP claims the email of an existing account, which it may not sign in to
+	req := httptest.NewRequest("GET", "/api/auth/oidc/login?org=test-org-oidc-link", nil)
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+	if w := callback(w.Header().Get("Location"), stateCookieOf(w)); w.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409 for an unlinked account, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The account's owner links the identity while signed in
+	token, err := testJWTManager.GenerateAccessToken(userID, "testoidclink@example.com", "Test OIDC Link")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	req = httptest.NewRequest("POST", "/api/auth/oidc/link?org=test-org-oidc-link", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	auth.RequireAuth(testJWTManager, handler.Link)(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var link SSOLinkResponse
+	if err := json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w := callback(link.URL, stateCookieOf(w)); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307 after linking, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var linked bool
+	testPool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM user_auth_methods WHERE user_id = $1 AND provider = 'oidc')`, userID,
+	).Scan(&linked)
+	if !linked {
+		t.Error("Expected the identity to be linked to the signed-in user")
+	}
+
+	// The linked identity now signs in
+	req = httptest.NewRequest("GET", "/api/auth/oidc/login?org=test-org-oidc-link", nil)
+	w = httptest.NewRecorder()
+	handler.Login(w, req)
+	if w := callback(w.Header().Get("Location"), stateCookieOf(w)); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected status 307 for a linked identity, got %d: %s", w.Code, w.Body.String())
+	}
+}
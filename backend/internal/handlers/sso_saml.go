@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	samlRequestCookie = "saml_request"
+	samlLinkCookie    = "saml_link"
+)
+
+// samlRequestState ties the IdP's response to the AuthnRequest we sent. It is
+// kept server-side, keyed by the relay state, which the browser also holds in
+// a cookie.
+type samlRequestState struct {
+	RequestID  string     `json:"request_id"`
+	OrgSlug    string     `json:"org"`
+	RedirectTo string     `json:"redirect_to,omitempty"`
+	LinkUserID *uuid.UUID `json:"link_user_id,omitempty"`
+}
+
+// samlLinkState is kept server-side, keyed by a link token, between Link and
+// the Login it sends the browser to
+type samlLinkState struct {
+	UserID  uuid.UUID `json:"user_id"`
+	OrgSlug string    `json:"org"`
+}
+
+// SAMLSSOHandler handles SAML 2.0 SSO, acting as a service provider per organization
+type SAMLSSOHandler struct {
+	ssoBase
+	states sso.StateStore
+	// assertions holds the IDs of accepted assertions so they can't be replayed
+	assertions sso.ReplayCache
+}
+
+// NewSAMLSSOHandler creates the handler; request state and assertion IDs are
+// kept in Valkey, or in memory when rdb is nil
+func NewSAMLSSOHandler(pool *pgxpool.Pool, jwtManager *auth.JWTManager, rdb *redis.Client, auditLog *audit.Logger) *SAMLSSOHandler {
+	return &SAMLSSOHandler{
+		ssoBase:    newSSOBase(pool, jwtManager, models.SSOSAML, auditLog),
+		states:     sso.NewStateStore(rdb),
+		assertions: sso.NewReplayCache(rdb),
+	}
+}
+
+// SAMLSSOConfigRequest represents the request body for configuring SAML SSO
+type SAMLSSOConfigRequest struct {
+	IDPEntityID      string                  `json:"idp_entity_id"`
+	IDPSSOURL        string                  `json:"idp_sso_url"`
+	IDPCertificate   string                  `json:"idp_certificate"`
+	Binding          string                  `json:"binding,omitempty"`
+	AttributeMapping *models.SSOClaimMapping `json:"attribute_mapping,omitempty"`
+	Enabled          *bool                   `json:"enabled,omitempty"`
+}
+
+// SAMLSSOConfigResponse represents the response for SAML SSO config, including
+// the service provider URLs to register with the IdP
+type SAMLSSOConfigResponse struct {
+	IDPEntityID      string                 `json:"idp_entity_id"`
+	IDPSSOURL        string                 `json:"idp_sso_url"`
+	IDPCertificate   string                 `json:"idp_certificate"`
+	Binding          string                 `json:"binding"`
+	AttributeMapping models.SSOClaimMapping `json:"attribute_mapping"`
+	EntityID         string                 `json:"entity_id"`
+	ACSURL           string                 `json:"acs_url"`
+	Enabled          bool                   `json:"enabled"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+}
+
+// metadataURL doubles as the service provider entity ID
+func (h *SAMLSSOHandler) metadataURL(orgSlug string) string {
+	return fmt.Sprintf("%s/api/auth/saml/%s/metadata", h.baseURL, orgSlug)
+}
+
+func (h *SAMLSSOHandler) acsURL(orgSlug string) string {
+	return fmt.Sprintf("%s/api/auth/saml/%s/acs", h.baseURL, orgSlug)
+}
+
+// samlProvider creates the service provider for an organization's SSO config
+func (h *SAMLSSOHandler) samlProvider(ctx context.Context, orgSlug string) (uuid.UUID, *sso.SAMLProvider, error) {
+	orgID, cfg, err := h.loadConfig(ctx, orgSlug)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	if cfg.IDPEntityID == nil || cfg.IDPSSOURL == nil || cfg.IDPCertificate == nil {
+		return uuid.Nil, nil, fmt.Errorf("saml SSO IdP not configured")
+	}
+
+	samlConfig := sso.SAMLConfig{
+		MetadataURL:    h.metadataURL(orgSlug),
+		ACSURL:         h.acsURL(orgSlug),
+		IDPEntityID:    *cfg.IDPEntityID,
+		IDPSSOURL:      *cfg.IDPSSOURL,
+		IDPCertificate: *cfg.IDPCertificate,
+	}
+	if cfg.SAMLBinding != nil {
+		samlConfig.Binding = *cfg.SAMLBinding
+	}
+	if cfg.ClaimMapping != nil {
+		samlConfig.Attributes = *cfg.ClaimMapping
+	}
+
+	provider, err := sso.NewSAMLProvider(samlConfig)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return orgID, provider, nil
+}
+
+// setRequestCookie stores or clears the relay state of the pending request.
+// The IdP posts the response cross-site, so the cookie must be SameSite=None
+// when served over HTTPS.
+func (h *SAMLSSOHandler) setRequestCookie(w http.ResponseWriter, value string, maxAge int) {
+	h.setCookie(w, samlRequestCookie, value, maxAge)
+}
+
+func (h *SAMLSSOHandler) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/api/auth/saml/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if strings.HasPrefix(h.baseURL, "https://") {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+}
+
+// Metadata serves the service provider metadata for an organization
+func (h *SAMLSSOHandler) Metadata(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	_, provider, err := h.samlProvider(ctx, r.PathValue("org"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusNotFound)
+		return
+	}
+
+	metadata, err := provider.Metadata()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate metadata"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// Login sends an AuthnRequest to the organization's IdP. A link token from
+// Link links the identity to the signed-in user who asked for it.
+func (h *SAMLSSOHandler) Login(w http.ResponseWriter, r *http.Request) {
+	orgSlug := r.URL.Query().Get("org")
+	if orgSlug == "" {
+		http.Error(w, `{"error":"org parameter is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	redirectTo := r.URL.Query().Get("redirect_to")
+	if redirectTo != "" && !h.redirectAllowed(redirectTo) {
+		http.Error(w, `{"error":"redirect_to is not allowed"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stateData := samlRequestState{OrgSlug: orgSlug, RedirectTo: redirectTo}
+	if linkToken := r.URL.Query().Get("link"); linkToken != "" {
+		// The token must have been issued to this browser
+		linkCookie, err := r.Cookie(samlLinkCookie)
+		if err != nil || linkCookie.Value != linkToken {
+			http.Error(w, `{"error":"link token mismatch"}`, http.StatusBadRequest)
+			return
+		}
+		h.setCookie(w, samlLinkCookie, "", -1)
+
+		data, err := h.states.Take(ctx, "link:"+linkToken)
+		var link samlLinkState
+		if err != nil || json.Unmarshal(data, &link) != nil || link.OrgSlug != orgSlug {
+			http.Error(w, `{"error":"invalid or expired link token"}`, http.StatusBadRequest)
+			return
+		}
+		stateData.LinkUserID = &link.UserID
+	}
+
+	_, provider, err := h.samlProvider(ctx, orgSlug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	relayState, err := generateState()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate state"}`, http.StatusInternalServerError)
+		return
+	}
+
+	authnRequest, err := provider.AuthnRequest(relayState)
+	if err != nil {
+		http.Error(w, `{"error":"failed to create SAML request"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the request ID server-side; only the relay state travels
+	stateData.RequestID = authnRequest.ID
+	data, _ := json.Marshal(stateData)
+	if err := h.states.Save(ctx, relayState, data, ssoStateTTL); err != nil {
+		http.Error(w, `{"error":"failed to store state"}`, http.StatusInternalServerError)
+		return
+	}
+	h.setRequestCookie(w, relayState, int(ssoStateTTL.Seconds()))
+
+	if authnRequest.PostForm != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(authnRequest.PostForm)
+		return
+	}
+	http.Redirect(w, r, authnRequest.RedirectURL, http.StatusTemporaryRedirect)
+}
+
+// Link starts a login at the org's IdP that links the signed-in user's
+// account to their identity there, returning the URL to send the browser to
+func (h *SAMLSSOHandler) Link(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgSlug := r.URL.Query().Get("org")
+	if orgSlug == "" {
+		http.Error(w, `{"error":"org parameter is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, _, err := h.samlProvider(ctx, orgSlug); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	linkToken, err := generateState()
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate state"}`, http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.Marshal(samlLinkState{UserID: userID, OrgSlug: orgSlug})
+	if err := h.states.Save(ctx, "link:"+linkToken, data, ssoStateTTL); err != nil {
+		http.Error(w, `{"error":"failed to store state"}`, http.StatusInternalServerError)
+		return
+	}
+	h.setCookie(w, samlLinkCookie, linkToken, int(ssoStateTTL.Seconds()))
+
+	params := url.Values{"org": {orgSlug}, "link": {linkToken}}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SSOLinkResponse{URL: h.baseURL + "/api/auth/saml/login?" + params.Encode()})
+}
+
+// ACS consumes the SAMLResponse posted by the IdP, provisions the user and
+// issues an access token
+func (h *SAMLSSOHandler) ACS(w http.ResponseWriter, r *http.Request) {
+	orgSlug := r.PathValue("org")
+
+	requestCookie, err := r.Cookie(samlRequestCookie)
+	if err != nil {
+		http.Error(w, `{"error":"missing SAML request cookie"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid form body"}`, http.StatusBadRequest)
+		return
+	}
+
+	relayState := r.PostForm.Get("RelayState")
+	if relayState == "" || relayState != requestCookie.Value {
+		http.Error(w, `{"error":"state mismatch"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Clear request cookie
+	h.setRequestCookie(w, "", -1)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Redeem the request; it cannot be used again
+	stateDataBytes, err := h.states.Take(ctx, relayState)
+	if err == sso.ErrStateNotFound {
+		http.Error(w, `{"error":"invalid or expired state"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to load state"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var stateData samlRequestState
+	if err := json.Unmarshal(stateDataBytes, &stateData); err != nil || stateData.RequestID == "" {
+		http.Error(w, `{"error":"invalid state format"}`, http.StatusBadRequest)
+		return
+	}
+	if stateData.OrgSlug != orgSlug {
+		http.Error(w, `{"error":"state mismatch"}`, http.StatusBadRequest)
+		return
+	}
+
+	samlResponse := r.PostForm.Get("SAMLResponse")
+	if samlResponse == "" {
+		http.Error(w, `{"error":"missing SAMLResponse"}`, http.StatusBadRequest)
+		return
+	}
+
+	orgID, provider, err := h.samlProvider(ctx, orgSlug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	identity, assertion, err := provider.ParseResponse(samlResponse, stateData.RequestID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to verify SAML response"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Each assertion is accepted once
+	fresh, err := h.assertions.Use(ctx, orgSlug+"|"+assertion.ID, time.Until(assertion.Expires))
+	if err != nil {
+		http.Error(w, `{"error":"failed to check SAML assertion"}`, http.StatusInternalServerError)
+		return
+	}
+	if !fresh {
+		http.Error(w, `{"error":"SAML assertion already used"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if identity.Subject == "" {
+		http.Error(w, `{"error":"SAML assertion has no subject"}`, http.StatusBadRequest)
+		return
+	}
+	if err := identity.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	h.completeLogin(ctx, w, r, orgID, identity, stateData.RedirectTo, stateData.LinkUserID)
+}
+
+// ConfigureSSO creates or updates SAML SSO configuration for an organization
+func (h *SAMLSSOHandler) ConfigureSSO(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	var req SAMLSSOConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.IDPEntityID == "" || req.IDPSSOURL == "" || req.IDPCertificate == "" {
+		http.Error(w, `{"error":"idp_entity_id, idp_sso_url and idp_certificate are required"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Binding == "" {
+		req.Binding = sso.SAMLBindingRedirect
+	}
+
+	attributeMapping := models.SSOClaimMapping{}
+	if req.AttributeMapping != nil {
+		attributeMapping = *req.AttributeMapping
+	}
+
+	// Validate the certificate and binding before saving
+	if _, err := sso.NewSAMLProvider(sso.SAMLConfig{
+		IDPEntityID:    req.IDPEntityID,
+		IDPSSOURL:      req.IDPSSOURL,
+		IDPCertificate: req.IDPCertificate,
+		Binding:        req.Binding,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	before := h.configSnapshot(ctx, orgID)
+
+	// Upsert SSO config; SAML has no client credentials
+	var config SAMLSSOConfigResponse
+	err = h.pool.QueryRow(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, idp_entity_id, idp_sso_url, idp_certificate, saml_binding, claim_mapping, enabled)
+		 VALUES ($1, 'saml', '', '', $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (organization_id, provider) DO UPDATE
+		 SET idp_entity_id = $2, idp_sso_url = $3, idp_certificate = $4, saml_binding = $5, claim_mapping = $6, enabled = $7, updated_at = NOW()
+		 RETURNING idp_entity_id, idp_sso_url, idp_certificate, saml_binding, enabled, created_at, updated_at`,
+		orgID, req.IDPEntityID, req.IDPSSOURL, req.IDPCertificate, req.Binding, attributeMapping, enabled,
+	).Scan(&config.IDPEntityID, &config.IDPSSOURL, &config.IDPCertificate, &config.Binding, &config.Enabled, &config.CreatedAt, &config.UpdatedAt)
+	if err != nil {
+		http.Error(w, `{"error":"failed to save SSO config"}`, http.StatusInternalServerError)
+		return
+	}
+	h.auditConfigChange(ctx, r, orgID, before)
+	config.AttributeMapping = attributeMapping
+
+	if err := h.fillServiceProviderURLs(ctx, orgID, &config); err != nil {
+		http.Error(w, `{"error":"failed to get organization"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// GetSSOConfig returns the SAML SSO configuration for an organization
+func (h *SAMLSSOHandler) GetSSOConfig(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	// Get SSO config
+	var config SAMLSSOConfigResponse
+	var entityID, ssoURL, certificate, binding *string
+	var attributeMapping *models.SSOClaimMapping
+	err = h.pool.QueryRow(ctx,
+		`SELECT idp_entity_id, idp_sso_url, idp_certificate, saml_binding, claim_mapping, enabled, created_at, updated_at
+		 FROM sso_configs WHERE organization_id = $1 AND provider = 'saml'`,
+		orgID,
+	).Scan(&entityID, &ssoURL, &certificate, &binding, &attributeMapping, &config.Enabled, &config.CreatedAt, &config.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		http.Error(w, `{"error":"saml SSO not configured"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get SSO config"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if entityID != nil {
+		config.IDPEntityID = *entityID
+	}
+	if ssoURL != nil {
+		config.IDPSSOURL = *ssoURL
+	}
+	if certificate != nil {
+		config.IDPCertificate = *certificate
+	}
+	config.Binding = sso.SAMLBindingRedirect
+	if binding != nil && *binding != "" {
+		config.Binding = *binding
+	}
+	if attributeMapping != nil {
+		config.AttributeMapping = *attributeMapping
+	}
+
+	if err := h.fillServiceProviderURLs(ctx, orgID, &config); err != nil {
+		http.Error(w, `{"error":"failed to get organization"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// fillServiceProviderURLs sets the entity ID and ACS URL the IdP must be configured with
+func (h *SAMLSSOHandler) fillServiceProviderURLs(ctx context.Context, orgID uuid.UUID, config *SAMLSSOConfigResponse) error {
+	var orgSlug string
+	if err := h.pool.QueryRow(ctx, `SELECT slug FROM organizations WHERE id = $1`, orgID).Scan(&orgSlug); err != nil {
+		return err
+	}
+	config.EntityID = h.metadataURL(orgSlug)
+	config.ACSURL = h.acsURL(orgSlug)
+	return nil
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/sso/ssotest"
+)
+
+func TestSAMLSSOConfigureRequiresAdmin(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org SAML', 'test-org-saml') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testsamlviewer@example.com', 'Test SAML Viewer') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'viewer')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "testsamlviewer@example.com", "Test SAML Viewer")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewSAMLSSOHandler(testPool, testJWTManager, nil, nil)
+
+	body := `{"idp_entity_id":"https://idp.example.com","idp_sso_url":"https://idp.example.com/sso","idp_certificate":"x"}`
+	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/saml", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w := httptest.NewRecorder()
+
+	auth.RequireAuth(testJWTManager, handler.ConfigureSSO)(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for non-admin, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSAMLSSOConfigureAsAdmin(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewSAMLIdP("https://idp.example.com/metadata", "https://idp.example.com/sso")
+	if err != nil {
+		t.Fatalf("Failed to create stub SAML IdP: %v", err)
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org SAML Admin', 'test-org-saml-admin') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testsamladmin@example.com', 'Test SAML Admin') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'admin')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "testsamladmin@example.com", "Test SAML Admin")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewSAMLSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// An invalid certificate is rejected
+	body, _ := json.Marshal(SAMLSSOConfigRequest{
+		IDPEntityID:    idp.EntityID,
+		IDPSSOURL:      idp.SSOURL,
+		IDPCertificate: "not a certificate",
+	})
+	req := httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/saml", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w := httptest.NewRecorder()
+
+	auth.RequireAuth(testJWTManager, handler.ConfigureSSO)(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for invalid certificate, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, _ = json.Marshal(SAMLSSOConfigRequest{
+		IDPEntityID:    idp.EntityID,
+		IDPSSOURL:      idp.SSOURL,
+		IDPCertificate: idp.CertificatePEM(),
+		Binding:        "post",
+	})
+	req = httptest.NewRequest("POST", "/api/orgs/"+orgID.String()+"/sso/saml", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("id", orgID.String())
+	w = httptest.NewRecorder()
+
+	auth.RequireAuth(testJWTManager, handler.ConfigureSSO)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response SAMLSSOConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Binding != "post" {
+		t.Errorf("Expected binding 'post', got '%s'", response.Binding)
+	}
+	if !strings.HasSuffix(response.ACSURL, "/api/auth/saml/test-org-saml-admin/acs") {
+		t.Errorf("Expected org ACS URL, got '%s'", response.ACSURL)
+	}
+	if !strings.HasSuffix(response.EntityID, "/api/auth/saml/test-org-saml-admin/metadata") {
+		t.Errorf("Expected metadata URL as entity ID, got '%s'", response.EntityID)
+	}
+}
+
+func TestSAMLSSOLoginAndACS(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewSAMLIdP("https://idp.example.com/metadata", "https://idp.example.com/sso")
+	if err != nil {
+		t.Fatalf("Failed to create stub SAML IdP: %v", err)
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org SAML ACS', 'test-org-saml-acs') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE email = 'testsamlacs@example.com'`)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, idp_entity_id, idp_sso_url, idp_certificate, enabled)
+		 VALUES ($1, 'saml', '', '', $2, $3, $4, true)`,
+		orgID, idp.EntityID, idp.SSOURL, idp.CertificatePEM(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	handler := NewSAMLSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// Metadata is served for the org
+	req := httptest.NewRequest("GET", "/api/auth/saml/test-org-saml-acs/metadata", nil)
+	req.SetPathValue("org", "test-org-saml-acs")
+	w := httptest.NewRecorder()
+	handler.Metadata(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for metadata, got %d: %s", w.Code, w.Body.String())
+	}
+	metadata := w.Body.Bytes()
+
+	// Login should redirect to the IdP with an AuthnRequest
+	req = httptest.NewRequest("GET", "/api/auth/saml/login?org=test-org-saml-acs", nil)
+	w = httptest.NewRecorder()
+	handler.Login(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Location"), idp.SSOURL+"?SAMLRequest=") {
+		t.Fatalf("Expected redirect to IdP SSO URL, got: %s", w.Header().Get("Location"))
+	}
+
+	var requestCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == samlRequestCookie {
+			requestCookie = c
+		}
+	}
+	if requestCookie == nil {
+		t.Fatal("Expected saml_request cookie to be set")
+	}
+
+	// IdP posts a signed response back to the ACS
+	requestID := samlRequestID(t, w.Header().Get("Location"))
+	samlResponse, err := idp.Respond(metadata, requestID, "saml-user-1", map[string][]string{
+		"email": {"testsamlacs@example.com"},
+		"name":  {"Test SAML ACS"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SAML response: %v", err)
+	}
+
+	acs := func(relayState string) *httptest.ResponseRecorder {
+		form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {relayState}}
+		req := httptest.NewRequest("POST", "/api/auth/saml/test-org-saml-acs/acs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("org", "test-org-saml-acs")
+		req.AddCookie(&http.Cookie{Name: samlRequestCookie, Value: relayState})
+		w := httptest.NewRecorder()
+		handler.ACS(w, req)
+		return w
+	}
+
+	// A relay state the server didn't issue is rejected
+	if w := acs("forged"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a forged relay state, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = acs(requestCookie.Value)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Location"), "#access_token=") {
+		t.Errorf("Expected access token in redirect, got: %s", w.Header().Get("Location"))
+	}
+
+	// The response can't be replayed
+	if w := acs(requestCookie.Value); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a replayed response, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// User should be provisioned as a viewer with the org- and IdP-qualified NameID linked
+	var role, providerUserID string
+	err = testPool.QueryRow(ctx,
+		`SELECT om.role, uam.provider_user_id FROM users u
+		 JOIN organization_memberships om ON om.user_id = u.id AND om.organization_id = $1
+		 JOIN user_auth_methods uam ON uam.user_id = u.id AND uam.provider = 'saml'
+		 WHERE u.email = 'testsamlacs@example.com'`,
+		orgID,
+	).Scan(&role, &providerUserID)
+	if err != nil {
+		t.Fatalf("Failed to find provisioned user: %v", err)
+	}
+	if role != "viewer" {
+		t.Errorf("Expected role 'viewer', got '%s'", role)
+	}
+	if providerUserID != orgID.String()+"|"+idp.EntityID+"|saml-user-1" {
+		t.Errorf("Expected org- and IdP-qualified provider user id, got '%s'", providerUserID)
+	}
+}
+
+// samlRequestID decodes the ID of the AuthnRequest in a redirect binding URL
+func samlRequestID(t *testing.T, location string) string {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("Invalid redirect URL: %v", err)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatalf("Invalid SAMLRequest encoding: %v", err)
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("Invalid SAMLRequest compression: %v", err)
+	}
+
+	var request struct {
+		ID string `xml:"ID,attr"`
+	}
+	if err := xml.Unmarshal(raw, &request); err != nil || request.ID == "" {
+		t.Fatalf("Failed to read AuthnRequest ID: %v", err)
+	}
+	return request.ID
+}
+
+func TestSAMLSSOSameEntityIDInAnotherOrg(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	const entityID = "https://shared-idp.example.com/metadata"
+
+	// The victim's org and its IdP, and another org whose admin configures the
+	// same entity ID with a certificate of their own
+	victimIdP, err := ssotest.NewSAMLIdP(entityID, "https://shared-idp.example.com/sso")
+	if err != nil {
+		t.Fatalf("Failed to create stub SAML IdP: %v", err)
+	}
+	attackerIdP, err := ssotest.NewSAMLIdP(entityID, "https://attacker.example.com/sso")
+	if err != nil {
+		t.Fatalf("Failed to create stub SAML IdP: %v", err)
+	}
+
+	orgIDs := map[string]uuid.UUID{}
+	for slug, idp := range map[string]*ssotest.SAMLIdP{
+		"test-org-saml-victim":   victimIdP,
+		"test-org-saml-attacker": attackerIdP,
+	} {
+		var orgID uuid.UUID
+		err := testPool.QueryRow(ctx,
+			`INSERT INTO organizations (name, slug) VALUES ($1, $1) RETURNING id`, slug,
+		).Scan(&orgID)
+		if err != nil {
+			t.Fatalf("Failed to create test org: %v", err)
+		}
+		defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+		_, err = testPool.Exec(ctx,
+			`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, idp_entity_id, idp_sso_url, idp_certificate, enabled)
+			 VALUES ($1, 'saml', '', '', $2, $3, $4, true)`,
+			orgID, idp.EntityID, idp.SSOURL, idp.CertificatePEM(),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create SSO config: %v", err)
+		}
+		orgIDs[slug] = orgID
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE email IN ('testsamlvictim@example.com', 'testsamlattacker@example.com')`)
+
+	handler := NewSAMLSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// login signs in through an org's ACS with a response from idp and
+	// returns the user the access token was issued to
+	login := func(orgSlug string, idp *ssotest.SAMLIdP, email string) uuid.UUID {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/api/auth/saml/"+orgSlug+"/metadata", nil)
+		req.SetPathValue("org", orgSlug)
+		w := httptest.NewRecorder()
+		handler.Metadata(w, req)
+		metadata := w.Body.Bytes()
+
+		req = httptest.NewRequest("GET", "/api/auth/saml/login?org="+orgSlug, nil)
+		w = httptest.NewRecorder()
+		handler.Login(w, req)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+		}
+		var relayState string
+		for _, c := range w.Result().Cookies() {
+			if c.Name == samlRequestCookie {
+				relayState = c.Value
+			}
+		}
+
+		samlResponse, err := idp.Respond(metadata, samlRequestID(t, w.Header().Get("Location")), "shared-name-id", map[string][]string{
+			"email": {email},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create SAML response: %v", err)
+		}
+
+		form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {relayState}}
+		req = httptest.NewRequest("POST", "/api/auth/saml/"+orgSlug+"/acs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("org", orgSlug)
+		req.AddCookie(&http.Cookie{Name: samlRequestCookie, Value: relayState})
+		w = httptest.NewRecorder()
+		handler.ACS(w, req)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected status 303, got %d: %s", w.Code, w.Body.String())
+		}
+
+		location := w.Header().Get("Location")
+		fragment := location[strings.Index(location, "#")+1:]
+		values, _ := url.ParseQuery(fragment)
+		claims, err := testJWTManager.VerifyAccessToken(values.Get("access_token"))
+		if err != nil {
+			t.Fatalf("Invalid access token in redirect: %v", err)
+		}
+		return claims.UserID
+	}
+
+	victimID := login("test-org-saml-victim", victimIdP, "testsamlvictim@example.com")
+
+	// The same NameID signed by the other org's IdP must not reach the victim's account
+	attackerID := login("test-org-saml-attacker", attackerIdP, "testsamlattacker@example.com")
+	if attackerID == victimID {
+		t.Fatal("Expected an assertion from another org's IdP not to sign in to the victim's account")
+	}
+
+	var isMember bool
+	err = testPool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM organization_memberships WHERE user_id = $1 AND organization_id = $2)`,
+		victimID, orgIDs["test-org-saml-attacker"],
+	).Scan(&isMember)
+	if err != nil {
+		t.Fatalf("Failed to check membership: %v", err)
+	}
+	if isMember {
+		t.Error("Expected the victim not to be added to the other org")
+	}
+
+	// Signing in again through the victim's org still reaches the victim
+	if userID := login("test-org-saml-victim", victimIdP, "testsamlvictim@example.com"); userID != victimID {
+		t.Errorf("Expected the victim's org to sign in to the victim's account, got user %s", userID)
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/audit"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso"
+)
+
+// SSOSettingsHandler manages an organization's SSO role mapping and login policy
+// (enforced SSO, MFA for admins)
+type SSOSettingsHandler struct {
+	pool  *pgxpool.Pool
+	audit *audit.Logger
+}
+
+func NewSSOSettingsHandler(pool *pgxpool.Pool, auditLog *audit.Logger) *SSOSettingsHandler {
+	return &SSOSettingsHandler{pool: pool, audit: auditLog}
+}
+
+// ssoSettingsSnapshot is the audit log's view of an org's SSO settings
+const ssoSettingsSnapshot = `SELECT to_jsonb(s) FROM sso_settings s WHERE organization_id = $1`
+
+// loadSSOSettings returns the org's SSO settings, or the defaults if none are saved
+func loadSSOSettings(ctx context.Context, pool *pgxpool.Pool, orgID uuid.UUID) (*models.SSOSettings, error) {
+	settings := models.SSOSettings{OrganizationID: orgID}
+	err := pool.QueryRow(ctx,
+		`SELECT enforce_sso, require_admin_mfa, role_mappings, default_role, break_glass_user_ids, updated_at
+		 FROM sso_settings WHERE organization_id = $1`,
+		orgID,
+	).Scan(&settings.EnforceSSO, &settings.RequireAdminMFA, &settings.RoleMappings, &settings.DefaultRole,
+		&settings.BreakGlassUserIDs, &settings.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		viewer := models.RoleViewer
+		return &models.SSOSettings{OrganizationID: orgID, RoleMappings: []models.GroupRoleMapping{}, DefaultRole: &viewer,
+			BreakGlassUserIDs: []uuid.UUID{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// ssoRole evaluates the org's role mapping rules for an identity signing in with
+// provider. It returns "" with ok true when no rules apply, leaving membership
+// as is, and ok false when rules apply but grant no role.
+func ssoRole(settings *models.SSOSettings, provider models.SSOProvider, identity *sso.Identity) (models.MembershipRole, bool) {
+	var mappings []models.GroupRoleMapping
+	for _, mapping := range settings.RoleMappings {
+		if mapping.Provider == "" || mapping.Provider == provider {
+			mappings = append(mappings, mapping)
+		}
+	}
+	if len(mappings) == 0 {
+		return "", true
+	}
+
+	var fallback models.MembershipRole
+	if settings.DefaultRole != nil {
+		fallback = *settings.DefaultRole
+	}
+	role := sso.ResolveIdentityRole(identity, mappings, fallback)
+	return role, role != ""
+}
+
+// passwordLoginDisabled reports whether the user belongs to an organization
+// that enforces SSO and has an SSO provider or directory enabled. Admins the
+// organization lists as break-glass accounts are exempt.
+func passwordLoginDisabled(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (bool, error) {
+	var disabled bool
+	err := pool.QueryRow(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM organization_memberships om
+			JOIN sso_settings s ON s.organization_id = om.organization_id AND s.enforce_sso
+			WHERE om.user_id = $1
+			  AND NOT (om.role = 'admin' AND om.user_id = ANY(s.break_glass_user_ids))
+			  AND (
+				EXISTS (SELECT 1 FROM sso_configs c WHERE c.organization_id = om.organization_id AND c.enabled)
+				OR EXISTS (SELECT 1 FROM ldap_configs l WHERE l.organization_id = om.organization_id AND l.enabled)
+			  )
+		)`,
+		userID,
+	).Scan(&disabled)
+	return disabled, err
+}
+
+// ssoEnabled reports whether the org has any SSO provider or directory enabled
+func ssoEnabled(ctx context.Context, pool *pgxpool.Pool, orgID uuid.UUID) (bool, error) {
+	var enabled bool
+	err := pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sso_configs WHERE organization_id = $1 AND enabled)
+		     OR EXISTS (SELECT 1 FROM ldap_configs WHERE organization_id = $1 AND enabled)`,
+		orgID,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// GetSettings returns the SSO settings for an organization
+func (h *SSOSettingsHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	settings, err := loadSSOSettings(ctx, h.pool, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get SSO settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateSettings updates the SSO role mapping rules and login policy for an organization
+func (h *SSOSettingsHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	// Get org ID from path
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Get current user from context
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Check if user is admin of org
+	if !requireSSOAdmin(ctx, h.pool, w, userID, orgID) {
+		return
+	}
+
+	var req models.UpdateSSOSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSSOSettings(ctx, h.pool, orgID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get SSO settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if req.RoleMappings != nil {
+		for _, mapping := range req.RoleMappings {
+			if mapping.Group == "" || !sso.ValidRole(mapping.Role) {
+				http.Error(w, `{"error":"role mappings need a group and a role of admin, editor or viewer"}`, http.StatusBadRequest)
+				return
+			}
+			switch mapping.Provider {
+			case "", models.SSOGoogle, models.SSOMicrosoft, models.SSOOIDC, models.SSOSAML:
+			default:
+				http.Error(w, `{"error":"role mapping provider must be google, microsoft, oidc or saml"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		settings.RoleMappings = req.RoleMappings
+	}
+	if req.DefaultRole != nil {
+		if !sso.ValidRole(*req.DefaultRole) {
+			http.Error(w, `{"error":"default_role must be admin, editor or viewer"}`, http.StatusBadRequest)
+			return
+		}
+		settings.DefaultRole = req.DefaultRole
+	} else if req.ClearDefaultRole {
+		settings.DefaultRole = nil
+	}
+	if req.EnforceSSO != nil {
+		settings.EnforceSSO = *req.EnforceSSO
+	}
+	if req.RequireAdminMFA != nil {
+		settings.RequireAdminMFA = *req.RequireAdminMFA
+	}
+	if req.BreakGlassUserIDs != nil {
+		// Only admins can be exempt, and only while they stay admins
+		var admins int
+		err := h.pool.QueryRow(ctx,
+			`SELECT COUNT(*) FROM organization_memberships
+			 WHERE organization_id = $1 AND role = 'admin' AND user_id = ANY($2)`,
+			orgID, req.BreakGlassUserIDs,
+		).Scan(&admins)
+		if err != nil {
+			http.Error(w, `{"error":"failed to check break-glass users"}`, http.StatusInternalServerError)
+			return
+		}
+		if admins != len(req.BreakGlassUserIDs) {
+			http.Error(w, `{"error":"break-glass users must be admins of the organization"}`, http.StatusBadRequest)
+			return
+		}
+		settings.BreakGlassUserIDs = req.BreakGlassUserIDs
+	}
+
+	if settings.EnforceSSO {
+		// Enforcing SSO without a way to sign in would lock every member out
+		enabled, err := ssoEnabled(ctx, h.pool, orgID)
+		if err != nil {
+			http.Error(w, `{"error":"failed to check SSO configuration"}`, http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			http.Error(w, `{"error":"enable an SSO provider before enforcing SSO"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	before := auditSnapshot(ctx, h.pool, ssoSettingsSnapshot, orgID)
+	err = h.pool.QueryRow(ctx,
+		`INSERT INTO sso_settings (organization_id, enforce_sso, role_mappings, default_role, require_admin_mfa, break_glass_user_ids)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (organization_id) DO UPDATE
+		 SET enforce_sso = $2, role_mappings = $3, default_role = $4, require_admin_mfa = $5, break_glass_user_ids = $6,
+		     updated_at = NOW()
+		 RETURNING updated_at`,
+		orgID, settings.EnforceSSO, settings.RoleMappings, settings.DefaultRole, settings.RequireAdminMFA, settings.BreakGlassUserIDs,
+	).Scan(&settings.UpdatedAt)
+	if err != nil {
+		http.Error(w, `{"error":"failed to save SSO settings"}`, http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.audit, &orgID, "sso.settings_update", "sso_settings", orgID.String(),
+		before, auditSnapshot(ctx, h.pool, ssoSettingsSnapshot, orgID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
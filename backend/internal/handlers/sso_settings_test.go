@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso/ssotest"
+)
+
+func TestSSOSettingsEnforceRequiresProvider(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org SSO Settings', 'test-org-sso-settings') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	var userID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO users (email, name) VALUES ('testssosettings@example.com', 'Test SSO Settings') RETURNING id`,
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role) VALUES ($1, $2, 'admin')`,
+		userID, orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+
+	token, err := testJWTManager.GenerateAccessToken(userID, "testssosettings@example.com", "Test SSO Settings")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	handler := NewSSOSettingsHandler(testPool, nil)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/orgs/"+orgID.String()+"/sso/settings", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.SetPathValue("id", orgID.String())
+		w := httptest.NewRecorder()
+		auth.RequireAuth(testJWTManager, handler.UpdateSettings)(w, req)
+		return w
+	}
+
+	if w := update(`{"role_mappings":[{"group":"admins","role":"owner"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid role, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := update(`{"enforce_sso":true}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when enforcing SSO without a provider, got %d: %s", w.Code, w.Body.String())
+	}
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, enabled)
+		 VALUES ($1, 'google', 'client-id', 'client-secret', true)`,
+		orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	w := update(`{"enforce_sso":true,"role_mappings":[{"group":"Dash.Admin","claim":"roles","role":"admin"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var settings models.SSOSettings
+	if err := json.NewDecoder(w.Body).Decode(&settings); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !settings.EnforceSSO {
+		t.Error("Expected enforce_sso to be true")
+	}
+	if len(settings.RoleMappings) != 1 || settings.RoleMappings[0].Claim != "roles" {
+		t.Errorf("Expected the saved role mapping, got %+v", settings.RoleMappings)
+	}
+	if settings.DefaultRole == nil || *settings.DefaultRole != models.RoleViewer {
+		t.Errorf("Expected default role 'viewer', got %v", settings.DefaultRole)
+	}
+
+	// Only the org's admins can be break-glass accounts
+	if w := update(`{"break_glass_user_ids":["` + uuid.NewString() + `"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a break-glass user outside the org, got %d: %s", w.Code, w.Body.String())
+	}
+	w = update(`{"break_glass_user_ids":["` + userID.String() + `"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	json.NewDecoder(w.Body).Decode(&settings)
+	if len(settings.BreakGlassUserIDs) != 1 || settings.BreakGlassUserIDs[0] != userID {
+		t.Errorf("Expected the admin to be a break-glass user, got %v", settings.BreakGlassUserIDs)
+	}
+}
+
+func TestPasswordLoginBlockedWhenSSOEnforced(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	ctx := context.Background()
+	testPool.Exec(ctx, "DELETE FROM users WHERE email = 'testenforcedsso@example.com'")
+	defer testPool.Exec(ctx, "DELETE FROM users WHERE email = 'testenforcedsso@example.com'")
+
+	regBody := `{"email":"testenforcedsso@example.com","password":"TestPassword123!","name":"Test Enforced"}`
+	regReq := httptest.NewRequest("POST", "/api/auth/register", bytes.NewBufferString(regBody))
+	regW := httptest.NewRecorder()
+	testAuthHandler.Register(regW, regReq)
+	if regW.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d", regW.Code)
+	}
+
+	var orgID uuid.UUID
+	err := testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org Enforced SSO', 'test-org-enforced-sso') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO organization_memberships (user_id, organization_id, role)
+		 SELECT id, $1, 'editor' FROM users WHERE email = 'testenforcedsso@example.com'`,
+		orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to add membership: %v", err)
+	}
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, enabled)
+		 VALUES ($1, 'google', 'client-id', 'client-secret', true)`,
+		orgID,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	login := func() *httptest.ResponseRecorder {
+		loginBody := `{"email":"testenforcedsso@example.com","password":"TestPassword123!"}`
+		loginReq := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(loginBody))
+		loginW := httptest.NewRecorder()
+		testAuthHandler.Login(loginW, loginReq)
+		return loginW
+	}
+
+	// SSO enabled but not enforced: password login still works
+	if w := login(); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 before enforcement, got %d: %s", w.Code, w.Body.String())
+	}
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_settings (organization_id, enforce_sso) VALUES ($1, true)`, orgID)
+	if err != nil {
+		t.Fatalf("Failed to enforce SSO: %v", err)
+	}
+
+	// Members can't sign in with a password, however their account was created
+	if w := login(); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 with SSO enforced, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Break-glass accounts must be admins to be exempt
+	_, err = testPool.Exec(ctx,
+		`UPDATE sso_settings SET break_glass_user_ids = ARRAY(SELECT id FROM users WHERE email = 'testenforcedsso@example.com')
+		 WHERE organization_id = $1`, orgID)
+	if err != nil {
+		t.Fatalf("Failed to set break-glass users: %v", err)
+	}
+	if w := login(); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a break-glass editor, got %d: %s", w.Code, w.Body.String())
+	}
+
+	testPool.Exec(ctx, `UPDATE organization_memberships SET role = 'admin' WHERE organization_id = $1`, orgID)
+	if w := login(); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for a break-glass admin, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCSSOCallbackAppliesRoleMappings(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewIdP("roles-client-id")
+	if err != nil {
+		t.Fatalf("Failed to start stub IdP: %v", err)
+	}
+	defer idp.Close()
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org SSO Roles', 'test-org-sso-roles') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE email = 'testssoroles@example.com'`)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, issuer_url, enabled)
+		 VALUES ($1, 'oidc', 'roles-client-id', 'roles-secret', $2, true)`,
+		orgID, idp.Issuer(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	mappings, _ := json.Marshal([]models.GroupRoleMapping{
+		{Group: "dash-admins", Role: models.RoleAdmin},
+		{Group: "dash-editors", Role: models.RoleEditor},
+	})
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_settings (organization_id, role_mappings, default_role) VALUES ($1, $2, NULL)`,
+		orgID, mappings,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO settings: %v", err)
+	}
+
+	handler := NewOIDCSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// signIn runs the login round trip with the given groups and returns the callback response
+	signIn := func(groups []interface{}) *httptest.ResponseRecorder {
+		idp.SetClaims(map[string]interface{}{
+			"sub":            "oidc-roles-user",
+			"email":          "testssoroles@example.com",
+			"email_verified": true,
+			"name":           "Test SSO Roles",
+			"groups":         groups,
+		})
+
+		req := httptest.NewRequest("GET", "/api/auth/oidc/login?org=test-org-sso-roles", nil)
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var stateCookie *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "oidc_oauth_state" {
+				stateCookie = c
+			}
+		}
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Failed to call IdP authorize endpoint: %v", err)
+		}
+		resp.Body.Close()
+		callbackURL, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("Invalid callback URL: %v", err)
+		}
+
+		req = httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callbackURL.RawQuery, nil)
+		req.AddCookie(stateCookie)
+		w = httptest.NewRecorder()
+		handler.Callback(w, req)
+		return w
+	}
+
+	roleOf := func() string {
+		var role string
+		testPool.QueryRow(ctx,
+			`SELECT om.role FROM organization_memberships om JOIN users u ON u.id = om.user_id
+			 WHERE om.organization_id = $1 AND u.email = 'testssoroles@example.com'`,
+			orgID,
+		).Scan(&role)
+		return role
+	}
+
+	if w := signIn([]interface{}{"dash-admins", "dash-editors"}); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+	}
+	if role := roleOf(); role != "admin" {
+		t.Errorf("Expected role 'admin', got '%s'", role)
+	}
+
+	// Roles are re-evaluated on the next login
+	if w := signIn([]interface{}{"dash-editors"}); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+	}
+	if role := roleOf(); role != "editor" {
+		t.Errorf("Expected role 'editor', got '%s'", role)
+	}
+
+	// No matching group and no default role denies access
+	if w := signIn([]interface{}{"other"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCSSOCallbackRequiresAdminMFA(t *testing.T) {
+	if testPool == nil {
+		t.Skip("Database not available")
+	}
+
+	idp, err := ssotest.NewIdP("mfa-client-id")
+	if err != nil {
+		t.Fatalf("Failed to start stub IdP: %v", err)
+	}
+	defer idp.Close()
+
+	ctx := context.Background()
+
+	var orgID uuid.UUID
+	err = testPool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug) VALUES ('Test Org SSO MFA', 'test-org-sso-mfa') RETURNING id`,
+	).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create test org: %v", err)
+	}
+	defer testPool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID)
+	defer testPool.Exec(ctx, `DELETE FROM users WHERE email = 'testssomfa@example.com'`)
+
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_configs (organization_id, provider, client_id, client_secret, issuer_url, enabled)
+		 VALUES ($1, 'oidc', 'mfa-client-id', 'mfa-secret', $2, true)`,
+		orgID, idp.Issuer(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO config: %v", err)
+	}
+
+	mappings, _ := json.Marshal([]models.GroupRoleMapping{
+		{Group: "dash-admins", Role: models.RoleAdmin},
+		{Group: "dash-editors", Role: models.RoleEditor},
+	})
+	_, err = testPool.Exec(ctx,
+		`INSERT INTO sso_settings (organization_id, role_mappings, default_role, require_admin_mfa) VALUES ($1, $2, NULL, true)`,
+		orgID, mappings,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create SSO settings: %v", err)
+	}
+
+	handler := NewOIDCSSOHandler(testPool, testJWTManager, nil, nil)
+
+	// signIn runs the login round trip with the given groups and returns the
+	// parameters in the fragment of the redirect to the frontend
+	signIn := func(groups []interface{}) url.Values {
+		idp.SetClaims(map[string]interface{}{
+			"sub":            "oidc-mfa-user",
+			"email":          "testssomfa@example.com",
+			"email_verified": true,
+			"name":           "Test SSO MFA",
+			"groups":         groups,
+		})
+
+		req := httptest.NewRequest("GET", "/api/auth/oidc/login?org=test-org-sso-mfa", nil)
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var stateCookie *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "oidc_oauth_state" {
+				stateCookie = c
+			}
+		}
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Failed to call IdP authorize endpoint: %v", err)
+		}
+		resp.Body.Close()
+		callbackURL, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("Invalid callback URL: %v", err)
+		}
+
+		req = httptest.NewRequest("GET", "/api/auth/oidc/callback?"+callbackURL.RawQuery, nil)
+		req.AddCookie(stateCookie)
+		w = httptest.NewRecorder()
+		handler.Callback(w, req)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("Expected status 307, got %d: %s", w.Code, w.Body.String())
+		}
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Invalid redirect URL: %v", err)
+		}
+		fragment, _ := url.ParseQuery(location.Fragment)
+		return fragment
+	}
+
+	// Non-admins sign in without a second factor
+	if fragment := signIn([]interface{}{"dash-editors"}); fragment.Get("access_token") == "" {
+		t.Fatalf("Expected an access token for an editor, got %v", fragment)
+	}
+
+	// Admins without a factor must enrol one before they get tokens
+	fragment := signIn([]interface{}{"dash-admins"})
+	if fragment.Get("access_token") != "" {
+		t.Fatal("Expected no access token for an admin without MFA")
+	}
+	if fragment.Get("mfa_enrollment_required") != "true" {
+		t.Errorf("Expected an MFA enrolment challenge, got %v", fragment)
+	}
+	claims, err := testJWTManager.VerifyMFAToken(fragment.Get("mfa_token"))
+	if err != nil || !claims.Enroll {
+		t.Errorf("Expected a valid enrolment MFA token, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestSSORedirectAllowed(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "https://dash.example.com")
+	t.Setenv("SSO_REDIRECT_ALLOWLIST", "https://admin.example.com/app/, http://localhost:3000")
+
+	base := newSSOBase(nil, nil, "", nil)
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"https://dash.example.com/auth/callback", true},
+		{"https://DASH.example.com/dashboards/1", true},
+		{"https://admin.example.com/app/callback", true},
+		{"https://admin.example.com/app", true},
+		{"https://admin.example.com/application", false},
+		{"https://admin.example.com/other", false},
+		{"http://localhost:3000/callback", true},
+		{"http://dash.example.com/auth/callback", false},
+		{"https://dash.example.com.evil.com/auth/callback", false},
+		{"https://user@dash.example.com/auth/callback", false},
+		{"//dash.example.com/auth/callback", false},
+		{"/auth/callback", false},
+		{"javascript:alert(1)", false},
+	}
+
+	for _, tt := range tests {
+		if got := base.redirectAllowed(tt.target); got != tt.want {
+			t.Errorf("redirectAllowed(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+
+	if got := base.loginRedirectURL("https://evil.com/"); got != "https://dash.example.com/auth/callback" {
+		t.Errorf("Expected disallowed redirect to fall back to the frontend, got %s", got)
+	}
+}
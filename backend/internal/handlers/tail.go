@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/auth"
+	"github.com/janhoon/dash/backend/internal/datasource"
+)
+
+// Live tail connection settings
+const (
+	// A client that can't take an event within tailWriteTimeout is too slow
+	// to keep up and is disconnected
+	tailWriteTimeout = 10 * time.Second
+	// Comments keep idle streams open through proxies
+	tailHeartbeat = 15 * time.Second
+	// Membership and the token are rechecked while streaming, so removed users
+	// and revoked tokens are cut off
+	tailRecheckInterval = time.Minute
+	// Lines already waiting are sent together, up to maxTailEventEntries
+	maxTailEventEntries = 500
+)
+
+// Default live tail connection limits
+const (
+	DefaultMaxTailConnections        = 200
+	DefaultMaxTailConnectionsPerUser = 5
+)
+
+// tailSlots caps the open tail connections, in total and per user
+type tailSlots struct {
+	mu         sync.Mutex
+	max        int
+	maxPerUser int
+	total      int
+	users      map[uuid.UUID]int
+}
+
+func newTailSlots(max, maxPerUser int) *tailSlots {
+	return &tailSlots{max: max, maxPerUser: maxPerUser, users: make(map[uuid.UUID]int)}
+}
+
+// acquire takes a slot for the user, returning false when a limit is reached
+func (s *tailSlots) acquire(userID uuid.UUID) (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.total >= s.max || s.users[userID] >= s.maxPerUser {
+		return nil, false
+	}
+	s.total++
+	s.users[userID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.total--
+			if s.users[userID]--; s.users[userID] == 0 {
+				delete(s.users, userID)
+			}
+		})
+	}, true
+}
+
+type TailHandler struct {
+	pool     *pgxpool.Pool
+	denylist *auth.Denylist // ends streams opened with tokens revoked since
+	slots    *tailSlots
+}
+
+func NewTailHandler(pool *pgxpool.Pool, denylist *auth.Denylist, maxConnections, maxPerUser int) *TailHandler {
+	return &TailHandler{pool: pool, denylist: denylist, slots: newTailSlots(maxConnections, maxPerUser)}
+}
+
+func (h *TailHandler) checkOrgMembership(ctx context.Context, userID, orgID uuid.UUID) (string, error) {
+	if role, ok := tokenOrgRole(ctx, userID, orgID); ok {
+		return role, nil
+	}
+
+	var role string
+	err := h.pool.QueryRow(ctx,
+		`SELECT role FROM organization_memberships WHERE user_id = $1 AND organization_id = $2`,
+		userID, orgID,
+	).Scan(&role)
+	return role, err
+}
+
+// stillMember rechecks membership in the database, as a token's org role
+// doesn't change while a stream is open
+func (h *TailHandler) stillMember(ctx context.Context, userID, orgID uuid.UUID) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := h.pool.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM organization_memberships WHERE user_id = $1 AND organization_id = $2)`,
+		userID, orgID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// tokenError returns why the token a stream was opened with no longer
+// authorizes it, or "" while it still does
+func (h *TailHandler) tokenError(ctx context.Context, claims *auth.TokenClaims, now time.Time) string {
+	if claims == nil {
+		return ""
+	}
+	if claims.ExpiresAt != nil && !now.Before(claims.ExpiresAt.Time) {
+		return "token has expired"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if h.denylist.Revoked(ctx, claims) {
+		return "token has been revoked"
+	}
+	return ""
+}
+
+// Tail streams new log lines from a Loki or Victoria Logs datasource as
+// server-sent events. Each "logs" event carries a datasource.TailBatch; an
+// "error" event ends the stream. Optional start is in Unix seconds and
+// defaults to now.
+func (h *TailHandler) Tail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid datasource id"}`, http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		http.Error(w, `{"error":"query is required"}`, http.StatusBadRequest)
+		return
+	}
+	start := time.Now()
+	if v := r.URL.Query().Get("start"); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, `{"error":"invalid start"}`, http.StatusBadRequest)
+			return
+		}
+		start = time.Unix(sec, 0)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ds, err := alerting.LoadDataSource(ctx, h.pool, id)
+	if err != nil {
+		cancel()
+		http.Error(w, `{"error":"datasource not found"}`, http.StatusNotFound)
+		return
+	}
+	_, err = h.checkOrgMembership(ctx, userID, ds.OrganizationID)
+	cancel()
+	if err != nil {
+		http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+		return
+	}
+
+	client, err := datasource.NewClient(*ds)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to create datasource client: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	tailer, ok := client.(datasource.LogTailer)
+	if !ok {
+		http.Error(w, `{"error":"tailing needs a Loki or Victoria Logs datasource"}`, http.StatusBadRequest)
+		return
+	}
+
+	release, ok := h.slots.acquire(userID)
+	if !ok {
+		http.Error(w, `{"error":"too many tail connections"}`, http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	streamCtx, stop := context.WithCancel(r.Context())
+	batches := make(chan datasource.TailBatch, 16)
+	tailErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		tailErr <- tailer.Tail(streamCtx, query, start, batches)
+	}()
+	// Wait for the upstream connection to close before giving up the slot
+	defer func() {
+		stop()
+		for range batches {
+		}
+	}()
+
+	rc := http.NewResponseController(w)
+	send := func(event string, data any) error {
+		rc.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+		if event == "" {
+			fmt.Fprint(w, ": keepalive\n\n")
+		} else {
+			payload, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		}
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := send("", nil); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+	recheck := time.NewTicker(tailRecheckInterval)
+	defer recheck.Stop()
+	// The stream ends when the token it was opened with expires
+	claims, _ := auth.GetClaims(r.Context())
+	var expired <-chan time.Time
+	if claims != nil && claims.ExpiresAt != nil {
+		expiry := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	for {
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				if err := <-tailErr; err != nil {
+					send("error", ErrorResponse{Status: "error", Error: err.Error()})
+				}
+				return
+			}
+			// Coalesce what's already waiting into one event
+		coalesce:
+			for len(batch.Entries) < maxTailEventEntries {
+				select {
+				case next, ok := <-batches:
+					if !ok {
+						break coalesce
+					}
+					batch.Entries = append(batch.Entries, next.Entries...)
+					batch.Dropped += next.Dropped
+				default:
+					break coalesce
+				}
+			}
+			if err := send("logs", batch); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := send("", nil); err != nil {
+				return
+			}
+		case <-expired:
+			send("error", ErrorResponse{Status: "error", Error: "token has expired"})
+			return
+		case <-recheck.C:
+			if msg := h.tokenError(r.Context(), claims, time.Now()); msg != "" {
+				send("error", ErrorResponse{Status: "error", Error: msg})
+				return
+			}
+			if !h.stillMember(r.Context(), userID, ds.OrganizationID) {
+				send("error", ErrorResponse{Status: "error", Error: "not a member of this organization"})
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
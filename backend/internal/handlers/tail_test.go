@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/auth"
+)
+
+func TestTailHandler_Unauthorized(t *testing.T) {
+	handler := NewTailHandler(nil, nil, 1, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/datasources/test/tail?query=x", nil)
+	req.SetPathValue("id", "not-a-uuid")
+	rr := httptest.NewRecorder()
+
+	handler.Tail(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestTailSlots(t *testing.T) {
+	slots := newTailSlots(3, 2)
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+
+	releaseA1, ok := slots.acquire(alice)
+	if !ok {
+		t.Fatal("Expected a first slot")
+	}
+	if _, ok := slots.acquire(alice); !ok {
+		t.Fatal("Expected a second slot for the same user")
+	}
+	if _, ok := slots.acquire(alice); ok {
+		t.Error("Expected the per-user limit to apply")
+	}
+	if _, ok := slots.acquire(bob); !ok {
+		t.Fatal("Expected a slot for another user")
+	}
+	if _, ok := slots.acquire(carol); ok {
+		t.Error("Expected the total limit to apply")
+	}
+
+	releaseA1()
+	releaseA1()
+	if _, ok := slots.acquire(carol); !ok {
+		t.Error("Expected a released slot to be reusable")
+	}
+	if _, ok := slots.acquire(bob); ok {
+		t.Error("Expected releasing twice to free only one slot")
+	}
+}
+
+func TestTailTokenRecheck(t *testing.T) {
+	jwtManager, err := auth.GenerateJWTManager()
+	if err != nil {
+		t.Fatalf("Failed to generate JWT manager: %v", err)
+	}
+	denylist := auth.NewDenylist(nil)
+	handler := NewTailHandler(nil, denylist, 1, 1)
+	ctx := context.Background()
+
+	token, err := jwtManager.GenerateAccessToken(uuid.New(), "testtail@example.com", "Test")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := jwtManager.VerifyAccessToken(token)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+
+	if msg := handler.tokenError(ctx, claims, time.Now()); msg != "" {
+		t.Errorf("Expected a fresh token to keep the stream open, got %q", msg)
+	}
+	if msg := handler.tokenError(ctx, claims, claims.ExpiresAt.Time); msg != "token has expired" {
+		t.Errorf("Expected the stream to end once the token expires, got %q", msg)
+	}
+
+	if err := denylist.RevokeToken(ctx, claims); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if msg := handler.tokenError(ctx, claims, time.Now()); msg != "token has been revoked" {
+		t.Errorf("Expected the stream to end once the token is revoked, got %q", msg)
+	}
+}
@@ -0,0 +1,249 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends transactional email (verification, password reset, ...)
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv builds the mailer selected by MAIL_DRIVER:
+//   - smtp: SMTP_HOST, SMTP_PORT (587), SMTP_USERNAME, SMTP_PASSWORD
+//   - file: appends messages to MAIL_FILE
+//   - log: writes messages to the server log, with link tokens redacted
+//
+// MAIL_FROM sets the sender for all drivers. Without MAIL_DRIVER there is no
+// mailer (nil), which disables email verification, password reset and
+// invitation emails.
+func NewFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Dash <noreply@localhost>"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("SMTP_HOST is required for the smtp mail driver")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(host, port),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			return nil, errors.New("MAIL_FILE is required for the file mail driver")
+		}
+		return NewFileMailer(path, from), nil
+	case "":
+		return nil, nil
+	case "log":
+		return NewLogMailer(log.Writer(), from), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// format renders msg as an RFC 5322 message
+func format(from string, msg Message, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// validate rejects messages that could inject extra headers
+func validate(msg Message) error {
+	if msg.To == "" {
+		return errors.New("message has no recipient")
+	}
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("message headers must not contain line breaks")
+	}
+	return nil
+}
+
+// SMTPMailer sends mail through an SMTP server, upgrading to TLS with STARTTLS
+// when the server supports it
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	envelopeFrom := m.From
+	if addr, err := parseAddress(m.From); err == nil {
+		envelopeFrom = addr
+	}
+
+	// net/smtp has no context support, so bound the send by the context instead
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, envelopeFrom, []string{msg.To}, format(m.From, msg, time.Now()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseAddress extracts the bare address from "Name <addr>"
+func parseAddress(from string) (string, error) {
+	start, end := strings.LastIndex(from, "<"), strings.LastIndex(from, ">")
+	if start >= 0 && end > start {
+		return from[start+1 : end], nil
+	}
+	if strings.Contains(from, "@") {
+		return strings.TrimSpace(from), nil
+	}
+	return "", fmt.Errorf("invalid sender address %q", from)
+}
+
+// WriterMailer writes messages to a writer instead of delivering them, for
+// local development and tests
+type WriterMailer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	from   string
+	redact bool
+}
+
+func NewWriterMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{w: w, from: from}
+}
+
+// NewLogMailer returns a WriterMailer that redacts the tokens in links, so
+// whoever can read the log can't use them to reset passwords or join orgs
+func NewLogMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{w: w, from: from, redact: true}
+}
+
+// linkToken matches the token query parameter of links in email bodies
+var linkToken = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	if m.redact {
+		msg.Body = linkToken.ReplaceAllString(msg.Body, "${1}REDACTED")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "----- email -----\r\n%s----- end email -----\r\n", format(m.from, msg, time.Now()))
+	return err
+}
+
+// FileMailer appends messages to a file
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "----- email -----\r\n%s----- end email -----\r\n", format(m.from, msg, time.Now()))
+	return err
+}
+
+// CaptureMailer keeps messages in memory instead of delivering them, for tests
+// and local tooling. Sent receives each message as it is sent.
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []Message
+	Sent     chan Message
+}
+
+func NewCaptureMailer() *CaptureMailer {
+	return &CaptureMailer{Sent: make(chan Message, 100)}
+}
+
+func (m *CaptureMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	m.mu.Unlock()
+
+	select {
+	case m.Sent <- msg:
+	default:
+	}
+	return nil
+}
+
+// Messages returns every message sent so far
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
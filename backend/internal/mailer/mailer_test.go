@@ -0,0 +1,171 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{To: "alice@example.com", Subject: "Reset your password", Body: "line one\nline two"}
+	out := string(format("Dash <noreply@example.com>", msg, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	for _, want := range []string{
+		"From: Dash <noreply@example.com>\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected message to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestValidateRejectsHeaderInjection(t *testing.T) {
+	tests := []Message{
+		{To: "", Subject: "hi"},
+		{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "hi"},
+		{To: "alice@example.com", Subject: "hi\nBcc: eve@example.com"},
+	}
+	for _, msg := range tests {
+		if err := validate(msg); err == nil {
+			t.Errorf("Expected %+v to be rejected", msg)
+		}
+	}
+}
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewWriterMailer(&buf, "noreply@example.com")
+
+	if err := m.Send(context.Background(), Message{To: "bob@example.com", Subject: "Hello", Body: "token=abc"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "To: bob@example.com") || !strings.Contains(buf.String(), "token=abc") {
+		t.Errorf("Unexpected output: %s", buf.String())
+	}
+}
+
+func TestLogMailerRedactsLinkTokens(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "noreply@example.com")
+
+	body := "Reset your password: https://dash.example.com/reset-password?token=s3cret&x=1\nOr https://dash.example.com/invitations/accept?token=0ther"
+	if err := m.Send(context.Background(), Message{To: "bob@example.com", Subject: "Reset", Body: body}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "s3cret") || strings.Contains(buf.String(), "0ther") {
+		t.Errorf("Expected link tokens to be redacted, got: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "reset-password?token=REDACTED&x=1") {
+		t.Errorf("Expected the link to be kept with its token redacted, got: %s", buf.String())
+	}
+}
+
+func TestNewFromEnvWithoutDriver(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "")
+	m, err := NewFromEnv()
+	if err != nil {
+		t.Fatalf("NewFromEnv failed: %v", err)
+	}
+	if m != nil {
+		t.Errorf("Expected no mailer without MAIL_DRIVER, got %T", m)
+	}
+}
+
+func TestFileMailerAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := NewFileMailer(path, "noreply@example.com")
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := m.Send(context.Background(), Message{To: to, Subject: "Hi", Body: "body"}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read mail file: %v", err)
+	}
+	if strings.Count(string(data), "----- email -----") != 2 {
+		t.Errorf("Expected 2 messages in file, got:\n%s", data)
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	// Minimal SMTP server that records the envelope and data
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		var transcript strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			transcript.WriteString(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					data, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if data == ".\r\n" {
+						break
+					}
+					transcript.WriteString(data)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	m := &SMTPMailer{Addr: ln.Addr().String(), From: "Dash <noreply@example.com>"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, Message{To: "carol@example.com", Subject: "Verify", Body: "hello"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	transcript := <-received
+	for _, want := range []string{"MAIL FROM:<noreply@example.com>", "RCPT TO:<carol@example.com>", "Subject: Verify", "hello"} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("Expected transcript to contain %q, got:\n%s", want, transcript)
+		}
+	}
+}
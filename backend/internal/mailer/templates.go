@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Template names, one file each in templates/ defining "subject" and "body"
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplatePasswordReset = "password_reset"
+	TemplateInvitation    = "invitation"
+	TemplateWelcome       = "welcome"
+	TemplateRoleChanged   = "role_changed"
+)
+
+// LinkData is used by templates that only carry a link
+type LinkData struct {
+	Link string
+}
+
+// InvitationData fills the invitation template
+type InvitationData struct {
+	OrgName     string
+	InviterName string
+	Role        string
+	Link        string
+	ExpiresAt   time.Time
+}
+
+// WelcomeData fills the welcome template sent when a user joins an organization
+type WelcomeData struct {
+	Name    string
+	OrgName string
+	Role    string
+	Link    string
+}
+
+// RoleChangedData fills the role change template
+type RoleChangedData struct {
+	Name      string
+	OrgName   string
+	OldRole   string
+	NewRole   string
+	ChangedBy string
+	Link      string
+}
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = loadTemplates()
+
+func loadTemplates() map[string]*template.Template {
+	entries, err := templateFS.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := make(map[string]*template.Template, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".tmpl")
+		loaded[name] = template.Must(template.New(name).Option("missingkey=error").ParseFS(templateFS, path.Join("templates", entry.Name())))
+	}
+	return loaded
+}
+
+// Render builds a message to the recipient from a named template
+func Render(to, name string, data interface{}) (Message, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+	}, nil
+}
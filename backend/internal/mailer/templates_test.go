@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    interface{}
+		subject string
+		body    []string
+	}{
+		{TemplateVerifyEmail, LinkData{Link: "https://dash.example.com/verify-email?token=abc"}, "Verify your email address",
+			[]string{"https://dash.example.com/verify-email?token=abc"}},
+		{TemplatePasswordReset, LinkData{Link: "https://dash.example.com/reset-password?token=abc"}, "Reset your password",
+			[]string{"reset-password?token=abc", "1 hour"}},
+		{TemplateInvitation, InvitationData{OrgName: "Acme", InviterName: "Alice", Role: "editor", Link: "https://dash.example.com/i", ExpiresAt: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)},
+			"You're invited to join Acme on Dash", []string{"Alice invited you to join Acme on Dash as editor", "9 March 2024"}},
+		{TemplateWelcome, WelcomeData{Name: "Bob", OrgName: "Acme", Role: "viewer", Link: "https://dash.example.com"},
+			"Welcome to Acme on Dash", []string{"Hi Bob,", "as viewer"}},
+		{TemplateRoleChanged, RoleChangedData{OrgName: "Acme", OldRole: "viewer", NewRole: "admin", ChangedBy: "Alice", Link: "https://dash.example.com"},
+			"Your role in Acme has changed", []string{"Hi,", "from viewer to admin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Render("bob@example.com", tt.name, tt.data)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			if msg.To != "bob@example.com" {
+				t.Errorf("Expected recipient bob@example.com, got %q", msg.To)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("Expected subject %q, got %q", tt.subject, msg.Subject)
+			}
+			for _, want := range tt.body {
+				if !strings.Contains(msg.Body, want) {
+					t.Errorf("Expected body to contain %q, got:\n%s", want, msg.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("bob@example.com", "nope", nil); err == nil {
+		t.Error("Expected an error for an unknown template")
+	}
+}
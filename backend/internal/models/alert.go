@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AlertState string
+
+const (
+	AlertPending  AlertState = "pending"
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+// Rule health after the last evaluation
+const (
+	AlertHealthUnknown = "unknown"
+	AlertHealthOK      = "ok"
+	AlertHealthError   = "error"
+)
+
+// Alert rule query types. Metrics rules run a metrics query, or a metric
+// query over logs (LogQL count_over_time, LogsQL stats); logs rules count the
+// lines matching a log query.
+const (
+	AlertQueryMetrics = "metrics"
+	AlertQueryLogs    = "logs"
+)
+
+// MaxAlertLogSamples is how many matching log lines are kept with an alert
+const MaxAlertLogSamples = 5
+
+// LogSample is a log line behind an alert from a log datasource
+type LogSample struct {
+	Timestamp string            `json:"timestamp"`
+	Line      string            `json:"line"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// AlertCondition reduces each series returned by the rule's query to one value
+// and compares it with the threshold. Logs rules compare the number of
+// matching lines, so their reducer is always count.
+type AlertCondition struct {
+	Reducer   string  `json:"reducer"`  // last, min, max, avg, sum, count
+	Operator  string  `json:"operator"` // >, >=, <, <=, ==, !=
+	Threshold float64 `json:"threshold"`
+}
+
+func (c AlertCondition) Validate() error {
+	switch c.Reducer {
+	case "last", "min", "max", "avg", "sum", "count":
+	default:
+		return errors.New("condition reducer must be one of: last, min, max, avg, sum, count")
+	}
+	switch c.Operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return errors.New("condition operator must be one of: >, >=, <, <=, ==, !=")
+	}
+	return nil
+}
+
+type AlertRule struct {
+	ID              uuid.UUID         `json:"id"`
+	OrganizationID  uuid.UUID         `json:"organization_id"`
+	DatasourceID    uuid.UUID         `json:"datasource_id"`
+	Name            string            `json:"name"`
+	Query           string            `json:"query"`
+	QueryType       string            `json:"query_type"` // metrics or logs
+	Condition       AlertCondition    `json:"condition"`
+	IntervalSeconds int               `json:"interval_seconds"` // how often the rule is evaluated
+	LookbackSeconds int               `json:"lookback_seconds"` // time range queried on each evaluation
+	ForSeconds      int               `json:"for_seconds"`      // how long the condition must hold before firing
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+	Enabled         bool              `json:"enabled"`
+	Health          string            `json:"health"`
+	LastError       string            `json:"last_error,omitempty"`
+	LastEvaluatedAt *time.Time        `json:"last_evaluated_at,omitempty"`
+	CreatedBy       *uuid.UUID        `json:"created_by,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+type CreateAlertRuleRequest struct {
+	DatasourceID    uuid.UUID         `json:"datasource_id"`
+	Name            string            `json:"name"`
+	Query           string            `json:"query"`
+	QueryType       string            `json:"query_type,omitempty"` // defaults to metrics
+	Condition       AlertCondition    `json:"condition"`
+	IntervalSeconds int               `json:"interval_seconds"`
+	LookbackSeconds int               `json:"lookback_seconds"`
+	ForSeconds      int               `json:"for_seconds"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	Enabled         *bool             `json:"enabled,omitempty"`
+}
+
+type UpdateAlertRuleRequest struct {
+	DatasourceID    *uuid.UUID        `json:"datasource_id,omitempty"`
+	Name            *string           `json:"name,omitempty"`
+	Query           *string           `json:"query,omitempty"`
+	QueryType       *string           `json:"query_type,omitempty"`
+	Condition       *AlertCondition   `json:"condition,omitempty"`
+	IntervalSeconds *int              `json:"interval_seconds,omitempty"`
+	LookbackSeconds *int              `json:"lookback_seconds,omitempty"`
+	ForSeconds      *int              `json:"for_seconds,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	Enabled         *bool             `json:"enabled,omitempty"`
+}
+
+// Bounds for rule timing
+const (
+	MinAlertIntervalSeconds     = 10
+	DefaultAlertIntervalSeconds = 60
+	DefaultAlertLookbackSeconds = 300
+)
+
+// Validate checks a rule's fields after defaults have been applied
+func (r *AlertRule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Query == "" {
+		return errors.New("query is required")
+	}
+	if r.DatasourceID == uuid.Nil {
+		return errors.New("datasource_id is required")
+	}
+	switch r.QueryType {
+	case AlertQueryMetrics:
+	case AlertQueryLogs:
+		if r.Condition.Reducer != "count" {
+			return errors.New("logs rules count matching lines, so the condition reducer must be count")
+		}
+	default:
+		return errors.New("query_type must be metrics or logs")
+	}
+	if err := r.Condition.Validate(); err != nil {
+		return err
+	}
+	if r.IntervalSeconds < MinAlertIntervalSeconds {
+		return errors.New("interval_seconds must be at least 10")
+	}
+	if r.LookbackSeconds <= 0 {
+		return errors.New("lookback_seconds must be positive")
+	}
+	if r.ForSeconds < 0 {
+		return errors.New("for_seconds must not be negative")
+	}
+	return nil
+}
+
+// AlertInstance is the state of one series matched by a rule. Series that
+// don't meet the condition have no instance.
+type AlertInstance struct {
+	RuleID          uuid.UUID         `json:"rule_id"`
+	OrganizationID  uuid.UUID         `json:"organization_id"`
+	Fingerprint     string            `json:"fingerprint"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+	State           AlertState        `json:"state"`
+	Value           float64           `json:"value"`
+	ActiveAt        time.Time         `json:"active_at"` // when the condition started to hold
+	FiredAt         *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt      *time.Time        `json:"resolved_at,omitempty"`
+	LastEvaluatedAt time.Time         `json:"last_evaluated_at"`
+	Silenced        bool              `json:"silenced"`          // matched a silence or maintenance window when last evaluated
+	Samples         []LogSample       `json:"samples,omitempty"` // recent matching lines, for rules on log datasources
+}
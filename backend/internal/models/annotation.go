@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxAnnotationTags bounds the tags on one annotation
+const MaxAnnotationTags = 20
+
+// Annotation marks a point or range in time, such as a deploy or an incident.
+// It shows on every dashboard of the organization unless bound to a dashboard,
+// and on every panel of that dashboard unless bound to a panel.
+type Annotation struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	DashboardID    *uuid.UUID `json:"dashboard_id,omitempty"`
+	PanelID        *uuid.UUID `json:"panel_id,omitempty"`
+	Time           time.Time  `json:"time"`
+	TimeEnd        *time.Time `json:"time_end,omitempty"` // set for ranges
+	Text           string     `json:"text"`
+	Tags           []string   `json:"tags"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type CreateAnnotationRequest struct {
+	DashboardID *uuid.UUID `json:"dashboard_id,omitempty"`
+	PanelID     *uuid.UUID `json:"panel_id,omitempty"`
+	Time        *time.Time `json:"time,omitempty"` // defaults to now
+	TimeEnd     *time.Time `json:"time_end,omitempty"`
+	Text        string     `json:"text"`
+	Tags        []string   `json:"tags,omitempty"`
+}
+
+type UpdateAnnotationRequest struct {
+	Time    *time.Time `json:"time,omitempty"`
+	TimeEnd *time.Time `json:"time_end,omitempty"`
+	Text    *string    `json:"text,omitempty"`
+	Tags    []string   `json:"tags,omitempty"`
+}
+
+func (a *Annotation) Validate() error {
+	if a.Text == "" {
+		return errors.New("text is required")
+	}
+	if a.PanelID != nil && a.DashboardID == nil {
+		return errors.New("dashboard_id is required with panel_id")
+	}
+	if a.TimeEnd != nil && a.TimeEnd.Before(a.Time) {
+		return errors.New("time_end must not be before time")
+	}
+	if len(a.Tags) > MaxAnnotationTags {
+		return errors.New("too many tags")
+	}
+	for _, tag := range a.Tags {
+		if tag == "" {
+			return errors.New("tags must not be empty")
+		}
+	}
+	return nil
+}
+
+// AnnotationQuery derives annotations for a dashboard from a datasource:
+// changes in the values of a Prometheus or VictoriaMetrics query's series, or
+// the lines matched by a Loki or Victoria Logs query
+type AnnotationQuery struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	DashboardID    uuid.UUID  `json:"dashboard_id"`
+	DatasourceID   uuid.UUID  `json:"datasource_id"`
+	Name           string     `json:"name"`
+	Query          string     `json:"query"`
+	Tags           []string   `json:"tags"` // added to each derived annotation
+	Enabled        bool       `json:"enabled"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type CreateAnnotationQueryRequest struct {
+	DatasourceID uuid.UUID `json:"datasource_id"`
+	Name         string    `json:"name"`
+	Query        string    `json:"query"`
+	Tags         []string  `json:"tags,omitempty"`
+	Enabled      *bool     `json:"enabled,omitempty"`
+}
+
+type UpdateAnnotationQueryRequest struct {
+	DatasourceID *uuid.UUID `json:"datasource_id,omitempty"`
+	Name         *string    `json:"name,omitempty"`
+	Query        *string    `json:"query,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Enabled      *bool      `json:"enabled,omitempty"`
+}
+
+func (q *AnnotationQuery) Validate() error {
+	if q.Name == "" {
+		return errors.New("name is required")
+	}
+	if q.Query == "" {
+		return errors.New("query is required")
+	}
+	if q.DatasourceID == uuid.Nil {
+		return errors.New("datasource_id is required")
+	}
+	if len(q.Tags) > MaxAnnotationTags {
+		return errors.New("too many tags")
+	}
+	return nil
+}
+
+// AnnotationEvent is an annotation as returned with panel data: a stored
+// annotation, or an event found by one of the dashboard's annotation queries
+type AnnotationEvent struct {
+	Time         time.Time  `json:"time"`
+	TimeEnd      *time.Time `json:"time_end,omitempty"`
+	Text         string     `json:"text"`
+	Tags         []string   `json:"tags"`
+	PanelID      *uuid.UUID `json:"panel_id,omitempty"` // stored annotations for one panel
+	AnnotationID *uuid.UUID `json:"annotation_id,omitempty"`
+	QueryID      *uuid.UUID `json:"query_id,omitempty"`
+}
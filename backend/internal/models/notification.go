@@ -0,0 +1,257 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type NotificationChannelType string
+
+const (
+	ChannelWebhook      NotificationChannelType = "webhook"
+	ChannelSlack        NotificationChannelType = "slack"
+	ChannelEmail        NotificationChannelType = "email"
+	ChannelAlertmanager NotificationChannelType = "alertmanager"
+)
+
+func (t NotificationChannelType) Valid() bool {
+	switch t {
+	case ChannelWebhook, ChannelSlack, ChannelEmail, ChannelAlertmanager:
+		return true
+	}
+	return false
+}
+
+// NotificationChannelSettings holds the settings of every channel type; each
+// type uses a subset
+type NotificationChannelSettings struct {
+	URL      string            `json:"url,omitempty"`      // webhook, slack, alertmanager
+	Secret   string            `json:"secret,omitempty"`   // webhook: HMAC-SHA256 signing key
+	Headers  map[string]string `json:"headers,omitempty"`  // webhook, alertmanager
+	Channel  string            `json:"channel,omitempty"`  // slack
+	Username string            `json:"username,omitempty"` // slack
+	To       []string          `json:"to,omitempty"`       // email
+}
+
+// Validate checks the settings required by the channel type
+func (s NotificationChannelSettings) Validate(t NotificationChannelType) error {
+	switch t {
+	case ChannelWebhook, ChannelSlack, ChannelAlertmanager:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("settings.url must be an http or https URL")
+		}
+	case ChannelEmail:
+		if len(s.To) == 0 {
+			return errors.New("settings.to needs at least one address")
+		}
+	default:
+		return errors.New("invalid channel type, must be one of: webhook, slack, email, alertmanager")
+	}
+	return nil
+}
+
+// Redacted returns the settings without their write-only values: the webhook
+// secret, header values and the Slack webhook URL, which embeds its token
+func (s NotificationChannelSettings) Redacted(t NotificationChannelType) NotificationChannelSettings {
+	s.Secret = ""
+	if len(s.Headers) > 0 {
+		headers := make(map[string]string, len(s.Headers))
+		for name := range s.Headers {
+			headers[name] = ""
+		}
+		s.Headers = headers
+	}
+	if t == ChannelSlack {
+		s.URL = ""
+	}
+	return s
+}
+
+// KeepWriteOnly fills the write-only values left empty in an update from the
+// stored settings, so clients can send back settings as they were read
+func (s *NotificationChannelSettings) KeepWriteOnly(t NotificationChannelType, stored NotificationChannelSettings) {
+	if s.Secret == "" {
+		s.Secret = stored.Secret
+	}
+	for name, value := range s.Headers {
+		if value == "" {
+			s.Headers[name] = stored.Headers[name]
+		}
+	}
+	if t == ChannelSlack && s.URL == "" {
+		s.URL = stored.URL
+	}
+}
+
+type NotificationChannel struct {
+	ID             uuid.UUID                   `json:"id"`
+	OrganizationID uuid.UUID                   `json:"organization_id"`
+	Name           string                      `json:"name"`
+	Type           NotificationChannelType     `json:"type"`
+	Settings       NotificationChannelSettings `json:"settings"`
+	CreatedAt      time.Time                   `json:"created_at"`
+	UpdatedAt      time.Time                   `json:"updated_at"`
+}
+
+type CreateNotificationChannelRequest struct {
+	Name     string                      `json:"name"`
+	Type     NotificationChannelType     `json:"type"`
+	Settings NotificationChannelSettings `json:"settings"`
+}
+
+// UpdateNotificationChannelRequest replaces the channel's settings; empty
+// write-only values (see NotificationChannelSettings.Redacted) keep the stored ones
+type UpdateNotificationChannelRequest struct {
+	Name     *string                      `json:"name,omitempty"`
+	Settings *NotificationChannelSettings `json:"settings,omitempty"`
+}
+
+// LabelMatcher selects alerts by one label. Regular expressions are anchored.
+type LabelMatcher struct {
+	Name     string `json:"name"`
+	Operator string `json:"operator"` // =, !=, =~, !~
+	Value    string `json:"value"`
+}
+
+func (m LabelMatcher) Validate() error {
+	if m.Name == "" {
+		return errors.New("matcher name is required")
+	}
+	switch m.Operator {
+	case "=", "!=":
+	case "=~", "!~":
+		if _, err := regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+			return fmt.Errorf("invalid matcher regex for %s", m.Name)
+		}
+	default:
+		return errors.New("matcher operator must be one of: =, !=, =~, !~")
+	}
+	return nil
+}
+
+// Matches reports whether the label set satisfies the matcher. A missing
+// label matches as the empty string.
+func (m LabelMatcher) Matches(labels map[string]string) bool {
+	v := labels[m.Name]
+	switch m.Operator {
+	case "=":
+		return v == m.Value
+	case "!=":
+		return v != m.Value
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return false
+		}
+		return re.MatchString(v) == (m.Operator == "=~")
+	}
+	return false
+}
+
+// LabelMatchers match when all of them do
+type LabelMatchers []LabelMatcher
+
+func (ms LabelMatchers) Validate() error {
+	for _, m := range ms {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms LabelMatchers) Matches(labels map[string]string) bool {
+	for _, m := range ms {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Notification timing defaults, as in Alertmanager
+const (
+	DefaultGroupWaitSeconds      = 30
+	DefaultGroupIntervalSeconds  = 300
+	DefaultRepeatIntervalSeconds = 4 * 3600
+)
+
+// NotificationRoute sends the alerts matching its matchers to a channel,
+// batched into one notification per distinct value of the group_by labels
+type NotificationRoute struct {
+	ID                    uuid.UUID     `json:"id"`
+	OrganizationID        uuid.UUID     `json:"organization_id"`
+	ChannelID             uuid.UUID     `json:"channel_id"`
+	Matchers              LabelMatchers `json:"matchers"`
+	GroupBy               []string      `json:"group_by"`
+	GroupWaitSeconds      int           `json:"group_wait_seconds"`      // wait before a new group's first notification
+	GroupIntervalSeconds  int           `json:"group_interval_seconds"`  // wait before notifying about changes to a group
+	RepeatIntervalSeconds int           `json:"repeat_interval_seconds"` // wait before repeating an unchanged notification
+	CreatedAt             time.Time     `json:"created_at"`
+	UpdatedAt             time.Time     `json:"updated_at"`
+}
+
+func (r *NotificationRoute) Validate() error {
+	if r.ChannelID == uuid.Nil {
+		return errors.New("channel_id is required")
+	}
+	if err := r.Matchers.Validate(); err != nil {
+		return err
+	}
+	if r.GroupWaitSeconds < 0 || r.GroupIntervalSeconds < 0 {
+		return errors.New("group_wait_seconds and group_interval_seconds must not be negative")
+	}
+	if r.RepeatIntervalSeconds < 60 {
+		return errors.New("repeat_interval_seconds must be at least 60")
+	}
+	return nil
+}
+
+type CreateNotificationRouteRequest struct {
+	ChannelID             uuid.UUID     `json:"channel_id"`
+	Matchers              LabelMatchers `json:"matchers,omitempty"`
+	GroupBy               []string      `json:"group_by,omitempty"`
+	GroupWaitSeconds      *int          `json:"group_wait_seconds,omitempty"`
+	GroupIntervalSeconds  *int          `json:"group_interval_seconds,omitempty"`
+	RepeatIntervalSeconds *int          `json:"repeat_interval_seconds,omitempty"`
+}
+
+type UpdateNotificationRouteRequest struct {
+	ChannelID             *uuid.UUID    `json:"channel_id,omitempty"`
+	Matchers              LabelMatchers `json:"matchers,omitempty"`
+	GroupBy               []string      `json:"group_by,omitempty"`
+	GroupWaitSeconds      *int          `json:"group_wait_seconds,omitempty"`
+	GroupIntervalSeconds  *int          `json:"group_interval_seconds,omitempty"`
+	RepeatIntervalSeconds *int          `json:"repeat_interval_seconds,omitempty"`
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// NotificationDelivery records one notification sent to a channel and its
+// attempts so far
+type NotificationDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	OrganizationID uuid.UUID       `json:"organization_id"`
+	ChannelID      uuid.UUID       `json:"channel_id"`
+	RouteID        *uuid.UUID      `json:"route_id,omitempty"` // nil for test notifications
+	GroupKey       string          `json:"group_key"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
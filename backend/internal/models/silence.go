@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Silence states, derived from the silence's time range
+const (
+	SilencePending = "pending"
+	SilenceActive  = "active"
+	SilenceExpired = "expired"
+)
+
+// Silence mutes the alerts matching all of its matchers between StartsAt and
+// EndsAt
+type Silence struct {
+	ID             uuid.UUID     `json:"id"`
+	OrganizationID uuid.UUID     `json:"organization_id"`
+	Matchers       LabelMatchers `json:"matchers"`
+	StartsAt       time.Time     `json:"starts_at"`
+	EndsAt         time.Time     `json:"ends_at"`
+	Comment        string        `json:"comment"`
+	CreatedBy      *uuid.UUID    `json:"created_by,omitempty"`
+	CreatedByEmail string        `json:"created_by_email"`
+	CreatedAt      time.Time     `json:"created_at"`
+	State          string        `json:"state"`
+}
+
+// StateAt returns whether the silence is pending, active or expired at t
+func (s *Silence) StateAt(t time.Time) string {
+	switch {
+	case t.Before(s.StartsAt):
+		return SilencePending
+	case t.Before(s.EndsAt):
+		return SilenceActive
+	default:
+		return SilenceExpired
+	}
+}
+
+type CreateSilenceRequest struct {
+	Matchers LabelMatchers `json:"matchers"`
+	StartsAt *time.Time    `json:"starts_at,omitempty"` // defaults to now
+	EndsAt   time.Time     `json:"ends_at"`
+	Comment  string        `json:"comment"`
+}
+
+// SilencePreviewRequest asks which active alerts a set of matchers would mute
+type SilencePreviewRequest struct {
+	Matchers LabelMatchers `json:"matchers"`
+}
+
+// Validate checks a silence about to be created at now
+func (s *Silence) Validate(now time.Time) error {
+	if len(s.Matchers) == 0 {
+		return errors.New("at least one matcher is required")
+	}
+	if err := s.Matchers.Validate(); err != nil {
+		return err
+	}
+	if s.Comment == "" {
+		return errors.New("comment is required")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if !s.EndsAt.After(now) {
+		return errors.New("ends_at must be in the future")
+	}
+	return nil
+}
+
+// MaxMaintenanceMinutes bounds a maintenance window to a week
+const MaxMaintenanceMinutes = 7 * 24 * 60
+
+// MaintenanceWindow mutes matching alerts on a weekly schedule: from
+// StartTime on each of Weekdays, for DurationMinutes, in Timezone
+type MaintenanceWindow struct {
+	ID              uuid.UUID     `json:"id"`
+	OrganizationID  uuid.UUID     `json:"organization_id"`
+	Name            string        `json:"name"`
+	Matchers        LabelMatchers `json:"matchers"`
+	Weekdays        []int         `json:"weekdays"`   // 0 = Sunday; empty means every day
+	StartTime       string        `json:"start_time"` // HH:MM
+	DurationMinutes int           `json:"duration_minutes"`
+	Timezone        string        `json:"timezone"`
+	CreatedBy       *uuid.UUID    `json:"created_by,omitempty"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+	Active          bool          `json:"active"`
+}
+
+type CreateMaintenanceWindowRequest struct {
+	Name            string        `json:"name"`
+	Matchers        LabelMatchers `json:"matchers"`
+	Weekdays        []int         `json:"weekdays,omitempty"`
+	StartTime       string        `json:"start_time"`
+	DurationMinutes int           `json:"duration_minutes"`
+	Timezone        string        `json:"timezone,omitempty"` // defaults to UTC
+}
+
+type UpdateMaintenanceWindowRequest struct {
+	Name            *string       `json:"name,omitempty"`
+	Matchers        LabelMatchers `json:"matchers,omitempty"`
+	Weekdays        []int         `json:"weekdays,omitempty"`
+	StartTime       *string       `json:"start_time,omitempty"`
+	DurationMinutes *int          `json:"duration_minutes,omitempty"`
+	Timezone        *string       `json:"timezone,omitempty"`
+}
+
+func (m *MaintenanceWindow) Validate() error {
+	if m.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(m.Matchers) == 0 {
+		return errors.New("at least one matcher is required")
+	}
+	if err := m.Matchers.Validate(); err != nil {
+		return err
+	}
+	for _, d := range m.Weekdays {
+		if d < 0 || d > 6 {
+			return errors.New("weekdays must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+	if _, _, err := m.startClock(); err != nil {
+		return err
+	}
+	if m.DurationMinutes <= 0 || m.DurationMinutes > MaxMaintenanceMinutes {
+		return fmt.Errorf("duration_minutes must be between 1 and %d", MaxMaintenanceMinutes)
+	}
+	if _, err := time.LoadLocation(m.Timezone); err != nil {
+		return errors.New("timezone must be an IANA time zone, e.g. Europe/Berlin")
+	}
+	return nil
+}
+
+func (m *MaintenanceWindow) startClock() (int, int, error) {
+	t, err := time.Parse("15:04", m.StartTime)
+	if err != nil {
+		return 0, 0, errors.New("start_time must be HH:MM")
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+func (m *MaintenanceWindow) onWeekday(d time.Weekday) bool {
+	if len(m.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range m.Weekdays {
+		if time.Weekday(w) == d {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt reports whether t falls in one of the window's occurrences.
+// Occurrences may run past midnight, so those starting up to a week earlier
+// are checked too.
+func (m *MaintenanceWindow) ActiveAt(t time.Time) bool {
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return false
+	}
+	hour, minute, err := m.startClock()
+	if err != nil {
+		return false
+	}
+	duration := time.Duration(m.DurationMinutes) * time.Minute
+
+	local := t.In(loc)
+	for offset := 0; offset <= 7; offset++ {
+		day := local.AddDate(0, 0, -offset)
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+		if !m.onWeekday(start.Weekday()) {
+			continue
+		}
+		if !t.Before(start) && t.Before(start.Add(duration)) {
+			return true
+		}
+	}
+	return false
+}
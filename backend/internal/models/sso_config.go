@@ -11,32 +11,126 @@ type SSOProvider string
 const (
 	SSOGoogle    SSOProvider = "google"
 	SSOMicrosoft SSOProvider = "microsoft"
+	SSOOIDC      SSOProvider = "oidc"
+	SSOSAML      SSOProvider = "saml"
+	SSOLDAP      SSOProvider = "ldap"
 )
 
+// SSOClaimMapping names the ID token claims holding user attributes.
+// Values may be dotted paths into nested claims, e.g. "realm_access.roles".
+type SSOClaimMapping struct {
+	Subject string `json:"subject,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Groups  string `json:"groups,omitempty"`
+}
+
 type SSOConfig struct {
-	ID             uuid.UUID   `json:"id"`
-	OrganizationID uuid.UUID   `json:"organization_id"`
-	Provider       SSOProvider `json:"provider"`
-	ClientID       string      `json:"client_id"`
-	ClientSecret   string      `json:"-"`
-	TenantID       *string     `json:"tenant_id,omitempty"`
-	Enabled        bool        `json:"enabled"`
-	CreatedAt      time.Time   `json:"created_at"`
-	UpdatedAt      time.Time   `json:"updated_at"`
+	ID             uuid.UUID        `json:"id"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Provider       SSOProvider      `json:"provider"`
+	ClientID       string           `json:"client_id"`
+	ClientSecret   string           `json:"-"`
+	TenantID       *string          `json:"tenant_id,omitempty"`
+	IssuerURL      *string          `json:"issuer_url,omitempty"`
+	Scopes         []string         `json:"scopes,omitempty"`
+	ClaimMapping   *SSOClaimMapping `json:"claim_mapping,omitempty"`
+	IDPEntityID    *string          `json:"idp_entity_id,omitempty"`
+	IDPSSOURL      *string          `json:"idp_sso_url,omitempty"`
+	IDPCertificate *string          `json:"idp_certificate,omitempty"`
+	SAMLBinding    *string          `json:"saml_binding,omitempty"`
+	Enabled        bool             `json:"enabled"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
 }
 
 type CreateSSOConfigRequest struct {
-	OrganizationID uuid.UUID   `json:"organization_id"`
-	Provider       SSOProvider `json:"provider"`
-	ClientID       string      `json:"client_id"`
-	ClientSecret   string      `json:"client_secret"`
-	TenantID       *string     `json:"tenant_id,omitempty"`
-	Enabled        *bool       `json:"enabled,omitempty"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Provider       SSOProvider      `json:"provider"`
+	ClientID       string           `json:"client_id"`
+	ClientSecret   string           `json:"client_secret"`
+	TenantID       *string          `json:"tenant_id,omitempty"`
+	IssuerURL      *string          `json:"issuer_url,omitempty"`
+	Scopes         []string         `json:"scopes,omitempty"`
+	ClaimMapping   *SSOClaimMapping `json:"claim_mapping,omitempty"`
+	IDPEntityID    *string          `json:"idp_entity_id,omitempty"`
+	IDPSSOURL      *string          `json:"idp_sso_url,omitempty"`
+	IDPCertificate *string          `json:"idp_certificate,omitempty"`
+	SAMLBinding    *string          `json:"saml_binding,omitempty"`
+	Enabled        *bool            `json:"enabled,omitempty"`
 }
 
 type UpdateSSOConfigRequest struct {
-	ClientID     *string `json:"client_id,omitempty"`
-	ClientSecret *string `json:"client_secret,omitempty"`
-	TenantID     *string `json:"tenant_id,omitempty"`
-	Enabled      *bool   `json:"enabled,omitempty"`
+	ClientID       *string          `json:"client_id,omitempty"`
+	ClientSecret   *string          `json:"client_secret,omitempty"`
+	TenantID       *string          `json:"tenant_id,omitempty"`
+	IssuerURL      *string          `json:"issuer_url,omitempty"`
+	Scopes         []string         `json:"scopes,omitempty"`
+	ClaimMapping   *SSOClaimMapping `json:"claim_mapping,omitempty"`
+	IDPEntityID    *string          `json:"idp_entity_id,omitempty"`
+	IDPSSOURL      *string          `json:"idp_sso_url,omitempty"`
+	IDPCertificate *string          `json:"idp_certificate,omitempty"`
+	SAMLBinding    *string          `json:"saml_binding,omitempty"`
+	Enabled        *bool            `json:"enabled,omitempty"`
+}
+
+// GroupRoleMapping grants an organization role to members of an external group.
+// Claim optionally names the claim or attribute holding Group (e.g. "roles" for
+// Entra ID app roles, "hd" for the Google Workspace domain) instead of the groups
+// claim, and Provider optionally limits the rule to one SSO provider. Google ID
+// tokens carry no groups, so Google logins only match rules naming a claim.
+type GroupRoleMapping struct {
+	Group    string         `json:"group"`
+	Role     MembershipRole `json:"role"`
+	Claim    string         `json:"claim,omitempty"`
+	Provider SSOProvider    `json:"provider,omitempty"`
+}
+
+// SSOSettings holds an organization's SSO role mapping rules and login policy.
+// When rules are set, members' roles are re-evaluated on every SSO login and
+// DefaultRole applies to users matching no rule; a nil DefaultRole denies them.
+// RequireAdminMFA makes the org's admins complete MFA on every login, SSO included.
+// EnforceSSO disables password login for the org's members, except the admins
+// in BreakGlassUserIDs, who keep it in case the identity provider is down.
+type SSOSettings struct {
+	OrganizationID    uuid.UUID          `json:"organization_id"`
+	EnforceSSO        bool               `json:"enforce_sso"`
+	RequireAdminMFA   bool               `json:"require_admin_mfa"`
+	RoleMappings      []GroupRoleMapping `json:"role_mappings"`
+	DefaultRole       *MembershipRole    `json:"default_role"`
+	BreakGlassUserIDs []uuid.UUID        `json:"break_glass_user_ids"`
+	UpdatedAt         *time.Time         `json:"updated_at,omitempty"`
+}
+
+type UpdateSSOSettingsRequest struct {
+	EnforceSSO      *bool              `json:"enforce_sso,omitempty"`
+	RequireAdminMFA *bool              `json:"require_admin_mfa,omitempty"`
+	RoleMappings    []GroupRoleMapping `json:"role_mappings,omitempty"`
+	DefaultRole     *MembershipRole    `json:"default_role,omitempty"`
+	// ClearDefaultRole unsets the default role so unmatched users are denied
+	ClearDefaultRole bool `json:"clear_default_role,omitempty"`
+	// BreakGlassUserIDs replaces the admins exempt from enforced SSO; [] clears them
+	BreakGlassUserIDs []uuid.UUID `json:"break_glass_user_ids,omitempty"`
+}
+
+type LDAPConfig struct {
+	ID                 uuid.UUID          `json:"id"`
+	OrganizationID     uuid.UUID          `json:"organization_id"`
+	URL                string             `json:"url"`
+	StartTLS           bool               `json:"start_tls"`
+	InsecureSkipVerify bool               `json:"insecure_skip_verify"`
+	RootCA             *string            `json:"root_ca,omitempty"`
+	BindDN             string             `json:"bind_dn"`
+	BindPassword       string             `json:"-"`
+	BaseDN             string             `json:"base_dn"`
+	UserFilter         string             `json:"user_filter"`
+	EmailAttribute     string             `json:"email_attribute"`
+	NameAttribute      string             `json:"name_attribute"`
+	GroupAttribute     string             `json:"group_attribute"`
+	GroupMappings      []GroupRoleMapping `json:"group_mappings"`
+	DefaultRole        *MembershipRole    `json:"default_role,omitempty"`
+	Enabled            bool               `json:"enabled"`
+	LastSyncedAt       *time.Time         `json:"last_synced_at,omitempty"`
+	CreatedAt          time.Time          `json:"created_at"`
+	UpdatedAt          time.Time          `json:"updated_at"`
 }
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// AlertmanagerChannel pushes alerts to an Alertmanager through its v2 API,
+// leaving grouping and routing of these alerts to Alertmanager
+type AlertmanagerChannel struct {
+	URL     string
+	Headers map[string]string
+	client  *http.Client
+}
+
+// postableAlert is an alert in Alertmanager's POST /api/v2/alerts body
+type postableAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+func (c *AlertmanagerChannel) Send(ctx context.Context, n *Notification) error {
+	alerts := make([]postableAlert, 0, len(n.Alerts))
+	for _, a := range n.Alerts {
+		alerts = append(alerts, postableAlert{
+			Labels:      a.Labels,
+			Annotations: withLogSamples(a.Annotations, a.Samples),
+			StartsAt:    a.StartsAt,
+			EndsAt:      a.EndsAt,
+		})
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, c.client, strings.TrimSuffix(c.URL, "/")+"/api/v2/alerts", body, c.Headers)
+}
+
+// withLogSamples adds an alert's log lines as a log_samples annotation, since
+// Alertmanager alerts carry no other free-form data
+func withLogSamples(annotations map[string]string, samples []models.LogSample) map[string]string {
+	if len(samples) == 0 {
+		return annotations
+	}
+	lines := make([]string, len(samples))
+	for i, sample := range samples {
+		lines[i] = sample.Timestamp + " " + sample.Line
+	}
+	merged := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	merged["log_samples"] = strings.Join(lines, "\n")
+	return merged
+}
@@ -0,0 +1,398 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/alerting"
+	"github.com/janhoon/dash/backend/internal/mailer"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// dispatchLockID keeps one instance dispatching at a time
+const dispatchLockID = 0x6e746679 // "ntfy"
+
+// MaxDeliveryAttempts is how often a delivery is tried before it fails
+const MaxDeliveryAttempts = 5
+
+// retryBackoff is the wait after a delivery's nth failed attempt
+func retryBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second << (attempts - 1)
+	return min(backoff, 15*time.Minute)
+}
+
+// Dispatcher routes alert instances to notification channels and delivers
+// the notifications, retrying failed deliveries with backoff
+type Dispatcher struct {
+	pool        *pgxpool.Pool
+	mail        mailer.Mailer
+	concurrency int // deliveries sent at once
+	timeout     time.Duration
+	now         func() time.Time
+}
+
+func NewDispatcher(pool *pgxpool.Pool, mail mailer.Mailer) *Dispatcher {
+	return &Dispatcher{
+		pool:        pool,
+		mail:        mail,
+		concurrency: 10,
+		timeout:     15 * time.Second,
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// RunOnce queues the notifications that are due and sends pending
+// deliveries. It does nothing while another instance is dispatching.
+func (d *Dispatcher) RunOnce(ctx context.Context) error {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, dispatchLockID).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, dispatchLockID)
+
+	now := d.now()
+	if err := d.route(ctx, now); err != nil {
+		return err
+	}
+	return d.deliverPending(ctx, now)
+}
+
+// route matches alert instances against every route and queues a delivery
+// for each group that is due
+func (d *Dispatcher) route(ctx context.Context, now time.Time) error {
+	rows, err := d.pool.Query(ctx,
+		`SELECT `+RouteColumns+` FROM notification_routes ORDER BY created_at`)
+	if err != nil {
+		return err
+	}
+	var routes []*models.NotificationRoute
+	for rows.Next() {
+		route, err := ScanRoute(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		routes = append(routes, route)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+
+	channelNames := map[uuid.UUID]string{}
+	rows, err = d.pool.Query(ctx, `SELECT id, name FROM notification_channels`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		channelNames[id] = name
+	}
+	rows.Close()
+
+	rows, err = d.pool.Query(ctx,
+		`SELECT `+alerting.InstanceColumns+` FROM alert_instances WHERE state <> $1`, models.AlertPending)
+	if err != nil {
+		return err
+	}
+	var instances []models.AlertInstance
+	for rows.Next() {
+		inst, err := alerting.ScanInstance(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		instances = append(instances, *inst)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Silences are checked now rather than at evaluation so they apply at
+	// once. Resolved alerts still go out so receivers see them end.
+	mutes, err := alerting.LoadMutes(ctx, d.pool, nil, now)
+	if err != nil {
+		return err
+	}
+	unmuted := instances[:0]
+	for _, inst := range instances {
+		if inst.State == models.AlertFiring && mutes.Muted(inst.OrganizationID, inst.Labels) {
+			continue
+		}
+		unmuted = append(unmuted, inst)
+	}
+	instances = unmuted
+
+	states := map[uuid.UUID]map[string]groupState{}
+	rows, err = d.pool.Query(ctx,
+		`SELECT route_id, group_key, first_seen_at, last_notified_at, firing_fingerprints FROM notification_groups`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var routeID uuid.UUID
+		var key string
+		var st groupState
+		if err := rows.Scan(&routeID, &key, &st.FirstSeenAt, &st.LastNotifiedAt, &st.Firing); err != nil {
+			rows.Close()
+			return err
+		}
+		if states[routeID] == nil {
+			states[routeID] = map[string]groupState{}
+		}
+		states[routeID][key] = st
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		groups := GroupAlerts(route, instances)
+		for key, g := range groups {
+			if err := d.routeGroup(ctx, route, channelNames[route.ChannelID], g, states[route.ID], now); err != nil {
+				log.Printf("Failed to route alert group %s: %v", key, err)
+			}
+		}
+		// Groups whose alerts are all gone
+		for key := range states[route.ID] {
+			if _, ok := groups[key]; !ok {
+				if _, err := d.pool.Exec(ctx,
+					`DELETE FROM notification_groups WHERE route_id = $1 AND group_key = $2`, route.ID, key,
+				); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) routeGroup(ctx context.Context, route *models.NotificationRoute, receiver string, g *Group, states map[string]groupState, now time.Time) error {
+	firing := g.firing()
+	st, ok := states[g.Key]
+	if !ok {
+		// Groups start with their first firing alert
+		if len(firing) == 0 {
+			return nil
+		}
+		st = groupState{FirstSeenAt: now}
+		if _, err := d.pool.Exec(ctx,
+			`INSERT INTO notification_groups (route_id, group_key, first_seen_at) VALUES ($1, $2, $3)
+			 ON CONFLICT DO NOTHING`,
+			route.ID, g.Key, now,
+		); err != nil {
+			return err
+		}
+	}
+	if !g.due(route, st, now) {
+		return nil
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	n := g.notification(route, receiver, st, now)
+	if len(n.Alerts) > 0 {
+		payload, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO notification_deliveries (organization_id, channel_id, route_id, group_key, status, payload, next_attempt_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			route.OrganizationID, route.ChannelID, route.ID, n.GroupKey, models.DeliveryPending, payload, now,
+		); err != nil {
+			return err
+		}
+	}
+
+	if len(firing) == 0 {
+		// Everything has resolved and been notified; the group starts afresh
+		_, err = tx.Exec(ctx, `DELETE FROM notification_groups WHERE route_id = $1 AND group_key = $2`, route.ID, g.Key)
+	} else {
+		_, err = tx.Exec(ctx,
+			`UPDATE notification_groups SET last_notified_at = $3, firing_fingerprints = $4
+			 WHERE route_id = $1 AND group_key = $2`,
+			route.ID, g.Key, now, firing,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// deliverPending sends the deliveries that are due
+func (d *Dispatcher) deliverPending(ctx context.Context, now time.Time) error {
+	rows, err := d.pool.Query(ctx,
+		`SELECT `+DeliveryColumns+` FROM notification_deliveries
+		 WHERE status = $1 AND next_attempt_at <= $2
+		 ORDER BY next_attempt_at LIMIT 100`,
+		models.DeliveryPending, now,
+	)
+	if err != nil {
+		return err
+	}
+	var deliveries []*models.NotificationDelivery
+	for rows.Next() {
+		delivery, err := ScanDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, d.concurrency)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ch, err := ScanChannel(d.pool.QueryRow(ctx,
+				`SELECT `+ChannelColumns+` FROM notification_channels WHERE id = $1`, delivery.ChannelID))
+			if err != nil {
+				log.Printf("Failed to load notification channel %s: %v", delivery.ChannelID, err)
+				return
+			}
+			if err := d.Deliver(ctx, delivery, ch); err != nil {
+				log.Printf("Warning: delivery %s to channel %s failed: %v", delivery.ID, ch.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Deliver makes one attempt at a delivery and records the outcome on it. A
+// failed attempt is retried later until MaxDeliveryAttempts is reached.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery *models.NotificationDelivery, ch *models.NotificationChannel) error {
+	return d.attempt(ctx, delivery, ch, MaxDeliveryAttempts)
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.NotificationDelivery, ch *models.NotificationChannel, maxAttempts int) error {
+	var n Notification
+	sendErr := json.Unmarshal(delivery.Payload, &n)
+	if sendErr == nil {
+		sendErr = d.send(ctx, ch, &n)
+	}
+
+	now := d.now()
+	delivery.Attempts++
+	switch {
+	case sendErr == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.NextAttemptAt = nil
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = nil
+	default:
+		next := now.Add(retryBackoff(delivery.Attempts))
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = &next
+	}
+
+	if _, err := d.pool.Exec(context.WithoutCancel(ctx),
+		`UPDATE notification_deliveries
+		 SET status = $2, attempts = $3, last_error = $4, next_attempt_at = $5, delivered_at = $6
+		 WHERE id = $1`,
+		delivery.ID, delivery.Status, delivery.Attempts, delivery.LastError, delivery.NextAttemptAt, delivery.DeliveredAt,
+	); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func (d *Dispatcher) send(ctx context.Context, ch *models.NotificationChannel, n *Notification) error {
+	channel, err := NewChannel(*ch, d.mail)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	return channel.Send(ctx, n)
+}
+
+// SendTest sends a sample firing alert to a channel right away, recording it
+// in the delivery history. Failed test deliveries are not retried.
+func (d *Dispatcher) SendTest(ctx context.Context, ch *models.NotificationChannel) (*models.NotificationDelivery, error) {
+	now := d.now()
+	labels := map[string]string{"alertname": "TestAlert", "severity": "info"}
+	n := NewNotification("test", ch.Name, nil, []Alert{{
+		Status:      string(models.AlertFiring),
+		Labels:      labels,
+		Annotations: map[string]string{"summary": "This is a test notification from Dash"},
+		StartsAt:    now,
+		EndsAt:      now.Add(5 * time.Minute),
+		Fingerprint: alerting.Fingerprint(labels),
+	}}, now)
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := ScanDelivery(d.pool.QueryRow(ctx,
+		`INSERT INTO notification_deliveries (organization_id, channel_id, group_key, status, payload)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING `+DeliveryColumns,
+		ch.OrganizationID, ch.ID, n.GroupKey, models.DeliveryPending, payload,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return delivery, d.attempt(ctx, delivery, ch, 1)
+}
+
+// Start dispatches notifications every tick until ctx is done
+func (d *Dispatcher) Start(ctx context.Context, tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.RunOnce(ctx); err != nil {
+					log.Printf("Warning: failed to dispatch notifications: %v", err)
+				}
+			}
+		}
+	}()
+}
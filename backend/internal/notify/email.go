@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/janhoon/dash/backend/internal/mailer"
+)
+
+// EmailChannel mails the notification to each recipient
+type EmailChannel struct {
+	To   []string
+	mail mailer.Mailer
+}
+
+func (c *EmailChannel) Send(ctx context.Context, n *Notification) error {
+	msg := mailer.Message{
+		Subject: n.Title(),
+		Body:    n.Text(),
+	}
+	for _, to := range c.To {
+		msg.To = to
+		if err := c.mail.Send(ctx, msg); err != nil {
+			return fmt.Errorf("failed to mail %s: %w", to, err)
+		}
+	}
+	return nil
+}
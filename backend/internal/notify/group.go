@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"sort"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// Group is the alerts of one route that are notified together
+type Group struct {
+	Key    string // the group_by labels, e.g. {cluster="eu", severity="page"}
+	Labels map[string]string
+	Alerts []models.AlertInstance
+}
+
+// GroupAlerts picks the firing and resolved instances matching the route and
+// groups them by the route's group_by labels
+func GroupAlerts(route *models.NotificationRoute, instances []models.AlertInstance) map[string]*Group {
+	groups := map[string]*Group{}
+	for _, inst := range instances {
+		if inst.State == models.AlertPending || inst.OrganizationID != route.OrganizationID {
+			continue
+		}
+		if !route.Matchers.Matches(inst.Labels) {
+			continue
+		}
+
+		labels := make(map[string]string, len(route.GroupBy))
+		for _, name := range route.GroupBy {
+			if v, ok := inst.Labels[name]; ok {
+				labels[name] = v
+			}
+		}
+		key := "{" + formatLabels(labels) + "}"
+		g, ok := groups[key]
+		if !ok {
+			g = &Group{Key: key, Labels: labels}
+			groups[key] = g
+		}
+		g.Alerts = append(g.Alerts, inst)
+	}
+	for _, g := range groups {
+		sort.Slice(g.Alerts, func(i, j int) bool { return g.Alerts[i].Fingerprint < g.Alerts[j].Fingerprint })
+	}
+	return groups
+}
+
+// groupState is what a group's last notification covered
+type groupState struct {
+	FirstSeenAt    time.Time
+	LastNotifiedAt *time.Time
+	Firing         []string // fingerprints firing at the last notification
+}
+
+// firing returns the fingerprints of the group's firing alerts, sorted
+func (g *Group) firing() []string {
+	var fps []string
+	for _, a := range g.Alerts {
+		if a.State == models.AlertFiring {
+			fps = append(fps, a.Fingerprint)
+		}
+	}
+	return fps
+}
+
+// changed reports whether alerts started firing or stopped since the last
+// notification
+func (g *Group) changed(st groupState) bool {
+	current := g.firing()
+	if len(current) != len(st.Firing) {
+		return true
+	}
+	previous := make(map[string]bool, len(st.Firing))
+	for _, fp := range st.Firing {
+		previous[fp] = true
+	}
+	for _, fp := range current {
+		if !previous[fp] {
+			return true
+		}
+	}
+	return false
+}
+
+// due decides whether the group is notified now. A new group waits group_wait
+// to collect alerts; changes wait group_interval after the last notification;
+// an unchanged group with firing alerts is repeated every repeat_interval.
+func (g *Group) due(route *models.NotificationRoute, st groupState, now time.Time) bool {
+	firing := len(g.firing()) > 0
+	if st.LastNotifiedAt == nil {
+		return firing && now.Sub(st.FirstSeenAt) >= time.Duration(route.GroupWaitSeconds)*time.Second
+	}
+	since := now.Sub(*st.LastNotifiedAt)
+	if g.changed(st) {
+		return since >= time.Duration(route.GroupIntervalSeconds)*time.Second
+	}
+	return firing && since >= time.Duration(route.RepeatIntervalSeconds)*time.Second
+}
+
+// notification builds the group's notification: its firing alerts and those
+// that resolved since the last notification. Firing alerts are valid for three
+// repeat intervals so receivers like Alertmanager expire them if we go quiet.
+func (g *Group) notification(route *models.NotificationRoute, receiver string, st groupState, now time.Time) *Notification {
+	notified := make(map[string]bool, len(st.Firing))
+	for _, fp := range st.Firing {
+		notified[fp] = true
+	}
+
+	validFor := 3 * time.Duration(route.RepeatIntervalSeconds) * time.Second
+	var alerts []Alert
+	for _, inst := range g.Alerts {
+		a := Alert{
+			Status:      string(inst.State),
+			Labels:      inst.Labels,
+			Annotations: inst.Annotations,
+			Value:       inst.Value,
+			StartsAt:    inst.ActiveAt,
+			Fingerprint: inst.Fingerprint,
+			Samples:     inst.Samples,
+		}
+		if inst.FiredAt != nil {
+			a.StartsAt = *inst.FiredAt
+		}
+		switch {
+		case inst.State == models.AlertFiring:
+			a.EndsAt = now.Add(validFor)
+		case notified[inst.Fingerprint] && inst.ResolvedAt != nil:
+			a.EndsAt = *inst.ResolvedAt
+		default:
+			continue
+		}
+		alerts = append(alerts, a)
+	}
+	return NewNotification(route.ID.String()+":"+g.Key, receiver, g.Labels, alerts, now)
+}
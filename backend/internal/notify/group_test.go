@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func testInstance(orgID uuid.UUID, fp string, state models.AlertState, labels map[string]string) models.AlertInstance {
+	return models.AlertInstance{
+		OrganizationID: orgID,
+		Fingerprint:    fp,
+		State:          state,
+		Labels:         labels,
+		ActiveAt:       time.Now().Add(-time.Hour),
+	}
+}
+
+func TestGroupAlerts(t *testing.T) {
+	orgID := uuid.New()
+	route := &models.NotificationRoute{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		Matchers:       models.LabelMatchers{{Name: "severity", Operator: "=", Value: "page"}},
+		GroupBy:        []string{"cluster"},
+	}
+	instances := []models.AlertInstance{
+		testInstance(orgID, "1", models.AlertFiring, map[string]string{"severity": "page", "cluster": "eu"}),
+		testInstance(orgID, "2", models.AlertResolved, map[string]string{"severity": "page", "cluster": "eu"}),
+		testInstance(orgID, "3", models.AlertFiring, map[string]string{"severity": "page", "cluster": "us"}),
+		testInstance(orgID, "4", models.AlertPending, map[string]string{"severity": "page", "cluster": "us"}),
+		testInstance(orgID, "5", models.AlertFiring, map[string]string{"severity": "info", "cluster": "us"}),
+		testInstance(uuid.New(), "6", models.AlertFiring, map[string]string{"severity": "page", "cluster": "us"}),
+	}
+
+	groups := GroupAlerts(route, instances)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	eu, us := groups[`{cluster="eu"}`], groups[`{cluster="us"}`]
+	if eu == nil || us == nil {
+		t.Fatalf("Unexpected group keys: %v", groups)
+	}
+	if len(eu.Alerts) != 2 || len(us.Alerts) != 1 || us.Alerts[0].Fingerprint != "3" {
+		t.Errorf("Unexpected groups eu=%d us=%d", len(eu.Alerts), len(us.Alerts))
+	}
+	if eu.Labels["cluster"] != "eu" {
+		t.Errorf("Unexpected group labels %v", eu.Labels)
+	}
+}
+
+func TestGroupTiming(t *testing.T) {
+	orgID := uuid.New()
+	route := &models.NotificationRoute{
+		ID:                    uuid.New(),
+		OrganizationID:        orgID,
+		GroupWaitSeconds:      30,
+		GroupIntervalSeconds:  300,
+		RepeatIntervalSeconds: 3600,
+	}
+	start := time.Now()
+	g := &Group{Key: "{}", Alerts: []models.AlertInstance{
+		testInstance(orgID, "a", models.AlertFiring, map[string]string{"alertname": "x"}),
+	}}
+
+	st := groupState{FirstSeenAt: start}
+	if g.due(route, st, start.Add(10*time.Second)) {
+		t.Error("Expected a new group to wait for group_wait")
+	}
+	if !g.due(route, st, start.Add(30*time.Second)) {
+		t.Error("Expected a new group to be notified after group_wait")
+	}
+
+	notified := start.Add(30 * time.Second)
+	st = groupState{FirstSeenAt: start, LastNotifiedAt: &notified, Firing: []string{"a"}}
+	if g.due(route, st, notified.Add(30*time.Minute)) {
+		t.Error("Expected an unchanged group to wait for repeat_interval")
+	}
+	if !g.due(route, st, notified.Add(time.Hour)) {
+		t.Error("Expected an unchanged group to repeat after repeat_interval")
+	}
+
+	// A new alert in the group is a change
+	g.Alerts = append(g.Alerts, testInstance(orgID, "b", models.AlertFiring, map[string]string{"alertname": "y"}))
+	if g.due(route, st, notified.Add(time.Minute)) {
+		t.Error("Expected changes to wait for group_interval")
+	}
+	if !g.due(route, st, notified.Add(5*time.Minute)) {
+		t.Error("Expected changes to be notified after group_interval")
+	}
+}
+
+func TestGroupNotificationIncludesNewlyResolved(t *testing.T) {
+	orgID := uuid.New()
+	route := &models.NotificationRoute{ID: uuid.New(), OrganizationID: orgID, RepeatIntervalSeconds: 3600}
+	now := time.Now()
+	resolvedAt := now.Add(-time.Minute)
+
+	stale := testInstance(orgID, "old", models.AlertResolved, nil)
+	stale.ResolvedAt = &resolvedAt
+	recent := testInstance(orgID, "b", models.AlertResolved, nil)
+	recent.ResolvedAt = &resolvedAt
+	g := &Group{Key: "{}", Alerts: []models.AlertInstance{
+		testInstance(orgID, "a", models.AlertFiring, nil), recent, stale,
+	}}
+
+	notified := now.Add(-10 * time.Minute)
+	st := groupState{LastNotifiedAt: &notified, Firing: []string{"a", "b"}}
+	n := g.notification(route, "ops", st, now)
+	if len(n.Alerts) != 2 {
+		t.Fatalf("Expected the firing alert and the one that just resolved, got %+v", n.Alerts)
+	}
+	if !n.Alerts[0].EndsAt.Equal(now.Add(3*time.Hour)) || !n.Alerts[1].EndsAt.Equal(resolvedAt) {
+		t.Errorf("Unexpected end times %v, %v", n.Alerts[0].EndsAt, n.Alerts[1].EndsAt)
+	}
+	if n.Status != "firing" || n.Receiver != "ops" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+}
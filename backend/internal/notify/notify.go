@@ -0,0 +1,180 @@
+// Package notify delivers alert notifications to channels: signed webhooks,
+// Slack-compatible webhooks, email and Alertmanager.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/mailer"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// Alert is one alert in a notification
+type Alert struct {
+	Status      string             `json:"status"` // firing or resolved
+	Labels      map[string]string  `json:"labels"`
+	Annotations map[string]string  `json:"annotations"`
+	Value       float64            `json:"value"`
+	StartsAt    time.Time          `json:"startsAt"`
+	EndsAt      time.Time          `json:"endsAt"` // when it resolved, or until when a firing alert is valid
+	Fingerprint string             `json:"fingerprint"`
+	Samples     []models.LogSample `json:"samples,omitempty"` // log lines behind alerts from log datasources
+}
+
+// Notification is a group of alerts sent together. It follows the shape of
+// Alertmanager's webhook payload so existing receivers can consume it.
+type Notification struct {
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"` // firing while any alert fires
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	Alerts            []Alert           `json:"alerts"`
+	Timestamp         time.Time         `json:"timestamp"`
+}
+
+// NewNotification builds a notification, filling in the status and the
+// labels and annotations shared by all alerts
+func NewNotification(groupKey, receiver string, groupLabels map[string]string, alerts []Alert, now time.Time) *Notification {
+	n := &Notification{
+		GroupKey:          groupKey,
+		Status:            "resolved",
+		Receiver:          receiver,
+		GroupLabels:       groupLabels,
+		CommonLabels:      map[string]string{},
+		CommonAnnotations: map[string]string{},
+		Alerts:            alerts,
+		Timestamp:         now,
+	}
+	if n.GroupLabels == nil {
+		n.GroupLabels = map[string]string{}
+	}
+	for i, a := range alerts {
+		if a.Status == "firing" {
+			n.Status = "firing"
+		}
+		if i == 0 {
+			for k, v := range a.Labels {
+				n.CommonLabels[k] = v
+			}
+			for k, v := range a.Annotations {
+				n.CommonAnnotations[k] = v
+			}
+			continue
+		}
+		for k, v := range n.CommonLabels {
+			if a.Labels[k] != v {
+				delete(n.CommonLabels, k)
+			}
+		}
+		for k, v := range n.CommonAnnotations {
+			if a.Annotations[k] != v {
+				delete(n.CommonAnnotations, k)
+			}
+		}
+	}
+	return n
+}
+
+// Firing returns the number of firing alerts
+func (n *Notification) Firing() int {
+	count := 0
+	for _, a := range n.Alerts {
+		if a.Status == "firing" {
+			count++
+		}
+	}
+	return count
+}
+
+// Title summarises the notification in one line, e.g. "[FIRING:2] HighCPU"
+func (n *Notification) Title() string {
+	var b strings.Builder
+	if n.Status == "firing" {
+		fmt.Fprintf(&b, "[FIRING:%d]", n.Firing())
+	} else {
+		b.WriteString("[RESOLVED]")
+	}
+	if name := n.CommonLabels["alertname"]; name != "" {
+		b.WriteString(" " + name)
+	}
+	if len(n.GroupLabels) > 0 {
+		b.WriteString(" (" + formatLabels(n.GroupLabels, "alertname") + ")")
+	}
+	return b.String()
+}
+
+// Text lists the alerts for plain text channels
+func (n *Notification) Text() string {
+	var b strings.Builder
+	for _, a := range n.Alerts {
+		fmt.Fprintf(&b, "[%s] %s\n", strings.ToUpper(a.Status), formatLabels(a.Labels))
+		if summary := a.Annotations["summary"]; summary != "" {
+			fmt.Fprintf(&b, "  %s\n", summary)
+		}
+		if description := a.Annotations["description"]; description != "" {
+			fmt.Fprintf(&b, "  %s\n", description)
+		}
+		fmt.Fprintf(&b, "  Value: %g, since %s\n", a.Value, a.StartsAt.UTC().Format(time.RFC3339))
+		if len(a.Samples) > 0 {
+			b.WriteString("  Log lines:\n")
+			for _, sample := range a.Samples {
+				fmt.Fprintf(&b, "    %s %s\n", sample.Timestamp, sample.Line)
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatLabels renders labels as k="v" pairs in key order
+func formatLabels(labels map[string]string, skip ...string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+outer:
+	for _, k := range keys {
+		for _, s := range skip {
+			if k == s {
+				continue outer
+			}
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// Channel delivers notifications to one destination
+type Channel interface {
+	Send(ctx context.Context, n *Notification) error
+}
+
+// NewChannel creates the channel for a configured notification channel.
+// Email goes out through the server's mailer.
+func NewChannel(ch models.NotificationChannel, mail mailer.Mailer) (Channel, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch ch.Type {
+	case models.ChannelWebhook:
+		return &WebhookChannel{URL: ch.Settings.URL, Secret: ch.Settings.Secret, Headers: ch.Settings.Headers, client: client}, nil
+	case models.ChannelSlack:
+		return &SlackChannel{URL: ch.Settings.URL, Channel: ch.Settings.Channel, Username: ch.Settings.Username, client: client}, nil
+	case models.ChannelEmail:
+		if mail == nil {
+			return nil, fmt.Errorf("email is not configured")
+		}
+		return &EmailChannel{To: ch.Settings.To, mail: mail}, nil
+	case models.ChannelAlertmanager:
+		return &AlertmanagerChannel{URL: ch.Settings.URL, Headers: ch.Settings.Headers, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported channel type: %s", ch.Type)
+	}
+}
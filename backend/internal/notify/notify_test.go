@@ -0,0 +1,341 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/mailer"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func testNotification() *Notification {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return NewNotification("route:{}", "ops", map[string]string{"cluster": "eu"}, []Alert{
+		{
+			Status:      "firing",
+			Labels:      map[string]string{"alertname": "HighCPU", "cluster": "eu", "instance": "a"},
+			Annotations: map[string]string{"summary": "a is hot"},
+			Value:       97,
+			StartsAt:    now.Add(-time.Minute),
+			EndsAt:      now.Add(time.Hour),
+			Fingerprint: "fa",
+		},
+		{
+			Status:      "resolved",
+			Labels:      map[string]string{"alertname": "HighCPU", "cluster": "eu", "instance": "b"},
+			Annotations: map[string]string{"summary": "b is hot"},
+			StartsAt:    now.Add(-time.Hour),
+			EndsAt:      now,
+			Fingerprint: "fb",
+		},
+	}, now)
+}
+
+// capture records requests to a stand-in receiver
+type capture struct {
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+func standIn(t *testing.T, status int) (*httptest.Server, chan capture) {
+	requests := make(chan capture, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- capture{path: r.URL.Path, headers: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestNewNotification(t *testing.T) {
+	n := testNotification()
+	if n.Status != "firing" || n.Firing() != 1 {
+		t.Errorf("Expected a firing notification with one firing alert, got %s/%d", n.Status, n.Firing())
+	}
+	if n.CommonLabels["alertname"] != "HighCPU" || n.CommonLabels["cluster"] != "eu" {
+		t.Errorf("Unexpected common labels %v", n.CommonLabels)
+	}
+	if _, ok := n.CommonLabels["instance"]; ok {
+		t.Error("Expected differing labels to be left out of the common labels")
+	}
+	if len(n.CommonAnnotations) != 0 {
+		t.Errorf("Expected no common annotations, got %v", n.CommonAnnotations)
+	}
+	if got := n.Title(); got != `[FIRING:1] HighCPU (cluster="eu")` {
+		t.Errorf("Unexpected title %q", got)
+	}
+	if text := n.Text(); !strings.Contains(text, "[FIRING]") || !strings.Contains(text, "[RESOLVED]") || !strings.Contains(text, "a is hot") {
+		t.Errorf("Unexpected text %q", text)
+	}
+}
+
+func TestWebhookChannelSignsBody(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	ch, err := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelWebhook,
+		Settings: models.NotificationChannelSettings{URL: server.URL + "/hook", Secret: "s3cret", Headers: map[string]string{"X-Team": "ops"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ch.Send(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	req := <-requests
+	if req.path != "/hook" || req.headers.Get("X-Team") != "ops" {
+		t.Errorf("Unexpected request to %s with headers %v", req.path, req.headers)
+	}
+
+	ts, err := strconv.ParseInt(req.headers.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("Expected a timestamp header, got %q", req.headers.Get(TimestampHeader))
+	}
+	if got, want := req.headers.Get(SignatureHeader), Sign("s3cret", ts, req.body); got != want {
+		t.Errorf("Signature %q does not match %q", got, want)
+	}
+	if Sign("other", ts, req.body) == req.headers.Get(SignatureHeader) {
+		t.Error("Expected the signature to depend on the secret")
+	}
+
+	var n Notification
+	if err := json.Unmarshal(req.body, &n); err != nil || len(n.Alerts) != 2 || n.Receiver != "ops" {
+		t.Errorf("Unexpected payload %s", req.body)
+	}
+}
+
+func TestWebhookChannelUnsigned(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	ch, _ := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelWebhook,
+		Settings: models.NotificationChannelSettings{URL: server.URL},
+	}, nil)
+	if err := ch.Send(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if req := <-requests; req.headers.Get(SignatureHeader) != "" {
+		t.Error("Expected no signature without a secret")
+	}
+}
+
+func TestWebhookChannelErrorStatus(t *testing.T) {
+	server, _ := standIn(t, http.StatusServiceUnavailable)
+	ch, _ := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelWebhook,
+		Settings: models.NotificationChannelSettings{URL: server.URL},
+	}, nil)
+	if err := ch.Send(context.Background(), testNotification()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the status in the error, got %v", err)
+	}
+}
+
+func TestChannelErrorsLeaveOutURL(t *testing.T) {
+	ch, _ := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelSlack,
+		Settings: models.NotificationChannelSettings{URL: "http://127.0.0.1:1/services/T000/B000/token"},
+	}, nil)
+	err := ch.Send(context.Background(), testNotification())
+	if err == nil || strings.Contains(err.Error(), "token") {
+		t.Errorf("Expected an error without the webhook URL, got %v", err)
+	}
+}
+
+func TestSlackChannel(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	ch, _ := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelSlack,
+		Settings: models.NotificationChannelSettings{URL: server.URL, Channel: "#alerts", Username: "dash"},
+	}, nil)
+	if err := ch.Send(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	var msg slackMessage
+	json.Unmarshal((<-requests).body, &msg)
+	if msg.Channel != "#alerts" || msg.Username != "dash" || !strings.HasPrefix(msg.Text, "[FIRING:1]") {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Color != "danger" {
+		t.Errorf("Expected a red attachment, got %+v", msg.Attachments)
+	}
+}
+
+func TestAlertmanagerChannel(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	ch, _ := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelAlertmanager,
+		Settings: models.NotificationChannelSettings{URL: server.URL + "/"},
+	}, nil)
+	n := testNotification()
+	if err := ch.Send(context.Background(), n); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	req := <-requests
+	if req.path != "/api/v2/alerts" {
+		t.Errorf("Expected a push to /api/v2/alerts, got %s", req.path)
+	}
+	var alerts []postableAlert
+	if err := json.Unmarshal(req.body, &alerts); err != nil || len(alerts) != 2 {
+		t.Fatalf("Unexpected payload %s", req.body)
+	}
+	if alerts[0].Labels["instance"] != "a" || !alerts[1].EndsAt.Equal(n.Alerts[1].EndsAt) {
+		t.Errorf("Unexpected alerts %+v", alerts)
+	}
+}
+
+func TestEmailChannel(t *testing.T) {
+	mail := mailer.NewCaptureMailer()
+	ch, err := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelEmail,
+		Settings: models.NotificationChannelSettings{To: []string{"a@example.com", "b@example.com"}},
+	}, mail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Send(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	messages := mail.Messages()
+	if len(messages) != 2 || messages[1].To != "b@example.com" {
+		t.Fatalf("Expected one message per recipient, got %+v", messages)
+	}
+	if !strings.HasPrefix(messages[0].Subject, "[FIRING:1] HighCPU") || !strings.Contains(messages[0].Body, "a is hot") {
+		t.Errorf("Unexpected message %+v", messages[0])
+	}
+
+	if _, err := NewChannel(models.NotificationChannel{Type: models.ChannelEmail}, nil); err == nil {
+		t.Error("Expected email channels to need a mailer")
+	}
+}
+
+func TestChannelSettingsValidate(t *testing.T) {
+	tests := []struct {
+		typ      models.NotificationChannelType
+		settings models.NotificationChannelSettings
+		valid    bool
+	}{
+		{models.ChannelWebhook, models.NotificationChannelSettings{URL: "https://example.com/hook"}, true},
+		{models.ChannelWebhook, models.NotificationChannelSettings{URL: "ftp://example.com"}, false},
+		{models.ChannelSlack, models.NotificationChannelSettings{}, false},
+		{models.ChannelEmail, models.NotificationChannelSettings{To: []string{"a@example.com"}}, true},
+		{models.ChannelEmail, models.NotificationChannelSettings{}, false},
+		{"pager", models.NotificationChannelSettings{URL: "https://example.com"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.settings.Validate(tt.typ); (err == nil) != tt.valid {
+			t.Errorf("Validate(%s, %+v) = %v, want valid=%v", tt.typ, tt.settings, err, tt.valid)
+		}
+	}
+}
+
+func TestChannelSettingsWriteOnly(t *testing.T) {
+	stored := models.NotificationChannelSettings{
+		URL:     "https://hooks.slack.com/services/T000/B000/token",
+		Secret:  "s3cret",
+		Headers: map[string]string{"Authorization": "Bearer abc"},
+		Channel: "#alerts",
+	}
+
+	redacted := stored.Redacted(models.ChannelSlack)
+	if redacted.URL != "" || redacted.Secret != "" || redacted.Headers["Authorization"] != "" || redacted.Channel != "#alerts" {
+		t.Errorf("Expected only write-only values to be blanked, got %+v", redacted)
+	}
+	if _, ok := redacted.Headers["Authorization"]; !ok || stored.Headers["Authorization"] == "" {
+		t.Error("Expected header names to be kept without changing the stored settings")
+	}
+	if webhook := stored.Redacted(models.ChannelWebhook); webhook.URL != stored.URL {
+		t.Errorf("Expected webhook URLs to be kept, got %q", webhook.URL)
+	}
+
+	// Settings sent back as read keep the stored values; new ones replace them
+	redacted.Channel = "#ops"
+	redacted.Headers["X-Team"] = "db"
+	redacted.KeepWriteOnly(models.ChannelSlack, stored)
+	if redacted.URL != stored.URL || redacted.Secret != "s3cret" || redacted.Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("Expected stored write-only values to be kept, got %+v", redacted)
+	}
+	if redacted.Channel != "#ops" || redacted.Headers["X-Team"] != "db" {
+		t.Errorf("Expected updated values to be applied, got %+v", redacted)
+	}
+}
+
+func TestLabelMatchers(t *testing.T) {
+	labels := map[string]string{"severity": "critical", "team": "db"}
+	tests := []struct {
+		matchers models.LabelMatchers
+		want     bool
+	}{
+		{nil, true},
+		{models.LabelMatchers{{Name: "severity", Operator: "=", Value: "critical"}}, true},
+		{models.LabelMatchers{{Name: "severity", Operator: "!=", Value: "critical"}}, false},
+		{models.LabelMatchers{{Name: "team", Operator: "=~", Value: "db|web"}}, true},
+		{models.LabelMatchers{{Name: "team", Operator: "=~", Value: "d"}}, false}, // anchored
+		{models.LabelMatchers{{Name: "team", Operator: "!~", Value: "web.*"}}, true},
+		{models.LabelMatchers{{Name: "env", Operator: "=", Value: ""}}, true},
+		{models.LabelMatchers{
+			{Name: "severity", Operator: "=", Value: "critical"},
+			{Name: "team", Operator: "=", Value: "web"},
+		}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.matchers.Matches(labels); got != tt.want {
+			t.Errorf("%+v.Matches = %v, want %v", tt.matchers, got, tt.want)
+		}
+	}
+
+	if err := (models.LabelMatcher{Name: "a", Operator: "=~", Value: "("}).Validate(); err == nil {
+		t.Error("Expected an invalid regex to be rejected")
+	}
+	if err := (models.LabelMatcher{Name: "a", Operator: "~"}).Validate(); err == nil {
+		t.Error("Expected an unknown operator to be rejected")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	if retryBackoff(1) != 30*time.Second || retryBackoff(2) != time.Minute {
+		t.Errorf("Unexpected backoff %v, %v", retryBackoff(1), retryBackoff(2))
+	}
+	if retryBackoff(20) != 15*time.Minute {
+		t.Errorf("Expected the backoff to be capped, got %v", retryBackoff(20))
+	}
+}
+
+func TestLogSamplesInNotifications(t *testing.T) {
+	n := testNotification()
+	n.Alerts[0].Samples = []models.LogSample{
+		{Timestamp: "2026-01-01T11:59:30Z", Line: "upstream timed out"},
+		{Timestamp: "2026-01-01T11:59:10Z", Line: "upstream timed out again"},
+	}
+
+	if text := n.Text(); !strings.Contains(text, "Log lines:\n    2026-01-01T11:59:30Z upstream timed out\n") {
+		t.Errorf("Expected the log lines in the text, got:\n%s", text)
+	}
+
+	server, requests := standIn(t, http.StatusOK)
+	ch, _ := NewChannel(models.NotificationChannel{
+		Type:     models.ChannelAlertmanager,
+		Settings: models.NotificationChannelSettings{URL: server.URL},
+	}, nil)
+	if err := ch.Send(context.Background(), n); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	var alerts []postableAlert
+	json.Unmarshal((<-requests).body, &alerts)
+	if alerts[0].Annotations["log_samples"] != "2026-01-01T11:59:30Z upstream timed out\n2026-01-01T11:59:10Z upstream timed out again" {
+		t.Errorf("Unexpected annotations %v", alerts[0].Annotations)
+	}
+	if _, ok := alerts[1].Annotations["log_samples"]; ok || n.Alerts[0].Annotations["log_samples"] != "" {
+		t.Error("Expected only the alert with samples to get the annotation, without changing it")
+	}
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// ChannelColumns are the notification_channels columns read by ScanChannel
+const ChannelColumns = `id, organization_id, name, type, settings, created_at, updated_at`
+
+// ScanChannel reads a row selected with ChannelColumns
+func ScanChannel(row pgx.Row) (*models.NotificationChannel, error) {
+	var ch models.NotificationChannel
+	if err := row.Scan(&ch.ID, &ch.OrganizationID, &ch.Name, &ch.Type, &ch.Settings, &ch.CreatedAt, &ch.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// RouteColumns are the notification_routes columns read by ScanRoute
+const RouteColumns = `id, organization_id, channel_id, matchers, group_by, group_wait_seconds,
+	group_interval_seconds, repeat_interval_seconds, created_at, updated_at`
+
+// ScanRoute reads a row selected with RouteColumns
+func ScanRoute(row pgx.Row) (*models.NotificationRoute, error) {
+	var r models.NotificationRoute
+	err := row.Scan(&r.ID, &r.OrganizationID, &r.ChannelID, &r.Matchers, &r.GroupBy, &r.GroupWaitSeconds,
+		&r.GroupIntervalSeconds, &r.RepeatIntervalSeconds, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DeliveryColumns are the notification_deliveries columns read by ScanDelivery
+const DeliveryColumns = `id, organization_id, channel_id, route_id, group_key, status, attempts, last_error,
+	payload, next_attempt_at, created_at, delivered_at`
+
+// ScanDelivery reads a row selected with DeliveryColumns
+func ScanDelivery(row pgx.Row) (*models.NotificationDelivery, error) {
+	var d models.NotificationDelivery
+	err := row.Scan(&d.ID, &d.OrganizationID, &d.ChannelID, &d.RouteID, &d.GroupKey, &d.Status, &d.Attempts,
+		&d.LastError, &d.Payload, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Headers on signed webhook requests
+const (
+	SignatureHeader = "X-Dash-Signature"
+	TimestampHeader = "X-Dash-Timestamp"
+)
+
+// Sign returns the signature of a webhook body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>". Covering
+// the timestamp lets receivers reject replayed requests.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookChannel posts the notification as JSON, signed when a secret is set
+type WebhookChannel struct {
+	URL     string
+	Secret  string
+	Headers map[string]string
+	client  *http.Client
+}
+
+func (c *WebhookChannel) Send(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	if c.Secret != "" {
+		ts := time.Now().Unix()
+		headers[TimestampHeader] = strconv.FormatInt(ts, 10)
+		headers[SignatureHeader] = Sign(c.Secret, ts, body)
+	}
+	return postJSON(ctx, c.client, c.URL, body, headers)
+}
+
+// SlackChannel posts to a Slack incoming webhook or a compatible one
+// (Mattermost, Rocket.Chat)
+type SlackChannel struct {
+	URL      string
+	Channel  string
+	Username string
+	client   *http.Client
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color string `json:"color"`
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+func (c *SlackChannel) Send(ctx context.Context, n *Notification) error {
+	color := "good"
+	if n.Status == "firing" {
+		color = "danger"
+	}
+	body, err := json.Marshal(slackMessage{
+		Channel:  c.Channel,
+		Username: c.Username,
+		Text:     n.Title(),
+		Attachments: []slackAttachment{{
+			Color: color,
+			Title: n.Title(),
+			Text:  n.Text(),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, c.client, c.URL, body, nil)
+}
+
+// postJSON posts body and treats any non-2xx response as a failure. Errors
+// leave out the URL, as some (Slack webhooks) embed a token, and are shown to
+// every member in the delivery log.
+func postJSON(ctx context.Context, client *http.Client, target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("failed to create request: invalid URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Dash-Alerting/1.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("request failed: %w", urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,264 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/janhoon/dash/backend/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+// Scopes reported when a query is refused
+const (
+	ScopeUser        = "user"
+	ScopeOrg         = "org"
+	ScopeDatasource  = "datasource"
+	ScopeDailyBudget = "daily_budget"
+)
+
+// slotTTL frees concurrency slots that were never released, e.g. after a crash.
+// It is well above the datasource query timeout.
+const slotTTL = 2 * time.Minute
+
+// Limits are an organization's datasource query limits. Zero means unlimited.
+type Limits struct {
+	UserQueriesPerMinute int `json:"user_queries_per_minute"`
+	OrgQueriesPerMinute  int `json:"org_queries_per_minute"`
+	MaxConcurrentQueries int `json:"max_concurrent_queries"` // per datasource
+	DailyQueryBudget     int `json:"daily_query_budget"`
+}
+
+// Validate rejects negative limits
+func (l Limits) Validate() error {
+	if l.UserQueriesPerMinute < 0 || l.OrgQueriesPerMinute < 0 || l.MaxConcurrentQueries < 0 || l.DailyQueryBudget < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// Within keeps each limit within the server's: an organization can tighten a
+// limit but not raise or remove it, so zero (unlimited) and values over the
+// server's limit fall back to it. Limits the server leaves unlimited are kept.
+func (l Limits) Within(server Limits) Limits {
+	clamp := func(v, max int) int {
+		if max > 0 && (v <= 0 || v > max) {
+			return max
+		}
+		return v
+	}
+	return Limits{
+		UserQueriesPerMinute: clamp(l.UserQueriesPerMinute, server.UserQueriesPerMinute),
+		OrgQueriesPerMinute:  clamp(l.OrgQueriesPerMinute, server.OrgQueriesPerMinute),
+		MaxConcurrentQueries: clamp(l.MaxConcurrentQueries, server.MaxConcurrentQueries),
+		DailyQueryBudget:     clamp(l.DailyQueryBudget, server.DailyQueryBudget),
+	}
+}
+
+// DefaultsFromEnv returns the limits for organizations without their own:
+//   - QUERY_LIMIT_USER_PER_MINUTE (120)
+//   - QUERY_LIMIT_ORG_PER_MINUTE (600)
+//   - QUERY_MAX_CONCURRENT_PER_DATASOURCE (10)
+//   - QUERY_DAILY_BUDGET (0, unlimited)
+func DefaultsFromEnv() Limits {
+	return Limits{
+		UserQueriesPerMinute: envInt("QUERY_LIMIT_USER_PER_MINUTE", 120),
+		OrgQueriesPerMinute:  envInt("QUERY_LIMIT_ORG_PER_MINUTE", 600),
+		MaxConcurrentQueries: envInt("QUERY_MAX_CONCURRENT_PER_DATASOURCE", 10),
+		DailyQueryBudget:     envInt("QUERY_DAILY_BUDGET", 0),
+	}
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
+
+// Exceeded is returned when a query is over one of the limits
+type Exceeded struct {
+	Scope      string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *Exceeded) Error() string {
+	return fmt.Sprintf("%s query limit of %d exceeded", e.Scope, e.Limit)
+}
+
+// Usage is an organization's current consumption of its limits
+type Usage struct {
+	Limits                Limits         `json:"limits"`
+	UserQueriesLastMinute int            `json:"user_queries_last_minute"`
+	OrgQueriesLastMinute  int            `json:"org_queries_last_minute"`
+	QueriesToday          int            `json:"queries_today"`
+	DailyBudgetRemaining  *int           `json:"daily_budget_remaining,omitempty"`
+	BudgetResetsAt        time.Time      `json:"budget_resets_at"`
+	ConcurrentQueries     map[string]int `json:"concurrent_queries"` // by datasource ID
+}
+
+// Manager enforces query limits, keeping counters in Valkey (or memory
+// without it) and per-org overrides in Postgres
+type Manager struct {
+	pool     *pgxpool.Pool
+	limiter  *ratelimit.Limiter
+	defaults Limits
+	now      func() time.Time
+}
+
+func NewManager(pool *pgxpool.Pool, rdb *redis.Client) *Manager {
+	return &Manager{
+		pool:     pool,
+		limiter:  ratelimit.New(rdb),
+		defaults: DefaultsFromEnv(),
+		now:      time.Now,
+	}
+}
+
+// Defaults returns the limits used for organizations without their own
+func (m *Manager) Defaults() Limits {
+	return m.defaults
+}
+
+// Limits returns the organization's limits, or the defaults if it has none
+func (m *Manager) Limits(ctx context.Context, orgID uuid.UUID) (Limits, error) {
+	var l Limits
+	err := m.pool.QueryRow(ctx,
+		`SELECT user_queries_per_minute, org_queries_per_minute, max_concurrent_queries, daily_query_budget
+		 FROM organization_quotas WHERE organization_id = $1`,
+		orgID,
+	).Scan(&l.UserQueriesPerMinute, &l.OrgQueriesPerMinute, &l.MaxConcurrentQueries, &l.DailyQueryBudget)
+	if err == pgx.ErrNoRows {
+		return m.defaults, nil
+	}
+	if err != nil {
+		return Limits{}, err
+	}
+	// Stored limits are rechecked in case the server's were lowered since
+	return l.Within(m.defaults), nil
+}
+
+// SetLimits saves the organization's own limits, kept within the defaults
+func (m *Manager) SetLimits(ctx context.Context, orgID uuid.UUID, l Limits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	l = l.Within(m.defaults)
+	_, err := m.pool.Exec(ctx,
+		`INSERT INTO organization_quotas (organization_id, user_queries_per_minute, org_queries_per_minute, max_concurrent_queries, daily_query_budget)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (organization_id) DO UPDATE SET
+			user_queries_per_minute = $2, org_queries_per_minute = $3,
+			max_concurrent_queries = $4, daily_query_budget = $5, updated_at = NOW()`,
+		orgID, l.UserQueriesPerMinute, l.OrgQueriesPerMinute, l.MaxConcurrentQueries, l.DailyQueryBudget,
+	)
+	return err
+}
+
+// ResetLimits drops the organization's own limits so the defaults apply
+func (m *Manager) ResetLimits(ctx context.Context, orgID uuid.UUID) error {
+	_, err := m.pool.Exec(ctx, `DELETE FROM organization_quotas WHERE organization_id = $1`, orgID)
+	return err
+}
+
+// Acquire admits a query by userID against a datasource of orgID. It returns a
+// function to call when the query finishes, or an *Exceeded error.
+func (m *Manager) Acquire(ctx context.Context, orgID, userID, datasourceID uuid.UUID) (func(), error) {
+	limits, err := m.Limits(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return m.acquire(ctx, limits, orgID, userID, datasourceID)
+}
+
+func (m *Manager) acquire(ctx context.Context, limits Limits, orgID, userID, datasourceID uuid.UUID) (func(), error) {
+	if limits.UserQueriesPerMinute > 0 {
+		res := m.limiter.Allow(ctx, userKey(userID), limits.UserQueriesPerMinute, time.Minute)
+		if !res.Allowed {
+			return nil, &Exceeded{Scope: ScopeUser, Limit: limits.UserQueriesPerMinute, RetryAfter: res.RetryAfter}
+		}
+	}
+	if limits.OrgQueriesPerMinute > 0 {
+		res := m.limiter.Allow(ctx, orgKey(orgID), limits.OrgQueriesPerMinute, time.Minute)
+		if !res.Allowed {
+			return nil, &Exceeded{Scope: ScopeOrg, Limit: limits.OrgQueriesPerMinute, RetryAfter: res.RetryAfter}
+		}
+	}
+
+	release := func() {}
+	if limits.MaxConcurrentQueries > 0 {
+		var ok bool
+		release, ok = m.limiter.Acquire(ctx, datasourceKey(datasourceID), limits.MaxConcurrentQueries, slotTTL)
+		if !ok {
+			return nil, &Exceeded{Scope: ScopeDatasource, Limit: limits.MaxConcurrentQueries, RetryAfter: time.Second}
+		}
+	}
+
+	// The budget is charged last so refused queries don't use it up
+	if limits.DailyQueryBudget > 0 {
+		now := m.now().UTC()
+		if _, ok := m.limiter.Consume(ctx, budgetKey(orgID, now), limits.DailyQueryBudget, 25*time.Hour); !ok {
+			release()
+			return nil, &Exceeded{Scope: ScopeDailyBudget, Limit: limits.DailyQueryBudget, RetryAfter: nextDay(now).Sub(now)}
+		}
+	}
+
+	return release, nil
+}
+
+// Usage reports the organization's current usage; userID's own rate is
+// included, as are the in-flight queries of each datasource
+func (m *Manager) Usage(ctx context.Context, orgID, userID uuid.UUID, datasourceIDs []uuid.UUID) (*Usage, error) {
+	limits, err := m.Limits(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now().UTC()
+	usage := &Usage{
+		Limits:                limits,
+		UserQueriesLastMinute: m.limiter.Recent(ctx, userKey(userID), time.Minute),
+		OrgQueriesLastMinute:  m.limiter.Recent(ctx, orgKey(orgID), time.Minute),
+		QueriesToday:          m.limiter.Count(ctx, budgetKey(orgID, now)),
+		BudgetResetsAt:        nextDay(now),
+		ConcurrentQueries:     make(map[string]int, len(datasourceIDs)),
+	}
+	if limits.DailyQueryBudget > 0 {
+		remaining := max(limits.DailyQueryBudget-usage.QueriesToday, 0)
+		usage.DailyBudgetRemaining = &remaining
+	}
+	for _, id := range datasourceIDs {
+		usage.ConcurrentQueries[id.String()] = m.limiter.Count(ctx, datasourceKey(id))
+	}
+	return usage, nil
+}
+
+func userKey(userID uuid.UUID) string {
+	return "query:user:" + userID.String()
+}
+
+func orgKey(orgID uuid.UUID) string {
+	return "query:org:" + orgID.String()
+}
+
+func datasourceKey(datasourceID uuid.UUID) string {
+	return "query_concurrency:" + datasourceID.String()
+}
+
+// budgetKey counts an organization's queries for one UTC day
+func budgetKey(orgID uuid.UUID, now time.Time) string {
+	return "query_budget:" + orgID.String() + ":" + now.Format("2006-01-02")
+}
+
+// nextDay returns the next UTC midnight, when daily budgets reset
+func nextDay(now time.Time) time.Time {
+	y, mo, d := now.UTC().Date()
+	return time.Date(y, mo, d+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,147 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/janhoon/dash/backend/internal/ratelimit"
+)
+
+func newTestManager(now time.Time) *Manager {
+	return &Manager{
+		limiter: ratelimit.New(nil),
+		now:     func() time.Time { return now },
+	}
+}
+
+func scopeOf(err error) string {
+	var exceeded *Exceeded
+	if errors.As(err, &exceeded) {
+		return exceeded.Scope
+	}
+	return ""
+}
+
+func TestUserAndOrgRateLimits(t *testing.T) {
+	m := newTestManager(time.Now())
+	ctx := context.Background()
+	orgID, ds := uuid.New(), uuid.New()
+	alice, bob := uuid.New(), uuid.New()
+	limits := Limits{UserQueriesPerMinute: 2, OrgQueriesPerMinute: 3}
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.acquire(ctx, limits, orgID, alice, ds); err != nil {
+			t.Fatalf("Expected query %d to be admitted: %v", i+1, err)
+		}
+	}
+	if _, err := m.acquire(ctx, limits, orgID, alice, ds); scopeOf(err) != ScopeUser {
+		t.Errorf("Expected the user limit, got %v", err)
+	}
+
+	if _, err := m.acquire(ctx, limits, orgID, bob, ds); err != nil {
+		t.Fatalf("Expected another user to be admitted: %v", err)
+	}
+	_, err := m.acquire(ctx, limits, orgID, bob, ds)
+	if scopeOf(err) != ScopeOrg {
+		t.Fatalf("Expected the org limit, got %v", err)
+	}
+	if err.(*Exceeded).RetryAfter <= 0 {
+		t.Error("Expected a retry delay")
+	}
+}
+
+func TestDatasourceConcurrency(t *testing.T) {
+	m := newTestManager(time.Now())
+	ctx := context.Background()
+	orgID, userID, ds := uuid.New(), uuid.New(), uuid.New()
+	limits := Limits{MaxConcurrentQueries: 1}
+
+	release, err := m.acquire(ctx, limits, orgID, userID, ds)
+	if err != nil {
+		t.Fatalf("Expected the first query to be admitted: %v", err)
+	}
+	if _, err := m.acquire(ctx, limits, orgID, userID, ds); scopeOf(err) != ScopeDatasource {
+		t.Errorf("Expected the concurrency limit, got %v", err)
+	}
+	if _, err := m.acquire(ctx, limits, orgID, userID, uuid.New()); err != nil {
+		t.Errorf("Expected another datasource to be admitted: %v", err)
+	}
+
+	release()
+	if _, err := m.acquire(ctx, limits, orgID, userID, ds); err != nil {
+		t.Errorf("Expected a query to be admitted after release: %v", err)
+	}
+}
+
+func TestDailyBudget(t *testing.T) {
+	now := time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)
+	m := newTestManager(now)
+	ctx := context.Background()
+	orgID, userID, ds := uuid.New(), uuid.New(), uuid.New()
+	limits := Limits{DailyQueryBudget: 2, MaxConcurrentQueries: 5}
+
+	for i := 0; i < 2; i++ {
+		release, err := m.acquire(ctx, limits, orgID, userID, ds)
+		if err != nil {
+			t.Fatalf("Expected query %d to be admitted: %v", i+1, err)
+		}
+		release()
+	}
+
+	_, err := m.acquire(ctx, limits, orgID, userID, ds)
+	if scopeOf(err) != ScopeDailyBudget {
+		t.Fatalf("Expected the daily budget, got %v", err)
+	}
+	if retry := err.(*Exceeded).RetryAfter; retry != 2*time.Hour {
+		t.Errorf("Expected to retry at midnight UTC (2h), got %v", retry)
+	}
+
+	// A refused query gives its concurrency slot back
+	if n := m.limiter.Count(ctx, datasourceKey(ds)); n != 0 {
+		t.Errorf("Expected no slots in use, got %d", n)
+	}
+
+	if n := m.limiter.Count(ctx, budgetKey(orgID, now)); n != 2 {
+		t.Errorf("Expected 2 queries today, got %d", n)
+	}
+
+	// The budget is per UTC day
+	m.now = func() time.Time { return now.Add(3 * time.Hour) }
+	if _, err := m.acquire(ctx, limits, orgID, userID, ds); err != nil {
+		t.Errorf("Expected a new day's budget: %v", err)
+	}
+}
+
+func TestZeroLimitsAreUnlimited(t *testing.T) {
+	m := newTestManager(time.Now())
+	ctx := context.Background()
+	orgID, userID, ds := uuid.New(), uuid.New(), uuid.New()
+
+	for i := 0; i < 100; i++ {
+		if _, err := m.acquire(ctx, Limits{}, orgID, userID, ds); err != nil {
+			t.Fatalf("Expected no limits to apply: %v", err)
+		}
+	}
+}
+
+func TestLimitsValidate(t *testing.T) {
+	if err := (Limits{UserQueriesPerMinute: -1}).Validate(); err == nil {
+		t.Error("Expected negative limits to be rejected")
+	}
+	if err := DefaultsFromEnv().Validate(); err != nil {
+		t.Errorf("Expected the defaults to be valid: %v", err)
+	}
+}
+
+func TestLimitsWithinServerLimits(t *testing.T) {
+	server := Limits{UserQueriesPerMinute: 120, OrgQueriesPerMinute: 600, MaxConcurrentQueries: 10}
+
+	got := Limits{UserQueriesPerMinute: 0, OrgQueriesPerMinute: 6000, MaxConcurrentQueries: 2, DailyQueryBudget: 500}.Within(server)
+	want := Limits{UserQueriesPerMinute: 120, OrgQueriesPerMinute: 600, MaxConcurrentQueries: 2, DailyQueryBudget: 500}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,451 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	windowPrefix   = "ratelimit:"
+	lockoutPrefix  = "lockout:"
+	failuresPrefix = "lockout_failures:"
+	counterPrefix  = "counter:"
+)
+
+// Result is the outcome of counting an attempt against a limit
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// LockoutPolicy locks a key out after repeated failures. Once Threshold
+// failures are recorded the key is locked for Base, doubling with every further
+// failure up to Max. Failures are forgotten after ResetAfter without one.
+type LockoutPolicy struct {
+	Threshold  int
+	Base       time.Duration
+	Max        time.Duration
+	ResetAfter time.Duration
+}
+
+// lockFor returns how long to lock a key after its nth failure
+func (p LockoutPolicy) lockFor(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// Limiter enforces sliding-window rate limits and lockouts. Counters live in
+// Valkey so limits hold across instances; without Valkey, or while it is
+// unreachable, they are kept in memory. A nil Limiter allows everything.
+type Limiter struct {
+	rdb *redis.Client
+	mem *memoryBackend
+	now func() time.Time
+
+	warnMu   sync.Mutex
+	lastWarn time.Time
+}
+
+// New returns a limiter backed by Valkey, or by memory only when rdb is nil
+func New(rdb *redis.Client) *Limiter {
+	return &Limiter{
+		rdb: rdb,
+		mem: newMemoryBackend(),
+		now: time.Now,
+	}
+}
+
+// fallback logs, at most once a minute, that Valkey failed and memory is used
+func (l *Limiter) fallback(err error) {
+	l.warnMu.Lock()
+	defer l.warnMu.Unlock()
+	if time.Since(l.lastWarn) > time.Minute {
+		log.Printf("Rate limiter falling back to in-memory counters: %v", err)
+		l.lastWarn = time.Now()
+	}
+}
+
+// slidingWindow keeps a log of attempt timestamps (ms) in a sorted set. An
+// attempt is only recorded when it is allowed.
+var slidingWindow = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return {0, 0, tonumber(oldest[2]) + window - now}
+end
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], window)
+return {1, limit - count - 1, 0}
+`)
+
+// Allow counts an attempt for key, allowing at most limit attempts in any
+// window-long period
+func (l *Limiter) Allow(ctx context.Context, key string, limit int, window time.Duration) Result {
+	if l == nil {
+		return Result{Allowed: true, Remaining: limit}
+	}
+	now := l.now()
+	if l.rdb != nil {
+		member := strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+		values, err := slidingWindow.Run(ctx, l.rdb, []string{windowPrefix + key},
+			now.UnixMilli(), window.Milliseconds(), limit, member,
+		).Int64Slice()
+		if err == nil {
+			return Result{
+				Allowed:    values[0] == 1,
+				Remaining:  int(values[1]),
+				RetryAfter: time.Duration(values[2]) * time.Millisecond,
+			}
+		}
+		l.fallback(err)
+	}
+	return l.mem.allow(key, limit, window, now)
+}
+
+// Locked returns how much longer key is locked out, or zero
+func (l *Limiter) Locked(ctx context.Context, key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if l.rdb != nil {
+		ttl, err := l.rdb.PTTL(ctx, lockoutPrefix+key).Result()
+		if err == nil {
+			if ttl > 0 {
+				return ttl
+			}
+			return 0
+		}
+		l.fallback(err)
+	}
+	return l.mem.locked(key, l.now())
+}
+
+// RecordFailure counts a failed attempt for key and applies the policy. It
+// returns the lockout started by this failure, or zero.
+func (l *Limiter) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if l.rdb != nil {
+		pipe := l.rdb.TxPipeline()
+		incr := pipe.Incr(ctx, failuresPrefix+key)
+		pipe.PExpire(ctx, failuresPrefix+key, policy.ResetAfter)
+		_, err := pipe.Exec(ctx)
+		if err == nil {
+			d := policy.lockFor(int(incr.Val()))
+			if d > 0 {
+				if err := l.rdb.Set(ctx, lockoutPrefix+key, 1, d).Err(); err != nil {
+					l.fallback(err)
+				}
+			}
+			return d
+		}
+		l.fallback(err)
+	}
+	return l.mem.recordFailure(key, policy, l.now())
+}
+
+// Reset clears the failures and any lockout for key, e.g. after a successful login
+func (l *Limiter) Reset(ctx context.Context, key string) {
+	if l == nil {
+		return
+	}
+	if l.rdb != nil {
+		if err := l.rdb.Del(ctx, failuresPrefix+key, lockoutPrefix+key).Err(); err != nil {
+			l.fallback(err)
+		}
+	}
+	l.mem.reset(key)
+}
+
+type memoryCounter struct {
+	value   int
+	expires time.Time
+}
+
+type memoryFailures struct {
+	count       int
+	last        time.Time
+	resetAfter  time.Duration
+	lockedUntil time.Time
+}
+
+// memoryBackend is the per-instance fallback for the Valkey counters
+type memoryBackend struct {
+	mu        sync.Mutex
+	windows   map[string][]time.Time
+	failures  map[string]*memoryFailures
+	counters  map[string]*memoryCounter
+	lastSweep time.Time
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		windows:  make(map[string][]time.Time),
+		failures: make(map[string]*memoryFailures),
+		counters: make(map[string]*memoryCounter),
+	}
+}
+
+// sweep drops idle entries so the maps don't grow without bound. Callers hold mu.
+func (m *memoryBackend) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, f := range m.failures {
+		if now.Sub(f.last) > f.resetAfter && now.After(f.lockedUntil) {
+			delete(m.failures, key)
+		}
+	}
+	for key, c := range m.counters {
+		if now.After(c.expires) {
+			delete(m.counters, key)
+		}
+	}
+	for key, attempts := range m.windows {
+		// Windows are short compared to an hour; anything older is stale
+		if len(attempts) == 0 || now.Sub(attempts[len(attempts)-1]) > time.Hour {
+			delete(m.windows, key)
+		}
+	}
+}
+
+func (m *memoryBackend) allow(key string, limit int, window time.Duration, now time.Time) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	attempts := m.windows[key]
+	start := 0
+	for start < len(attempts) && !attempts[start].After(now.Add(-window)) {
+		start++
+	}
+	attempts = attempts[start:]
+
+	if len(attempts) >= limit {
+		m.windows[key] = attempts
+		return Result{RetryAfter: attempts[0].Add(window).Sub(now)}
+	}
+
+	m.windows[key] = append(attempts, now)
+	return Result{Allowed: true, Remaining: limit - len(attempts) - 1}
+}
+
+func (m *memoryBackend) locked(key string, now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.failures[key]; ok && now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+func (m *memoryBackend) recordFailure(key string, policy LockoutPolicy, now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	f, ok := m.failures[key]
+	if !ok || now.Sub(f.last) > policy.ResetAfter {
+		f = &memoryFailures{}
+		m.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	f.resetAfter = policy.ResetAfter
+
+	d := policy.lockFor(f.count)
+	if d > 0 {
+		f.lockedUntil = now.Add(d)
+	}
+	return d
+}
+
+func (m *memoryBackend) reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, key)
+}
+
+// acquireSlot takes one of max concurrent slots; the TTL frees slots leaked by
+// a crashed instance
+var acquireSlot = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n > tonumber(ARGV[1]) then
+	redis.call('DECR', KEYS[1])
+	return 0
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// releaseSlot gives a slot back without going below zero
+var releaseSlot = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') > 0 then
+	return redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// Acquire takes one of max concurrent slots for key. The returned release
+// function must be called when the work is done. Slots are freed after ttl in
+// case release is never called.
+func (l *Limiter) Acquire(ctx context.Context, key string, max int, ttl time.Duration) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	if l.rdb != nil {
+		acquired, err := acquireSlot.Run(ctx, l.rdb, []string{counterPrefix + key}, max, ttl.Milliseconds()).Int()
+		if err == nil {
+			if acquired == 0 {
+				return nil, false
+			}
+			return func() {
+				// The request context may already be done
+				releaseSlot.Run(context.Background(), l.rdb, []string{counterPrefix + key})
+			}, true
+		}
+		l.fallback(err)
+	}
+	if !l.mem.add(key, 1, max, ttl, true, l.now()) {
+		return nil, false
+	}
+	return func() { l.mem.add(key, -1, 0, ttl, false, l.now()) }, true
+}
+
+// incrementUpTo adds one to a counter unless that would exceed the budget
+var incrementUpTo = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if n > tonumber(ARGV[1]) then
+	redis.call('DECR', KEYS[1])
+	return {0, n - 1}
+end
+return {1, n}
+`)
+
+// Consume uses one unit of a budget for key, e.g. a daily query allowance. The
+// counter starts when first used and is dropped after ttl. It returns the
+// amount used, including this unit when allowed.
+func (l *Limiter) Consume(ctx context.Context, key string, budget int, ttl time.Duration) (used int, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+	if l.rdb != nil {
+		values, err := incrementUpTo.Run(ctx, l.rdb, []string{counterPrefix + key}, budget, ttl.Milliseconds()).Int64Slice()
+		if err == nil {
+			return int(values[1]), values[0] == 1
+		}
+		l.fallback(err)
+	}
+	now := l.now()
+	ok = l.mem.add(key, 1, budget, ttl, false, now)
+	return l.mem.count(key, now), ok
+}
+
+// Count returns the current value of a counter used by Acquire or Consume
+func (l *Limiter) Count(ctx context.Context, key string) int {
+	if l == nil {
+		return 0
+	}
+	if l.rdb != nil {
+		n, err := l.rdb.Get(ctx, counterPrefix+key).Int()
+		if err == redis.Nil {
+			return 0
+		}
+		if err == nil {
+			return n
+		}
+		l.fallback(err)
+	}
+	return l.mem.count(key, l.now())
+}
+
+// Recent returns how many attempts Allow has recorded for key within window
+func (l *Limiter) Recent(ctx context.Context, key string, window time.Duration) int {
+	if l == nil {
+		return 0
+	}
+	now := l.now()
+	if l.rdb != nil {
+		n, err := l.rdb.ZCount(ctx, windowPrefix+key, "("+strconv.FormatInt(now.Add(-window).UnixMilli(), 10), "+inf").Result()
+		if err == nil {
+			return int(n)
+		}
+		l.fallback(err)
+	}
+	return l.mem.recent(key, window, now)
+}
+
+func (m *memoryBackend) recent(key string, window time.Duration, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.windows[key] {
+		if t.After(now.Add(-window)) {
+			n++
+		}
+	}
+	return n
+}
+
+// add changes a counter by delta, refusing increments past max. A counter
+// expires ttl after it was created, or after its last change with refresh;
+// counters never go below zero.
+func (m *memoryBackend) add(key string, delta, max int, ttl time.Duration, refresh bool, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	c, ok := m.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &memoryCounter{expires: now.Add(ttl)}
+		m.counters[key] = c
+	}
+	if delta > 0 && c.value+delta > max {
+		return false
+	}
+	c.value += delta
+	if c.value < 0 {
+		c.value = 0
+	}
+	if refresh {
+		c.expires = now.Add(ttl)
+	}
+	return true
+}
+
+func (m *memoryBackend) count(key string, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.counters[key]; ok && now.Before(c.expires) {
+		return c.value
+	}
+	return 0
+}
@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testLimiter runs the shared checks; advance moves the limiter's clock (and
+// the backing store's, if any) forward
+func testLimiter(t *testing.T, l *Limiter, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if res := l.Allow(ctx, "login:ip:1.2.3.4", 3, time.Minute); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("Expected attempt %d to be allowed with %d remaining, got %+v", i+1, 2-i, res)
+		}
+		advance(10 * time.Second)
+	}
+
+	res := l.Allow(ctx, "login:ip:1.2.3.4", 3, time.Minute)
+	if res.Allowed {
+		t.Fatal("Expected the fourth attempt to be limited")
+	}
+	// The oldest attempt was 30s ago, so it leaves the window in 30s
+	if res.RetryAfter != 30*time.Second {
+		t.Errorf("Expected RetryAfter 30s, got %v", res.RetryAfter)
+	}
+
+	// Other keys are counted separately
+	if res := l.Allow(ctx, "login:ip:5.6.7.8", 3, time.Minute); !res.Allowed {
+		t.Error("Expected a different key to be allowed")
+	}
+
+	// The window slides: once the oldest attempt is out, one more is allowed
+	advance(31 * time.Second)
+	if res := l.Allow(ctx, "login:ip:1.2.3.4", 3, time.Minute); !res.Allowed {
+		t.Error("Expected an attempt to be allowed after the oldest one expired")
+	}
+	if res := l.Allow(ctx, "login:ip:1.2.3.4", 3, time.Minute); res.Allowed {
+		t.Error("Expected the window to still hold two recent attempts")
+	}
+}
+
+func testLockout(t *testing.T, l *Limiter, advance func(time.Duration)) {
+	ctx := context.Background()
+	policy := LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 3 * time.Minute, ResetAfter: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if d := l.RecordFailure(ctx, "login:user@example.com", policy); d != 0 {
+			t.Fatalf("Expected no lockout after %d failures, got %v", i+1, d)
+		}
+	}
+	if d := l.Locked(ctx, "login:user@example.com"); d != 0 {
+		t.Fatalf("Expected not to be locked, got %v", d)
+	}
+
+	// Lockouts double with each further failure, up to the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		if d := l.RecordFailure(ctx, "login:user@example.com", policy); d != want {
+			t.Errorf("Expected a %v lockout, got %v", want, d)
+		}
+	}
+	if d := l.Locked(ctx, "login:user@example.com"); d <= 0 || d > 3*time.Minute {
+		t.Errorf("Expected to be locked for up to 3m, got %v", d)
+	}
+
+	advance(4 * time.Minute)
+	if d := l.Locked(ctx, "login:user@example.com"); d != 0 {
+		t.Errorf("Expected the lockout to expire, got %v", d)
+	}
+
+	l.RecordFailure(ctx, "login:user@example.com", policy)
+	l.Reset(ctx, "login:user@example.com")
+	if d := l.Locked(ctx, "login:user@example.com"); d != 0 {
+		t.Errorf("Expected Reset to clear the lockout, got %v", d)
+	}
+	if d := l.RecordFailure(ctx, "login:user@example.com", policy); d != 0 {
+		t.Errorf("Expected Reset to clear the failure count, got %v", d)
+	}
+}
+
+func testCounters(t *testing.T, l *Limiter, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	// Concurrency slots
+	release1, ok := l.Acquire(ctx, "ds:1", 2, time.Minute)
+	if !ok {
+		t.Fatal("Expected the first slot to be acquired")
+	}
+	release2, ok := l.Acquire(ctx, "ds:1", 2, time.Minute)
+	if !ok {
+		t.Fatal("Expected the second slot to be acquired")
+	}
+	if _, ok := l.Acquire(ctx, "ds:1", 2, time.Minute); ok {
+		t.Error("Expected a third slot to be refused")
+	}
+	if n := l.Count(ctx, "ds:1"); n != 2 {
+		t.Errorf("Expected 2 slots in use, got %d", n)
+	}
+	release1()
+	release2()
+	release2() // releasing twice doesn't go below zero
+	if n := l.Count(ctx, "ds:1"); n != 0 {
+		t.Errorf("Expected no slots in use after release, got %d", n)
+	}
+
+	// Budgets
+	for i := 1; i <= 3; i++ {
+		if used, ok := l.Consume(ctx, "budget:org", 3, time.Hour); !ok || used != i {
+			t.Fatalf("Expected unit %d to be allowed, got used=%d ok=%v", i, used, ok)
+		}
+	}
+	if used, ok := l.Consume(ctx, "budget:org", 3, time.Hour); ok || used != 3 {
+		t.Errorf("Expected the budget to be exhausted at 3, got used=%d ok=%v", used, ok)
+	}
+	advance(time.Hour + time.Second)
+	if n := l.Count(ctx, "budget:org"); n != 0 {
+		t.Errorf("Expected the budget to reset after its ttl, got %d", n)
+	}
+
+	// Recent counts what Allow recorded in the window
+	l.Allow(ctx, "query:user", 10, time.Minute)
+	advance(30 * time.Second)
+	l.Allow(ctx, "query:user", 10, time.Minute)
+	if n := l.Recent(ctx, "query:user", time.Minute); n != 2 {
+		t.Errorf("Expected 2 recent attempts, got %d", n)
+	}
+	advance(31 * time.Second)
+	if n := l.Recent(ctx, "query:user", time.Minute); n != 1 {
+		t.Errorf("Expected 1 recent attempt, got %d", n)
+	}
+}
+
+func newTestValkeyLimiter(t *testing.T) (*Limiter, func(time.Duration)) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	l := New(rdb)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) {
+		now = now.Add(d)
+		mr.FastForward(d)
+	}
+}
+
+func newTestMemoryLimiter() (*Limiter, func(time.Duration)) {
+	l := New(nil)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestValkeyLimiter(t *testing.T) {
+	l, advance := newTestValkeyLimiter(t)
+	testLimiter(t, l, advance)
+}
+
+func TestValkeyLockout(t *testing.T) {
+	l, advance := newTestValkeyLimiter(t)
+	testLockout(t, l, advance)
+}
+
+func TestValkeyCounters(t *testing.T) {
+	l, advance := newTestValkeyLimiter(t)
+	testCounters(t, l, advance)
+}
+
+func TestMemoryCounters(t *testing.T) {
+	l, advance := newTestMemoryLimiter()
+	testCounters(t, l, advance)
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	l, advance := newTestMemoryLimiter()
+	testLimiter(t, l, advance)
+}
+
+func TestMemoryLockout(t *testing.T) {
+	l, advance := newTestMemoryLimiter()
+	testLockout(t, l, advance)
+}
+
+func TestNilLimiterAllowsEverything(t *testing.T) {
+	var l *Limiter
+	ctx := context.Background()
+	if res := l.Allow(ctx, "key", 0, time.Minute); !res.Allowed {
+		t.Error("Expected a nil limiter to allow")
+	}
+	if _, ok := l.Acquire(ctx, "key", 0, time.Minute); !ok {
+		t.Error("Expected a nil limiter to hand out slots")
+	}
+	if _, ok := l.Consume(ctx, "key", 0, time.Minute); !ok {
+		t.Error("Expected a nil limiter to have no budget")
+	}
+}
+
+func TestFallsBackToMemoryWhenValkeyIsDown(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+	mr.Close()
+
+	l := New(rdb)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if res := l.Allow(ctx, "register:ip:1.2.3.4", 2, time.Minute); !res.Allowed {
+			t.Fatalf("Expected attempt %d to be allowed", i+1)
+		}
+	}
+	if res := l.Allow(ctx, "register:ip:1.2.3.4", 2, time.Minute); res.Allowed {
+		t.Error("Expected the in-memory fallback to enforce the limit")
+	}
+}
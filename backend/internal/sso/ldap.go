@@ -0,0 +1,223 @@
+package sso
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLDAPUserNotFound   = errors.New("LDAP user not found")
+)
+
+// DefaultLDAPUserFilter finds a user by uid or mail; {username} is replaced with the escaped login name
+const DefaultLDAPUserFilter = "(&(objectClass=person)(|(uid={username})(mail={username})))"
+
+const defaultLDAPTimeout = 10 * time.Second
+
+// LDAPConfig configures authentication against an LDAP or Active Directory server
+type LDAPConfig struct {
+	// URL is ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades an ldap:// connection before binding
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// RootCA is an optional PEM bundle used to verify the server certificate
+	RootCA string
+
+	// BindDN and BindPassword are the service account used to search for users.
+	// Both empty means an anonymous search bind.
+	BindDN       string
+	BindPassword string
+
+	BaseDN     string
+	UserFilter string
+
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+
+	Timeout time.Duration
+}
+
+// LDAPProvider authenticates users with a search-then-bind against a directory
+type LDAPProvider struct {
+	cfg       LDAPConfig
+	tlsConfig *tls.Config
+}
+
+// NewLDAPProvider validates the config and fills in attribute defaults
+func NewLDAPProvider(cfg LDAPConfig) (*LDAPProvider, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, errors.New("LDAP URL must be ldap://host[:port] or ldaps://host[:port]")
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("StartTLS cannot be used with ldaps://")
+	}
+	if cfg.BaseDN == "" {
+		return nil, errors.New("LDAP base DN is required")
+	}
+
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = DefaultLDAPUserFilter
+	}
+	if !strings.Contains(cfg.UserFilter, "{username}") {
+		return nil, errors.New("LDAP user filter must contain {username}")
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.NameAttribute == "" {
+		cfg.NameAttribute = "cn"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultLDAPTimeout
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.RootCA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.RootCA)) {
+			return nil, errors.New("invalid LDAP root CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &LDAPProvider{cfg: cfg, tlsConfig: tlsConfig}, nil
+}
+
+// Issuer identifies the directory; it qualifies user DNs in linked accounts
+func (p *LDAPProvider) Issuer() string {
+	return p.cfg.URL
+}
+
+// connect dials the directory, upgrades with StartTLS if configured and binds
+// as the service account
+func (p *LDAPProvider) connect() (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.cfg.Timeout}
+	conn, err := ldap.DialURL(p.cfg.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(p.cfg.Timeout)
+
+	if p.cfg.StartTLS {
+		if err := conn.StartTLS(p.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if p.cfg.BindDN != "" {
+		err = conn.Bind(p.cfg.BindDN, p.cfg.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to bind as service account: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (p *LDAPProvider) attributes() []string {
+	return []string{p.cfg.EmailAttribute, p.cfg.NameAttribute, p.cfg.GroupAttribute}
+}
+
+// Authenticate finds the user matching username and verifies password by
+// binding as them. It returns ErrInvalidCredentials for unknown users, ambiguous
+// matches and wrong passwords alike.
+func (p *LDAPProvider) Authenticate(username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which always succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(p.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, p.attributes(), nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) &&
+		!ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("failed to search for user: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to bind as user: %w", err)
+	}
+
+	return p.identity(entry), nil
+}
+
+// Lookup re-reads a user's attributes and groups by DN with the service account,
+// returning ErrLDAPUserNotFound if the entry no longer exists
+func (p *LDAPProvider) Lookup(dn string) (*Identity, error) {
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", p.attributes(), nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrLDAPUserNotFound
+		}
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrLDAPUserNotFound
+	}
+
+	return p.identity(result.Entries[0]), nil
+}
+
+// identity maps a directory entry to an identity; the subject is the entry DN
+func (p *LDAPProvider) identity(entry *ldap.Entry) *Identity {
+	identity := &Identity{
+		Issuer:  p.cfg.URL,
+		Subject: entry.DN,
+		Email:   entry.GetAttributeValue(p.cfg.EmailAttribute),
+		Name:    entry.GetAttributeValue(p.cfg.NameAttribute),
+		Groups:  entry.GetAttributeValues(p.cfg.GroupAttribute),
+		Claims:  map[string]interface{}{},
+	}
+	for _, attr := range entry.Attributes {
+		identity.Claims[attr.Name] = attr.Values
+	}
+	return identity
+}
@@ -0,0 +1,154 @@
+package sso
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+// startTestDirectory starts an in-process LDAP server with alice (in the admins
+// group), bob (no groups) and the svc service account, all with password "password"
+func startTestDirectory(t *testing.T, opt ...testdirectory.Option) *testdirectory.Directory {
+	t.Helper()
+	d := testdirectory.Start(t, opt...)
+	users := testdirectory.NewUsers(t, []string{"alice"}, testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"admins"})...))
+	users = append(users, testdirectory.NewUsers(t, []string{"bob", "svc"})...)
+	d.SetUsers(users...)
+	d.SetGroups(testdirectory.NewGroup(t, "admins", []string{"alice"}))
+	return d
+}
+
+func newTestLDAPProvider(t *testing.T, d *testdirectory.Directory, scheme string, startTLS bool) *LDAPProvider {
+	t.Helper()
+	provider, err := NewLDAPProvider(LDAPConfig{
+		URL:            fmt.Sprintf("%s://%s:%d", scheme, d.Host(), d.Port()),
+		StartTLS:       startTLS,
+		RootCA:         d.Cert(),
+		BindDN:         "cn=svc," + testdirectory.DefaultUserDN,
+		BindPassword:   "password",
+		BaseDN:         testdirectory.DefaultUserDN,
+		UserFilter:     "(cn={username})",
+		EmailAttribute: "email",
+		NameAttribute:  "name",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create LDAP provider: %v", err)
+	}
+	return provider
+}
+
+func TestLDAPAuthenticateLDAPS(t *testing.T) {
+	d := startTestDirectory(t)
+	provider := newTestLDAPProvider(t, d, "ldaps", false)
+
+	identity, err := provider.Authenticate("alice", "password")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	if identity.Subject != "cn=alice,"+testdirectory.DefaultUserDN {
+		t.Errorf("Expected user DN as subject, got '%s'", identity.Subject)
+	}
+	if identity.Email != "alice@example.com" {
+		t.Errorf("Expected email 'alice@example.com', got '%s'", identity.Email)
+	}
+	if identity.Name != "alice" {
+		t.Errorf("Expected name 'alice', got '%s'", identity.Name)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"cn=admins," + testdirectory.DefaultGroupDN}) {
+		t.Errorf("Expected admins group DN, got %v", identity.Groups)
+	}
+	if err := identity.Validate(); err != nil {
+		t.Errorf("Expected identity to be valid, got %v", err)
+	}
+}
+
+func TestLDAPAuthenticateStartTLS(t *testing.T) {
+	d := startTestDirectory(t, testdirectory.WithNoTLS(t))
+	provider := newTestLDAPProvider(t, d, "ldap", true)
+
+	if _, err := provider.Authenticate("bob", "password"); err != nil {
+		t.Fatalf("Authenticate over StartTLS failed: %v", err)
+	}
+}
+
+func TestLDAPAuthenticateInvalidCredentials(t *testing.T) {
+	d := startTestDirectory(t)
+	provider := newTestLDAPProvider(t, d, "ldaps", false)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "wrong"},
+		{"unknown user", "mallory", "password"},
+		{"empty password", "alice", ""},
+	}
+
+	for _, tt := range tests {
+		if _, err := provider.Authenticate(tt.username, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestLDAPAuthenticateUntrustedCertificate(t *testing.T) {
+	d := startTestDirectory(t)
+	provider, err := NewLDAPProvider(LDAPConfig{
+		URL:          fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port()),
+		BindDN:       "cn=svc," + testdirectory.DefaultUserDN,
+		BindPassword: "password",
+		BaseDN:       testdirectory.DefaultUserDN,
+		UserFilter:   "(cn={username})",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create LDAP provider: %v", err)
+	}
+
+	_, err = provider.Authenticate("alice", "password")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected TLS verification error, got %v", err)
+	}
+}
+
+func TestLDAPLookup(t *testing.T) {
+	d := startTestDirectory(t)
+	provider := newTestLDAPProvider(t, d, "ldaps", false)
+
+	identity, err := provider.Lookup("cn=alice," + testdirectory.DefaultUserDN)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(identity.Groups) != 1 {
+		t.Errorf("Expected alice's group, got %v", identity.Groups)
+	}
+
+	// Removing the user from the directory surfaces as not found
+	d.SetUsers(testdirectory.NewUsers(t, []string{"bob", "svc"})...)
+	if _, err := provider.Lookup("cn=alice," + testdirectory.DefaultUserDN); !errors.Is(err, ErrLDAPUserNotFound) {
+		t.Errorf("Expected ErrLDAPUserNotFound, got %v", err)
+	}
+}
+
+func TestNewLDAPProviderValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  LDAPConfig
+	}{
+		{"bad scheme", LDAPConfig{URL: "http://ldap.example.com", BaseDN: "dc=example,dc=org"}},
+		{"missing base dn", LDAPConfig{URL: "ldap://ldap.example.com"}},
+		{"starttls with ldaps", LDAPConfig{URL: "ldaps://ldap.example.com", BaseDN: "dc=example,dc=org", StartTLS: true}},
+		{"filter without placeholder", LDAPConfig{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=org", UserFilter: "(uid=x)"}},
+		{"invalid root ca", LDAPConfig{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=org", RootCA: "nope"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewLDAPProvider(tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
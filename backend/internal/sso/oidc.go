@@ -0,0 +1,247 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/janhoon/dash/backend/internal/models"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrMissingIDToken   = errors.New("token response did not contain an id_token")
+	ErrNonceMismatch    = errors.New("id_token nonce mismatch")
+	ErrEmailNotVerified = errors.New("email not verified")
+	ErrMissingEmail     = errors.New("no email found in id_token claims")
+)
+
+// DefaultScopes are requested when an OIDC config does not specify any
+var DefaultScopes = []string{oidc.ScopeOpenID, "email", "profile"}
+
+// DefaultClaimMapping maps the standard OIDC claims to user attributes
+var DefaultClaimMapping = models.SSOClaimMapping{
+	Subject: "sub",
+	Email:   "email",
+	Name:    "name",
+	Groups:  "groups",
+}
+
+// OIDCConfig configures an OpenID Connect relying party
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Claims       models.SSOClaimMapping
+
+	// Endpoint and JWKSURL skip discovery when set, for providers with
+	// well-known endpoints (Google, Microsoft)
+	Endpoint *oauth2.Endpoint
+	JWKSURL  string
+}
+
+// Identity is the user identity extracted from a verified ID token
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	// EmailVerified is whether the provider says it verified the email. It
+	// only means something for providers trusted with emails.
+	EmailVerified bool
+	Name          string
+	Groups        []string
+	Claims        map[string]interface{}
+}
+
+// OIDCProvider runs the authorization code flow against an OIDC provider
+type OIDCProvider struct {
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	claims   models.SSOClaimMapping
+}
+
+// NewOIDCProvider creates a provider, running discovery against
+// {issuer}/.well-known/openid-configuration unless static endpoints are given
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	var provider *oidc.Provider
+	if cfg.Endpoint != nil {
+		if cfg.JWKSURL == "" {
+			return nil, errors.New("jwks url is required with a static endpoint")
+		}
+		provider = (&oidc.ProviderConfig{
+			IssuerURL: cfg.IssuerURL,
+			AuthURL:   cfg.Endpoint.AuthURL,
+			TokenURL:  cfg.Endpoint.TokenURL,
+			JWKSURL:   cfg.JWKSURL,
+		}).NewProvider(ctx)
+	} else {
+		var err error
+		provider, err = oidc.NewProvider(ctx, cfg.IssuerURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+		}
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+
+	return &OIDCProvider{
+		oauth2: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint:     provider.Endpoint(),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		claims:   mergeClaimMapping(cfg.Claims),
+	}, nil
+}
+
+// AuthCodeURL returns the provider's authorization URL for the given state and nonce
+func (p *OIDCProvider) AuthCodeURL(state, nonce string, opts ...oauth2.AuthCodeOption) string {
+	return p.oauth2.AuthCodeURL(state, append(opts, oidc.Nonce(nonce))...)
+}
+
+// Exchange trades an authorization code for tokens, verifies the ID token
+// signature, audience, expiry and nonce, and maps its claims to an Identity
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string, opts ...oauth2.AuthCodeOption) (*Identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code for token: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse id_token claims: %w", err)
+	}
+
+	return p.identity(idToken.Issuer, claims), nil
+}
+
+// identity maps raw claims to an Identity using the configured claim mapping
+func (p *OIDCProvider) identity(issuer string, claims map[string]interface{}) *Identity {
+	identity := &Identity{
+		Issuer:  issuer,
+		Subject: ClaimString(claims, p.claims.Subject),
+		Email:   ClaimString(claims, p.claims.Email),
+		Name:    ClaimString(claims, p.claims.Name),
+		Groups:  ClaimStrings(claims, p.claims.Groups),
+		Claims:  claims,
+	}
+
+	// Emails are unverified unless the provider says otherwise
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+
+	return identity
+}
+
+// Validate checks that an identity carries the attributes needed to provision a user
+func (i *Identity) Validate() error {
+	if i.Email == "" {
+		return ErrMissingEmail
+	}
+	if i.Subject == "" {
+		return errors.New("no subject found in id_token claims")
+	}
+	return nil
+}
+
+// ClaimString resolves a dotted claim path (e.g. "realm_access.roles") to a string
+func ClaimString(claims map[string]interface{}, path string) string {
+	switch v := lookupClaim(claims, path).(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+// ClaimStrings resolves a dotted claim path to a list of strings, accepting
+// either a JSON array or a single string value
+func ClaimStrings(claims map[string]interface{}, path string) []string {
+	switch v := lookupClaim(claims, path).(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		// SAML and LDAP identities carry attribute values as string slices
+		return v
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	}
+	return nil
+}
+
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	// Claims with literal dots (e.g. namespaced URLs) take precedence
+	if v, ok := claims[path]; ok {
+		return v
+	}
+
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil
+		}
+	}
+	return current
+}
+
+// mergeClaimMapping fills unset claim mapping fields with the defaults
+func mergeClaimMapping(m models.SSOClaimMapping) models.SSOClaimMapping {
+	if m.Subject == "" {
+		m.Subject = DefaultClaimMapping.Subject
+	}
+	if m.Email == "" {
+		m.Email = DefaultClaimMapping.Email
+	}
+	if m.Name == "" {
+		m.Name = DefaultClaimMapping.Name
+	}
+	if m.Groups == "" {
+		m.Groups = DefaultClaimMapping.Groups
+	}
+	return m
+}
@@ -0,0 +1,246 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso/ssotest"
+	"golang.org/x/oauth2"
+)
+
+func setupTestIdP(t *testing.T) *ssotest.IdP {
+	idp, err := ssotest.NewIdP("test-client")
+	if err != nil {
+		t.Fatalf("Failed to start stub IdP: %v", err)
+	}
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func newTestProvider(t *testing.T, idp *ssotest.IdP, claims models.SSOClaimMapping) *OIDCProvider {
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+		Claims:       claims,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return provider
+}
+
+func TestNewOIDCProviderDiscovery(t *testing.T) {
+	idp := setupTestIdP(t)
+	provider := newTestProvider(t, idp, models.SSOClaimMapping{})
+
+	authURL, err := url.Parse(provider.AuthCodeURL("test-state", "test-nonce"))
+	if err != nil {
+		t.Fatalf("Failed to parse auth URL: %v", err)
+	}
+
+	if !strings.HasPrefix(authURL.String(), idp.Issuer()+"/authorize") {
+		t.Errorf("Expected auth URL from discovery, got %s", authURL)
+	}
+	q := authURL.Query()
+	if q.Get("nonce") != "test-nonce" {
+		t.Errorf("Expected nonce 'test-nonce', got '%s'", q.Get("nonce"))
+	}
+	if q.Get("state") != "test-state" {
+		t.Errorf("Expected state 'test-state', got '%s'", q.Get("state"))
+	}
+	if q.Get("scope") != "openid email profile" {
+		t.Errorf("Expected default scopes, got '%s'", q.Get("scope"))
+	}
+}
+
+func TestNewOIDCProviderDiscoveryFails(t *testing.T) {
+	idp := setupTestIdP(t)
+
+	_, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerURL: idp.Issuer() + "/missing",
+		ClientID:  "test-client",
+	})
+	if err == nil {
+		t.Error("Expected discovery to fail for unknown issuer")
+	}
+}
+
+func TestExchangeMapsClaims(t *testing.T) {
+	idp := setupTestIdP(t)
+	idp.SetClaims(map[string]interface{}{
+		"sub":                "user-123",
+		"upn":                "jane@example.com",
+		"preferred_username": "Jane Doe",
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"dash-admins", "dash-users"},
+		},
+	})
+
+	provider := newTestProvider(t, idp, models.SSOClaimMapping{
+		Email:  "upn",
+		Name:   "preferred_username",
+		Groups: "realm_access.roles",
+	})
+
+	identity, err := provider.Exchange(context.Background(), idp.IssueCode("nonce-1"), "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+
+	if identity.Issuer != idp.Issuer() {
+		t.Errorf("Expected issuer %s, got %s", idp.Issuer(), identity.Issuer)
+	}
+	if identity.Subject != "user-123" {
+		t.Errorf("Expected subject 'user-123', got '%s'", identity.Subject)
+	}
+	if identity.Email != "jane@example.com" {
+		t.Errorf("Expected email 'jane@example.com', got '%s'", identity.Email)
+	}
+	if identity.Name != "Jane Doe" {
+		t.Errorf("Expected name 'Jane Doe', got '%s'", identity.Name)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"dash-admins", "dash-users"}) {
+		t.Errorf("Expected groups from nested claim, got %v", identity.Groups)
+	}
+	if err := identity.Validate(); err != nil {
+		t.Errorf("Expected identity to be valid, got %v", err)
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	idp := setupTestIdP(t)
+	provider := newTestProvider(t, idp, models.SSOClaimMapping{})
+
+	_, err := provider.Exchange(context.Background(), idp.IssueCode("issued-nonce"), "expected-nonce")
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+}
+
+func TestExchangeRejectsInvalidSignature(t *testing.T) {
+	idp := setupTestIdP(t)
+	provider := newTestProvider(t, idp, models.SSOClaimMapping{})
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	idp.SetKey(otherKey)
+
+	_, err = provider.Exchange(context.Background(), idp.IssueCode("nonce-1"), "nonce-1")
+	if err == nil {
+		t.Error("Expected exchange to fail for token signed with unpublished key")
+	}
+}
+
+func TestExchangeInvalidCode(t *testing.T) {
+	idp := setupTestIdP(t)
+	provider := newTestProvider(t, idp, models.SSOClaimMapping{})
+
+	_, err := provider.Exchange(context.Background(), "unknown-code", "nonce-1")
+	if err == nil {
+		t.Error("Expected exchange to fail for unknown code")
+	}
+}
+
+func TestExchangeWithPKCE(t *testing.T) {
+	idp := setupTestIdP(t)
+	provider := newTestProvider(t, idp, models.SSOClaimMapping{})
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := url.Parse(provider.AuthCodeURL("test-state", "nonce-1", oauth2.S256ChallengeOption(verifier)))
+	if err != nil {
+		t.Fatalf("Failed to parse auth URL: %v", err)
+	}
+	challenge := authURL.Query().Get("code_challenge")
+	if challenge == "" || authURL.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("Expected S256 code challenge in auth URL, got %s", authURL)
+	}
+
+	_, err = provider.Exchange(context.Background(), idp.IssueCodeWithChallenge("nonce-1", challenge), "nonce-1",
+		oauth2.VerifierOption(oauth2.GenerateVerifier()))
+	if err == nil {
+		t.Error("Expected exchange to fail with the wrong code verifier")
+	}
+
+	_, err = provider.Exchange(context.Background(), idp.IssueCodeWithChallenge("nonce-1", challenge), "nonce-1",
+		oauth2.VerifierOption(verifier))
+	if err != nil {
+		t.Errorf("Exchange with code verifier failed: %v", err)
+	}
+}
+
+func TestIdentityValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity Identity
+		want     error
+	}{
+		{"valid", Identity{Subject: "s", Email: "a@example.com", EmailVerified: true}, nil},
+		{"missing email", Identity{Subject: "s", EmailVerified: true}, ErrMissingEmail},
+		{"unverified email", Identity{Subject: "s", Email: "a@example.com"}, nil},
+	}
+
+	for _, tt := range tests {
+		err := tt.identity.Validate()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Validate() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEmailVerifiedClaim(t *testing.T) {
+	provider := &OIDCProvider{claims: mergeClaimMapping(models.SSOClaimMapping{})}
+
+	tests := []struct {
+		claims map[string]interface{}
+		want   bool
+	}{
+		{map[string]interface{}{"email_verified": true}, true},
+		{map[string]interface{}{"email_verified": false}, false},
+		{map[string]interface{}{"email_verified": "false"}, false},
+		{map[string]interface{}{}, false},
+	}
+
+	for _, tt := range tests {
+		got := provider.identity("issuer", tt.claims).EmailVerified
+		if got != tt.want {
+			t.Errorf("identity(%v).EmailVerified = %v, want %v", tt.claims, got, tt.want)
+		}
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups":                     []interface{}{"a", "b", 3},
+		"role":                       "admin",
+		"https://example.com/groups": []interface{}{"namespaced"},
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"groups", []string{"a", "b"}},
+		{"role", []string{"admin"}},
+		{"https://example.com/groups", []string{"namespaced"}},
+		{"missing", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got := ClaimStrings(claims, tt.path)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ClaimStrings(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
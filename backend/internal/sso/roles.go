@@ -0,0 +1,56 @@
+package sso
+
+import (
+	"strings"
+
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+// rolePrecedence ranks roles so that the most privileged matching mapping wins
+var rolePrecedence = map[models.MembershipRole]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleAdmin:  3,
+}
+
+// ValidRole reports whether role is an organization membership role
+func ValidRole(role models.MembershipRole) bool {
+	return rolePrecedence[role] > 0
+}
+
+// ResolveRole returns the highest role granted by mappings whose group is in
+// groups, or fallback when none match. Group names (or DNs) compare
+// case-insensitively. An empty result means the user gets no membership.
+func ResolveRole(groups []string, mappings []models.GroupRoleMapping, fallback models.MembershipRole) models.MembershipRole {
+	return resolveRole(func(models.GroupRoleMapping) []string { return groups }, mappings, fallback)
+}
+
+// ResolveIdentityRole is ResolveRole for an identity: mappings naming a claim
+// match against that claim's values, the rest against the identity's groups
+func ResolveIdentityRole(identity *Identity, mappings []models.GroupRoleMapping, fallback models.MembershipRole) models.MembershipRole {
+	return resolveRole(func(mapping models.GroupRoleMapping) []string {
+		if mapping.Claim != "" {
+			return ClaimStrings(identity.Claims, mapping.Claim)
+		}
+		return identity.Groups
+	}, mappings, fallback)
+}
+
+func resolveRole(values func(models.GroupRoleMapping) []string, mappings []models.GroupRoleMapping, fallback models.MembershipRole) models.MembershipRole {
+	var role models.MembershipRole
+	for _, mapping := range mappings {
+		if rolePrecedence[mapping.Role] <= rolePrecedence[role] {
+			continue
+		}
+		for _, group := range values(mapping) {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(mapping.Group)) {
+				role = mapping.Role
+				break
+			}
+		}
+	}
+	if role == "" {
+		return fallback
+	}
+	return role
+}
@@ -0,0 +1,63 @@
+package sso
+
+import (
+	"testing"
+
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+func TestResolveRole(t *testing.T) {
+	mappings := []models.GroupRoleMapping{
+		{Group: "cn=viewers,ou=groups,dc=example,dc=org", Role: models.RoleViewer},
+		{Group: "cn=admins,ou=groups,dc=example,dc=org", Role: models.RoleAdmin},
+		{Group: "cn=editors,ou=groups,dc=example,dc=org", Role: models.RoleEditor},
+	}
+
+	tests := []struct {
+		name     string
+		groups   []string
+		fallback models.MembershipRole
+		want     models.MembershipRole
+	}{
+		{"single match", []string{"cn=editors,ou=groups,dc=example,dc=org"}, "", models.RoleEditor},
+		{"highest wins", []string{"cn=viewers,ou=groups,dc=example,dc=org", "cn=admins,ou=groups,dc=example,dc=org"}, "", models.RoleAdmin},
+		{"case insensitive", []string{"CN=Admins,OU=Groups,DC=example,DC=org"}, "", models.RoleAdmin},
+		{"no match uses fallback", []string{"cn=other"}, models.RoleViewer, models.RoleViewer},
+		{"no match without fallback", nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ResolveRole(tt.groups, mappings, tt.fallback); got != tt.want {
+			t.Errorf("%s: ResolveRole() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveIdentityRole(t *testing.T) {
+	mappings := []models.GroupRoleMapping{
+		{Group: "dash-editors", Role: models.RoleEditor},
+		{Group: "Dash.Admin", Role: models.RoleAdmin, Claim: "roles"},
+		{Group: "example.com", Role: models.RoleViewer, Claim: "hd"},
+	}
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		want     models.MembershipRole
+	}{
+		{"group claim", &Identity{Groups: []string{"dash-editors"}}, models.RoleEditor},
+		{"app role claim", &Identity{
+			Groups: []string{"dash-editors"},
+			Claims: map[string]interface{}{"roles": []interface{}{"Dash.Admin"}},
+		}, models.RoleAdmin},
+		{"string claim", &Identity{Claims: map[string]interface{}{"hd": "example.com"}}, models.RoleViewer},
+		{"attribute values", &Identity{Claims: map[string]interface{}{"roles": []string{"dash.admin"}}}, models.RoleAdmin},
+		{"group name in other claim does not match", &Identity{Claims: map[string]interface{}{"roles": []interface{}{"dash-editors"}}}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := ResolveIdentityRole(tt.identity, mappings, ""); got != tt.want {
+			t.Errorf("%s: ResolveIdentityRole() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
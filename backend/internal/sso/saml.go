@@ -0,0 +1,309 @@
+package sso
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/janhoon/dash/backend/internal/models"
+)
+
+var (
+	ErrInvalidIDPCertificate = errors.New("invalid IdP certificate")
+	ErrInvalidSAMLResponse   = errors.New("invalid SAML response")
+)
+
+// SAML request bindings supported when sending the AuthnRequest to the IdP
+const (
+	SAMLBindingRedirect = "redirect"
+	SAMLBindingPost     = "post"
+)
+
+// defaultSAMLAttributes lists the attribute names tried, in order, when an
+// attribute mapping is not configured. They cover the common IdP defaults.
+var defaultSAMLAttributes = struct {
+	Email, Name, Groups []string
+}{
+	Email: []string{
+		"email",
+		"mail",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	},
+	Name: []string{
+		"name",
+		"displayName",
+		"cn",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+	},
+	Groups: []string{
+		"groups",
+		"memberOf",
+		"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups",
+	},
+}
+
+// SAMLConfig configures a SAML 2.0 service provider for a single IdP
+type SAMLConfig struct {
+	// EntityID identifies this service provider; MetadataURL is used if empty
+	EntityID    string
+	MetadataURL string
+	ACSURL      string
+
+	IDPEntityID string
+	IDPSSOURL   string
+	// IDPCertificate is the PEM (or bare base64 DER) certificate the IdP signs with
+	IDPCertificate string
+	// Binding is how the AuthnRequest is sent: "redirect" (default) or "post"
+	Binding string
+
+	// Attributes names the assertion attributes holding user attributes.
+	// Subject defaults to the assertion NameID.
+	Attributes models.SSOClaimMapping
+}
+
+// SAMLProvider is a SAML 2.0 service provider
+type SAMLProvider struct {
+	sp         *saml.ServiceProvider
+	binding    string
+	attributes models.SSOClaimMapping
+}
+
+// SAMLRequest is an AuthnRequest ready to be sent to the IdP
+type SAMLRequest struct {
+	// ID must be remembered and passed to ParseResponse to bind the response to this request
+	ID string
+	// RedirectURL is set for the redirect binding
+	RedirectURL string
+	// PostForm is an auto-submitting HTML form, set for the POST binding
+	PostForm []byte
+}
+
+// ParseCertificate parses a PEM encoded or bare base64 DER certificate
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	data = strings.TrimSpace(data)
+	var der []byte
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+		if err != nil {
+			return nil, ErrInvalidIDPCertificate
+		}
+		der = decoded
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, ErrInvalidIDPCertificate
+	}
+	return cert, nil
+}
+
+// NewSAMLProvider creates a service provider trusting the configured IdP certificate
+func NewSAMLProvider(cfg SAMLConfig) (*SAMLProvider, error) {
+	if cfg.IDPEntityID == "" || cfg.IDPSSOURL == "" {
+		return nil, errors.New("IdP entity ID and SSO URL are required")
+	}
+
+	cert, err := ParseCertificate(cfg.IDPCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL, err := url.Parse(cfg.MetadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata URL: %w", err)
+	}
+	acsURL, err := url.Parse(cfg.ACSURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACS URL: %w", err)
+	}
+
+	binding := cfg.Binding
+	if binding == "" {
+		binding = SAMLBindingRedirect
+	}
+	if binding != SAMLBindingRedirect && binding != SAMLBindingPost {
+		return nil, fmt.Errorf("unsupported SAML binding %q", binding)
+	}
+
+	keyDescriptor := saml.KeyDescriptor{
+		Use: "signing",
+		KeyInfo: saml.KeyInfo{
+			X509Data: saml.X509Data{
+				X509Certificates: []saml.X509Certificate{
+					{Data: base64.StdEncoding.EncodeToString(cert.Raw)},
+				},
+			},
+		},
+	}
+
+	idpMetadata := &saml.EntityDescriptor{
+		EntityID: cfg.IDPEntityID,
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{
+			SSODescriptor: saml.SSODescriptor{
+				RoleDescriptor: saml.RoleDescriptor{
+					ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+					KeyDescriptors:             []saml.KeyDescriptor{keyDescriptor},
+				},
+			},
+			SingleSignOnServices: []saml.Endpoint{
+				{Binding: saml.HTTPRedirectBinding, Location: cfg.IDPSSOURL},
+				{Binding: saml.HTTPPostBinding, Location: cfg.IDPSSOURL},
+			},
+		}},
+	}
+
+	return &SAMLProvider{
+		sp: &saml.ServiceProvider{
+			EntityID:          cfg.EntityID,
+			MetadataURL:       *metadataURL,
+			AcsURL:            *acsURL,
+			IDPMetadata:       idpMetadata,
+			AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		},
+		binding:    binding,
+		attributes: cfg.Attributes,
+	}, nil
+}
+
+// Metadata returns the service provider metadata XML to register with the IdP
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	buf, err := xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), buf...), nil
+}
+
+// AuthnRequest builds an authentication request using the configured binding
+func (p *SAMLProvider) AuthnRequest(relayState string) (*SAMLRequest, error) {
+	samlBinding := saml.HTTPRedirectBinding
+	if p.binding == SAMLBindingPost {
+		samlBinding = saml.HTTPPostBinding
+	}
+
+	req, err := p.sp.MakeAuthenticationRequest(p.sp.GetSSOBindingLocation(samlBinding), samlBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return nil, err
+	}
+
+	if samlBinding == saml.HTTPPostBinding {
+		return &SAMLRequest{ID: req.ID, PostForm: req.Post(relayState)}, nil
+	}
+
+	redirectURL, err := req.Redirect(relayState, p.sp)
+	if err != nil {
+		return nil, err
+	}
+	return &SAMLRequest{ID: req.ID, RedirectURL: redirectURL.String()}, nil
+}
+
+// SAMLAssertion identifies an accepted assertion, so replays of it can be refused
+type SAMLAssertion struct {
+	ID string
+	// Expires is when the assertion would be rejected as too old anyway
+	Expires time.Time
+}
+
+// ParseResponse validates a base64 encoded SAMLResponse posted to the ACS for
+// the request with the given ID and maps the signed assertion to an identity
+func (p *SAMLProvider) ParseResponse(samlResponse, requestID string) (*Identity, *SAMLAssertion, error) {
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, nil, ErrInvalidSAMLResponse
+	}
+
+	assertion, err := p.sp.ParseXMLResponse(raw, []string{requestID}, p.sp.AcsURL)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) && invalid.PrivateErr != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, invalid.PrivateErr)
+		}
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+	}
+	if assertion.ID == "" {
+		return nil, nil, fmt.Errorf("%w: assertion has no ID", ErrInvalidSAMLResponse)
+	}
+
+	accepted := &SAMLAssertion{ID: assertion.ID, Expires: assertion.IssueInstant.Add(saml.MaxIssueDelay)}
+	return p.identity(assertion), accepted, nil
+}
+
+// identity maps assertion attributes to an identity
+func (p *SAMLProvider) identity(assertion *saml.Assertion) *Identity {
+	attrs := map[string][]string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			var values []string
+			for _, v := range attr.Values {
+				if v.Value != "" {
+					values = append(values, v.Value)
+				}
+			}
+			if attr.Name != "" {
+				attrs[attr.Name] = append(attrs[attr.Name], values...)
+			}
+			if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+				attrs[attr.FriendlyName] = append(attrs[attr.FriendlyName], values...)
+			}
+		}
+	}
+
+	var nameID string
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		nameID = assertion.Subject.NameID.Value
+	}
+
+	identity := &Identity{
+		Issuer:  assertion.Issuer.Value,
+		Subject: nameID,
+		Email:   firstAttribute(attrs, p.attributes.Email, defaultSAMLAttributes.Email),
+		Name:    firstAttribute(attrs, p.attributes.Name, defaultSAMLAttributes.Name),
+		Groups:  attributeValues(attrs, p.attributes.Groups, defaultSAMLAttributes.Groups),
+		Claims:  map[string]interface{}{},
+	}
+
+	if p.attributes.Subject != "" {
+		identity.Subject = firstAttribute(attrs, p.attributes.Subject, nil)
+	}
+	if identity.Email == "" && strings.Contains(nameID, "@") {
+		identity.Email = nameID
+	}
+
+	for key, values := range attrs {
+		identity.Claims[key] = values
+	}
+	return identity
+}
+
+// attributeValues returns the values of the mapped attribute, or of the first
+// default attribute present when no mapping is configured
+func attributeValues(attrs map[string][]string, mapped string, defaults []string) []string {
+	if mapped != "" {
+		return attrs[mapped]
+	}
+	for _, name := range defaults {
+		if values := attrs[name]; len(values) > 0 {
+			return values
+		}
+	}
+	return nil
+}
+
+func firstAttribute(attrs map[string][]string, mapped string, defaults []string) string {
+	values := attributeValues(attrs, mapped, defaults)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
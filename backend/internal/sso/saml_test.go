@@ -0,0 +1,248 @@
+package sso
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhoon/dash/backend/internal/models"
+	"github.com/janhoon/dash/backend/internal/sso/ssotest"
+)
+
+const (
+	testSPMetadataURL = "http://localhost:8080/api/auth/saml/acme/metadata"
+	testSPACSURL      = "http://localhost:8080/api/auth/saml/acme/acs"
+)
+
+func setupTestSAMLIdP(t *testing.T) *ssotest.SAMLIdP {
+	idp, err := ssotest.NewSAMLIdP("https://idp.example.com/metadata", "https://idp.example.com/sso")
+	if err != nil {
+		t.Fatalf("Failed to create stub SAML IdP: %v", err)
+	}
+	return idp
+}
+
+func newTestSAMLProvider(t *testing.T, idp *ssotest.SAMLIdP, binding string, attributes models.SSOClaimMapping) *SAMLProvider {
+	provider, err := NewSAMLProvider(SAMLConfig{
+		MetadataURL:    testSPMetadataURL,
+		ACSURL:         testSPACSURL,
+		IDPEntityID:    idp.EntityID,
+		IDPSSOURL:      idp.SSOURL,
+		IDPCertificate: idp.CertificatePEM(),
+		Binding:        binding,
+		Attributes:     attributes,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SAML provider: %v", err)
+	}
+	return provider
+}
+
+func respond(t *testing.T, idp *ssotest.SAMLIdP, provider *SAMLProvider, requestID, nameID string, attributes map[string][]string) string {
+	metadata, err := provider.Metadata()
+	if err != nil {
+		t.Fatalf("Failed to get metadata: %v", err)
+	}
+	response, err := idp.Respond(metadata, requestID, nameID, attributes)
+	if err != nil {
+		t.Fatalf("Failed to create SAML response: %v", err)
+	}
+	return response
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, "", models.SSOClaimMapping{})
+
+	metadata, err := provider.Metadata()
+	if err != nil {
+		t.Fatalf("Metadata failed: %v", err)
+	}
+
+	xml := string(metadata)
+	if !strings.Contains(xml, `entityID="`+testSPMetadataURL+`"`) {
+		t.Errorf("Expected metadata URL as entity ID, got %s", xml)
+	}
+	if !strings.Contains(xml, `Location="`+testSPACSURL+`"`) {
+		t.Errorf("Expected ACS location in metadata, got %s", xml)
+	}
+}
+
+func TestSAMLAuthnRequestRedirectBinding(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, SAMLBindingRedirect, models.SSOClaimMapping{})
+
+	req, err := provider.AuthnRequest("relay-1")
+	if err != nil {
+		t.Fatalf("AuthnRequest failed: %v", err)
+	}
+
+	if req.ID == "" {
+		t.Error("Expected request ID to be set")
+	}
+	if req.PostForm != nil {
+		t.Error("Expected no POST form for redirect binding")
+	}
+
+	redirectURL, err := url.Parse(req.RedirectURL)
+	if err != nil {
+		t.Fatalf("Invalid redirect URL: %v", err)
+	}
+	if !strings.HasPrefix(req.RedirectURL, idp.SSOURL) {
+		t.Errorf("Expected redirect to IdP SSO URL, got %s", req.RedirectURL)
+	}
+	if redirectURL.Query().Get("SAMLRequest") == "" {
+		t.Error("Expected SAMLRequest query parameter")
+	}
+	if redirectURL.Query().Get("RelayState") != "relay-1" {
+		t.Errorf("Expected RelayState 'relay-1', got '%s'", redirectURL.Query().Get("RelayState"))
+	}
+}
+
+func TestSAMLAuthnRequestPostBinding(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, SAMLBindingPost, models.SSOClaimMapping{})
+
+	req, err := provider.AuthnRequest("relay-1")
+	if err != nil {
+		t.Fatalf("AuthnRequest failed: %v", err)
+	}
+
+	form := string(req.PostForm)
+	if req.RedirectURL != "" {
+		t.Error("Expected no redirect URL for POST binding")
+	}
+	if !strings.Contains(form, `action="`+idp.SSOURL+`"`) || !strings.Contains(form, `name="SAMLRequest"`) {
+		t.Errorf("Expected auto-submitting form posting to IdP, got %s", form)
+	}
+}
+
+func TestSAMLParseResponseMapsAttributes(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, "", models.SSOClaimMapping{Groups: "memberOf"})
+
+	response := respond(t, idp, provider, "id-request-1", "jane", map[string][]string{
+		"mail":        {"jane@example.com"},
+		"displayName": {"Jane Doe"},
+		"memberOf":    {"dash-admins", "dash-users"},
+	})
+
+	identity, assertion, err := provider.ParseResponse(response, "id-request-1")
+	if err != nil {
+		t.Fatalf("ParseResponse failed: %v", err)
+	}
+
+	if assertion.ID == "" || !assertion.Expires.After(time.Now()) {
+		t.Errorf("Expected the assertion ID and a future expiry, got %+v", assertion)
+	}
+	if identity.Issuer != idp.EntityID {
+		t.Errorf("Expected issuer %s, got %s", idp.EntityID, identity.Issuer)
+	}
+	if identity.Subject != "jane" {
+		t.Errorf("Expected subject 'jane', got '%s'", identity.Subject)
+	}
+	if identity.Email != "jane@example.com" {
+		t.Errorf("Expected email 'jane@example.com', got '%s'", identity.Email)
+	}
+	if identity.Name != "Jane Doe" {
+		t.Errorf("Expected name 'Jane Doe', got '%s'", identity.Name)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"dash-admins", "dash-users"}) {
+		t.Errorf("Expected groups from memberOf, got %v", identity.Groups)
+	}
+	if err := identity.Validate(); err != nil {
+		t.Errorf("Expected identity to be valid, got %v", err)
+	}
+}
+
+func TestSAMLParseResponseEmailFromNameID(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, "", models.SSOClaimMapping{})
+
+	response := respond(t, idp, provider, "id-request-1", "jane@example.com", nil)
+
+	identity, _, err := provider.ParseResponse(response, "id-request-1")
+	if err != nil {
+		t.Fatalf("ParseResponse failed: %v", err)
+	}
+	if identity.Email != "jane@example.com" {
+		t.Errorf("Expected email from NameID, got '%s'", identity.Email)
+	}
+}
+
+func TestSAMLParseResponseRejectsWrongRequestID(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, "", models.SSOClaimMapping{})
+
+	response := respond(t, idp, provider, "id-request-1", "jane@example.com", nil)
+
+	_, _, err := provider.ParseResponse(response, "id-request-2")
+	if !errors.Is(err, ErrInvalidSAMLResponse) {
+		t.Errorf("Expected ErrInvalidSAMLResponse, got %v", err)
+	}
+}
+
+func TestSAMLParseResponseRejectsInvalidSignature(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, "", models.SSOClaimMapping{})
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	idp.SetKey(otherKey)
+
+	response := respond(t, idp, provider, "id-request-1", "jane@example.com", nil)
+
+	_, _, err = provider.ParseResponse(response, "id-request-1")
+	if !errors.Is(err, ErrInvalidSAMLResponse) {
+		t.Errorf("Expected ErrInvalidSAMLResponse, got %v", err)
+	}
+}
+
+func TestSAMLParseResponseRejectsUnsigned(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp, "", models.SSOClaimMapping{})
+
+	unsigned := base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="x" Version="2.0"/>`))
+	if _, _, err := provider.ParseResponse(unsigned, "id-request-1"); !errors.Is(err, ErrInvalidSAMLResponse) {
+		t.Errorf("Expected ErrInvalidSAMLResponse, got %v", err)
+	}
+}
+
+func TestNewSAMLProviderValidation(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+
+	tests := []struct {
+		name string
+		cfg  SAMLConfig
+	}{
+		{"missing sso url", SAMLConfig{IDPEntityID: idp.EntityID, IDPCertificate: idp.CertificatePEM()}},
+		{"invalid certificate", SAMLConfig{IDPEntityID: idp.EntityID, IDPSSOURL: idp.SSOURL, IDPCertificate: "not a cert"}},
+		{"unknown binding", SAMLConfig{IDPEntityID: idp.EntityID, IDPSSOURL: idp.SSOURL, IDPCertificate: idp.CertificatePEM(), Binding: "artifact"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewSAMLProvider(tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestParseCertificateBareBase64(t *testing.T) {
+	idp := setupTestSAMLIdP(t)
+
+	pemCert := idp.CertificatePEM()
+	lines := strings.Split(strings.TrimSpace(pemCert), "\n")
+	bare := strings.Join(lines[1:len(lines)-1], "\n")
+
+	if _, err := ParseCertificate(bare); err != nil {
+		t.Errorf("Expected bare base64 certificate to parse, got %v", err)
+	}
+}
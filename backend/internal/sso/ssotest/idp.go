@@ -0,0 +1,223 @@
+// Package ssotest provides stub OpenID Connect and SAML identity providers for tests.
+package ssotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "ssotest-key"
+
+// IdP is an in-process OIDC provider serving discovery, JWKS, authorize and
+// token endpoints. ID tokens carry Claims plus the standard iss/aud/exp/nonce.
+type IdP struct {
+	*httptest.Server
+	ClientID string
+
+	mu        sync.Mutex
+	claims    map[string]interface{}
+	key       *rsa.PrivateKey
+	published *rsa.PublicKey
+	codes     map[string]authCode
+}
+
+// authCode is what the provider remembers about an issued authorization code
+type authCode struct {
+	nonce         string
+	codeChallenge string // S256 PKCE challenge, if the client sent one
+}
+
+// NewIdP starts a stub provider issuing tokens for the given client ID
+func NewIdP(clientID string) (*IdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	idp := &IdP{
+		ClientID: clientID,
+		claims: map[string]interface{}{
+			"sub":            "stub-user",
+			"email":          "stub-user@example.com",
+			"email_verified": true,
+			"name":           "Stub User",
+		},
+		key:       key,
+		published: &key.PublicKey,
+		codes:     make(map[string]authCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+
+	return idp, nil
+}
+
+// Issuer returns the issuer URL the provider advertises and signs tokens with
+func (i *IdP) Issuer() string {
+	return i.URL
+}
+
+// SetClaims replaces the claims carried by subsequently issued ID tokens
+func (i *IdP) SetClaims(claims map[string]interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.claims = claims
+}
+
+// SetKey replaces the signing key without publishing it, so that issued
+// tokens fail signature verification
+func (i *IdP) SetKey(key *rsa.PrivateKey) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.key = key
+}
+
+// IssueCode creates an authorization code bound to the given nonce, as the
+// authorize endpoint would after the user signs in
+func (i *IdP) IssueCode(nonce string) string {
+	return i.issueCode(authCode{nonce: nonce})
+}
+
+// IssueCodeWithChallenge is IssueCode for a client using PKCE; redeeming the
+// code requires the verifier matching the S256 challenge
+func (i *IdP) IssueCodeWithChallenge(nonce, codeChallenge string) string {
+	return i.issueCode(authCode{nonce: nonce, codeChallenge: codeChallenge})
+}
+
+func (i *IdP) issueCode(c authCode) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.codes[code] = c
+	return code
+}
+
+func (i *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *IdP) jwks(w http.ResponseWriter, r *http.Request) {
+	// Only the original key is published; see SetKey
+	pub := i.published
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize signs the user in immediately and redirects back with a code
+func (i *IdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != i.ClientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if method := q.Get("code_challenge_method"); method != "" && method != "S256" {
+		http.Error(w, "unsupported code_challenge_method", http.StatusBadRequest)
+		return
+	}
+
+	params := redirectURI.Query()
+	params.Set("code", i.IssueCodeWithChallenge(q.Get("nonce"), q.Get("code_challenge")))
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (i *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	i.mu.Lock()
+	c, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+	if ok && c.codeChallenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		ok = base64.RawURLEncoding.EncodeToString(sum[:]) == c.codeChallenge
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := i.signIDToken(c.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "stub-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (i *IdP) signIDToken(nonce string) (string, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range i.claims {
+		claims[k] = v
+	}
+	claims["iss"] = i.URL
+	claims["aud"] = i.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(i.key)
+}
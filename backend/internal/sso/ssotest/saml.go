@@ -0,0 +1,146 @@
+package ssotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// SAMLIdP issues signed SAML responses for a service provider's metadata
+type SAMLIdP struct {
+	EntityID string
+	SSOURL   string
+
+	mu   sync.Mutex
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+// NewSAMLIdP creates a stub IdP with a freshly generated self-signed certificate
+func NewSAMLIdP(entityID, ssoURL string) (*SAMLIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ssotest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SAMLIdP{EntityID: entityID, SSOURL: ssoURL, key: key, cert: cert}, nil
+}
+
+// CertificatePEM returns the signing certificate to configure on the SP
+func (i *SAMLIdP) CertificatePEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.cert.Raw}))
+}
+
+// SetKey replaces the signing key while keeping the advertised certificate,
+// so that issued responses fail signature verification
+func (i *SAMLIdP) SetKey(key *rsa.PrivateKey) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.key = key
+}
+
+// Respond returns a base64 encoded, signed SAMLResponse answering the request
+// with the given ID, as the IdP would POST it to the SP's ACS
+func (i *SAMLIdP) Respond(spMetadata []byte, requestID, nameID string, attributes map[string][]string) (string, error) {
+	i.mu.Lock()
+	key := i.key
+	i.mu.Unlock()
+
+	metadataURL, err := url.Parse(i.EntityID)
+	if err != nil {
+		return "", err
+	}
+	ssoURL, err := url.Parse(i.SSOURL)
+	if err != nil {
+		return "", err
+	}
+
+	var sp saml.EntityDescriptor
+	if err := xml.Unmarshal(spMetadata, &sp); err != nil {
+		return "", err
+	}
+	if len(sp.SPSSODescriptors) == 0 {
+		return "", errors.New("metadata has no SP descriptor")
+	}
+	descriptor := &sp.SPSSODescriptors[0]
+
+	var acs *saml.IndexedEndpoint
+	for j := range descriptor.AssertionConsumerServices {
+		if descriptor.AssertionConsumerServices[j].Binding == saml.HTTPPostBinding {
+			acs = &descriptor.AssertionConsumerServices[j]
+		}
+	}
+	if acs == nil {
+		return "", errors.New("metadata has no HTTP-POST assertion consumer service")
+	}
+
+	session := &saml.Session{
+		ID:           "ssotest-session",
+		CreateTime:   time.Now(),
+		ExpireTime:   time.Now().Add(time.Hour),
+		Index:        "1",
+		NameID:       nameID,
+		NameIDFormat: string(saml.UnspecifiedNameIDFormat),
+	}
+	for name, values := range attributes {
+		attr := saml.Attribute{Name: name, NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"}
+		for _, v := range values {
+			attr.Values = append(attr.Values, saml.AttributeValue{Type: "xs:string", Value: v})
+		}
+		session.CustomAttributes = append(session.CustomAttributes, attr)
+	}
+
+	req := &saml.IdpAuthnRequest{
+		IDP: &saml.IdentityProvider{
+			Key:         key,
+			Certificate: i.cert,
+			MetadataURL: *metadataURL,
+			SSOURL:      *ssoURL,
+		},
+		Request: saml.AuthnRequest{
+			ID:                          requestID,
+			AssertionConsumerServiceURL: acs.Location,
+		},
+		HTTPRequest:             httptest.NewRequest("POST", i.SSOURL, nil),
+		ServiceProviderMetadata: &sp,
+		SPSSODescriptor:         descriptor,
+		ACSEndpoint:             acs,
+		Now:                     saml.TimeNow(),
+	}
+
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		return "", err
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		return "", err
+	}
+	return form.SAMLResponse, nil
+}
@@ -0,0 +1,134 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const loginStatePrefix = "sso_state:"
+
+// ErrStateNotFound is returned for unknown, expired or already used login state
+var ErrStateNotFound = errors.New("login state not found or expired")
+
+// StateStore keeps login round-trip state (nonce, PKCE verifier, ...) on the
+// server. Take removes the entry so each state can be redeemed only once.
+type StateStore interface {
+	Save(ctx context.Context, state string, data []byte, ttl time.Duration) error
+	Take(ctx context.Context, state string) ([]byte, error)
+}
+
+// NewStateStore returns a Valkey-backed store, or an in-memory one for
+// single-instance deployments when rdb is nil
+func NewStateStore(rdb *redis.Client) StateStore {
+	if rdb == nil {
+		return &memoryStateStore{entries: make(map[string]memoryStateEntry)}
+	}
+	return &valkeyStateStore{rdb: rdb}
+}
+
+type valkeyStateStore struct {
+	rdb *redis.Client
+}
+
+func (s *valkeyStateStore) Save(ctx context.Context, state string, data []byte, ttl time.Duration) error {
+	return s.rdb.Set(ctx, loginStatePrefix+state, data, ttl).Err()
+}
+
+func (s *valkeyStateStore) Take(ctx context.Context, state string) ([]byte, error) {
+	data, err := s.rdb.GetDel(ctx, loginStatePrefix+state).Bytes()
+	if err == redis.Nil {
+		return nil, ErrStateNotFound
+	}
+	return data, err
+}
+
+type memoryStateEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+type memoryStateStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryStateEntry
+}
+
+func (s *memoryStateStore) Save(ctx context.Context, state string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired entries so abandoned logins don't accumulate
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+
+	s.entries[state] = memoryStateEntry{data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryStateStore) Take(ctx context.Context, state string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[state]
+	delete(s.entries, state)
+	if !ok || time.Now().After(entry.expires) {
+		return nil, ErrStateNotFound
+	}
+	return entry.data, nil
+}
+
+const replayPrefix = "sso_replay:"
+
+// ReplayCache remembers single-use values, such as SAML assertion IDs, until
+// they would be rejected as expired anyway
+type ReplayCache interface {
+	// Use records id, reporting false if it was already used
+	Use(ctx context.Context, id string, ttl time.Duration) (bool, error)
+}
+
+// NewReplayCache returns a Valkey-backed cache, or an in-memory one for
+// single-instance deployments when rdb is nil
+func NewReplayCache(rdb *redis.Client) ReplayCache {
+	if rdb == nil {
+		return &memoryReplayCache{used: make(map[string]time.Time)}
+	}
+	return &valkeyReplayCache{rdb: rdb}
+}
+
+type valkeyReplayCache struct {
+	rdb *redis.Client
+}
+
+func (c *valkeyReplayCache) Use(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return c.rdb.SetNX(ctx, replayPrefix+id, 1, max(ttl, time.Second)).Result()
+}
+
+type memoryReplayCache struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func (c *memoryReplayCache) Use(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, expires := range c.used {
+		if now.After(expires) {
+			delete(c.used, key)
+		}
+	}
+
+	if _, ok := c.used[id]; ok {
+		return false, nil
+	}
+	c.used[id] = now.Add(ttl)
+	return true, nil
+}
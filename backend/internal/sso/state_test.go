@@ -0,0 +1,114 @@
+package sso
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func testStateStore(t *testing.T, store StateStore, expire func(time.Duration)) {
+	ctx := context.Background()
+
+	if err := store.Save(ctx, "state-1", []byte("data"), time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := store.Take(ctx, "state-1")
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if string(data) != "data" {
+		t.Errorf("Expected 'data', got '%s'", data)
+	}
+
+	// State is single use
+	if _, err := store.Take(ctx, "state-1"); err != ErrStateNotFound {
+		t.Errorf("Expected ErrStateNotFound on reuse, got %v", err)
+	}
+
+	if _, err := store.Take(ctx, "unknown"); err != ErrStateNotFound {
+		t.Errorf("Expected ErrStateNotFound for unknown state, got %v", err)
+	}
+
+	if err := store.Save(ctx, "state-2", []byte("data"), time.Second); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	expire(2 * time.Second)
+	if _, err := store.Take(ctx, "state-2"); err != ErrStateNotFound {
+		t.Errorf("Expected ErrStateNotFound for expired state, got %v", err)
+	}
+}
+
+func TestValkeyStateStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	testStateStore(t, NewStateStore(rdb), mr.FastForward)
+}
+
+func TestMemoryStateStore(t *testing.T) {
+	store := NewStateStore(nil)
+	testStateStore(t, store, func(d time.Duration) {
+		// Backdate entries instead of sleeping
+		memory := store.(*memoryStateStore)
+		memory.mu.Lock()
+		for key, entry := range memory.entries {
+			entry.expires = entry.expires.Add(-d)
+			memory.entries[key] = entry
+		}
+		memory.mu.Unlock()
+	})
+}
+
+func testReplayCache(t *testing.T, cache ReplayCache, expire func(time.Duration)) {
+	ctx := context.Background()
+
+	if ok, err := cache.Use(ctx, "assertion-1", time.Minute); err != nil || !ok {
+		t.Fatalf("Expected first use to succeed, got %v, %v", ok, err)
+	}
+	if ok, err := cache.Use(ctx, "assertion-1", time.Minute); err != nil || ok {
+		t.Errorf("Expected replay to be refused, got %v, %v", ok, err)
+	}
+	if ok, err := cache.Use(ctx, "assertion-2", time.Minute); err != nil || !ok {
+		t.Errorf("Expected another ID to be accepted, got %v, %v", ok, err)
+	}
+
+	expire(2 * time.Minute)
+	if ok, err := cache.Use(ctx, "assertion-1", time.Minute); err != nil || !ok {
+		t.Errorf("Expected an expired ID to be forgotten, got %v, %v", ok, err)
+	}
+}
+
+func TestValkeyReplayCache(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	testReplayCache(t, NewReplayCache(rdb), mr.FastForward)
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	cache := NewReplayCache(nil)
+	testReplayCache(t, cache, func(d time.Duration) {
+		memory := cache.(*memoryReplayCache)
+		memory.mu.Lock()
+		for key, expires := range memory.used {
+			memory.used[key] = expires.Add(-d)
+		}
+		memory.mu.Unlock()
+	})
+}